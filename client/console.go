@@ -0,0 +1,43 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdConsole(args ...string) error {
+	var opts struct {
+		Follow bool `short:"f" long:"follow" default:"false" default-mask:"-" description:"Follow the console output until the VM quits"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "console [-f] VM_ID\n\nprint the console output of a VM"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"console\" requires a minimum of 1 argument, please provide VM ID.\n")
+	}
+
+	v := url.Values{}
+	v.Set("vm", args[1])
+	if opts.Follow {
+		v.Set("follow", "yes")
+	}
+	body, _, _, err := cli.clientRequest("GET", "/vm/console?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(cli.out, body)
+	return err
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdPodAddContainer(args ...string) error {
+	var opts struct {
+		File string `short:"f" long:"file" value-name:"\"\"" description:"The spec file of the container"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "pod add-container -f CONTAINER_FILE POD_ID\n\nadd a container to a running pod, and start it"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"pod add-container\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+	if opts.File == "" {
+		return fmt.Errorf("\"pod add-container\" requires the container spec file, please provide it with -f.\n")
+	}
+
+	podId := args[2]
+	jsonbody, err := ioutil.ReadFile(opts.File)
+	if err != nil {
+		return err
+	}
+	code, cause, containerId, err := cli.AddContainer(podId, string(jsonbody))
+	if err != nil {
+		return err
+	}
+	if code != types.E_OK {
+		return fmt.Errorf("Error code is %d, cause is %s", code, cause)
+	}
+	fmt.Printf("Container %s is added to the POD %s\n", containerId, podId)
+	return nil
+}
+
+// AddContainer creates a container from the spec and starts it in the
+// running pod, it returns the ID of the new container.
+func (cli *HyperClient) AddContainer(podId, jsonbody string) (int, string, string, error) {
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("containerArgs", jsonbody)
+	body, _, err := readBody(cli.call("POST", "/pod/addcontainer?"+v.Encode(), nil, nil))
+	if err != nil {
+		return -1, "", "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, "", "", err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return -1, "", "", fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo.GetInt("Code"), remoteInfo.Get("Cause"), remoteInfo.Get("ContainerID"), nil
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"hyper/engine"
 	"hyper/lib/promise"
+	"hyper/lib/stdcopy"
 	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"strings"
 
 	gflag "github.com/jessevdk/go-flags"
@@ -14,8 +17,12 @@ import (
 
 func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	var opts struct {
-		Attach bool `short:"a" long:"attach" default:"true" value-name:"false" description:"attach current terminal to the stdio of command"`
-		Vm     bool `long:"vm" default:"false" value-name:"false" description:"attach to vm"`
+		Attach  bool     `short:"a" long:"attach" default:"true" value-name:"false" description:"attach current terminal to the stdio of command"`
+		Vm      bool     `long:"vm" default:"false" value-name:"false" description:"attach to vm"`
+		NoTty   bool     `short:"T" long:"no-tty" default:"false" value-name:"false" description:"run the command without tty, separate its stdout and stderr, and exit with its exit code"`
+		Env     []string `short:"e" long:"env" value-name:"[]" description:"set environment variables of the command, NAME=VALUE"`
+		Workdir string   `short:"w" long:"workdir" value-name:"\"\"" description:"working directory of the command"`
+		User    string   `short:"u" long:"user" value-name:"\"\"" description:"user the command runs as"`
 	}
 	var parser = gflag.NewParser(&opts, gflag.Default|gflag.IgnoreUnknown)
 	parser.Usage = "exec [OPTIONS] POD|CONTAINER COMMAND [ARGS...]\n\nrun a command in a container of a running pod"
@@ -71,6 +78,19 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	}
 	v.Set("command", string(command))
 	v.Set("tag", tag)
+	if len(opts.Env) > 0 {
+		env, err := json.Marshal(opts.Env)
+		if err != nil {
+			return err
+		}
+		v.Set("env", string(env))
+	}
+	v.Set("workdir", opts.Workdir)
+	v.Set("user", opts.User)
+	if opts.NoTty {
+		v.Set("tty", "no")
+		return cli.execNoTty(v, hostname)
+	}
 
 	var (
 		hijacked = make(chan io.Closer)
@@ -115,6 +135,36 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	return nil
 }
 
+// execNoTty runs the command without tty, its stdout and stderr are copied
+// to the ones of the client, which exits with the exit code of the command.
+func (cli *HyperClient) execNoTty(v url.Values, hostname string) error {
+	var (
+		r, w   = io.Pipe()
+		result = make(chan error, 1)
+		code   = -1
+	)
+	go func() {
+		var err error
+		code, err = stdcopy.Demux(r, cli.out, cli.err)
+		// drain the output left if any
+		io.Copy(ioutil.Discard, r)
+		result <- err
+	}()
+
+	err := cli.hijack("POST", "/exec?"+v.Encode(), false, cli.in, w, w, nil, nil, hostname)
+	w.Close()
+	if err != nil {
+		return err
+	}
+	if err := <-result; err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
 func (cli *HyperClient) GetPodInfo(podName string) (string, error) {
 	// get the pod or container info before we start the exec
 	v := url.Values{}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+	"hyper/pod"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdPodExport(args ...string) error {
+	var opts struct {
+		Format string `long:"format" value-name:"json" default:"json" description:"The format of the output, json, yaml or kubernetes"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "pod export [--format=json|yaml|kubernetes] POD_ID\n\nexport the spec of a pod, which could be used to run it again"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"pod export\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+
+	podId := args[2]
+	userPod, err := cli.ExportPod(podId)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch opts.Format {
+	case "json":
+		data, err = json.MarshalIndent(userPod, "", "  ")
+	case "yaml":
+		data, err = userPod.ToYaml()
+	case "kubernetes":
+		kpod, warnings, kerr := userPod.ToKPod()
+		if kerr != nil {
+			return kerr
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(cli.err, "Warning: %s\n", w)
+		}
+		data, err = json.MarshalIndent(kpod, "", "  ")
+	default:
+		return fmt.Errorf("Unknown format %s, it should be json, yaml or kubernetes", opts.Format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s\n", strings.TrimRight(string(data), "\n"))
+	return nil
+}
+
+// ExportPod returns the spec of the pod, which has the containers and the
+// volumes added after it started, and the resources it is resized to.
+func (cli *HyperClient) ExportPod(podId string) (*pod.UserPod, error) {
+	v := url.Values{}
+	v.Set("podId", podId)
+	body, _, err := readBody(cli.call("GET", "/pod/export?"+v.Encode(), nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return nil, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	userPod := &pod.UserPod{}
+	if err := remoteInfo.GetJson("PodSpec", userPod); err != nil {
+		return nil, err
+	}
+	return userPod, nil
+}
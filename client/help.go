@@ -18,6 +18,20 @@ Command:
   replace                replace a running pod with a new one, the old one become 'pending'
   rm                     destroy a pod
   attach                 attach to the tty of a specified container in a pod
+  migrate                move a running pod to another hyperd
+  pause                  freeze all the processes of a running pod
+  unpause                resume a paused pod
+  resize                 change the CPU number or memory size of a running pod
+  console                print the console output of a VM
+  logs                   fetch the logs of a container
+  wait                   block until a pod finishes, and exit with its exit code
+  pod add-container      add a container to a running pod, and start it
+  pod export             export the spec of a pod as a pod file or a Kubernetes manifest
+  volume attach          mount a volume to the containers of a running pod
+  volume detach          unmount a volume from the containers of a running pod
+  network create         create a network isolated from the other ones
+  network ls             list the networks
+  network rm             remove a network which is not used by any pod
 
   pull                   pull an image from a Docker registry server
   info                   display system-wide information
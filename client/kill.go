@@ -25,26 +25,34 @@ func (cli *HyperClient) HyperCmdKill(args ...string) error {
 	}
 
 	vmId := args[1]
+	if _, _, err := cli.KillVm(vmId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (cli *HyperClient) KillVm(vmId string) (int, string, error) {
 	v := url.Values{}
 	v.Set("vm", vmId)
 	body, _, err := readBody(cli.call("POST", "/vm/kill?"+v.Encode(), nil, nil))
 	if err != nil {
-		return err
+		return -1, "", err
 	}
 	out := engine.NewOutput()
 	remoteInfo, err := out.AddEnv()
 	if err != nil {
-		return err
+		return -1, "", err
 	}
 
 	if _, err := out.Write(body); err != nil {
 		fmt.Printf("Error reading remote info: %s", err)
-		return err
+		return -1, "", err
 	}
 	out.Close()
 	if remoteInfo.Exists("ID") {
 		// TODO ...
 	}
 
-	return nil
+	return remoteInfo.GetInt("Code"), remoteInfo.Get("Cause"), nil
 }
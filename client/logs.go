@@ -0,0 +1,65 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdLogs(args ...string) error {
+	var opts struct {
+		Follow bool   `short:"f" long:"follow" default:"false" default-mask:"-" description:"Follow the log output until the pod stops"`
+		Since  string `long:"since" value-name:"\"\"" description:"Show the logs since the time, in RFC3339, unix seconds or relative such as 10m"`
+		Tail   string `long:"tail" default:"all" value-name:"all" description:"Number of lines to show from the end of the logs"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "logs [-f] [--since TIME] [--tail N] CONTAINER_ID\n\nfetch the logs of a container"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"logs\" requires a minimum of 1 argument, please provide container ID.\n")
+	}
+
+	v := url.Values{}
+	v.Set("container", args[1])
+	if opts.Follow {
+		v.Set("follow", "yes")
+	}
+	v.Set("since", opts.Since)
+	v.Set("tail", opts.Tail)
+	body, _, _, err := cli.clientRequest("GET", "/container/logs?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		entry := &types.ContainerLogEntry{}
+		if err := decoder.Decode(entry); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		out := cli.out
+		if entry.Stream == "stderr" {
+			out = cli.err
+		}
+		if _, err := io.WriteString(out, entry.Log); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdMigrate(args ...string) error {
+	var opts struct {
+		To string `long:"to" value-name:"tcp://host:port" description:"Address of the target hyperd, such as unix:///var/run/hyper-2.sock or tcp://192.168.1.2:1246"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "migrate --to tcp://host:port|unix:///path POD_ID\n\nmove a running pod to another hyperd, the one on another host should share the storage with this one"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"migrate\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+	if opts.To == "" {
+		return fmt.Errorf("\"migrate\" requires the target hyperd, please provide it with --to.\n")
+	}
+
+	podId := args[1]
+	code, cause, err := cli.MigratePod(podId, opts.To)
+	if err != nil {
+		return err
+	}
+	if code != types.E_OK {
+		return fmt.Errorf("Error code is %d, cause is %s", code, cause)
+	}
+	fmt.Printf("Successfully migrated the POD %s to %s\n", podId, opts.To)
+	return nil
+}
+
+func (cli *HyperClient) MigratePod(podId, to string) (int, string, error) {
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("to", to)
+	body, _, err := readBody(cli.call("POST", "/pod/migrate?"+v.Encode(), nil, nil))
+	if err != nil {
+		return -1, "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, "", err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return -1, "", fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo.GetInt("Code"), remoteInfo.Get("Cause"), nil
+}
+
+// IncomingPod asks the daemon to prepare a VM for the pod migrated from
+// another daemon, with shareDir, the share dir of the source VM, mounted.
+// The VM listens on a tcp port of host, the address the daemon is reached
+// at, if it is set. It returns the VM id and the uri the VM listens on.
+func (cli *HyperClient) IncomingPod(podId string, cpu, mem int, podArgs, vmData string, containers []string, shareDir, host string) (string, string, error) {
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("cpu", fmt.Sprintf("%d", cpu))
+	v.Set("mem", fmt.Sprintf("%d", mem))
+	v.Set("podArgs", podArgs)
+	v.Set("vmData", vmData)
+	v.Set("containers", strings.Join(containers, ":"))
+	v.Set("shareDir", shareDir)
+	v.Set("migrateHost", host)
+	body, _, err := readBody(cli.call("POST", "/pod/incoming?"+v.Encode(), nil, nil))
+	if err != nil {
+		return "", "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return "", "", fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	if remoteInfo.GetInt("Code") != types.E_OK {
+		return "", "", fmt.Errorf("Error code is %d, cause is %s", remoteInfo.GetInt("Code"), remoteInfo.Get("Cause"))
+	}
+	return remoteInfo.Get("ID"), remoteInfo.Get("Uri"), nil
+}
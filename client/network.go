@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdNetwork(args ...string) error {
+	return fmt.Errorf("\"network\" requires a subcommand, please use \"network create\", \"network ls\" or \"network rm\".\n")
+}
+
+func (cli *HyperClient) HyperCmdNetworkCreate(args ...string) error {
+	var opts struct {
+		Subnet  string `long:"subnet" value-name:"\"\"" description:"The subnet of the network in CIDR format, e.g. 10.10.0.0/24"`
+		Subnet6 string `long:"subnet6" value-name:"\"\"" description:"The IPv6 subnet of the network, e.g. fd00:10::/64, the network is IPv4 only if it is not given"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "network create --subnet SUBNET [--subnet6 SUBNET] NAME\n\ncreate a network, the pods on it can not reach the pods on the other networks"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"network create\" requires a minimum of 1 argument, please provide the network name.\n")
+	}
+	if opts.Subnet == "" {
+		return fmt.Errorf("Please specify the subnet of the network with --subnet")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	v.Set("subnet", opts.Subnet)
+	v.Set("subnet6", opts.Subnet6)
+	remoteInfo, err := cli.networkCall("POST", "/network/create", v)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Network %s is created on bridge %s\n", remoteInfo.Get("Name"), remoteInfo.Get("Bridge"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdNetworkLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "network ls\n\nlist the networks"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	remoteInfo, err := cli.networkCall("GET", "/network/list", url.Values{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-15s%-16s%-20s%s\n", "Network", "Bridge", "Subnet", "IPv6 Subnet")
+	for _, nw := range remoteInfo.GetList("networkData") {
+		fields := strings.SplitN(nw, ":", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		fmt.Printf("%-15s%-16s%-20s%s\n", fields[0], fields[1], fields[2], fields[3])
+	}
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdNetworkRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "network rm NAME [NAME...]\n\nremove the networks which are not used by any pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"network rm\" requires a minimum of 1 argument, please provide the network name.\n")
+	}
+
+	for _, name := range args[2:] {
+		v := url.Values{}
+		v.Set("name", name)
+		if _, err := cli.networkCall("POST", "/network/remove", v); err != nil {
+			return err
+		}
+		fmt.Printf("Network %s is removed\n", name)
+	}
+	return nil
+}
+
+func (cli *HyperClient) networkCall(method, path string, v url.Values) (*engine.Env, error) {
+	body, _, err := readBody(cli.call(method, path+"?"+v.Encode(), nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return nil, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo, nil
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdPause(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "pause POD_ID\n\nfreeze all the processes of a running pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"pause\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+
+	podId := args[1]
+	code, cause, err := cli.PausePod(podId, true)
+	if err != nil {
+		return err
+	}
+	if code != types.E_OK {
+		return fmt.Errorf("Error code is %d, cause is %s", code, cause)
+	}
+	fmt.Printf("Successfully paused the POD: %s\n", podId)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdUnpause(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "unpause POD_ID\n\nresume a paused pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"unpause\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+
+	podId := args[1]
+	code, cause, err := cli.PausePod(podId, false)
+	if err != nil {
+		return err
+	}
+	if code != types.E_OK {
+		return fmt.Errorf("Error code is %d, cause is %s", code, cause)
+	}
+	fmt.Printf("Successfully unpaused the POD: %s\n", podId)
+	return nil
+}
+
+// PausePod pauses the pod, or unpauses it if pause is false
+func (cli *HyperClient) PausePod(podId string, pause bool) (int, string, error) {
+	path := "/pod/pause?"
+	if !pause {
+		path = "/pod/unpause?"
+	}
+	v := url.Values{}
+	v.Set("podId", podId)
+	body, _, err := readBody(cli.call("POST", path+v.Encode(), nil, nil))
+	if err != nil {
+		return -1, "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, "", err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return -1, "", fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo.GetInt("Code"), remoteInfo.Get("Cause"), nil
+}
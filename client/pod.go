@@ -16,6 +16,9 @@ import (
 
 // We need to process the POD json data with the given file
 func (cli *HyperClient) HyperCmdPod(args ...string) error {
+	if len(args) > 0 && args[0] == "add-container" {
+		return cli.HyperCmdPodAddContainer(args[1:]...)
+	}
 	t1 := time.Now()
 	var parser = gflag.NewParser(nil, gflag.Default)
 	parser.Usage = "pod POD_FILE\n\nCreate a pod, initialize a pod and run it"
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"hyper/engine"
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdResize(args ...string) error {
+	var opts struct {
+		Cpu    int `long:"cpu" value-name:"0" description:"CPU number of the POD, no more than the max CPU it started with"`
+		Memory int `long:"memory" value-name:"0" description:"Memory size (MB) of the POD, no more than the size it started with"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "resize [--cpu N] [--memory N] POD_ID\n\nchange the CPU number or memory size of a running pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"resize\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+	if opts.Cpu <= 0 && opts.Memory <= 0 {
+		return fmt.Errorf("\"resize\" requires the CPU number or memory size, please provide it with --cpu or --memory.\n")
+	}
+
+	podId := args[1]
+	code, cause, cpu, memory, err := cli.ResizePod(podId, opts.Cpu, opts.Memory)
+	if err != nil {
+		return err
+	}
+	if code != types.E_OK {
+		return fmt.Errorf("Error code is %d, cause is %s", code, cause)
+	}
+	fmt.Printf("Successfully resized the POD %s", podId)
+	if opts.Cpu > 0 {
+		fmt.Printf(", CPU number is %d", cpu)
+	}
+	if opts.Memory > 0 {
+		fmt.Printf(", memory is %d MB", memory)
+	}
+	fmt.Printf(" now\n")
+	return nil
+}
+
+// ResizePod changes the CPU number or memory size of the pod, either is
+// skipped if it is 0. It returns the size the pod actually has.
+func (cli *HyperClient) ResizePod(podId string, cpu, memory int) (int, string, int, int, error) {
+	v := url.Values{}
+	v.Set("podId", podId)
+	if cpu > 0 {
+		v.Set("cpu", strconv.Itoa(cpu))
+	}
+	if memory > 0 {
+		v.Set("memory", strconv.Itoa(memory))
+	}
+	body, _, err := readBody(cli.call("POST", "/pod/resize?"+v.Encode(), nil, nil))
+	if err != nil {
+		return -1, "", 0, 0, err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, "", 0, 0, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return -1, "", 0, 0, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo.GetInt("Code"), remoteInfo.Get("Cause"), remoteInfo.GetInt("Cpu"), remoteInfo.GetInt("Memory"), nil
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -24,16 +25,21 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 	var opts struct {
 		PodFile       string   `short:"p" long:"podfile" value-name:"\"\"" description:"Create and Run a pod based on the pod file"`
 		K8s           string   `short:"k" long:"kubernetes" value-name:"\"\"" description:"Create and Run a pod based on the kubernetes pod file"`
+		Compose       string   `long:"compose" value-name:"\"\"" description:"Create and Run a pod based on the docker compose file, all the services of which run in the pod"`
 		Yaml          bool     `short:"y" long:"yaml" default:"false" default-mask:"-" description:"Create a pod based on Yaml file"`
 		Name          string   `long:"name" value-name:"\"\"" description:"Assign a name to the container"`
 		Attach        bool     `long:"attach" default:"true" default-mask:"-" description:"Attach the stdin, stdout and stderr to the container"`
 		Workdir       string   `long:"workdir" default:"/" value-name:"\"\"" default-mask:"-" description:"Working directory inside the container"`
 		Tty           bool     `long:"tty" default:"true" default-mask:"-" description:"Allocate a pseudo-TTY"`
 		Cpu           int      `long:"cpu" default:"1" value-name:"1" default-mask:"-" description:"CPU number for the VM"`
+		MaxCpu        int      `long:"max-cpu" default:"0" value-name:"0" default-mask:"-" description:"Maximum CPU number the VM could be resized to"`
 		Memory        int      `long:"memory" default:"128" value-name:"128" default-mask:"-" description:"Memory size (MB) for the VM"`
 		Env           []string `long:"env" value-name:"[]" default-mask:"-" description:"Set environment variables"`
 		EntryPoint    string   `long:"entrypoint" value-name:"\"\"" default-mask:"-" description:"Overwrite the default ENTRYPOINT of the image"`
 		RestartPolicy string   `long:"restart" default:"never" value-name:"\"\"" default-mask:"-" description:"Restart policy to apply when a container exits (never, onFailure, always)"`
+		Network       string   `long:"network" value-name:"\"\"" default-mask:"-" description:"Connect the pod to a network created by 'network create'"`
+		Ip            string   `long:"ip" value-name:"\"\"" default-mask:"-" description:"Static IP address of the pod, allocated if not given"`
+		Mac           string   `long:"mac" value-name:"\"\"" default-mask:"-" description:"Static MAC address of the pod, a random one if not given"`
 	}
 
 	var parser = gflag.NewParser(&opts, gflag.Default|gflag.IgnoreUnknown)
@@ -94,10 +100,13 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 		if err := json.Unmarshal(jsonbody, &kpod); err != nil {
 			return err
 		}
-		pod, err = kpod.Convert()
+		pod, warnings, err := kpod.Convert()
 		if err != nil {
 			return err
 		}
+		for _, w := range warnings {
+			fmt.Fprintf(cli.err, "Warning: %s\n", w)
+		}
 		jsonbody, err = json.Marshal(*pod)
 		if err != nil {
 			return err
@@ -113,6 +122,46 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 		return nil
 	}
 
+	if opts.Compose != "" {
+		body, err := ioutil.ReadFile(opts.Compose)
+		if err != nil {
+			return err
+		}
+		dir, err := filepath.Abs(filepath.Dir(opts.Compose))
+		if err != nil {
+			return err
+		}
+		cf, warnings, err := pod.ParseCompose(body, dir)
+		if err != nil {
+			return err
+		}
+		// the pod is named after the directory, like the project of compose
+		name := opts.Name
+		if name == "" {
+			name = filepath.Base(dir)
+		}
+		userPod, more, err := cf.Convert(name)
+		if err != nil {
+			return err
+		}
+		for _, w := range append(warnings, more...) {
+			fmt.Fprintf(cli.err, "Warning: %s\n", w)
+		}
+		jsonbody, err := json.Marshal(*userPod)
+		if err != nil {
+			return err
+		}
+		t1 := time.Now()
+		podId, err := cli.RunPod(string(jsonbody))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("POD id is %s\n", podId)
+		t2 := time.Now()
+		fmt.Printf("Time to run a POD is %d ms\n", (t2.UnixNano()-t1.UnixNano())/1000000)
+		return nil
+	}
+
 	if len(args) == 0 {
 		return fmt.Errorf("%s: \"run\" requires a minimum of 1 argument, please provide the image.", os.Args[0])
 	}
@@ -162,16 +211,17 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 	var userPod = &pod.UserPod{
 		Name:       opts.Name,
 		Containers: containerList,
-		Resource:   pod.UserResource{Vcpu: opts.Cpu, Memory: opts.Memory},
+		Resource:   pod.UserResource{Vcpu: opts.Cpu, MaxVcpu: opts.MaxCpu, Memory: opts.Memory},
 		Files:      []pod.UserFile{},
 		Volumes:    []pod.UserVolume{},
 		Tty:        opts.Tty,
 	}
-	/*
-		if err := userPod.Validate(); err != nil {
-			return err
-		}
-	*/
+	if opts.Network != "" || opts.Ip != "" || opts.Mac != "" {
+		userPod.Interfaces = []pod.UserInterface{{Network: opts.Network, Ip: opts.Ip, Mac: opts.Mac}}
+	}
+	if err := userPod.Validate(); err != nil {
+		return err
+	}
 	jsonString, _ := json.Marshal(userPod)
 	podId, err := cli.RunPod(string(jsonString))
 	if err != nil {
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdVolume(args ...string) error {
+	return fmt.Errorf("\"volume\" requires a subcommand, please use \"volume attach\" or \"volume detach\".\n")
+}
+
+func (cli *HyperClient) HyperCmdVolumeAttach(args ...string) error {
+	var opts struct {
+		Containers []string `short:"c" long:"container" value-name:"[]" description:"The containers to mount the volume, all the containers of the pod by default"`
+		ReadOnly   bool     `short:"r" long:"readonly" default:"false" default-mask:"-" description:"Mount the volume read only"`
+		Source     string   `long:"source" value-name:"\"\"" description:"The source of a new volume, a dir or an image file"`
+		Driver     string   `long:"driver" value-name:"\"\"" description:"The driver of a new volume, vfs, raw or qcow2"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "volume attach [OPTIONS] POD_ID VOLUME:PATH\n\nmount a volume to the containers of a running pod, the volume is created if it is not in the pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("\"volume attach\" requires a minimum of 2 arguments, please provide POD ID and VOLUME:PATH.\n")
+	}
+	podId := args[2]
+	fields := strings.SplitN(args[3], ":", 2)
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return fmt.Errorf("Invalid volume %s, should be VOLUME:PATH", args[3])
+	}
+
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("volume", fields[0])
+	v.Set("path", fields[1])
+	if opts.ReadOnly {
+		v.Set("readOnly", "yes")
+	}
+	v.Set("source", opts.Source)
+	v.Set("driver", opts.Driver)
+	if err := cli.volumeCall("attach", v, opts.Containers); err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s is attached to the POD %s\n", fields[0], podId)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdVolumeDetach(args ...string) error {
+	var opts struct {
+		Containers []string `short:"c" long:"container" value-name:"[]" description:"The containers to unmount the volume, all the containers using it by default"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "volume detach [OPTIONS] POD_ID VOLUME\n\nunmount a volume from the containers of a running pod, the volume is removed once no container uses it"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("\"volume detach\" requires a minimum of 2 arguments, please provide POD ID and VOLUME.\n")
+	}
+	podId := args[2]
+
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("volume", args[3])
+	if err := cli.volumeCall("detach", v, opts.Containers); err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s is detached from the POD %s\n", args[3], podId)
+	return nil
+}
+
+// volumeCall attaches or detaches the volume of the containers
+func (cli *HyperClient) volumeCall(op string, v url.Values, containers []string) error {
+	if len(containers) > 0 {
+		list, err := json.Marshal(containers)
+		if err != nil {
+			return err
+		}
+		v.Set("containers", string(list))
+	}
+	body, _, err := readBody(cli.call("POST", "/volume/"+op+"?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	if code := remoteInfo.GetInt("Code"); code != types.E_OK {
+		return fmt.Errorf("Error code is %d, cause is %s", code, remoteInfo.Get("Cause"))
+	}
+	return nil
+}
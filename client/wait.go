@@ -0,0 +1,67 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdWait(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "wait POD_ID\n\nblock until a pod finishes, and exit with the code of its first failing container"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("\"wait\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+
+	podId := args[1]
+	exitCode, failed, containers, err := cli.WaitPod(podId)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		fields := strings.SplitN(c, ":", 2)
+		if len(fields) == 2 {
+			fmt.Fprintf(cli.out, "%s\t%s\n", fields[0], fields[1])
+		}
+	}
+	if exitCode != 0 {
+		fmt.Fprintf(cli.err, "Container %s of POD %s exited with code %d\n", failed, podId, exitCode)
+		os.Exit(exitCode)
+	}
+	return nil
+}
+
+// WaitPod blocks until the pod finishes. It returns the exit code and ID of
+// the first failing container, and the "ID:code" of all the containers.
+func (cli *HyperClient) WaitPod(podId string) (int, string, []string, error) {
+	v := url.Values{}
+	v.Set("podId", podId)
+	body, _, err := readBody(cli.call("POST", "/pod/wait?"+v.Encode(), nil, nil))
+	if err != nil {
+		return -1, "", nil, err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, "", nil, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return -1, "", nil, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo.GetInt("ExitCode"), remoteInfo.Get("Container"), remoteInfo.GetList("Containers"), nil
+}
@@ -34,6 +34,9 @@ func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 	if err != nil {
 		return err
 	}
+	if pod, ok := daemon.podList[podName]; ok && pod.Status == types.S_POD_PAUSED {
+		return fmt.Errorf("The POD %s is paused, unpause it before attach", podName)
+	}
 	var (
 		ttyIO        qemu.TtyIO
 		qemuCallback = make(chan *types.QemuResponse, 1)
@@ -59,7 +62,10 @@ func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 	}
 	qemuEvent.(chan qemu.QemuEvent) <- attachCommand
 
-	<-qemuCallback
+	rsp := <-qemuCallback
+	if rsp != nil && rsp.Code == types.E_FAILED {
+		return fmt.Errorf("%s", rsp.Cause)
+	}
 	defer func() {
 		glog.V(2).Info("Defer function for exec!")
 	}()
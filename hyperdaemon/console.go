@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"hyper/engine"
+	"hyper/qemu"
+)
+
+func (daemon *Daemon) CmdVmConsole(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not read the console without VM ID")
+	}
+	vmId := job.Args[0]
+	follow := len(job.Args) > 1 && job.Args[1] == "yes"
+
+	return daemon.ReadConsole(vmId, follow, job.Stdout)
+}
+
+// ReadConsole writes the console log of the VM to w, the log is kept after
+// the VM quits. If follow is set, it keeps writing the new output until the
+// VM quits.
+func (daemon *Daemon) ReadConsole(vmId string, follow bool, w io.Writer) error {
+	name := qemu.ConsoleLogPath(vmId)
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Can not find the console log of VM(%s)", vmId)
+		}
+		return err
+	}
+	defer func() {
+		file.Close()
+	}()
+
+	// the rotated log is older
+	if rotated, err := os.Open(name + ".1"); err == nil {
+		_, err = io.Copy(w, rotated)
+		rotated.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	var stopped chan bool
+	if follow {
+		stopped = daemon.watchVm(vmId)
+		defer daemon.vmWatchers.unwatch(vmId, stopped)
+	}
+	for {
+		quit := !follow
+		select {
+		case <-stopped:
+			quit = true
+		default:
+		}
+		if _, err := io.Copy(w, file); err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+
+		// wait for the new output, or the VM to quit
+		select {
+		case <-stopped:
+		case <-time.After(200 * time.Millisecond):
+		}
+		// continue with the new log once rotated
+		current, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if info, err := file.Stat(); err == nil && !os.SameFile(info, current) {
+			if _, err := io.Copy(w, file); err != nil {
+				return err
+			}
+			file.Close()
+			if file, err = os.Open(name); err != nil {
+				return err
+			}
+		}
+	}
+}
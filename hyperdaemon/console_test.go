@@ -0,0 +1,51 @@
+package daemon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"hyper/qemu"
+)
+
+func TestReadConsoleFollow(t *testing.T) {
+	vmId := "vm-consoletest"
+	name := qemu.ConsoleLogPath(vmId)
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path.Dir(name))
+	if err := ioutil.WriteFile(name, []byte("booting\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := &Daemon{vmList: map[string]*Vm{vmId: &Vm{Id: vmId}}}
+	out := &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- daemon.ReadConsole(vmId, true, out)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	daemon.RemoveVm(vmId)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the console is still followed after the VM quits")
+	}
+	if out.String() != "booting\n" {
+		t.Errorf("wrong console log %q", out.String())
+	}
+
+	// the console of the quitted VM is not followed
+	out.Reset()
+	if err := daemon.ReadConsole(vmId, true, out); err != nil || out.String() != "booting\n" {
+		t.Errorf("failed to read the console log %q: %v", out.String(), err)
+	}
+}
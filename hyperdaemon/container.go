@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"syscall"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/qemu"
+	"hyper/storage/aufs"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdPodAddContainer(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not add a container without POD ID and container spec")
+	}
+	podId := job.Args[0]
+	containerArgs := job.Args[1]
+
+	code, cause, containerId, err := daemon.AddContainer(podId, containerArgs)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.Set("ContainerID", containerId)
+	v.SetInt("Code", code)
+	v.Set("Cause", cause)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddContainer creates a container from the spec and starts it in the
+// running pod, the container is then a part of the pod spec in the DB, and
+// of the persist info of the VM.
+func (daemon *Daemon) AddContainer(podId, containerArgs string) (int, string, string, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return -1, "", "", fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	if mypod.Status != types.S_POD_RUNNING || mypod.Vm == "" {
+		return -1, "", "", fmt.Errorf("The POD(%s) is not running, can not add container to it", podId)
+	}
+
+	spec := &pod.UserContainer{}
+	if err := json.Unmarshal([]byte(containerArgs), spec); err != nil {
+		return -1, "", "", fmt.Errorf("Invalid container spec: %s", err.Error())
+	}
+	if spec.Image == "" {
+		return -1, "", "", fmt.Errorf("Please specify the image of the container")
+	}
+	if len(spec.Ports) > 0 {
+		return -1, "", "", fmt.Errorf("Can not map ports of a container added to a running POD")
+	}
+
+	podData, err := daemon.GetPodByName(podId)
+	if err != nil {
+		return -1, "", "", err
+	}
+	userPod, err := pod.ProcessPodBytes(podData)
+	if err != nil {
+		return -1, "", "", err
+	}
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = userPod.Containers[0].RestartPolicy
+	}
+	userPod.Containers = append(userPod.Containers, *spec)
+	if err := userPod.Validate(); err != nil {
+		return -1, "", "", err
+	}
+	podData, err = json.Marshal(userPod)
+	if err != nil {
+		return -1, "", "", err
+	}
+
+	qemuPodEvent, _, _, err := daemon.GetQemuChan(mypod.Vm)
+	if err != nil {
+		return -1, "", "", err
+	}
+
+	body, _, err := daemon.dockerCli.SendCmdCreate(spec.Image)
+	if err != nil {
+		return -1, "", "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, "", "", err
+	}
+	if _, err := out.Write(body); err != nil {
+		return -1, "", "", fmt.Errorf("Error while reading remote info!\n")
+	}
+	out.Close()
+
+	containerId := remoteInfo.Get("Id")
+	daemon.SetPodByContainer(containerId, podId, "", "", []string{}, types.S_POD_CREATED)
+	c := daemon.containerList[len(daemon.containerList)-1]
+
+	files := make(map[string](pod.UserFile))
+	for _, v := range userPod.Files {
+		files[v.Name] = v
+	}
+	sharedDir := path.Join(qemu.BaseDir, mypod.Vm, qemu.ShareDirTag)
+	info, err := daemon.prepareContainer(c, spec, files, sharedDir)
+	if err != nil {
+		daemon.dropContainer(c, nil, sharedDir)
+		return -1, "", "", err
+	}
+
+	addEvent := &qemu.NewContainerCommand{
+		Spec:     spec,
+		Info:     info,
+		Callback: make(chan *types.QemuResponse, 1),
+	}
+	qemuPodEvent.(chan qemu.QemuEvent) <- addEvent
+	qemuResponse := <-addEvent.Callback
+	glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+	if data, ok := qemuResponse.Data.([]byte); ok {
+		daemon.UpdateVmData(mypod.Vm, data)
+	}
+	if qemuResponse.Code != types.E_OK {
+		daemon.dropContainer(c, info, sharedDir)
+		return qemuResponse.Code, qemuResponse.Cause, "", nil
+	}
+
+	c.Status = types.S_POD_RUNNING
+	mypod.Containers = append(mypod.Containers, c)
+	if err := daemon.WritePodToDB(podId, podData); err != nil {
+		glog.Error("Found an error while saving the POD file")
+		return -1, "", "", err
+	}
+	if err := daemon.WritePodAndContainers(podId); err != nil {
+		glog.Error("Found an error while saving the Containers info")
+		return -1, "", "", err
+	}
+
+	return qemuResponse.Code, qemuResponse.Cause, containerId, nil
+}
+
+// dropContainer removes the container which could not be added to the pod,
+// info is nil if its rootfs is not prepared.
+func (daemon *Daemon) dropContainer(c *Container, info *qemu.ContainerInfo, sharedDir string) {
+	if info != nil && info.Fstype == "dir" {
+		mount := path.Join(sharedDir, info.Image)
+		var err error
+		if daemon.Storage.StorageType == "aufs" {
+			err = aufs.Unmount(mount)
+		} else {
+			err = syscall.Unmount(mount, 0)
+		}
+		if err != nil {
+			glog.Warningf("Cannot umount %s: %s", mount, err.Error())
+		}
+	}
+	if _, _, err := daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
+		glog.V(1).Infof("Error to rm container: %s", err.Error())
+	}
+	removeContainerLog(c.Id)
+	for i, v := range daemon.containerList {
+		if v == c {
+			daemon.containerList = append(daemon.containerList[:i], daemon.containerList[i+1:]...)
+			break
+		}
+	}
+}
@@ -1,20 +1,24 @@
 package daemon
 
 import (
+	"encoding/json"
 	"fmt"
 	"hyper/docker"
 	"hyper/engine"
 	"hyper/lib/glog"
 	"hyper/lib/portallocator"
 	"hyper/network"
+	"hyper/qemu"
 	apiserver "hyper/server"
 	dm "hyper/storage/devicemapper"
 	"hyper/types"
 	"os"
+	"path"
 	"sync"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Unknwon/goconfig"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -26,6 +30,7 @@ type Vm struct {
 	Pod                *Pod
 	Status             uint
 	Cpu                int
+	MaxCpu             int
 	Mem                int
 	qemuChan           interface{}
 	mainQemuClientChan interface{}
@@ -50,6 +55,15 @@ type Container struct {
 	Image  string
 	Cmds   []string
 	Status uint
+	// ExitCode and FinishedAt are set once the pod of the container
+	// finished, FinishedAt is zero before that.
+	ExitCode   int
+	FinishedAt time.Time
+}
+
+type containerExit struct {
+	ExitCode   int       `json:"exitCode"`
+	FinishedAt time.Time `json:"finishedAt"`
 }
 
 type Storage struct {
@@ -79,6 +93,10 @@ type Daemon struct {
 	BridgeIP          string
 	Host              string
 	Storage           *Storage
+	vmPool            *VmPool
+	snapshots         *VmSnapshots
+	vmWatchers        watchers
+	podWatchers       watchers
 }
 
 // Install installs daemon capabilities to eng.
@@ -95,6 +113,21 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"podRm":             daemon.CmdPodRm,
 		"podRun":            daemon.CmdPodRun,
 		"podStop":           daemon.CmdPodStop,
+		"podMigrate":        daemon.CmdPodMigrate,
+		"podIncoming":       daemon.CmdPodIncoming,
+		"podPause":          daemon.CmdPodPause,
+		"podUnpause":        daemon.CmdPodUnpause,
+		"podResize":         daemon.CmdPodResize,
+		"podWait":           daemon.CmdPodWait,
+		"podAddContainer":   daemon.CmdPodAddContainer,
+		"podExport":         daemon.CmdPodExport,
+		"volumeAttach":      daemon.CmdVolumeAttach,
+		"volumeDetach":      daemon.CmdVolumeDetach,
+		"networkCreate":     daemon.CmdNetworkCreate,
+		"networkList":       daemon.CmdNetworkList,
+		"networkRemove":     daemon.CmdNetworkRemove,
+		"vmConsole":         daemon.CmdVmConsole,
+		"containerLogs":     daemon.CmdLogs,
 		"vmCreate":          daemon.CmdVmCreate,
 		"vmKill":            daemon.CmdVmKill,
 		"list":              daemon.CmdList,
@@ -114,6 +147,7 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 
 func (daemon *Daemon) Restore() error {
 	if daemon.GetPodNum() == 0 {
+		network.CleanupPortMaps()
 		return nil
 	}
 
@@ -204,11 +238,31 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 	glog.V(0).Infof("The config: kernel=%s, initrd=%s", kernel, initrd)
 	biface, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Bridge")
 	bridgeip, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "BridgeIP")
-	glog.V(0).Infof("The config: bridge=%s, ip=%s", biface, bridgeip)
+	bridgeip6, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "BridgeIPv6")
+	glog.V(0).Infof("The config: bridge=%s, ip=%s, ipv6=%s", biface, bridgeip, bridgeip6)
 	bios, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Bios")
 	cbfs, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Cbfs")
 	glog.V(0).Infof("The config: bios=%s, cbfs=%s", bios, cbfs)
 	host, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Host")
+	hypervisor, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Hypervisor")
+	if err := qemu.SelectDriver(hypervisor); err != nil {
+		glog.Errorf("%s", err.Error())
+		return nil, err
+	}
+	poolConf, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "VmPool")
+	poolSizes, err := ParseVmPool(poolConf)
+	if err != nil {
+		glog.Errorf("%s", err.Error())
+		return nil, err
+	}
+	var snapshots *VmSnapshots
+	if cfg.MustBool(goconfig.DEFAULT_SECTION, "VmSnapshot", false) {
+		snapshots, err = NewVmSnapshots(path.Join(qemu.BaseDir, "snapshots"))
+		if err != nil {
+			glog.Errorf("Failed to prepare the VM snapshots: %s", err.Error())
+			return nil, err
+		}
+	}
 
 	var tempdir = "/var/run/hyper/"
 	os.Setenv("TMPDIR", tempdir)
@@ -222,7 +276,7 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 		return nil, err
 	}
 
-	if err := network.InitNetwork(biface, bridgeip); err != nil {
+	if err := network.InitNetwork(biface, bridgeip, bridgeip6); err != nil {
 		glog.Errorf("InitNetwork failed, %s\n", err.Error())
 		return nil, err
 	}
@@ -260,6 +314,8 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 		qemuClientChan:    qemuclient,
 		subQemuClientChan: subQemuClient,
 		Host:              host,
+		vmPool:            NewVmPool(poolSizes),
+		snapshots:         snapshots,
 	}
 
 	stor := &Storage{}
@@ -352,11 +408,15 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 	} else {
 		daemon.CleanVolume(0)
 	}
+	if err := daemon.RestoreNetworks(); err != nil {
+		return nil, err
+	}
 	eng.OnShutdown(func() {
 		if err := daemon.shutdown(); err != nil {
 			glog.Errorf("Error during daemon.shutdown(): %v", err)
 		}
 	})
+	daemon.FillVmPool()
 
 	return daemon, nil
 }
@@ -517,6 +577,13 @@ func (daemon *Daemon) WritePodAndContainers(podName string) error {
 	return nil
 }
 
+// WritePodContainers stores the containers of a pod which is not created
+// yet, CreatePod would use them instead of creating new ones.
+func (daemon *Daemon) WritePodContainers(podName string, containers []string) error {
+	key := fmt.Sprintf("pod-container-%s", podName)
+	return (daemon.db).Put([]byte(key), []byte(strings.Join(containers, ":")), nil)
+}
+
 func (daemon *Daemon) GetPodContainersByName(podName string) ([]string, error) {
 	key := fmt.Sprintf("pod-container-%s", podName)
 	data, err := (daemon.db).Get([]byte(key), nil)
@@ -651,7 +718,7 @@ func (daemon *Daemon) GetPodByContainer(containerId string) (string, error) {
 			break
 		}
 	}
-	if c.Id != containerId {
+	if c == nil || c.Id != containerId {
 		return "", fmt.Errorf("Can not find that container!")
 	}
 
@@ -671,6 +738,7 @@ func (daemon *Daemon) RemovePod(podId string) {
 		}
 	}
 	delete(daemon.podList, podId)
+	daemon.podWatchers.wake(podId)
 }
 
 func (daemon *Daemon) AddVm(vm *Vm) {
@@ -679,6 +747,7 @@ func (daemon *Daemon) AddVm(vm *Vm) {
 
 func (daemon *Daemon) RemoveVm(vmId string) {
 	delete(daemon.vmList, vmId)
+	daemon.vmWatchers.wake(vmId)
 }
 
 func (daemon *Daemon) SetContainerStatus(podId string, status uint) {
@@ -689,13 +758,23 @@ func (daemon *Daemon) SetContainerStatus(podId string, status uint) {
 
 func (daemon *Daemon) SetPodContainerStatus(podId string, data []uint32) {
 	failure := 0
+	now := time.Now()
 	for i, c := range daemon.podList[podId].Containers {
+		if i >= len(data) {
+			glog.Warningf("No exit code of container %s in POD(%s)", c.Id, podId)
+			break
+		}
 		if data[i] != 0 {
 			failure++
 			c.Status = types.S_POD_FAILED
 		} else {
 			c.Status = types.S_POD_SUCCEEDED
 		}
+		c.ExitCode = int(data[i])
+		c.FinishedAt = now
+		if err := daemon.WriteContainerExit(c); err != nil {
+			glog.Errorf("Failed to store the exit code of container %s: %s", c.Id, err.Error())
+		}
 	}
 	if failure == 0 {
 		daemon.podList[podId].Status = types.S_POD_SUCCEEDED
@@ -704,6 +783,45 @@ func (daemon *Daemon) SetPodContainerStatus(podId string, data []uint32) {
 	}
 }
 
+func (daemon *Daemon) WriteContainerExit(c *Container) error {
+	key := fmt.Sprintf("exit-%s", c.Id)
+	data, err := json.Marshal(&containerExit{
+		ExitCode:   c.ExitCode,
+		FinishedAt: c.FinishedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return (daemon.db).Put([]byte(key), data, nil)
+}
+
+// GetContainerExit loads the exit code of the container stored in the db,
+// the container is left unchanged if it has not finished yet.
+func (daemon *Daemon) GetContainerExit(c *Container) error {
+	key := fmt.Sprintf("exit-%s", c.Id)
+	data, err := (daemon.db).Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	exit := &containerExit{}
+	if err := json.Unmarshal(data, exit); err != nil {
+		return err
+	}
+	c.ExitCode = exit.ExitCode
+	c.FinishedAt = exit.FinishedAt
+	return nil
+}
+
+func (daemon *Daemon) DeleteContainerExit(c *Container) error {
+	c.ExitCode = 0
+	c.FinishedAt = time.Time{}
+	key := fmt.Sprintf("exit-%s", c.Id)
+	return (daemon.db).Delete([]byte(key), nil)
+}
+
 func (daemon *Daemon) UpdateVmData(vmId string, data []byte) error {
 	key := fmt.Sprintf("vmdata-%s", vmId)
 	_, err := (daemon.db).Get([]byte(key), nil)
@@ -756,6 +874,7 @@ func (daemon *Daemon) CleanVolume(stop int) error {
 
 func (daemon *Daemon) DestroyAllVm() error {
 	glog.V(0).Info("The daemon will stop all pod")
+	daemon.vmPool.stop()
 	for _, pod := range daemon.podList {
 		daemon.StopPod(pod.Id, "yes")
 	}
@@ -770,6 +889,7 @@ func (daemon *Daemon) DestroyAllVm() error {
 }
 
 func (daemon *Daemon) DestroyAndKeepVm() error {
+	daemon.vmPool.stop()
 	for i := 0; i < 3; i++ {
 		code, err := daemon.ReleaseAllVms()
 		if err != nil && code == types.E_BUSY {
@@ -784,6 +904,7 @@ func (daemon *Daemon) DestroyAndKeepVm() error {
 func (daemon *Daemon) shutdown() error {
 	glog.V(0).Info("The daemon will be shutdown")
 	glog.V(0).Info("Shutdown all VMs")
+	daemon.vmPool.stop()
 	for vm, _ := range daemon.vmList {
 		daemon.KillVm(vm)
 	}
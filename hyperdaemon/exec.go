@@ -3,9 +3,12 @@ package daemon
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"strings"
 
 	"hyper/engine"
 	"hyper/lib/glog"
+	"hyper/lib/stdcopy"
 	"hyper/qemu"
 	"hyper/types"
 )
@@ -24,6 +27,10 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 		vmId    string
 		podId   string
 		command = []string{}
+		envs    = []qemu.VmEnvironmentVar{}
+		tty     = true
+		workdir string
+		user    string
 	)
 
 	if job.Args[2] != "" {
@@ -31,6 +38,17 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 			return err
 		}
 	}
+	// the command runs on a tty, unless the client asks for its exit code
+	if len(job.Args) > 7 {
+		tty = job.Args[4] != "no"
+		if job.Args[5] != "" {
+			if envs, err = parseExecEnvs(job.Args[5]); err != nil {
+				return err
+			}
+		}
+		workdir = job.Args[6]
+		user = job.Args[7]
+	}
 
 	// We need find the vm id which running POD, and stop it
 	if typeKey == "pod" {
@@ -48,9 +66,15 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 	if err != nil {
 		return err
 	}
+	if vm, ok := daemon.vmList[vmId]; ok && vm.Pod != nil && vm.Pod.Status == types.S_POD_PAUSED {
+		return fmt.Errorf("The POD %s is paused, unpause it before exec", vm.Pod.Id)
+	}
 
 	execCmd := &qemu.ExecCommand{
 		Command: command,
+		Envs:    envs,
+		Workdir: workdir,
+		User:    user,
 		Streams: &qemu.TtyIO{
 			Stdin:     job.Stdin,
 			Stdout:    job.Stdout,
@@ -59,6 +83,19 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 		},
 	}
 
+	// without tty, the stdout and stderr are multiplexed, and followed by
+	// the exit code of the command
+	var mux *stdcopy.Mux
+	if !tty {
+		mux = stdcopy.NewMux(job.Stdout)
+		// closing stdin closes the connection, which is kept for the exit code
+		execCmd.Streams.Stdin = ioutil.NopCloser(job.Stdin)
+		execCmd.Streams.Stdout = mux.Stream(stdcopy.Stdout)
+		execCmd.ErrStreams = &qemu.TtyIO{
+			Stdout: mux.Stream(stdcopy.Stderr),
+		}
+	}
+
 	if typeKey == "pod" {
 		execCmd.Container = ""
 	} else {
@@ -72,9 +109,45 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 
 	qemuEvent.(chan qemu.QemuEvent) <- execCmd
 
-	<-execCmd.Streams.Callback
+	rsp := <-execCmd.Streams.Callback
+	if rsp != nil && rsp.Code == types.E_FAILED {
+		if mux != nil {
+			fmt.Fprintf(execCmd.ErrStreams.Stdout, "%s\n", rsp.Cause)
+			mux.WriteExit(126)
+		}
+		return fmt.Errorf("%s", rsp.Cause)
+	}
+	if mux != nil {
+		code, ok := rsp.Data.(int)
+		if !ok {
+			fmt.Fprintf(execCmd.ErrStreams.Stdout, "The exit code of the command is unknown\n")
+			code = 255
+		}
+		glog.V(1).Infof("Exec command %v exited with %d", command, code)
+		if err := mux.WriteExit(code); err != nil {
+			return err
+		}
+	}
 	defer func() {
 		glog.V(2).Info("Defer function for exec!")
 	}()
 	return nil
 }
+
+// parseExecEnvs parses the environment variables of exec command, which
+// is a json list of NAME=VALUE.
+func parseExecEnvs(data string) ([]qemu.VmEnvironmentVar, error) {
+	list := []string{}
+	if err := json.Unmarshal([]byte(data), &list); err != nil {
+		return nil, err
+	}
+	envs := []qemu.VmEnvironmentVar{}
+	for _, e := range list {
+		fields := strings.SplitN(e, "=", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("Invalid environment variable %s, should be NAME=VALUE", e)
+		}
+		envs = append(envs, qemu.VmEnvironmentVar{Env: fields[0], Value: fields[1]})
+	}
+	return envs, nil
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"hyper/engine"
+	"hyper/pod"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdPodExport(job *engine.Job) error {
+	if len(job.Args) < 1 {
+		return fmt.Errorf("Can not export the POD without POD ID")
+	}
+	podId := job.Args[0]
+	userPod, err := daemon.ExportPod(podId)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(userPod)
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.Set("PodSpec", string(data))
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ExportPod rebuilds the spec of the pod from the one in the DB, which has
+// the containers and the volumes added to the running pod, and from the
+// VM of the pod, which could have been resized.
+func (daemon *Daemon) ExportPod(podId string) (*pod.UserPod, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return nil, fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	podData, err := daemon.GetPodByName(podId)
+	if err != nil {
+		return nil, err
+	}
+	userPod, err := pod.ProcessPodBytes(podData)
+	if err != nil {
+		return nil, err
+	}
+	if mypod.Name != "" {
+		userPod.Name = mypod.Name
+	}
+
+	if vm, ok := daemon.vmList[mypod.Vm]; ok && mypod.Status == types.S_POD_RUNNING {
+		if vm.Cpu > 0 {
+			userPod.Resource.Vcpu = vm.Cpu
+		}
+		if vm.Mem > 0 {
+			userPod.Resource.Memory = vm.Mem
+		}
+	}
+	return userPod, nil
+}
@@ -0,0 +1,579 @@
+// +build fakevm
+
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hyper/docker"
+	"hyper/engine"
+	"hyper/lib/stdcopy"
+	"hyper/lib/version"
+	"hyper/pod"
+	"hyper/qemu"
+	apiserver "hyper/server"
+	"hyper/types"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const fakePodArgs = `{"id":"fakepod","containers":[{"name":"c1","image":"busybox","command":["sh"]}],"resource":{"vcpu":1,"memory":128}}`
+
+// serveFakeDocker answers the docker API used to create the containers of
+// a pod on a unix socket in dir.
+func serveFakeDocker(t *testing.T, dir string) (string, func()) {
+	sock := path.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		lock  = &sync.Mutex{}
+		count = 0
+	)
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/containers/create"):
+			lock.Lock()
+			count++
+			id := fmt.Sprintf("fakecontainer%d", count)
+			lock.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"Id": id})
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/json"):
+			info := &docker.ConfigJSON{}
+			info.Config.Env = []string{"PATH=/bin"}
+			info.Config.Cmd = []string{"sh"}
+			json.NewEncoder(w).Encode(info)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return sock, func() { l.Close() }
+}
+
+// newFakeDaemon returns a daemon running the VMs with driver, the pods are
+// created by a fake docker.
+func newFakeDaemon(t *testing.T, driver *qemu.FakeDriver) (*Daemon, func()) {
+	dir, err := ioutil.TempDir("", "hyper-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := leveldb.OpenFile(path.Join(dir, "hyper.db"), nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	sock, stopDocker := serveFakeDocker(t, dir)
+
+	origDriver, origLogDir := qemu.HDriver, qemu.ContainerLogDir
+	qemu.HDriver = driver
+	qemu.ContainerLogDir = path.Join(dir, "logs")
+	restoreNetwork := qemu.FakeNetwork()
+
+	daemon := &Daemon{
+		ID:                "fake",
+		db:                db,
+		dockerCli:         docker.NewDockerCli("", "unix", sock, nil),
+		containerList:     []*Container{},
+		podList:           map[string]*Pod{},
+		vmList:            map[string]*Vm{},
+		qemuChan:          map[string]interface{}{},
+		qemuClientChan:    map[string]interface{}{},
+		subQemuClientChan: map[string]interface{}{},
+		Storage:           &Storage{StorageType: "fake", Fstype: "dir"},
+	}
+	return daemon, func() {
+		qemu.HDriver, qemu.ContainerLogDir = origDriver, origLogDir
+		restoreNetwork()
+		stopDocker()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFakeDaemonStartPod(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{})
+	defer cleanup()
+
+	code, cause, err := daemon.StartPod("pod-fakestart", "vm-fakestart", fakePodArgs)
+	if err != nil || code != types.E_OK {
+		t.Fatalf("failed to start the pod: %d %s %v", code, cause, err)
+	}
+	mypod := daemon.podList["pod-fakestart"]
+	if mypod == nil || mypod.Status != types.S_POD_RUNNING || len(mypod.Containers) != 1 {
+		t.Fatalf("wrong pod after started %#v", mypod)
+	}
+	if vmId, err := daemon.db.Get([]byte("vm-pod-fakestart"), nil); err != nil || string(vmId) != "vm-fakestart" {
+		t.Errorf("the VM of the pod is not saved: %s %v", vmId, err)
+	}
+	// associate them like CmdPodStart
+	mypod.Vm = "vm-fakestart"
+	daemon.AddVm(&Vm{Id: "vm-fakestart", Pod: mypod, Status: types.S_VM_ASSOCIATED, Cpu: 1, Mem: 128})
+
+	code, cause, err = daemon.StopPod("pod-fakestart", "yes")
+	if err != nil || code != types.E_VM_SHUTDOWN {
+		t.Fatalf("failed to stop the pod: %d %s %v", code, cause, err)
+	}
+	if mypod.Status != types.S_POD_FAILED || mypod.Vm != "" {
+		t.Errorf("wrong pod after stopped, status %d, vm %s", mypod.Status, mypod.Vm)
+	}
+	if _, ok := daemon.vmList["vm-fakestart"]; ok {
+		t.Error("the VM is not removed after shut down")
+	}
+}
+
+func TestFakeDaemonStartPodInitCrash(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{InitCrash: qemu.INIT_STARTPOD})
+	defer cleanup()
+
+	code, _, _ := daemon.StartPod("pod-fakecrash", "vm-fakecrash", fakePodArgs)
+	if code == types.E_OK {
+		t.Fatal("the pod is started in a crashed VM")
+	}
+}
+
+// serveApi calls the API of the daemon like the client
+func serveApi(t *testing.T, daemon *Daemon, method, uri string, form url.Values) *engine.Env {
+	eng := engine.New("")
+	if err := daemon.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, uri+"?"+form.Encode(), bytes.NewBufferString(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	apiserver.ServeRequest(eng, version.Version("1.0"), rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s %s returned %d: %s", method, uri, rec.Code, rec.Body.String())
+	}
+
+	env := &engine.Env{}
+	if err := env.Decode(rec.Body); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestFakeDaemonApiPodRun(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{})
+	defer cleanup()
+
+	env := serveApi(t, daemon, "POST", "/pod/run", url.Values{"podArgs": {fakePodArgs}})
+	podId := env.Get("ID")
+	if env.GetInt("Code") != types.E_OK || !strings.HasPrefix(podId, "pod-") {
+		t.Fatalf("failed to run the pod %v", env.Map())
+	}
+	if data, err := daemon.GetPodByName(podId); err != nil {
+		t.Error("the pod is not saved ", err)
+	} else if userPod, err := pod.ProcessPodBytes(data); err != nil || userPod.Name != "fakepod" {
+		t.Errorf("wrong pod is saved %s", data)
+	}
+
+	env = serveApi(t, daemon, "POST", "/pod/stop", url.Values{"podId": {podId}, "stopVm": {"yes"}})
+	if env.GetInt("Code") != types.E_VM_SHUTDOWN {
+		t.Errorf("failed to stop the pod %v", env.Map())
+	}
+}
+
+func pooledVms(pool *VmPool, size VmSize) []string {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	ids := []string{}
+	for _, vm := range pool.idle[size] {
+		ids = append(ids, vm.id)
+	}
+	return ids
+}
+
+// waitPooledVms waits for the pool to have count idle VMs of size
+func waitPooledVms(t *testing.T, pool *VmPool, size VmSize, count int) []string {
+	for i := 0; i < 500; i++ {
+		if ids := pooledVms(pool, size); len(ids) == count {
+			return ids
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the pool does not have %d idle VMs", count)
+	return nil
+}
+
+func TestFakeDaemonVmPool(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{})
+	defer cleanup()
+	size := VmSize{Cpu: 1, Mem: 128}
+	daemon.vmPool = NewVmPool(map[VmSize]int{size: 1})
+	defer daemon.DestroyAndKeepVm()
+
+	daemon.FillVmPool()
+	dead := waitPooledVms(t, daemon.vmPool, size, 1)[0]
+
+	// the VM dies in the pool, it is replaced
+	hub, _, _, err := daemon.GetQemuChan(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.(chan qemu.QemuEvent) <- &qemu.ShutdownCommand{}
+	vmId := dead
+	for i := 0; i < 500 && vmId == dead; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if ids := pooledVms(daemon.vmPool, size); len(ids) == 1 {
+			vmId = ids[0]
+		}
+	}
+	if vmId == dead {
+		t.Fatal("the dead VM is kept in the pool")
+	}
+	if _, ok := daemon.vmList[dead]; ok {
+		t.Error("the dead VM is kept in vmList")
+	}
+
+	// the pod runs in the pooled VM, which is no longer watched by the pool
+	env := serveApi(t, daemon, "POST", "/pod/run", url.Values{"podArgs": {fakePodArgs}})
+	podId := env.Get("ID")
+	if env.GetInt("Code") != types.E_OK {
+		t.Fatalf("failed to run the pod %v", env.Map())
+	}
+	if daemon.podList[podId].Vm != vmId {
+		t.Errorf("the pod runs in %s instead of the pooled VM %s", daemon.podList[podId].Vm, vmId)
+	}
+	env = serveApi(t, daemon, "POST", "/pod/stop", url.Values{"podId": {podId}, "stopVm": {"yes"}})
+	if env.GetInt("Code") != types.E_VM_SHUTDOWN {
+		t.Errorf("failed to stop the pod %v", env.Map())
+	}
+
+	// the idle VM is killed without the pool stealing its status
+	vmId = waitPooledVms(t, daemon.vmPool, size, 1)[0]
+	if code, _, err := daemon.KillVm(vmId); err != nil || code != types.E_VM_SHUTDOWN {
+		t.Errorf("failed to kill the pooled VM: %d %v", code, err)
+	}
+}
+
+func TestFakeDaemonVmPoolBootFail(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{LaunchFail: true})
+	defer cleanup()
+	size := VmSize{Cpu: 1, Mem: 128}
+	daemon.vmPool = NewVmPool(map[VmSize]int{size: 1})
+	defer daemon.DestroyAndKeepVm()
+
+	daemon.FillVmPool()
+	for i := 0; i < 50; i++ {
+		daemon.vmPool.lock.Lock()
+		booting := daemon.vmPool.booting[size]
+		daemon.vmPool.lock.Unlock()
+		if booting == 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if ids := pooledVms(daemon.vmPool, size); len(ids) != 0 {
+		t.Errorf("the VM failed to boot is pooled: %v", ids)
+	}
+	daemon.vmPool.lock.Lock()
+	defer daemon.vmPool.lock.Unlock()
+	if booting := daemon.vmPool.booting[size]; booting != 0 {
+		t.Errorf("%d VMs are still booting", booting)
+	}
+}
+
+// takeSnapshot takes the snapshot of size with the daemon, it fails the
+// test if that does not finish in time.
+func takeSnapshot(t *testing.T, daemon *Daemon, size VmSize) *VmSnapshots {
+	dir, err := ioutil.TempDir("", "hyper-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewVmSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		daemon.takeSnapshot(s, size)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("taking the snapshot timeout")
+	}
+	return s
+}
+
+func TestFakeDaemonSnapshot(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{})
+	defer cleanup()
+	size := VmSize{Cpu: 1, Mem: 128}
+
+	s := takeSnapshot(t, daemon, size)
+	defer os.RemoveAll(s.dir)
+	if image := s.lookup(size); image == "" {
+		t.Error("the snapshot is not taken")
+	}
+}
+
+func TestFakeDaemonSnapshotBootFail(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{LaunchFail: true})
+	defer cleanup()
+	size := VmSize{Cpu: 1, Mem: 128}
+
+	s := takeSnapshot(t, daemon, size)
+	defer os.RemoveAll(s.dir)
+	if image := s.lookup(size); image != "" {
+		t.Error("the snapshot is taken without VM ", image)
+	}
+	if s.taking[size] {
+		t.Error("the snapshot is still being taken")
+	}
+}
+
+func TestFakeDaemonLogsFollow(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{ContainerOutput: true})
+	defer cleanup()
+
+	code, cause, err := daemon.StartPod("pod-fakelogs", "vm-fakelogs", fakePodArgs)
+	if err != nil || code != types.E_OK {
+		t.Fatalf("failed to start the pod: %d %s %v", code, cause, err)
+	}
+	mypod := daemon.podList["pod-fakelogs"]
+	mypod.Vm = "vm-fakelogs"
+	daemon.AddVm(&Vm{Id: "vm-fakelogs", Pod: mypod, Status: types.S_VM_ASSOCIATED, Cpu: 1, Mem: 128})
+
+	out := &bytes.Buffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- daemon.ReadContainerLogs(mypod.Containers[0].Id, true, time.Time{}, -1, out)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if code, _, err := daemon.StopPod("pod-fakelogs", "yes"); err != nil || code != types.E_VM_SHUTDOWN {
+		t.Fatalf("failed to stop the pod: %d %v", code, err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the logs are still followed after the pod stopped")
+	}
+	if out.Len() == 0 {
+		t.Error("the output of the container is not logged")
+	}
+}
+
+func TestFakeDaemonWaitPodRestarted(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{ExitCodes: []uint32{3}})
+	defer cleanup()
+	args := `{"id":"fakepod","type":"kubernetes","containers":[{"name":"c1","image":"busybox","command":["sh"],"restartPolicy":"always"}],"resource":{"vcpu":1,"memory":128}}`
+
+	code, cause, err := daemon.StartPod("pod-fakewait", "vm-fakewait", args)
+	if err != nil || code != types.E_OK {
+		t.Fatalf("failed to start the pod: %d %s %v", code, cause, err)
+	}
+	first := daemon.podList["pod-fakewait"]
+
+	// the pod is restarted once it finished, the waiter gets its exit codes
+	done := make(chan error, 1)
+	var containers []*Container
+	go func() {
+		var err error
+		containers, err = daemon.WaitPod("pod-fakewait")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting for the pod timeout")
+	}
+	if len(containers) != 1 || containers[0].ExitCode != 3 {
+		t.Errorf("wrong exit codes of the pod %v", containers)
+	}
+
+	for i := 0; i < 500; i++ {
+		if mypod := daemon.podList["pod-fakewait"]; mypod != nil && mypod != first && mypod.Status == types.S_POD_RUNNING {
+			if code, _, err := daemon.StopPod("pod-fakewait", "yes"); err != nil || code != types.E_VM_SHUTDOWN {
+				t.Errorf("failed to stop the restarted pod: %d %v", code, err)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("the pod is not restarted")
+}
+
+// hijackApi calls the streaming API of the daemon like the client, it
+// returns the connection upgraded to the raw stream.
+func hijackApi(t *testing.T, daemon *Daemon, uri string, form url.Values) (net.Conn, *bufio.Reader) {
+	eng := engine.New("")
+	if err := daemon.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiserver.ServeRequest(eng, version.Version("1.0"), w, r)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "POST %s?%s HTTP/1.1\r\nHost: hyper\r\nContent-Length: 0\r\n\r\n", uri, form.Encode())
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		t.Fatalf("%s is not upgraded: %s", uri, rsp.Status)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, reader
+}
+
+func TestFakeDaemonApiExec(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{})
+	defer cleanup()
+
+	env := serveApi(t, daemon, "POST", "/pod/run", url.Values{"podArgs": {fakePodArgs}})
+	podId := env.Get("ID")
+	if env.GetInt("Code") != types.E_OK {
+		t.Fatalf("failed to run the pod %v", env.Map())
+	}
+	form := url.Values{
+		"type":    {"container"},
+		"value":   {daemon.podList[podId].Containers[0].Id},
+		"command": {`["echo","hello"]`},
+		"tag":     {"exec1"},
+	}
+
+	// the fake init echoes the command, the stream is closed once it quits
+	conn, reader := hijackApi(t, daemon, "/exec", form)
+	out, err := ioutil.ReadAll(reader)
+	conn.Close()
+	if err != nil || string(out) != "echo hello\n" {
+		t.Errorf("wrong output of the command %q: %v", out, err)
+	}
+
+	// the command does not run in the paused pod
+	env = serveApi(t, daemon, "POST", "/pod/pause", url.Values{"podId": {podId}})
+	if env.GetInt("Code") != types.E_OK {
+		t.Fatalf("failed to pause the pod %v", env.Map())
+	}
+	form.Set("tag", "exec2")
+	conn, reader = hijackApi(t, daemon, "/exec", form)
+	out, err = ioutil.ReadAll(reader)
+	conn.Close()
+	if err != nil || len(out) != 0 {
+		t.Errorf("the command runs in the paused pod: %q %v", out, err)
+	}
+	serveApi(t, daemon, "POST", "/pod/unpause", url.Values{"podId": {podId}})
+
+	env = serveApi(t, daemon, "POST", "/pod/stop", url.Values{"podId": {podId}, "stopVm": {"yes"}})
+	if env.GetInt("Code") != types.E_VM_SHUTDOWN {
+		t.Errorf("failed to stop the pod %v", env.Map())
+	}
+}
+
+func TestFakeDaemonApiExecExitCode(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{ExecExitCode: 7})
+	defer cleanup()
+
+	env := serveApi(t, daemon, "POST", "/pod/run", url.Values{"podArgs": {fakePodArgs}})
+	podId := env.Get("ID")
+	if env.GetInt("Code") != types.E_OK {
+		t.Fatalf("failed to run the pod %v", env.Map())
+	}
+	form := url.Values{
+		"type":    {"container"},
+		"value":   {daemon.podList[podId].Containers[0].Id},
+		"command": {`["echo","hello"]`},
+		"tag":     {"exec1"},
+		"tty":     {"no"},
+		"env":     {`["A=1"]`},
+	}
+
+	// the output without tty is followed by the exit code
+	conn, reader := hijackApi(t, daemon, "/exec", form)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code, err := stdcopy.Demux(reader, stdout, stderr)
+	conn.Close()
+	if err != nil || code != 7 {
+		t.Errorf("wrong exit code of the command %d: %v", code, err)
+	}
+	if stdout.String() != "echo hello\n" {
+		t.Errorf("wrong output of the command %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "envs=A=1") {
+		t.Errorf("the command does not run with the envs: %q", stderr.String())
+	}
+
+	env = serveApi(t, daemon, "POST", "/pod/stop", url.Values{"podId": {podId}, "stopVm": {"yes"}})
+	if env.GetInt("Code") != types.E_VM_SHUTDOWN {
+		t.Errorf("failed to stop the pod %v", env.Map())
+	}
+}
+
+func TestFakeDaemonApiAttach(t *testing.T) {
+	daemon, cleanup := newFakeDaemon(t, &qemu.FakeDriver{})
+	defer cleanup()
+	args := `{"id":"fakepod","containers":[{"name":"c1","image":"busybox","command":["sh"]}],"resource":{"vcpu":1,"memory":128},"tty":true}`
+
+	env := serveApi(t, daemon, "POST", "/pod/run", url.Values{"podArgs": {args}})
+	podId := env.Get("ID")
+	if env.GetInt("Code") != types.E_OK {
+		t.Fatalf("failed to run the pod %v", env.Map())
+	}
+	form := url.Values{
+		"type":  {"container"},
+		"value": {daemon.podList[podId].Containers[0].Id},
+		"tag":   {"attach1"},
+	}
+
+	// the fake tty echoes the input
+	conn, reader := hijackApi(t, daemon, "/attach", form)
+	fmt.Fprintf(conn, "ping\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("wrong output of the attached container %q: %v", line, err)
+	}
+	conn.Close()
+
+	// the paused pod could not be attached
+	env = serveApi(t, daemon, "POST", "/pod/pause", url.Values{"podId": {podId}})
+	if env.GetInt("Code") != types.E_OK {
+		t.Fatalf("failed to pause the pod %v", env.Map())
+	}
+	form.Set("tag", "attach2")
+	conn, reader = hijackApi(t, daemon, "/attach", form)
+	fmt.Fprintf(conn, "ping\n")
+	if line, err := reader.ReadString('\n'); err == nil {
+		t.Errorf("the paused pod is attached, got %q", line)
+	}
+	conn.Close()
+	serveApi(t, daemon, "POST", "/pod/unpause", url.Values{"podId": {podId}})
+
+	env = serveApi(t, daemon, "POST", "/pod/stop", url.Values{"podId": {podId}, "stopVm": {"yes"}})
+	if env.GetInt("Code") != types.E_VM_SHUTDOWN {
+		t.Errorf("failed to stop the pod %v", env.Map())
+	}
+}
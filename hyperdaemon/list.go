@@ -70,6 +70,12 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 					status = "succeeded(kubernetes)"
 				}
 				break
+			case types.S_POD_MIGRATING:
+				status = "migrating"
+				break
+			case types.S_POD_PAUSED:
+				status = "paused"
+				break
 			default:
 				status = ""
 				break
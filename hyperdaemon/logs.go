@@ -0,0 +1,195 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/qemu"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdLogs(job *engine.Job) error {
+	if len(job.Args) < 4 {
+		return fmt.Errorf("Can not read the logs without container ID")
+	}
+	containerId := job.Args[0]
+	follow := job.Args[1] == "yes"
+	since, err := parseLogSince(job.Args[2])
+	if err != nil {
+		return err
+	}
+	tail := -1
+	if job.Args[3] != "" && job.Args[3] != "all" {
+		if tail, err = strconv.Atoi(job.Args[3]); err != nil || tail < 0 {
+			return fmt.Errorf("Invalid number of lines %s", job.Args[3])
+		}
+	}
+
+	return daemon.ReadContainerLogs(containerId, follow, since, tail, job.Stdout)
+}
+
+// parseLogSince parses the time from which the logs are read, in RFC3339
+// format, in unix seconds, or a duration relative to now such as 10m.
+func parseLogSince(since string) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %s, should be RFC3339, unix seconds or a duration", since)
+}
+
+// ReadContainerLogs writes the log entries of the container to w, one json
+// line each. The entries before since are skipped, and only the last tail
+// ones are written if tail is not negative. If follow is set, it keeps
+// writing the new entries until the pod of the container stops.
+func (daemon *Daemon) ReadContainerLogs(containerId string, follow bool, since time.Time, tail int, w io.Writer) error {
+	podId, err := daemon.GetPodByContainer(containerId)
+	if err != nil {
+		return err
+	}
+	var stopped chan bool
+	if follow {
+		stopped = daemon.watchPod(podId)
+		defer daemon.podWatchers.unwatch(podId, stopped)
+	}
+	file, err := os.Open(qemu.ContainerLogPath(containerId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// nothing is logged yet
+			if !follow {
+				return nil
+			}
+			if file, err = waitContainerLog(containerId, stopped); file == nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var (
+		lines   = [][]byte{}
+		partial = []byte{}
+	)
+	// read the lines written by now, the partial one is left for follow
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			partial = line
+			break
+		}
+		if !logAfter(line, since) {
+			continue
+		}
+		if tail < 0 {
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) > tail {
+			lines = lines[1:]
+		}
+	}
+	for _, line := range lines {
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+
+	for follow {
+		quit := false
+		select {
+		case <-stopped:
+			quit = true
+		default:
+		}
+		for {
+			line, err := reader.ReadBytes('\n')
+			partial = append(partial, line...)
+			if err != nil {
+				break
+			}
+			if logAfter(partial, since) {
+				if _, err := w.Write(partial); err != nil {
+					return err
+				}
+			}
+			partial = []byte{}
+		}
+		if quit {
+			break
+		}
+		// wait for the new entries, or the pod to stop
+		select {
+		case <-stopped:
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// waitContainerLog waits for the log of the container to be created, it
+// returns nil if the pod stops before that.
+func waitContainerLog(containerId string, stopped chan bool) (*os.File, error) {
+	for {
+		select {
+		case <-stopped:
+			return nil, nil
+		case <-time.After(200 * time.Millisecond):
+		}
+		file, err := os.Open(qemu.ContainerLogPath(containerId))
+		if err == nil {
+			return file, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+func (daemon *Daemon) podRunning(podId string) bool {
+	mypod, ok := daemon.podList[podId]
+	return ok && (mypod.Status == types.S_POD_RUNNING || mypod.Status == types.S_POD_PAUSED)
+}
+
+// logAfter checks whether the log entry is written after since
+func logAfter(line []byte, since time.Time) bool {
+	if since.IsZero() {
+		return true
+	}
+	entry := &types.ContainerLogEntry{}
+	if err := json.Unmarshal(bytes.TrimSpace(line), entry); err != nil {
+		glog.Warningf("bad container log entry %s", string(line))
+		return false
+	}
+	return !entry.Time.Before(since)
+}
+
+// removeContainerLog removes the log of the container once the container
+// is removed.
+func removeContainerLog(containerId string) {
+	if err := os.RemoveAll(path.Dir(qemu.ContainerLogPath(containerId))); err != nil {
+		glog.Warningf("fail to remove the log of container %s: %s", containerId, err.Error())
+	}
+}
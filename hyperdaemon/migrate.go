@@ -0,0 +1,380 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"hyper/client"
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/qemu"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdPodMigrate(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not migrate the POD without POD ID and target daemon")
+	}
+	podId := job.Args[0]
+	to := job.Args[1]
+
+	code, cause, err := daemon.MigratePod(podId, to)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.SetInt("Code", code)
+	v.Set("Cause", cause)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseMigrationTarget parses the address of the target daemon, which is
+// the unix socket of another daemon on this host, unix:///path, or the tcp
+// address of a daemon on this or another host, tcp://host:port.
+func parseMigrationTarget(to string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(to, "unix://") && len(to) > len("unix://"):
+		return "unix", strings.TrimPrefix(to, "unix://"), nil
+	case strings.HasPrefix(to, "tcp://"):
+		addr := strings.TrimPrefix(to, "tcp://")
+		if host, port, err := net.SplitHostPort(addr); err == nil && host != "" && port != "" {
+			return "tcp", addr, nil
+		}
+	}
+	return "", "", fmt.Errorf("Invalid target %s, should be the address of the daemon, unix:///path or tcp://host:port", to)
+}
+
+// localHost returns whether host is an address of this host
+func localHost(host string) bool {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MigratePod moves the running pod to the daemon at address 'to'. The
+// target daemon launches a VM waiting for the migration with the share
+// dir of the VM of the pod mounted, then the VM is migrated to it. The
+// containers and volumes are kept for the target daemon, so are the port
+// maps if it is on this host, the pod is removed from this daemon once the
+// VM quits. The target daemon reached over tcp listens for the migration
+// on the host it is reached at, it could be on another host only if the
+// storage of the pod is shared with this one.
+func (daemon *Daemon) MigratePod(podId, to string) (int, string, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return -1, "", fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	if mypod.Status != types.S_POD_RUNNING || mypod.Vm == "" {
+		return -1, "", fmt.Errorf("The POD(%s) is not running, can not migrate it", podId)
+	}
+	proto, addr, err := parseMigrationTarget(to)
+	if err != nil {
+		return -1, "", err
+	}
+
+	vmId := mypod.Vm
+	vm, ok := daemon.vmList[vmId]
+	if !ok {
+		return -1, "", fmt.Errorf("Can not find the VM(%s)", vmId)
+	}
+	podData, err := daemon.GetPodByName(podId)
+	if err != nil {
+		return -1, "", err
+	}
+	vmData, err := daemon.GetVmData(vmId)
+	if err != nil {
+		return -1, "", err
+	}
+	qemuPodEvent, _, _, err := daemon.GetQemuChan(vmId)
+	if err != nil {
+		return -1, "", err
+	}
+
+	containers := []string{}
+	for _, c := range mypod.Containers {
+		containers = append(containers, c.Id)
+	}
+	size := vmSize(vm.Cpu, vm.Mem)
+	shareDir := path.Join(qemu.BaseDir, vmId, qemu.ShareDirTag)
+	host, remote := "", false
+	if proto == "tcp" {
+		host, _, _ = net.SplitHostPort(addr)
+		remote = !localHost(host)
+	}
+	cli := client.NewHyperClient(proto, addr, nil)
+	targetVm, uri, err := cli.IncomingPod(podId, size.Cpu, size.Mem, string(podData), string(vmData), containers, shareDir, host)
+	if err != nil {
+		return -1, "", err
+	}
+	glog.Infof("VM %s on %s is waiting for POD %s on %s", targetVm, to, podId, uri)
+
+	// the VM would quit once migrated, do not take it as the pod finished
+	policy := mypod.RestartPolicy
+	mypod.Status = types.S_POD_MIGRATING
+	mypod.RestartPolicy = "never"
+	migrateEvent := &qemu.MigrateCommand{
+		Uri:      uri,
+		Remote:   remote,
+		Callback: make(chan *types.QemuResponse, 1),
+	}
+	qemuPodEvent.(chan qemu.QemuEvent) <- migrateEvent
+	qemuResponse := <-migrateEvent.Callback
+	glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+	if qemuResponse.Code != types.E_OK {
+		glog.Errorf("Failed to migrate POD %s: %s", podId, qemuResponse.Cause)
+		// the VM may have quit in the meantime
+		if mypod.Status == types.S_POD_MIGRATING {
+			mypod.Status = types.S_POD_RUNNING
+			mypod.RestartPolicy = policy
+		}
+		if _, _, err := cli.KillVm(targetVm); err != nil {
+			glog.Errorf("Failed to kill VM %s on %s: %s", targetVm, to, err.Error())
+		}
+		return qemuResponse.Code, qemuResponse.Cause, nil
+	}
+
+	glog.Infof("POD %s is migrated to %s", podId, to)
+	return qemuResponse.Code, qemuResponse.Cause, nil
+}
+
+// forgetMigratedPod removes the pod migrated to another daemon, without
+// removing its containers or volumes.
+func (daemon *Daemon) forgetMigratedPod(podId string) {
+	daemon.DeleteVmByPod(podId)
+	daemon.DeletePodFromDB(podId)
+	daemon.DeletePodContainerFromDB(podId)
+	daemon.RemovePod(podId)
+}
+
+func (daemon *Daemon) CmdPodIncoming(job *engine.Job) (err error) {
+	if len(job.Args) < 8 {
+		return fmt.Errorf("Not enough arguments for the incoming POD")
+	}
+	podId := job.Args[0]
+	cpu, err := strconv.Atoi(job.Args[1])
+	if err != nil {
+		return err
+	}
+	mem, err := strconv.Atoi(job.Args[2])
+	if err != nil {
+		return err
+	}
+	containers := strings.Split(job.Args[5], ":")
+
+	vmId, uri, err := daemon.IncomingPod(podId, cpu, mem, job.Args[3], []byte(job.Args[4]), containers, job.Args[6], job.Args[7])
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", vmId)
+	v.Set("Uri", uri)
+	v.SetInt("Code", types.E_OK)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IncomingPod launches a VM to receive the migration of a pod, with the
+// devices of the VM described by vmData and the share dir of the source VM
+// mounted. The VM listens on a tcp port if host, the address the source
+// daemon reaches this one at, is set, or on a unix socket otherwise. It
+// returns the VM id and the uri listened once the VM is ready.
+func (daemon *Daemon) IncomingPod(podId string, cpu, mem int, podArgs string,
+	vmData []byte, containers []string, shareDir, host string) (string, string, error) {
+
+	if _, ok := daemon.podList[podId]; ok {
+		return "", "", fmt.Errorf("The POD(%s) already exists", podId)
+	}
+	if !strings.HasPrefix(path.Clean(shareDir), qemu.BaseDir+"/") || path.Base(shareDir) != qemu.ShareDirTag {
+		return "", "", fmt.Errorf("Invalid share dir %s of the source VM", shareDir)
+	}
+	listen, port := "", ""
+	if host != "" {
+		// the source daemon may be on another host
+		if err := checkSharedStorage(shareDir, vmData); err != nil {
+			return "", "", err
+		}
+		var err error
+		if port, err = freePort(); err != nil {
+			return "", "", err
+		}
+		listen = "tcp:0.0.0.0:" + port
+	}
+
+	// reuse the containers of the pod
+	if err := daemon.WritePodContainers(podId, containers); err != nil {
+		return "", "", err
+	}
+	wg := new(sync.WaitGroup)
+	if err := daemon.CreatePod(podArgs, podId, wg); err != nil {
+		daemon.DeletePodContainerFromDB(podId)
+		return "", "", err
+	}
+
+	var (
+		vmId          = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		qemuPodEvent  = make(chan qemu.QemuEvent, 128)
+		qemuStatus    = make(chan *types.QemuResponse, 128)
+		subQemuStatus = make(chan *types.QemuResponse, 128)
+	)
+	b := &qemu.BootConfig{
+		CPU:    cpu,
+		Memory: mem,
+		Kernel: daemon.kernel,
+		Initrd: daemon.initrd,
+		Bios:   daemon.bios,
+		Cbfs:   daemon.cbfs,
+	}
+	go qemu.QemuIncoming(vmId, qemuPodEvent, qemuStatus, wg, b, vmData, shareDir, listen)
+	if err := daemon.SetQemuChan(vmId, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
+		glog.V(1).Infof("SetQemuChan error: %s", err.Error())
+		daemon.forgetMigratedPod(podId)
+		return "", "", err
+	}
+
+	// wait for the VM to be ready for the migration
+	var qemuResponse *types.QemuResponse
+	for {
+		qemuResponse = <-qemuStatus
+		glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+		if qemuResponse.Code == types.E_OK || qemuResponse.Code == types.E_FAILED ||
+			qemuResponse.Code == types.E_BAD_REQUEST {
+			break
+		}
+	}
+	uri, ok := qemuResponse.Data.(string)
+	if qemuResponse.Code != types.E_OK || !ok {
+		daemon.DeleteQemuChan(vmId)
+		daemon.forgetMigratedPod(podId)
+		return "", "", fmt.Errorf("Failed to prepare VM for the incoming POD: %s", qemuResponse.Cause)
+	}
+	if host != "" {
+		uri = "tcp:" + net.JoinHostPort(host, port)
+	}
+
+	vm := &Vm{
+		Id:     vmId,
+		Pod:    daemon.podList[podId],
+		Status: types.S_VM_ASSOCIATED,
+		Cpu:    cpu,
+		Mem:    mem,
+	}
+	vm.loadCpu(vmData)
+	daemon.AddVm(vm)
+	go daemon.waitIncomingPod(podId, vmId, qemuStatus, subQemuStatus)
+
+	return vmId, uri, nil
+}
+
+// checkSharedStorage checks the storage of the source VM is on this host,
+// which is true only if the source daemon is on this host or shares the
+// storage with this one.
+func checkSharedStorage(shareDir string, vmData []byte) error {
+	if info, err := os.Stat(shareDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("The share dir %s of the source VM is not on this host, the POD could be migrated to another host only if %s is on a filesystem shared with it",
+			shareDir, qemu.BaseDir)
+	}
+	var info struct {
+		VolumeList []*qemu.PersistVolumeInfo
+	}
+	if err := json.Unmarshal(vmData, &info); err != nil {
+		return err
+	}
+	for _, vol := range info.VolumeList {
+		if vol.Fstype == "" || vol.Fstype == "dir" {
+			continue
+		}
+		if _, err := os.Stat(vol.Filename); err != nil {
+			return fmt.Errorf("The block device %s of the source VM is not on this host, the POD could be migrated to another host only if its storage is shared with it",
+				vol.Filename)
+		}
+	}
+	return nil
+}
+
+// freePort picks a free tcp port for the VM to listen on
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	return port, err
+}
+
+// waitIncomingPod runs the pod in the VM once the migration finished, or
+// removes the pod if the VM quits before that.
+func (daemon *Daemon) waitIncomingPod(podId, vmId string, qemuStatus, subQemuStatus chan *types.QemuResponse) {
+	var (
+		qemuResponse *types.QemuResponse
+		ok           bool
+	)
+	for {
+		qemuResponse, ok = <-qemuStatus
+		if !ok {
+			// the VM is killed
+			daemon.forgetMigratedPod(podId)
+			return
+		}
+		subQemuStatus <- qemuResponse
+		if qemuResponse.Code == types.E_POD_RUNNING {
+			break
+		}
+		if qemuResponse.Code == types.E_VM_SHUTDOWN {
+			glog.Errorf("VM %s quit before POD %s migrated in", vmId, podId)
+			daemon.RemoveVm(vmId)
+			daemon.DeleteQemuChan(vmId)
+			daemon.forgetMigratedPod(podId)
+			return
+		}
+	}
+
+	glog.Infof("POD %s is migrated in VM %s", podId, vmId)
+	mypod := daemon.podList[podId]
+	mypod.Vm = vmId
+	mypod.Status = types.S_POD_RUNNING
+	daemon.SetContainerStatus(podId, types.S_POD_RUNNING)
+	if data, ok := qemuResponse.Data.([]byte); ok {
+		daemon.UpdateVmData(vmId, data)
+	}
+	if err := daemon.UpdateVmByPod(podId, vmId); err != nil {
+		glog.Error(err.Error())
+	}
+	daemon.podStatusLoop(podId, vmId, qemuStatus, subQemuStatus)
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseMigrationTarget(t *testing.T) {
+	proto, addr, err := parseMigrationTarget("unix:///var/run/hyper-2.sock")
+	if err != nil || proto != "unix" || addr != "/var/run/hyper-2.sock" {
+		t.Errorf("wrong target %s %s: %v", proto, addr, err)
+	}
+	proto, addr, err = parseMigrationTarget("tcp://192.168.1.2:1246")
+	if err != nil || proto != "tcp" || addr != "192.168.1.2:1246" {
+		t.Errorf("wrong target %s %s: %v", proto, addr, err)
+	}
+	for _, to := range []string{"tcp://192.168.1.2", "tcp://:1246", "unix://", "/var/run/hyper-2.sock"} {
+		if _, _, err := parseMigrationTarget(to); err == nil {
+			t.Errorf("invalid target %s is accepted", to)
+		}
+	}
+}
+
+func TestLocalHost(t *testing.T) {
+	if !localHost("127.0.0.1") || !localHost("localhost") {
+		t.Error("the loopback address is not taken as this host")
+	}
+	if localHost("192.0.2.1") {
+		t.Error("the address of another host is taken as this host")
+	}
+}
+
+func TestIncomingPodShareDir(t *testing.T) {
+	daemon := &Daemon{}
+	for _, dir := range []string{"/", "/etc", "/var/run/hyper/../../../etc/share_dir", "/var/run/hyper/vm-src"} {
+		if _, _, err := daemon.IncomingPod("pod-test", 1, 128, "", nil, nil, dir, ""); err == nil {
+			t.Errorf("share dir %s is accepted", dir)
+		}
+	}
+}
+
+func TestIncomingPodSharedStorage(t *testing.T) {
+	daemon := &Daemon{}
+	// the share dir of the VM on another host is not on this one
+	_, _, err := daemon.IncomingPod("pod-test", 1, 128, "", []byte("{}"), nil, "/var/run/hyper/vm-nosuchvm/share_dir", "192.0.2.1")
+	if err == nil {
+		t.Error("the share dir not on this host is accepted")
+	}
+
+	dir, err := ioutil.TempDir("", "hyper-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := []byte(`{"VolumeList": [{"Name": "vol1", "Filename": "/dev/mapper/nosuch-vol", "Format": "raw", "Fstype": "ext4"}]}`)
+	if err := checkSharedStorage(dir, data); err == nil {
+		t.Error("the block device not on this host is accepted")
+	}
+	data = []byte(`{"VolumeList": [{"Name": "vol1", "Filename": "` + dir + `", "Format": "vfs", "Fstype": "dir"}]}`)
+	if err := checkSharedStorage(dir, data); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,330 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/network"
+	"hyper/pod"
+	"hyper/types"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// the name of the network of the default bridge
+const defaultNetwork = "default"
+
+// the bridge of a network is named after it, which should fit in IFNAMSIZ.
+// The name starts with a letter, as the API would take a number for a number
+const networkBridgePrefix = "hy-"
+
+var (
+	validNetworkName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]{0,11}$`)
+	// the names which the API would take for json values
+	reservedNetworkNames = map[string]bool{"true": true, "false": true, "null": true}
+)
+
+// Network is a user defined network, the pods on it are on a bridge of
+// their own, and can not reach the pods on the other networks. The pods
+// have IPv6 addresses too if Subnet6 is not empty.
+type Network struct {
+	Name    string `json:"name"`
+	Bridge  string `json:"bridge"`
+	Subnet  string `json:"subnet"`
+	Subnet6 string `json:"subnet6,omitempty"`
+}
+
+func (daemon *Daemon) CmdNetworkCreate(job *engine.Job) error {
+	if len(job.Args) < 3 {
+		return fmt.Errorf("Can not create a network without name and subnet")
+	}
+	nw, err := daemon.CreateNetwork(job.Args[0], job.Args[1], job.Args[2])
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("Name", nw.Name)
+	v.Set("Bridge", nw.Bridge)
+	v.Set("Subnet", nw.Subnet)
+	v.Set("Subnet6", nw.Subnet6)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) CmdNetworkList(job *engine.Job) error {
+	networks, err := daemon.ListNetworks()
+	if err != nil {
+		return err
+	}
+
+	// the IPv6 subnet goes last as it has ':' in it
+	networkJsonResponse := []string{defaultNetwork + ":" + network.BridgeIface + ":" + network.BridgeIP + ":" + network.BridgeIPv6}
+	for _, nw := range networks {
+		networkJsonResponse = append(networkJsonResponse, nw.Name+":"+nw.Bridge+":"+nw.Subnet+":"+nw.Subnet6)
+	}
+
+	v := &engine.Env{}
+	v.SetList("networkData", networkJsonResponse)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) CmdNetworkRemove(job *engine.Job) error {
+	if len(job.Args) < 1 {
+		return fmt.Errorf("Can not remove a network without name")
+	}
+	name := job.Args[0]
+	if err := daemon.RemoveNetwork(name); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("Name", name)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateNetwork sets up the bridge of the network with the subnet, and the
+// IPv6 subnet6 if it is not empty, which should not overlap with the other
+// networks.
+func (daemon *Daemon) CreateNetwork(name, subnet, subnet6 string) (*Network, error) {
+	if !validNetworkName.MatchString(name) || reservedNetworkNames[name] {
+		return nil, fmt.Errorf("Invalid network name %s, it should be at most 12 letters, digits, '_', '.' or '-', starting with a letter", name)
+	}
+	if name == defaultNetwork {
+		return nil, fmt.Errorf("Network %s exists already", name)
+	}
+	if _, err := daemon.GetNetwork(name); err == nil {
+		return nil, fmt.Errorf("Network %s exists already", name)
+	}
+
+	networks, err := daemon.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	used := []*Network{{Name: defaultNetwork, Subnet: network.BridgeIP, Subnet6: network.BridgeIPv6}}
+	used = append(used, networks...)
+
+	nw := &Network{
+		Name:   name,
+		Bridge: networkBridgePrefix + name,
+	}
+	if nw.Subnet, err = checkSubnet(subnet, used, func(n *Network) string { return n.Subnet }); err != nil {
+		return nil, err
+	}
+	if subnet6 != "" {
+		if nw.Subnet6, err = checkSubnet(subnet6, used, func(n *Network) string { return n.Subnet6 }); err != nil {
+			return nil, err
+		}
+	}
+	if err := network.SetupBridge(nw.Bridge, nw.Subnet, nw.Subnet6); err != nil {
+		return nil, err
+	}
+	if err := daemon.WriteNetworkToDB(nw); err != nil {
+		network.RemoveBridge(nw.Bridge)
+		return nil, err
+	}
+	glog.Infof("network %s is created on bridge %s", nw.Name, nw.Bridge)
+	return nw, nil
+}
+
+// checkSubnet returns the normalized subnet, which should not overlap with
+// the subnets of the networks.
+func checkSubnet(subnet string, networks []*Network, subnetOf func(*Network) string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", fmt.Errorf("Invalid subnet %s: %s", subnet, err.Error())
+	}
+	for _, nw := range networks {
+		s := subnetOf(nw)
+		_, otherNet, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		if otherNet.Contains(ipNet.IP) || ipNet.Contains(otherNet.IP) {
+			return "", fmt.Errorf("Subnet %s overlaps with network %s (%s)", subnet, nw.Name, s)
+		}
+	}
+	return ipNet.String(), nil
+}
+
+// RemoveNetwork tears down the network which is not used by any pod.
+func (daemon *Daemon) RemoveNetwork(name string) error {
+	if name == defaultNetwork {
+		return fmt.Errorf("Can not remove the default network")
+	}
+	nw, err := daemon.GetNetwork(name)
+	if err != nil {
+		return err
+	}
+	for podId := range daemon.podList {
+		podData, err := daemon.GetPodByName(podId)
+		if err != nil {
+			continue
+		}
+		userPod, err := pod.ProcessPodBytes(podData)
+		if err != nil {
+			continue
+		}
+		for _, inf := range userPod.Interfaces {
+			if inf.Network == name {
+				return fmt.Errorf("Network %s is used by the POD(%s)", name, podId)
+			}
+		}
+	}
+
+	if err := network.RemoveBridge(nw.Bridge); err != nil {
+		glog.Warningf("Unable to remove bridge %s: %s", nw.Bridge, err.Error())
+	}
+	return daemon.DeleteNetworkFromDB(name)
+}
+
+// RestoreNetworks sets up the bridges of the networks in the DB
+func (daemon *Daemon) RestoreNetworks() error {
+	networks, err := daemon.ListNetworks()
+	if err != nil {
+		return err
+	}
+	for _, nw := range networks {
+		if err := network.SetupBridge(nw.Bridge, nw.Subnet, nw.Subnet6); err != nil {
+			glog.Errorf("Unable to restore network %s: %s", nw.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// resolveNetworks replaces the networks of the nics of the pod with their
+// bridges, which can not be given as the bridges of the nics directly.
+func (daemon *Daemon) resolveNetworks(userPod *pod.UserPod) error {
+	for i, inf := range userPod.Interfaces {
+		if inf.Network == "" {
+			// the bridges of the networks are only reached by their names,
+			// or the pods could join the networks isolated from them
+			if strings.HasPrefix(inf.Bridge, networkBridgePrefix) {
+				return fmt.Errorf("in interface %d, bridge %s belongs to a network, use the network instead", i, inf.Bridge)
+			}
+			continue
+		}
+		if inf.Network == defaultNetwork {
+			userPod.Interfaces[i].Bridge = network.BridgeIface
+			continue
+		}
+		nw, err := daemon.GetNetwork(inf.Network)
+		if err != nil {
+			return err
+		}
+		userPod.Interfaces[i].Bridge = nw.Bridge
+	}
+	return nil
+}
+
+// checkAddresses checks the static addresses of the nics of the pod, the
+// IPs should be in the subnets of their bridges and not allocated yet, and
+// the MACs should not be used by the other running pods.
+func (daemon *Daemon) checkAddresses(podId string, userPod *pod.UserPod) error {
+	macs := make(map[string]int)
+	for i, inf := range userPod.Interfaces {
+		if err := network.CheckAddress(inf.Bridge, inf.Ip, inf.Ip6); err != nil {
+			return fmt.Errorf("in interface %d, %s", i, err.Error())
+		}
+		if inf.Mac != "" {
+			mac, err := net.ParseMAC(inf.Mac)
+			if err != nil {
+				return fmt.Errorf("in interface %d, %s", i, err.Error())
+			}
+			userPod.Interfaces[i].Mac = mac.String()
+			macs[mac.String()] = i
+		}
+	}
+	if len(macs) == 0 {
+		return nil
+	}
+
+	for id, p := range daemon.podList {
+		if id == podId || p.Status != types.S_POD_RUNNING {
+			continue
+		}
+		podData, err := daemon.GetPodByName(id)
+		if err != nil {
+			continue
+		}
+		other, err := pod.ProcessPodBytes(podData)
+		if err != nil {
+			continue
+		}
+		for _, inf := range other.Interfaces {
+			mac, err := net.ParseMAC(inf.Mac)
+			if err != nil {
+				continue
+			}
+			if i, ok := macs[mac.String()]; ok {
+				return fmt.Errorf("in interface %d, mac %s is used by the POD(%s)", i, inf.Mac, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (daemon *Daemon) WriteNetworkToDB(nw *Network) error {
+	key := fmt.Sprintf("network-%s", nw.Name)
+	data, err := json.Marshal(nw)
+	if err != nil {
+		return err
+	}
+	return (daemon.db).Put([]byte(key), data, nil)
+}
+
+func (daemon *Daemon) GetNetwork(name string) (*Network, error) {
+	key := fmt.Sprintf("network-%s", name)
+	data, err := (daemon.db).Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, fmt.Errorf("Can not find network %s", name)
+		}
+		return nil, err
+	}
+	nw := &Network{}
+	if err := json.Unmarshal(data, nw); err != nil {
+		return nil, err
+	}
+	return nw, nil
+}
+
+func (daemon *Daemon) ListNetworks() ([]*Network, error) {
+	networks := []*Network{}
+	iter := (daemon.db).NewIterator(util.BytesPrefix([]byte("network-")), nil)
+	for iter.Next() {
+		nw := &Network{}
+		if err := json.Unmarshal(iter.Value(), nw); err != nil {
+			glog.Warningf("Got a bad network item %s", string(iter.Key()))
+			continue
+		}
+		networks = append(networks, nw)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+func (daemon *Daemon) DeleteNetworkFromDB(name string) error {
+	key := fmt.Sprintf("network-%s", name)
+	return (daemon.db).Delete([]byte(key), nil)
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"testing"
+
+	"hyper/pod"
+)
+
+func TestResolveNetworksRawBridge(t *testing.T) {
+	daemon := &Daemon{}
+	userPod := &pod.UserPod{
+		Interfaces: []pod.UserInterface{{Bridge: "br1"}, {Bridge: networkBridgePrefix + "teamA"}},
+	}
+	if err := daemon.resolveNetworks(userPod); err == nil {
+		t.Error("the bridge of a network is given directly")
+	}
+}
+
+func TestCreateNetworkName(t *testing.T) {
+	daemon := &Daemon{}
+	for _, name := range []string{"1234", "true", "null", "-net", "averylongnetwork"} {
+		if _, err := daemon.CreateNetwork(name, "10.1.0.0/24", ""); err == nil {
+			t.Errorf("network %s is created", name)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"fmt"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/qemu"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdPodPause(job *engine.Job) error {
+	return daemon.cmdPodPause(job, true)
+}
+
+func (daemon *Daemon) CmdPodUnpause(job *engine.Job) error {
+	return daemon.cmdPodPause(job, false)
+}
+
+func (daemon *Daemon) cmdPodPause(job *engine.Job, pause bool) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not pause or unpause the POD without POD ID")
+	}
+	podId := job.Args[0]
+
+	code, cause, err := daemon.PausePod(podId, pause)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.SetInt("Code", code)
+	v.Set("Cause", cause)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PausePod freezes the VM of a running pod, or resumes the paused one if
+// pause is false.
+func (daemon *Daemon) PausePod(podId string, pause bool) (int, string, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return -1, "", fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	var (
+		from, to = uint(types.S_POD_RUNNING), uint(types.S_POD_PAUSED)
+		event    qemu.QemuEvent
+		callback = make(chan *types.QemuResponse, 1)
+	)
+	if pause {
+		if mypod.Status != types.S_POD_RUNNING {
+			return -1, "", fmt.Errorf("The POD(%s) is not running, can not pause it", podId)
+		}
+		event = &qemu.PauseCommand{Callback: callback}
+	} else {
+		if mypod.Status != types.S_POD_PAUSED {
+			return -1, "", fmt.Errorf("The POD(%s) is not paused, can not unpause it", podId)
+		}
+		from, to = to, from
+		event = &qemu.UnpauseCommand{Callback: callback}
+	}
+
+	qemuPodEvent, _, _, err := daemon.GetQemuChan(mypod.Vm)
+	if err != nil {
+		return -1, "", err
+	}
+	qemuPodEvent.(chan qemu.QemuEvent) <- event
+	qemuResponse := <-callback
+	glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+
+	if qemuResponse.Code != types.E_OK {
+		return qemuResponse.Code, qemuResponse.Cause, nil
+	}
+	// keep the paused state for the daemon restarting
+	if data, ok := qemuResponse.Data.([]byte); ok {
+		daemon.UpdateVmData(mypod.Vm, data)
+	}
+	// the pod may have been stopped in the meantime
+	if mypod.Status == from {
+		mypod.Status = to
+		daemon.SetContainerStatus(podId, to)
+	}
+	return qemuResponse.Code, qemuResponse.Cause, nil
+}
@@ -28,6 +28,10 @@ func (daemon *Daemon) CmdPodCreate(job *engine.Job) error {
 		return fmt.Errorf("Pod full, the maximum Pod is 1024!")
 	}
 	podArgs := job.Args[0]
+	// reject the bad spec before creating any container of it
+	if _, err := pod.ValidatePodBytes([]byte(podArgs)); err != nil {
+		return err
+	}
 
 	wg := new(sync.WaitGroup)
 	podId := fmt.Sprintf("pod-%s", pod.RandStr(10, "alpha"))
@@ -63,7 +67,7 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 	glog.Info("pod:%s, vm:%s", podId, vmId)
 	// Do the status check for the given pod
 	if pod, ok := daemon.podList[podId]; ok {
-		if pod.Status == types.S_POD_RUNNING {
+		if pod.Status == types.S_POD_RUNNING || pod.Status == types.S_POD_PAUSED {
 			return fmt.Errorf("The pod(%s) is running, can not start it", podId)
 		} else {
 			if pod.Type == "kubernetes" && pod.Status != types.S_POD_CREATED {
@@ -82,7 +86,10 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 		return err
 	}
 	if vmId == "" {
-		vmId = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		vmId = daemon.pooledVmFor(userPod.Resource)
+		if vmId == "" {
+			vmId = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		}
 	} else {
 		if userPod.Resource.Vcpu != daemon.vmList[vmId].Cpu {
 			return fmt.Errorf("The new pod's cpu setting is different the current VM's cpu")
@@ -90,6 +97,9 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 		if userPod.Resource.Memory != daemon.vmList[vmId].Mem {
 			return fmt.Errorf("The new pod's memory setting is different the current VM's memory")
 		}
+		if alive := daemon.vmPool.remove(vmId); !alive {
+			return fmt.Errorf("The VM(%s) is dead", vmId)
+		}
 	}
 
 	code, cause, err := daemon.StartPod(podId, vmId, "")
@@ -104,6 +114,7 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 		Pod:    daemon.podList[podId],
 		Status: types.S_VM_ASSOCIATED,
 		Cpu:    userPod.Resource.Vcpu,
+		MaxCpu: userPod.Resource.MaxVcpu,
 		Mem:    userPod.Resource.Memory,
 	}
 	daemon.podList[podId].Vm = vmId
@@ -128,7 +139,14 @@ func (daemon *Daemon) CmdPodRun(job *engine.Job) error {
 	}
 	podArgs := job.Args[0]
 
-	vmId := fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+	spec, err := pod.ValidatePodBytes([]byte(podArgs))
+	if err != nil {
+		return err
+	}
+	vmId := daemon.pooledVmFor(spec.Resource)
+	if vmId == "" {
+		vmId = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+	}
 	podId := fmt.Sprintf("pod-%s", pod.RandStr(10, "alpha"))
 
 	glog.Info(podArgs)
@@ -157,6 +175,7 @@ func (daemon *Daemon) CmdPodRun(job *engine.Job) error {
 		Pod:    daemon.podList[podId],
 		Status: types.S_VM_ASSOCIATED,
 		Cpu:    userPod.Resource.Vcpu,
+		MaxCpu: userPod.Resource.MaxVcpu,
 		Mem:    userPod.Resource.Memory,
 	}
 	daemon.podList[podId].Vm = vmId
@@ -196,7 +215,8 @@ func (daemon *Daemon) CreatePod(podArgs, podId string, wg *sync.WaitGroup) error
 	} else {
 		// Process the 'Containers' section
 		glog.V(1).Info("Process the Containers section in POD SPEC\n")
-		for _, c := range userPod.Containers {
+		// the init containers go first, the VM runs them in order
+		for _, c := range userPod.AllContainers() {
 			imgName := c.Image
 			body, _, err := daemon.dockerCli.SendCmdCreate(imgName)
 			if err != nil {
@@ -223,6 +243,9 @@ func (daemon *Daemon) CreatePod(podArgs, podId string, wg *sync.WaitGroup) error
 	containers := []*Container{}
 	for _, v := range daemon.containerList {
 		if v.PodId == podId {
+			if err := daemon.GetContainerExit(v); err != nil {
+				glog.Warningf("Failed to load the exit code of container %s: %s", v.Id, err.Error())
+			}
 			containers = append(containers, v)
 		}
 	}
@@ -243,17 +266,8 @@ func (daemon *Daemon) CreatePod(podArgs, podId string, wg *sync.WaitGroup) error
 
 func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error) {
 	var (
-		fstype            string
-		poolName          string
-		volPoolName       string
-		devPrefix         string
-		storageDriver     string
-		rootPath          string
-		devFullName       string
-		rootfs            string
 		containerInfoList = []*qemu.ContainerInfo{}
 		volumuInfoList    = []*qemu.VolumeInfo{}
-		cli               = daemon.dockerCli
 		qemuPodEvent      = make(chan qemu.QemuEvent, 128)
 		qemuStatus        = make(chan *types.QemuResponse, 128)
 		subQemuStatus     = make(chan *types.QemuResponse, 128)
@@ -262,8 +276,6 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 		mypod             *Pod
 		wg		  *sync.WaitGroup
 		err               error
-		uid               string
-		gid               string
 	)
 	if podArgs == "" {
 		mypod = daemon.podList[podId]
@@ -282,6 +294,12 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 	if err != nil {
 		return -1, "", err
 	}
+	if err := daemon.resolveNetworks(userPod); err != nil {
+		return -1, "", err
+	}
+	if err := daemon.checkAddresses(podId, userPod); err != nil {
+		return -1, "", err
+	}
 
 	vm := daemon.vmList[vmId]
 	if vm == nil {
@@ -296,14 +314,7 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 		if userPod.Resource.Memory > 0 {
 			mem = userPod.Resource.Memory
 		}
-		b := &qemu.BootConfig{
-			CPU:    cpu,
-			Memory: mem,
-			Kernel: daemon.kernel,
-			Initrd: daemon.initrd,
-			Bios:   daemon.bios,
-			Cbfs:   daemon.cbfs,
-		}
+		b := daemon.bootConfig(cpu, userPod.Resource.MaxVcpu, mem)
 
 		go qemu.QemuLoop(vmId, qemuPodEvent, qemuStatus, b)
 		if err := daemon.SetQemuChan(vmId, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
@@ -329,310 +340,33 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 		mypod = daemon.podList[podId]
 	}
 
-	storageDriver = daemon.Storage.StorageType
-	if storageDriver == "devicemapper" {
-		poolName = daemon.Storage.PoolName
-		fstype = daemon.Storage.Fstype
-		volPoolName = "hyper-volume-pool"
-		devPrefix = poolName[:strings.Index(poolName, "-pool")]
-		rootPath = "/var/lib/docker/devicemapper"
-		rootfs = "/rootfs"
-	} else if storageDriver == "aufs" {
-		rootPath = daemon.Storage.RootPath
-		fstype = daemon.Storage.Fstype
-		rootfs = ""
-	} else if storageDriver == "overlay" {
-		rootPath = daemon.Storage.RootPath
-		fstype = daemon.Storage.Fstype
-		rootfs = ""
-	}
-
 	// Process the 'Files' section
 	files := make(map[string](pod.UserFile))
 	for _, v := range userPod.Files {
 		files[v.Name] = v
 	}
 
+	specs := userPod.AllContainers()
 	for i, c := range mypod.Containers {
-		var jsonResponse *docker.ConfigJSON
-		if jsonResponse, err = cli.GetContainerInfo(c.Id); err != nil {
-			glog.Error("got error when get container Info ", err.Error())
+		containerInfo, err := daemon.prepareContainer(c, &specs[i], files, sharedDir)
+		if err != nil {
 			return -1, "", err
 		}
-
-		if storageDriver == "devicemapper" {
-			if err := dm.CreateNewDevice(c.Id, devPrefix, rootPath); err != nil {
-				return -1, "", err
-			}
-			devFullName, err = dm.MountContainerToSharedDir(c.Id, sharedDir, devPrefix)
-			if err != nil {
-				glog.Error("got error when mount container to share dir ", err.Error())
-				return -1, "", err
-			}
-			fstype, err = dm.ProbeFsType(devFullName)
-			if err != nil {
-				fstype = "ext4"
-			}
-		} else if storageDriver == "aufs" {
-			devFullName, err = aufs.MountContainerToSharedDir(c.Id, rootPath, sharedDir, "")
-			if err != nil {
-				glog.Error("got error when mount container to share dir ", err.Error())
-				return -1, "", err
-			}
-			devFullName = "/" + c.Id + "/rootfs"
-		} else if storageDriver == "overlay" {
-			devFullName, err = overlay.MountContainerToSharedDir(c.Id, rootPath, sharedDir, "")
-			if err != nil {
-				glog.Error("got error when mount container to share dir ", err.Error())
-				return -1, "", err
-			}
-			devFullName = "/" + c.Id + "/rootfs"
-		}
-
-		for _, f := range userPod.Containers[i].Files {
-			targetPath := f.Path
-			file, ok := files[f.Filename]
-			if !ok {
-				continue
-			}
-			var fromFile = "/tmp/" + file.Name
-			defer os.RemoveAll(fromFile)
-			if file.Uri != "" {
-				err = utils.DownloadFile(file.Uri, fromFile)
-				if err != nil {
-					return -1, "", err
-				}
-			} else if file.Contents != "" {
-				err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
-				if err != nil {
-					return -1, "", err
-				}
-			} else {
-				continue
-			}
-			// we need to decode the content
-			fi, err := os.Open(fromFile)
-			if err != nil {
-				return -1, "", err
-			}
-			defer fi.Close()
-			fileContent, err := ioutil.ReadAll(fi)
-			if err != nil {
-				return -1, "", err
-			}
-			if file.Encoding == "base64" {
-				newContent, err := utils.Base64Decode(string(fileContent))
-				if err != nil {
-					return -1, "", err
-				}
-				err = ioutil.WriteFile(fromFile, []byte(newContent), 0666)
-				if err != nil {
-					return -1, "", err
-				}
-			} else {
-				err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
-				if err != nil {
-					return -1, "", err
-				}
-			}
-			// get the uid and gid for that attached file
-			fileUser := f.User
-			fileGroup := f.Group
-			u, _ := user.Current()
-			if fileUser == "" {
-				uid = u.Uid
-			} else {
-				u, _ = user.Lookup(fileUser)
-				uid = u.Uid
-				gid = u.Gid
-			}
-			if fileGroup == "" {
-				gid = u.Gid
-			}
-
-			if storageDriver == "devicemapper" {
-				err := dm.AttachFiles(c.Id, devPrefix, fromFile, targetPath, rootPath, f.Perm, uid, gid)
-				if err != nil {
-					glog.Error("got error when attach files ", err.Error())
-					return -1, "", err
-				}
-			} else if storageDriver == "aufs" {
-				err := aufs.AttachFiles(c.Id, fromFile, targetPath, rootPath, f.Perm, uid, gid)
-				if err != nil {
-					glog.Error("got error when attach files ", err.Error())
-					return -1, "", err
-				}
-			} else if storageDriver == "overlay" {
-				err := overlay.AttachFiles(c.Id, fromFile, targetPath, rootPath, f.Perm, uid, gid)
-				if err != nil {
-					glog.Error("got error when attach files ", err.Error())
-					return -1, "", err
-				}
-			}
-		}
-
-		env := make(map[string]string)
-		for _, v := range jsonResponse.Config.Env {
-			env[v[:strings.Index(v, "=")]] = v[strings.Index(v, "=")+1:]
-		}
-		for _, e := range userPod.Containers[i].Envs {
-			env[e.Env] = e.Value
-		}
-		glog.V(1).Infof("Parsing envs for container %d: %d Evs", i, len(env))
-		glog.V(1).Infof("The fs type is %s", fstype)
-		glog.V(1).Infof("WorkingDir is %s", string(jsonResponse.Config.WorkingDir))
-		glog.V(1).Infof("Image is %s", string(devFullName))
-		containerInfo := &qemu.ContainerInfo{
-			Id:         c.Id,
-			Rootfs:     rootfs,
-			Image:      devFullName,
-			Fstype:     fstype,
-			Workdir:    jsonResponse.Config.WorkingDir,
-			Entrypoint: jsonResponse.Config.Entrypoint,
-			Cmd:        jsonResponse.Config.Cmd,
-			Envs:       env,
-		}
-		glog.V(1).Infof("Container Info is \n%v", containerInfo)
 		containerInfoList = append(containerInfoList, containerInfo)
-		glog.V(1).Infof("container %d created %s, workdir %s, env: %v", i, c.Id, jsonResponse.Config.WorkingDir, env)
 	}
 
 	// Process the 'Volumes' section
 	for _, v := range userPod.Volumes {
-		if v.Source == "" {
-			if storageDriver == "devicemapper" {
-				volName := fmt.Sprintf("%s-%s-%s", volPoolName, podId, v.Name)
-				dev_id, _ := daemon.GetVolumeId(podId, volName)
-				glog.Error("DeviceID is %d", dev_id)
-				if dev_id < 1 {
-					dev_id, _ = daemon.GetMaxDeviceId()
-					err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id+1), false)
-					if err != nil {
-						return -1, "", err
-					}
-				} else {
-					err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id), true)
-					if err != nil {
-						return -1, "", err
-					}
-				}
-
-				fstype, err = dm.ProbeFsType("/dev/mapper/" + volName)
-				if err != nil {
-					fstype = "ext4"
-				}
-				myVol := &qemu.VolumeInfo{
-					Name:     v.Name,
-					Filepath: path.Join("/dev/mapper/", volName),
-					Fstype:   fstype,
-					Format:   "raw",
-				}
-				volumuInfoList = append(volumuInfoList, myVol)
-				glog.V(1).Infof("volume %s created with dm as %s", v.Name, volName)
-				continue
-
-			} else {
-				// Make sure the v.Name is given
-				v.Source = path.Join("/var/tmp/hyper/", v.Name)
-				if _, err := os.Stat(v.Source); err != nil && os.IsNotExist(err) {
-					if err := os.MkdirAll(v.Source, os.FileMode(0777)); err != nil {
-						return -1, "", err
-					}
-				}
-				v.Driver = "vfs"
-			}
-		}
-
-		if v.Driver != "vfs" {
-			glog.V(1).Infof("bypass %s volume %s", v.Driver, v.Name)
-			continue
-		}
-
-		// Process the situation if the source is not NULL, we need to bind that dir to sharedDir
-		var flags uintptr = syscall.MS_BIND
-
-		mountSharedDir := pod.RandStr(10, "alpha")
-		targetDir := path.Join(sharedDir, mountSharedDir)
-		glog.V(1).Infof("trying to bind dir %s to %s", v.Source, targetDir)
-
-		if err := os.MkdirAll(targetDir, 0755); err != nil && !os.IsExist(err) {
-			glog.Errorf("error to create dir %s for volume %s", targetDir, v.Name)
+		myVol, err := daemon.prepareVolume(podId, v, sharedDir, userPod.Resource.Memory)
+		if err != nil {
 			return -1, "", err
 		}
-
-		if err := syscall.Mount(v.Source, targetDir, "dir", flags, "--bind"); err != nil {
-			glog.Errorf("bind dir %s failed: %s", v.Source, err.Error())
-			return -1, "", err
+		if myVol != nil {
+			volumuInfoList = append(volumuInfoList, myVol)
 		}
-		myVol := &qemu.VolumeInfo{
-			Name:     v.Name,
-			Filepath: mountSharedDir,
-			Fstype:   "dir",
-			Format:   "",
-		}
-		glog.V(1).Infof("dir %s is bound to %s", v.Source, targetDir)
-		volumuInfoList = append(volumuInfoList, myVol)
-	}
-
-	go func(interface{}) {
-		for {
-			qemuResponse := <-qemuStatus
-			subQemuStatus <- qemuResponse
-			if qemuResponse.Code == types.E_POD_FINISHED {
-				data := qemuResponse.Data.([]uint32)
-				daemon.SetPodContainerStatus(podId, data)
-				daemon.podList[podId].Vm = ""
-			} else if qemuResponse.Code == types.E_VM_SHUTDOWN {
-				if daemon.podList[podId].Status == types.S_POD_RUNNING {
-					daemon.podList[podId].Status = types.S_POD_SUCCEEDED
-					daemon.SetContainerStatus(podId, types.S_POD_SUCCEEDED)
-				}
-				daemon.podList[podId].Vm = ""
-				daemon.RemoveVm(vmId)
-				daemon.DeleteQemuChan(vmId)
-				mypod = daemon.podList[podId]
-				if mypod.Type == "kubernetes" {
-					switch mypod.Status {
-					case types.S_POD_SUCCEEDED:
-						if mypod.RestartPolicy == "always" {
-							daemon.RestartPod(mypod)
-						} else {
-							daemon.DeletePodFromDB(podId)
-							for _, c := range daemon.podList[podId].Containers {
-								glog.V(1).Infof("Ready to rm container: %s", c.Id)
-								if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
-									glog.V(1).Infof("Error to rm container: %s", err.Error())
-								}
-							}
-							//							daemon.RemovePod(podId)
-							daemon.DeletePodContainerFromDB(podId)
-							daemon.DeleteVolumeId(podId)
-						}
-						break
-					case types.S_POD_FAILED:
-						if mypod.RestartPolicy != "never" {
-							daemon.RestartPod(mypod)
-						} else {
-							daemon.DeletePodFromDB(podId)
-							for _, c := range daemon.podList[podId].Containers {
-								glog.V(1).Infof("Ready to rm container: %s", c.Id)
-								if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
-									glog.V(1).Infof("Error to rm container: %s", err.Error())
-								}
-							}
-							//							daemon.RemovePod(podId)
-							daemon.DeletePodContainerFromDB(podId)
-							daemon.DeleteVolumeId(podId)
-						}
-						break
-					default:
-						break
-					}
-				}
-				break
-			}
-		}
-	}(subQemuStatus)
+	}
+
+	go daemon.podStatusLoop(podId, vmId, qemuStatus, subQemuStatus)
 
 	if daemon.podList[podId].Type == "kubernetes" {
 		for _, c := range userPod.Containers {
@@ -651,6 +385,10 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 	daemon.podList[podId].Status = types.S_POD_RUNNING
 	// Set the container status to online
 	daemon.SetContainerStatus(podId, types.S_POD_RUNNING)
+	// Forget the exit codes of the last run
+	for _, c := range daemon.podList[podId].Containers {
+		daemon.DeleteContainerExit(c)
+	}
 
 	// wait for the qemu response
 	var qemuResponse *types.QemuResponse
@@ -667,6 +405,10 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 	if qemuResponse.Data == nil {
 		return qemuResponse.Code, qemuResponse.Cause, fmt.Errorf("QEMU response data is nil")
 	}
+	if qemuResponse.Code == types.E_POD_FINISHED {
+		// an init container failed, the exit codes are set by podStatusLoop
+		return qemuResponse.Code, qemuResponse.Cause, fmt.Errorf("The init containers of POD %s failed", podId)
+	}
 	data := qemuResponse.Data.([]byte)
 	daemon.UpdateVmData(vmId, data)
 	// add or update the Vm info for POD
@@ -678,6 +420,344 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 	return qemuResponse.Code, qemuResponse.Cause, nil
 }
 
+// prepareContainer mounts the rootfs of the container into the share dir
+// of the VM, or prepares its dm device, and attaches the files to it.
+func (daemon *Daemon) prepareContainer(c *Container, spec *pod.UserContainer,
+	files map[string]pod.UserFile, sharedDir string) (*qemu.ContainerInfo, error) {
+	var (
+		storageDriver = daemon.Storage.StorageType
+		fstype        = daemon.Storage.Fstype
+		rootPath      = daemon.Storage.RootPath
+		devPrefix     string
+		rootfs        string
+		devFullName   string
+		uid           string
+		gid           string
+		err           error
+	)
+	if storageDriver == "devicemapper" {
+		poolName := daemon.Storage.PoolName
+		devPrefix = poolName[:strings.Index(poolName, "-pool")]
+		rootPath = "/var/lib/docker/devicemapper"
+		rootfs = "/rootfs"
+	}
+
+	var jsonResponse *docker.ConfigJSON
+	if jsonResponse, err = daemon.dockerCli.GetContainerInfo(c.Id); err != nil {
+		glog.Error("got error when get container Info ", err.Error())
+		return nil, err
+	}
+
+	if storageDriver == "devicemapper" {
+		if err := dm.CreateNewDevice(c.Id, devPrefix, rootPath); err != nil {
+			return nil, err
+		}
+		devFullName, err = dm.MountContainerToSharedDir(c.Id, sharedDir, devPrefix)
+		if err != nil {
+			glog.Error("got error when mount container to share dir ", err.Error())
+			return nil, err
+		}
+		fstype, err = dm.ProbeFsType(devFullName)
+		if err != nil {
+			fstype = "ext4"
+		}
+	} else if storageDriver == "aufs" {
+		devFullName, err = aufs.MountContainerToSharedDir(c.Id, rootPath, sharedDir, "")
+		if err != nil {
+			glog.Error("got error when mount container to share dir ", err.Error())
+			return nil, err
+		}
+		devFullName = "/" + c.Id + "/rootfs"
+	} else if storageDriver == "overlay" {
+		devFullName, err = overlay.MountContainerToSharedDir(c.Id, rootPath, sharedDir, "")
+		if err != nil {
+			glog.Error("got error when mount container to share dir ", err.Error())
+			return nil, err
+		}
+		devFullName = "/" + c.Id + "/rootfs"
+	}
+
+	for _, f := range spec.Files {
+		targetPath := f.Path
+		file, ok := files[f.Filename]
+		if !ok {
+			continue
+		}
+		var fromFile = "/tmp/" + file.Name
+		defer os.RemoveAll(fromFile)
+		if file.Uri != "" {
+			err = utils.DownloadFile(file.Uri, fromFile)
+			if err != nil {
+				return nil, err
+			}
+		} else if file.Contents != "" {
+			err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			continue
+		}
+		// we need to decode the content
+		fi, err := os.Open(fromFile)
+		if err != nil {
+			return nil, err
+		}
+		defer fi.Close()
+		fileContent, err := ioutil.ReadAll(fi)
+		if err != nil {
+			return nil, err
+		}
+		if file.Encoding == "base64" {
+			newContent, err := utils.Base64Decode(string(fileContent))
+			if err != nil {
+				return nil, err
+			}
+			err = ioutil.WriteFile(fromFile, []byte(newContent), 0666)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// get the uid and gid for that attached file
+		fileUser := f.User
+		fileGroup := f.Group
+		u, _ := user.Current()
+		if fileUser == "" {
+			uid = u.Uid
+		} else {
+			u, _ = user.Lookup(fileUser)
+			uid = u.Uid
+			gid = u.Gid
+		}
+		if fileGroup == "" {
+			gid = u.Gid
+		}
+
+		if storageDriver == "devicemapper" {
+			err := dm.AttachFiles(c.Id, devPrefix, fromFile, targetPath, rootPath, f.Perm, uid, gid)
+			if err != nil {
+				glog.Error("got error when attach files ", err.Error())
+				return nil, err
+			}
+		} else if storageDriver == "aufs" {
+			err := aufs.AttachFiles(c.Id, fromFile, targetPath, rootPath, f.Perm, uid, gid)
+			if err != nil {
+				glog.Error("got error when attach files ", err.Error())
+				return nil, err
+			}
+		} else if storageDriver == "overlay" {
+			err := overlay.AttachFiles(c.Id, fromFile, targetPath, rootPath, f.Perm, uid, gid)
+			if err != nil {
+				glog.Error("got error when attach files ", err.Error())
+				return nil, err
+			}
+		}
+	}
+
+	env := make(map[string]string)
+	for _, v := range jsonResponse.Config.Env {
+		env[v[:strings.Index(v, "=")]] = v[strings.Index(v, "=")+1:]
+	}
+	for _, e := range spec.Envs {
+		env[e.Env] = e.Value
+	}
+	glog.V(1).Infof("Parsing envs for container %s: %d Evs", c.Id, len(env))
+	glog.V(1).Infof("The fs type is %s", fstype)
+	glog.V(1).Infof("WorkingDir is %s", string(jsonResponse.Config.WorkingDir))
+	glog.V(1).Infof("Image is %s", string(devFullName))
+	containerInfo := &qemu.ContainerInfo{
+		Id:         c.Id,
+		Rootfs:     rootfs,
+		Image:      devFullName,
+		Fstype:     fstype,
+		Workdir:    jsonResponse.Config.WorkingDir,
+		Entrypoint: jsonResponse.Config.Entrypoint,
+		Cmd:        jsonResponse.Config.Cmd,
+		Envs:       env,
+	}
+	glog.V(1).Infof("Container Info is \n%v", containerInfo)
+	glog.V(1).Infof("container %s created, workdir %s, env: %v", c.Id, jsonResponse.Config.WorkingDir, env)
+	return containerInfo, nil
+}
+
+// prepareVolume creates the dm device of the volume, or binds its dir to
+// the share dir of the VM. It returns nil for the volumes qemu inserts
+// from their source directly. The tmpfs volumes are in the memory of the
+// host, they are limited to the memory of the pod in MiB.
+func (daemon *Daemon) prepareVolume(podId string, v pod.UserVolume, sharedDir string, memory int) (*qemu.VolumeInfo, error) {
+	var (
+		storageDriver = daemon.Storage.StorageType
+		volPoolName   string
+		fstype        string
+		err           error
+	)
+	if storageDriver == "devicemapper" {
+		volPoolName = "hyper-volume-pool"
+	}
+
+	if v.Source == "" && v.Driver != "tmpfs" {
+		if storageDriver == "devicemapper" {
+			volName := fmt.Sprintf("%s-%s-%s", volPoolName, podId, v.Name)
+			dev_id, _ := daemon.GetVolumeId(podId, volName)
+			glog.Error("DeviceID is %d", dev_id)
+			if dev_id < 1 {
+				dev_id, _ = daemon.GetMaxDeviceId()
+				err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id+1), false)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id), true)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			fstype, err = dm.ProbeFsType("/dev/mapper/" + volName)
+			if err != nil {
+				fstype = "ext4"
+			}
+			myVol := &qemu.VolumeInfo{
+				Name:     v.Name,
+				Filepath: path.Join("/dev/mapper/", volName),
+				Fstype:   fstype,
+				Format:   "raw",
+			}
+			glog.V(1).Infof("volume %s created with dm as %s", v.Name, volName)
+			return myVol, nil
+
+		} else {
+			// Make sure the v.Name is given
+			v.Source = path.Join("/var/tmp/hyper/", v.Name)
+			if _, err := os.Stat(v.Source); err != nil && os.IsNotExist(err) {
+				if err := os.MkdirAll(v.Source, os.FileMode(0777)); err != nil {
+					return nil, err
+				}
+			}
+			v.Driver = "vfs"
+		}
+	}
+
+	if v.Driver != "vfs" && v.Driver != "tmpfs" {
+		glog.V(1).Infof("bypass %s volume %s", v.Driver, v.Name)
+		return nil, nil
+	}
+
+	// Process the situation if the source is not NULL, we need to bind that dir to sharedDir
+	var flags uintptr = syscall.MS_BIND
+
+	mountSharedDir := pod.RandStr(10, "alpha")
+	targetDir := path.Join(sharedDir, mountSharedDir)
+	glog.V(1).Infof("trying to bind dir %s to %s", v.Source, targetDir)
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil && !os.IsExist(err) {
+		glog.Errorf("error to create dir %s for volume %s", targetDir, v.Name)
+		return nil, err
+	}
+
+	if v.Driver == "tmpfs" {
+		// the volume in memory is a tmpfs shared to the VM
+		if err := syscall.Mount("tmpfs", targetDir, "tmpfs", 0, fmt.Sprintf("size=%dm", memory)); err != nil {
+			glog.Errorf("mount tmpfs to %s failed: %s", targetDir, err.Error())
+			return nil, err
+		}
+		glog.V(1).Infof("tmpfs is mounted to %s", targetDir)
+	} else if err := syscall.Mount(v.Source, targetDir, "dir", flags, "--bind"); err != nil {
+		glog.Errorf("bind dir %s failed: %s", v.Source, err.Error())
+		return nil, err
+	}
+	myVol := &qemu.VolumeInfo{
+		Name:     v.Name,
+		Filepath: mountSharedDir,
+		Fstype:   "dir",
+		Format:   "",
+	}
+	glog.V(1).Infof("volume %s is ready in %s", v.Name, targetDir)
+	return myVol, nil
+}
+
+// podStatusLoop updates the pod with the responses of the VM running it,
+// the responses are forwarded to subQemuStatus.
+func (daemon *Daemon) podStatusLoop(podId, vmId string, qemuStatus, subQemuStatus chan *types.QemuResponse) {
+	for {
+		qemuResponse := <-qemuStatus
+		subQemuStatus <- qemuResponse
+		if qemuResponse.Code == types.E_POD_FINISHED {
+			data := qemuResponse.Data.([]uint32)
+			daemon.SetPodContainerStatus(podId, data)
+			daemon.podList[podId].Vm = ""
+			daemon.podWatchers.wake(podId)
+		} else if qemuResponse.Code == types.E_VM_SHUTDOWN {
+			if daemon.podList[podId].Status == types.S_POD_MIGRATING {
+				daemon.RemoveVm(vmId)
+				daemon.DeleteQemuChan(vmId)
+				daemon.forgetMigratedPod(podId)
+				daemon.podWatchers.wake(podId)
+				break
+			}
+			if daemon.podList[podId].Status == types.S_POD_RUNNING ||
+				daemon.podList[podId].Status == types.S_POD_PAUSED {
+				daemon.podList[podId].Status = types.S_POD_SUCCEEDED
+				daemon.SetContainerStatus(podId, types.S_POD_SUCCEEDED)
+			}
+			daemon.podList[podId].Vm = ""
+			daemon.RemoveVm(vmId)
+			daemon.DeleteQemuChan(vmId)
+			// wake up the waiters before the pod is restarted
+			daemon.podWatchers.wake(podId)
+			mypod := daemon.podList[podId]
+			if mypod.Type == "kubernetes" {
+				switch mypod.Status {
+				case types.S_POD_SUCCEEDED:
+					if mypod.RestartPolicy == "always" {
+						daemon.RestartPod(mypod)
+					} else {
+						daemon.DeletePodFromDB(podId)
+						for _, c := range daemon.podList[podId].Containers {
+							glog.V(1).Infof("Ready to rm container: %s", c.Id)
+							if _, _, err := daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
+								glog.V(1).Infof("Error to rm container: %s", err.Error())
+							}
+							removeContainerLog(c.Id)
+							daemon.DeleteContainerExit(c)
+						}
+						//							daemon.RemovePod(podId)
+						daemon.DeletePodContainerFromDB(podId)
+						daemon.DeleteVolumeId(podId)
+					}
+					break
+				case types.S_POD_FAILED:
+					if mypod.RestartPolicy != "never" {
+						daemon.RestartPod(mypod)
+					} else {
+						daemon.DeletePodFromDB(podId)
+						for _, c := range daemon.podList[podId].Containers {
+							glog.V(1).Infof("Ready to rm container: %s", c.Id)
+							if _, _, err := daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
+								glog.V(1).Infof("Error to rm container: %s", err.Error())
+							}
+							removeContainerLog(c.Id)
+							daemon.DeleteContainerExit(c)
+						}
+						//							daemon.RemovePod(podId)
+						daemon.DeletePodContainerFromDB(podId)
+						daemon.DeleteVolumeId(podId)
+					}
+					break
+				default:
+					break
+				}
+			}
+			break
+		}
+	}
+}
+
 // The caller must make sure that the restart policy and the status is right to restart
 func (daemon *Daemon) RestartPod(mypod *Pod) error {
 	// Remove the pod
@@ -687,6 +767,8 @@ func (daemon *Daemon) RestartPod(mypod *Pod) error {
 		if _, _, err := daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 			glog.V(1).Infof("Error to rm container: %s", err.Error())
 		}
+		removeContainerLog(c.Id)
+		daemon.DeleteContainerExit(c)
 	}
 	daemon.RemovePod(mypod.Id)
 	daemon.DeletePodContainerFromDB(mypod.Id)
@@ -714,6 +796,7 @@ func (daemon *Daemon) RestartPod(mypod *Pod) error {
 		Pod:    daemon.podList[mypod.Id],
 		Status: types.S_VM_ASSOCIATED,
 		Cpu:    userPod.Resource.Vcpu,
+		MaxCpu: userPod.Resource.MaxVcpu,
 		Mem:    userPod.Resource.Memory,
 	}
 	daemon.podList[mypod.Id].Vm = vmId
@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/qemu"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdPodResize(job *engine.Job) error {
+	if len(job.Args) < 3 {
+		return fmt.Errorf("Can not resize the POD without POD ID, cpu and memory size")
+	}
+	podId := job.Args[0]
+	cpu, mem := 0, 0
+	if job.Args[1] != "" {
+		n, err := strconv.Atoi(job.Args[1])
+		if err != nil {
+			return fmt.Errorf("Invalid cpu number %s", job.Args[1])
+		}
+		cpu = n
+	}
+	if job.Args[2] != "" {
+		n, err := strconv.Atoi(job.Args[2])
+		if err != nil {
+			return fmt.Errorf("Invalid memory size %s", job.Args[2])
+		}
+		mem = n
+	}
+	if cpu <= 0 && mem <= 0 {
+		return fmt.Errorf("Can not resize the POD without cpu or memory size")
+	}
+
+	code, cause, vm, err := daemon.ResizePod(podId, cpu, mem)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.SetInt("Cpu", vm.Cpu)
+	v.SetInt("Memory", vm.Mem)
+	v.SetInt("Code", code)
+	v.Set("Cause", cause)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResizePod plugs vcpus into the running pod until it has cpu of them, and
+// sets its memory to mem MB, either is skipped if it is 0. It returns the
+// size the VM actually has.
+func (daemon *Daemon) ResizePod(podId string, cpu, mem int) (int, string, *Vm, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return -1, "", nil, fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	if mypod.Status != types.S_POD_RUNNING || mypod.Vm == "" {
+		return -1, "", nil, fmt.Errorf("The POD(%s) is not running, can not resize it", podId)
+	}
+	vm, ok := daemon.vmList[mypod.Vm]
+	if !ok {
+		return -1, "", nil, fmt.Errorf("Can not find the VM(%s)", mypod.Vm)
+	}
+	qemuPodEvent, _, _, err := daemon.GetQemuChan(mypod.Vm)
+	if err != nil {
+		return -1, "", nil, err
+	}
+
+	// the size the VM has now, the memory is the balloon size
+	actual := &Vm{Id: vm.Id, Cpu: vm.Cpu, MaxCpu: vm.MaxCpu}
+	if cpu > 0 {
+		addEvent := &qemu.CpuAddCommand{
+			Cpu:      cpu,
+			Callback: make(chan *types.QemuResponse, 1),
+		}
+		qemuPodEvent.(chan qemu.QemuEvent) <- addEvent
+		qemuResponse := <-addEvent.Callback
+		glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+		if qemuResponse.Code != types.E_OK {
+			return qemuResponse.Code, qemuResponse.Cause, actual, nil
+		}
+		vm.Cpu = cpu
+		actual.Cpu = cpu
+		if data, ok := qemuResponse.Data.([]byte); ok {
+			daemon.UpdateVmData(vm.Id, data)
+		}
+	}
+	if mem <= 0 {
+		return types.E_OK, "Resize POD success", actual, nil
+	}
+
+	resizeEvent := &qemu.ResizeCommand{
+		Memory:   mem,
+		Callback: make(chan *types.QemuResponse, 1),
+	}
+	qemuPodEvent.(chan qemu.QemuEvent) <- resizeEvent
+	qemuResponse := <-resizeEvent.Callback
+	glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+
+	actual.Mem, _ = qemuResponse.Data.(int)
+	return qemuResponse.Code, qemuResponse.Cause, actual, nil
+}
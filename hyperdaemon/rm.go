@@ -19,7 +19,7 @@ func (daemon *Daemon) CmdPodRm(job *engine.Job) (err error) {
 		return fmt.Errorf("Can not find that Pod(%s)", podId)
 	}
 
-	if daemon.podList[podId].Status != types.S_POD_RUNNING {
+	if daemon.podList[podId].Status != types.S_POD_RUNNING && daemon.podList[podId].Status != types.S_POD_PAUSED {
 		// If the pod type is kubernetes, we just remove the pod from the pod list.
 		// The persistent data has been removed since we got the E_VM_SHUTDOWN event.
 		if daemon.podList[podId].Type == "kubernetes" {
@@ -32,6 +32,8 @@ func (daemon *Daemon) CmdPodRm(job *engine.Job) (err error) {
 				if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 					glog.V(1).Infof("Error to rm container: %s", err.Error())
 				}
+				removeContainerLog(c.Id)
+				daemon.DeleteContainerExit(c)
 			}
 			daemon.RemovePod(podId)
 			daemon.DeletePodContainerFromDB(podId)
@@ -50,6 +52,8 @@ func (daemon *Daemon) CmdPodRm(job *engine.Job) (err error) {
 				if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 					glog.V(1).Infof("Error to rm container: %s", err.Error())
 				}
+				removeContainerLog(c.Id)
+				daemon.DeleteContainerExit(c)
 			}
 			daemon.RemovePod(podId)
 			daemon.DeletePodContainerFromDB(podId)
@@ -0,0 +1,170 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/qemu"
+	"hyper/types"
+)
+
+// snapshotTimeout is how long the VM is given to save its memory
+const snapshotTimeout = 5 * time.Minute
+
+// VmSnapshots keeps the memory images of idle VMs, one for each VM size.
+// A new VM is restored from the image of its size instead of booting the
+// kernel, the image is taken in background the first time a size is used.
+type VmSnapshots struct {
+	dir    string
+	lock   *sync.Mutex
+	taking map[VmSize]bool
+}
+
+// NewVmSnapshots cleans the images left in dir, they may be taken with
+// another kernel or initrd.
+func NewVmSnapshots(dir string) (*VmSnapshots, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &VmSnapshots{
+		dir:    dir,
+		lock:   &sync.Mutex{},
+		taking: make(map[VmSize]bool),
+	}, nil
+}
+
+func (s *VmSnapshots) path(size VmSize) string {
+	return path.Join(s.dir, fmt.Sprintf("vm-%d-%d.img", size.Cpu, size.Mem))
+}
+
+// lookup returns the image of the size, or "" if it is not taken yet
+func (s *VmSnapshots) lookup(size VmSize) string {
+	image := s.path(size)
+	if _, err := os.Stat(image); err != nil {
+		return ""
+	}
+	return image
+}
+
+// bootConfig returns the config to boot a VM, which is restored from the
+// snapshot of its size if there is one. The restored VMs could not plug
+// more vcpus.
+func (daemon *Daemon) bootConfig(cpu, maxCpu, mem int) *qemu.BootConfig {
+	b := &qemu.BootConfig{
+		CPU:    cpu,
+		MaxCPU: maxCpu,
+		Memory: mem,
+		Kernel: daemon.kernel,
+		Initrd: daemon.initrd,
+		Bios:   daemon.bios,
+		Cbfs:   daemon.cbfs,
+	}
+	if daemon.snapshots == nil || maxCpu > cpu {
+		return b
+	}
+
+	size := vmSize(cpu, mem)
+	if b.Snapshot = daemon.snapshots.lookup(size); b.Snapshot == "" {
+		go daemon.takeSnapshot(daemon.snapshots, size)
+	}
+	return b
+}
+
+// takeSnapshot boots a VM of the size and saves its memory as the image
+// of the size in s. The VM is not added to vmList, it is shut down after
+// that.
+func (daemon *Daemon) takeSnapshot(s *VmSnapshots, size VmSize) {
+	s.lock.Lock()
+	if s.taking[size] {
+		s.lock.Unlock()
+		return
+	}
+	s.taking[size] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.taking, size)
+		s.lock.Unlock()
+	}()
+
+	var (
+		vmId         = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		qemuPodEvent = make(chan qemu.QemuEvent, 128)
+		qemuStatus   = make(chan *types.QemuResponse, 128)
+		image        = s.path(size)
+		tmp          = image + ".tmp"
+	)
+	b := &qemu.BootConfig{
+		CPU:    size.Cpu,
+		Memory: size.Mem,
+		Kernel: daemon.kernel,
+		Initrd: daemon.initrd,
+		Bios:   daemon.bios,
+		Cbfs:   daemon.cbfs,
+	}
+	go qemu.QemuLoop(vmId, qemuPodEvent, qemuStatus, b)
+
+	for {
+		qemuResponse := <-qemuStatus
+		if qemuResponse.Code == types.E_VM_RUNNING {
+			break
+		}
+		if vmExited(qemuResponse) {
+			glog.Errorf("Failed to boot VM %s for the snapshot: %s", vmId, qemuResponse.Cause)
+			return
+		}
+	}
+
+	var (
+		result  = make(chan *types.QemuResponse, 1)
+		timeout = time.After(snapshotTimeout)
+		rsp     *types.QemuResponse
+	)
+	qemuPodEvent <- &qemu.SnapshotCommand{Path: tmp, Callback: result}
+	for rsp == nil {
+		select {
+		case rsp = <-result:
+		case qemuResponse := <-qemuStatus:
+			if vmExited(qemuResponse) {
+				glog.Errorf("VM %s exited while taking the snapshot: %s", vmId, qemuResponse.Cause)
+				os.Remove(tmp)
+				return
+			}
+		case <-timeout:
+			rsp = &types.QemuResponse{Code: types.E_FAILED, Cause: "timeout"}
+		}
+	}
+
+	qemuPodEvent <- &qemu.ShutdownCommand{}
+	for qemuResponse := range qemuStatus {
+		if vmExited(qemuResponse) {
+			break
+		}
+	}
+
+	if rsp.Code != types.E_OK {
+		glog.Errorf("Failed to take the snapshot of VM %s: %s", vmId, rsp.Cause)
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, image); err != nil {
+		glog.Errorf("Failed to save the snapshot %s: %s", image, err.Error())
+		os.Remove(tmp)
+		return
+	}
+	glog.V(1).Infof("Took the snapshot %s of VM %s", image, vmId)
+}
+
+// vmExited tells whether the VM is gone after reporting rsp, it is closed
+// without reporting shutdown if it fails at boot.
+func vmExited(rsp *types.QemuResponse) bool {
+	return rsp.Code == types.E_VM_SHUTDOWN || rsp.Code == types.E_FAILED || rsp.Code == types.E_BAD_REQUEST
+}
@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestBootConfigSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "stale.img"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := NewVmSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, "stale.img")); !os.IsNotExist(err) {
+		t.Error("the stale snapshot is not cleaned")
+	}
+
+	image := snapshots.path(VmSize{Cpu: 1, Mem: 128})
+	if err := ioutil.WriteFile(image, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// keep the daemon from taking the missing ones
+	snapshots.taking[VmSize{Cpu: 2, Mem: 256}] = true
+
+	daemon := &Daemon{kernel: "kernel", initrd: "initrd", snapshots: snapshots}
+	if b := daemon.bootConfig(0, 0, 0); b.Snapshot != image {
+		t.Errorf("the default VM is not restored from %s, but %q", image, b.Snapshot)
+	}
+	if b := daemon.bootConfig(1, 0, 128); b.Snapshot != image || b.Kernel != "kernel" || b.Initrd != "initrd" {
+		t.Errorf("wrong boot config %#v", b)
+	}
+	if b := daemon.bootConfig(1, 2, 128); b.Snapshot != "" {
+		t.Error("the VM which could plug vcpus is restored from ", b.Snapshot)
+	}
+	if b := daemon.bootConfig(2, 0, 256); b.Snapshot != "" {
+		t.Error("the VM is restored from a missing snapshot ", b.Snapshot)
+	}
+
+	daemon.snapshots = nil
+	if b := daemon.bootConfig(1, 0, 128); b.Snapshot != "" {
+		t.Error("the VM is restored with the snapshots disabled ", b.Snapshot)
+	}
+}
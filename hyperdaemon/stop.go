@@ -34,7 +34,8 @@ func (daemon *Daemon) CmdPodStop(job *engine.Job) error {
 func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
 	glog.V(1).Infof("Prepare to stop the POD: %s", podId)
 	// find the vm id which running POD, and stop it
-	if daemon.podList[podId].Status != types.S_POD_RUNNING {
+	// the paused VM is resumed before stopped
+	if daemon.podList[podId].Status != types.S_POD_RUNNING && daemon.podList[podId].Status != types.S_POD_PAUSED {
 		return -1, "", fmt.Errorf("The POD %s has aleady stopped, can not stop again!", podId)
 	}
 	vmid, err := daemon.GetPodVmByName(podId)
@@ -97,5 +98,6 @@ func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
 	}
 	daemon.podList[podId].Status = types.S_POD_FAILED
 	daemon.SetContainerStatus(podId, types.S_POD_FAILED)
+	daemon.podWatchers.wake(podId)
 	return qemuResponse.Code, qemuResponse.Cause, nil
 }
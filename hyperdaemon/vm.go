@@ -1,11 +1,13 @@
 package daemon
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 
 	"hyper/engine"
 	"hyper/lib/glog"
+	"hyper/network"
 	"hyper/pod"
 	"hyper/qemu"
 	"hyper/types"
@@ -13,12 +15,8 @@ import (
 
 func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 	var (
-		vmId          = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
-		qemuPodEvent  = make(chan qemu.QemuEvent, 128)
-		qemuStatus    = make(chan *types.QemuResponse, 128)
-		subQemuStatus = make(chan *types.QemuResponse, 128)
-		cpu           = 1
-		mem           = 128
+		cpu = 1
+		mem = 128
 	)
 	if job.Args[0] != "" {
 		cpu, err = strconv.Atoi(job.Args[0])
@@ -32,18 +30,37 @@ func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 			return err
 		}
 	}
-	b := &qemu.BootConfig{
-		CPU:    cpu,
-		Memory: mem,
-		Kernel: daemon.kernel,
-		Initrd: daemon.initrd,
-		Bios:   daemon.bios,
-		Cbfs:   daemon.cbfs,
+
+	vmId, err := daemon.CreateVm(cpu, mem)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", vmId)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
 	}
+
+	return nil
+}
+
+// CreateVm boots an idle VM without pod
+func (daemon *Daemon) CreateVm(cpu, mem int) (string, error) {
+	var (
+		vmId          = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		qemuPodEvent  = make(chan qemu.QemuEvent, 128)
+		qemuStatus    = make(chan *types.QemuResponse, 128)
+		subQemuStatus = make(chan *types.QemuResponse, 128)
+	)
+	b := daemon.bootConfig(cpu, 0, mem)
 	go qemu.QemuLoop(vmId, qemuPodEvent, qemuStatus, b)
 	if err := daemon.SetQemuChan(vmId, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
 		glog.V(1).Infof("SetQemuChan error: %s", err.Error())
-		return err
+		return "", err
 	}
 
 	vm := &Vm{
@@ -55,16 +72,7 @@ func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 	}
 	daemon.AddVm(vm)
 
-	// Prepare the qemu status to client
-	v := &engine.Env{}
-	v.Set("ID", vmId)
-	v.SetInt("Code", 0)
-	v.Set("Cause", "")
-	if _, err := v.WriteTo(job.Stdout); err != nil {
-		return err
-	}
-
-	return nil
+	return vmId, nil
 }
 
 func (daemon *Daemon) CmdVmKill(job *engine.Job) error {
@@ -90,6 +98,10 @@ func (daemon *Daemon) CmdVmKill(job *engine.Job) error {
 }
 
 func (daemon *Daemon) KillVm(vmId string) (int, string, error) {
+	// stop the pool watching the VM before reading its status
+	if alive := daemon.vmPool.remove(vmId); !alive {
+		return types.E_VM_SHUTDOWN, "", nil
+	}
 	qemuPodEvent, qemuStatus, subQemuStatus, err := daemon.GetQemuChan(vmId)
 	if err != nil {
 		return -1, "", err
@@ -149,6 +161,9 @@ func (daemon *Daemon) AssociateAllVms() error {
 			continue
 		}
 		glog.V(1).Infof("The data for vm(%s) is %v", mypod.Vm, data)
+		if err := qemu.RestoreInterfaces(data); err != nil {
+			glog.Errorf("Unable to restore the network of VM(%s): %s", mypod.Vm, err.Error())
+		}
 		go qemu.QemuAssociate(mypod.Vm, qemuPodEvent, qemuStatus, mypod.Wg, data)
 		if err := daemon.SetQemuChan(mypod.Vm, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
 			glog.V(1).Infof("SetQemuChan error: %s", err.Error())
@@ -159,70 +174,21 @@ func (daemon *Daemon) AssociateAllVms() error {
 			Pod:    mypod,
 			Status: types.S_VM_ASSOCIATED,
 			Cpu:    userPod.Resource.Vcpu,
+			MaxCpu: userPod.Resource.MaxVcpu,
 			Mem:    userPod.Resource.Memory,
 		}
+		vm.loadCpu(data)
 		daemon.AddVm(vm)
-		daemon.SetContainerStatus(mypod.Id, types.S_POD_RUNNING)
-		mypod.Status = types.S_POD_RUNNING
-		go func(interface{}) {
-			for {
-				podId := mypod.Id
-				qemuResponse := <-qemuStatus
-				subQemuStatus <- qemuResponse
-				if qemuResponse.Code == types.E_POD_FINISHED {
-					data := qemuResponse.Data.([]uint32)
-					daemon.SetPodContainerStatus(podId, data)
-				} else if qemuResponse.Code == types.E_VM_SHUTDOWN {
-					if daemon.podList[mypod.Id].Status == types.S_POD_RUNNING {
-						daemon.podList[mypod.Id].Status = types.S_POD_SUCCEEDED
-						daemon.SetContainerStatus(podId, types.S_POD_SUCCEEDED)
-					}
-					daemon.podList[mypod.Id].Vm = ""
-					daemon.RemoveVm(mypod.Vm)
-					daemon.DeleteQemuChan(mypod.Vm)
-					if mypod.Type == "kubernetes" {
-						switch mypod.Status {
-						case types.S_POD_SUCCEEDED:
-							if mypod.RestartPolicy == "always" {
-								daemon.RestartPod(mypod)
-							} else {
-								daemon.DeletePodFromDB(podId)
-								for _, c := range mypod.Containers {
-									glog.V(1).Infof("Ready to rm container: %s", c.Id)
-									if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
-										glog.V(1).Infof("Error to rm container: %s", err.Error())
-									}
-								}
-								//								daemon.RemovePod(podId)
-								daemon.DeletePodContainerFromDB(podId)
-								daemon.DeleteVolumeId(podId)
-							}
-							break
-						case types.S_POD_FAILED:
-							if mypod.RestartPolicy != "never" {
-								daemon.RestartPod(mypod)
-							} else {
-								daemon.DeletePodFromDB(podId)
-								for _, c := range mypod.Containers {
-									glog.V(1).Infof("Ready to rm container: %s", c.Id)
-									if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
-										glog.V(1).Infof("Error to rm container: %s", err.Error())
-									}
-								}
-								//								daemon.RemovePod(podId)
-								daemon.DeletePodContainerFromDB(podId)
-								daemon.DeleteVolumeId(podId)
-							}
-							break
-						default:
-							break
-						}
-					}
-					break
-				}
-			}
-		}(subQemuStatus)
+		status := uint(types.S_POD_RUNNING)
+		if vmPaused(data) {
+			status = types.S_POD_PAUSED
+		}
+		daemon.SetContainerStatus(mypod.Id, status)
+		mypod.Status = status
+		go daemon.podStatusLoop(mypod.Id, mypod.Vm, qemuStatus, subQemuStatus)
 	}
+	// the port maps of the VMs gone while the daemon was down are stale
+	network.CleanupPortMaps()
 	return nil
 }
 
@@ -260,3 +226,28 @@ func (daemon *Daemon) ReleaseAllVms() (int, error) {
 	}
 	return types.E_OK, nil
 }
+
+// loadCpu reads the vcpus from the persisted data of the VM, they may have
+// been plugged after the pod started.
+func (vm *Vm) loadCpu(data []byte) {
+	var info struct {
+		Cpu    int
+		MaxCpu int
+	}
+	if err := json.Unmarshal(data, &info); err != nil || info.Cpu == 0 {
+		return
+	}
+	vm.Cpu = info.Cpu
+	vm.MaxCpu = info.MaxCpu
+}
+
+// vmPaused reads whether the VM was frozen from its persisted data.
+func vmPaused(data []byte) bool {
+	var info struct {
+		Paused bool
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return false
+	}
+	return info.Paused
+}
@@ -0,0 +1,294 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+)
+
+// VmSize is the cpu and memory of the VMs in a pool
+type VmSize struct {
+	Cpu int
+	Mem int
+}
+
+// VmPool keeps booted idle VMs of the configured sizes, so a new pod could
+// be run without waiting for the VM to boot.
+type VmPool struct {
+	lock    *sync.Mutex
+	sizes   map[VmSize]int
+	idle    map[VmSize][]*pooledVm
+	booting map[VmSize]int
+	stopped bool
+}
+
+// pooledVm is an idle VM in the pool, its status is watched until it is
+// taken out of the pool through take.
+type pooledVm struct {
+	id   string
+	take chan chan bool
+}
+
+func NewVmPool(sizes map[VmSize]int) *VmPool {
+	return &VmPool{
+		lock:    &sync.Mutex{},
+		sizes:   sizes,
+		idle:    make(map[VmSize][]*pooledVm),
+		booting: make(map[VmSize]int),
+	}
+}
+
+// ParseVmPool parses the pool config, which is in the format of
+// "cpu:mem:count,cpu:mem:count"
+func ParseVmPool(conf string) (map[VmSize]int, error) {
+	sizes := make(map[VmSize]int)
+	for _, item := range strings.Split(conf, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Invalid VM pool setting %s, should be cpu:mem:count", item)
+		}
+		var nums [3]int
+		for i, f := range fields {
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("Invalid VM pool setting %s, should be cpu:mem:count", item)
+			}
+			nums[i] = n
+		}
+		sizes[VmSize{Cpu: nums[0], Mem: nums[1]}] += nums[2]
+	}
+	return sizes, nil
+}
+
+// vmSize returns the size of the VM that a pod would be started in
+func vmSize(cpu, mem int) VmSize {
+	if cpu <= 0 {
+		cpu = 1
+	}
+	if mem <= 0 {
+		mem = 128
+	}
+	return VmSize{Cpu: cpu, Mem: mem}
+}
+
+// pooledVmFor returns an idle VM in the pool for the pod, the pooled VMs
+// could not plug more vcpus.
+func (daemon *Daemon) pooledVmFor(res pod.UserResource) string {
+	if res.MaxVcpu > res.Vcpu {
+		return ""
+	}
+	return daemon.GetPooledVm(res.Vcpu, res.Memory)
+}
+
+// GetPooledVm takes an idle VM of the given size out of the pool and starts
+// refilling the pool, it returns "" if there isn't any.
+func (daemon *Daemon) GetPooledVm(cpu, mem int) string {
+	pool := daemon.vmPool
+	if pool == nil {
+		return ""
+	}
+	size := vmSize(cpu, mem)
+
+	for {
+		pool.lock.Lock()
+		vms := pool.idle[size]
+		if len(vms) == 0 {
+			pool.lock.Unlock()
+			return ""
+		}
+		vm := vms[0]
+		pool.idle[size] = vms[1:]
+		pool.lock.Unlock()
+
+		// the VM may die just before taken, try the next one
+		if vm.handOver() {
+			glog.V(1).Infof("Take VM %s from the pool", vm.id)
+			daemon.FillVmPool()
+			return vm.id
+		}
+	}
+}
+
+// FillVmPool boots VMs in background until each size in the pool has
+// enough idle VMs.
+func (daemon *Daemon) FillVmPool() {
+	pool := daemon.vmPool
+	if pool == nil {
+		return
+	}
+
+	pool.lock.Lock()
+	if pool.stopped {
+		pool.lock.Unlock()
+		return
+	}
+	boots := make(map[VmSize]int)
+	for size, count := range pool.sizes {
+		if n := count - len(pool.idle[size]) - pool.booting[size]; n > 0 {
+			boots[size] = n
+			pool.booting[size] += n
+		}
+	}
+	pool.lock.Unlock()
+
+	// CreateVm takes a while, do not hold the pool with it
+	for size, n := range boots {
+		for i := 0; i < n; i++ {
+			vmId, err := daemon.CreateVm(size.Cpu, size.Mem)
+			if err != nil {
+				glog.Errorf("Failed to boot VM for the pool: %s", err.Error())
+				pool.lock.Lock()
+				pool.booting[size] -= n - i
+				pool.lock.Unlock()
+				break
+			}
+			go daemon.waitPooledVm(pool, size, vmId)
+		}
+	}
+}
+
+// waitPooledVm waits for the VM to be running and puts it into the pool,
+// then watches it until it is taken out of the pool. The VM dying in the
+// pool is removed and replaced.
+func (daemon *Daemon) waitPooledVm(pool *VmPool, size VmSize, vmId string) {
+	_, ret2, _, err := daemon.GetQemuChan(vmId)
+	if err != nil {
+		glog.Error(err.Error())
+		return
+	}
+	qemuStatus := ret2.(chan *types.QemuResponse)
+
+	running := false
+	for !running {
+		qemuResponse, ok := <-qemuStatus
+		if !ok {
+			break
+		}
+		// the VM is closed without shutdown reported if it fails at boot
+		if code := qemuResponse.Code; code == types.E_VM_SHUTDOWN || code == types.E_FAILED || code == types.E_BAD_REQUEST {
+			break
+		}
+		running = qemuResponse.Code == types.E_VM_RUNNING
+	}
+	if !running {
+		// Do not refill here, the VM would fail again at boot
+		glog.Errorf("Pooled VM %s failed to boot", vmId)
+		pool.lock.Lock()
+		pool.booting[size]--
+		pool.lock.Unlock()
+		daemon.RemoveVm(vmId)
+		daemon.DeleteQemuChan(vmId)
+		return
+	}
+
+	vm := &pooledVm{id: vmId, take: make(chan chan bool)}
+	pool.lock.Lock()
+	pool.booting[size]--
+	if pool.stopped {
+		pool.lock.Unlock()
+		return
+	}
+	pool.idle[size] = append(pool.idle[size], vm)
+	pool.lock.Unlock()
+	glog.V(1).Infof("VM %s is ready in the pool", vmId)
+
+	// Do not read the VM's status once it is taken, StartPod will do it
+	for {
+		select {
+		case reply := <-vm.take:
+			reply <- true
+			return
+		case qemuResponse, ok := <-qemuStatus:
+			if ok && qemuResponse.Code != types.E_VM_SHUTDOWN {
+				continue
+			}
+		}
+
+		glog.Errorf("Pooled VM %s is dead", vmId)
+		if !pool.drop(vm) {
+			// it has been taken out of the pool, tell the taker
+			reply := <-vm.take
+			reply <- false
+		}
+		daemon.RemoveVm(vmId)
+		daemon.DeleteQemuChan(vmId)
+		daemon.FillVmPool()
+		return
+	}
+}
+
+// handOver stops watching the VM taken out of the pool, it returns false
+// if the VM is dead.
+func (vm *pooledVm) handOver() bool {
+	reply := make(chan bool)
+	vm.take <- reply
+	return <-reply
+}
+
+// drop removes the VM from the idle ones, it returns false if the VM is not
+// in the pool.
+func (pool *VmPool) drop(vm *pooledVm) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	for size, vms := range pool.idle {
+		for i, v := range vms {
+			if v == vm {
+				pool.idle[size] = append(vms[:i:i], vms[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove takes the VM out of the pool if it is idle in it, it returns
+// false if the VM is dead in the pool.
+func (pool *VmPool) remove(vmId string) bool {
+	if pool == nil {
+		return true
+	}
+	pool.lock.Lock()
+	var vm *pooledVm
+	for size, vms := range pool.idle {
+		for i, v := range vms {
+			if v.id == vmId {
+				vm = v
+				pool.idle[size] = append(vms[:i:i], vms[i+1:]...)
+				break
+			}
+		}
+	}
+	pool.lock.Unlock()
+	if vm == nil {
+		return true
+	}
+	return vm.handOver()
+}
+
+// stop keeps the pool from booting more VMs, the idle ones are left in
+// vmList and handled as the other VMs.
+func (pool *VmPool) stop() {
+	if pool == nil {
+		return
+	}
+	pool.lock.Lock()
+	pool.stopped = true
+	idle := pool.idle
+	pool.idle = make(map[VmSize][]*pooledVm)
+	pool.lock.Unlock()
+
+	for _, vms := range idle {
+		for _, vm := range vms {
+			vm.handOver()
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/qemu"
+	"hyper/types"
+)
+
+func (daemon *Daemon) CmdVolumeAttach(job *engine.Job) error {
+	if len(job.Args) < 7 {
+		return fmt.Errorf("Can not attach the volume without POD ID, volume name and mount point")
+	}
+	podId := job.Args[0]
+	name := job.Args[1]
+	mpoint := job.Args[2]
+	readOnly := job.Args[3] == "yes"
+	if name == "" || mpoint == "" {
+		return fmt.Errorf("Can not attach the volume without volume name and mount point")
+	}
+	containers, err := parseVolumeContainers(job.Args[4])
+	if err != nil {
+		return err
+	}
+	vol := pod.UserVolume{
+		Name:   name,
+		Source: job.Args[5],
+		Driver: job.Args[6],
+	}
+
+	code, cause, err := daemon.AttachVolume(podId, vol, mpoint, readOnly, containers)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.SetInt("Code", code)
+	v.Set("Cause", cause)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) CmdVolumeDetach(job *engine.Job) error {
+	if len(job.Args) < 3 {
+		return fmt.Errorf("Can not detach the volume without POD ID and volume name")
+	}
+	podId := job.Args[0]
+	name := job.Args[1]
+	containers, err := parseVolumeContainers(job.Args[2])
+	if err != nil {
+		return err
+	}
+
+	code, cause, err := daemon.DetachVolume(podId, name, containers)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the qemu status to client
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.SetInt("Code", code)
+	v.Set("Cause", cause)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseVolumeContainers parses the containers to attach or detach the
+// volume, which is a json list of container names or IDs.
+func parseVolumeContainers(data string) ([]string, error) {
+	containers := []string{}
+	if data == "" {
+		return containers, nil
+	}
+	if err := json.Unmarshal([]byte(data), &containers); err != nil {
+		return nil, fmt.Errorf("Invalid container list %s", data)
+	}
+	return containers, nil
+}
+
+// AttachVolume mounts the volume to mpoint of the containers of the running
+// pod, or all its containers if none is given. The volume is created if it
+// is not in the pod spec.
+func (daemon *Daemon) AttachVolume(podId string, vol pod.UserVolume, mpoint string, readOnly bool, containers []string) (int, string, error) {
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return -1, "", err
+	}
+	targets, err := volumeTargets(mypod, userPod, containers)
+	if err != nil {
+		return -1, "", err
+	}
+	if len(containers) == 0 {
+		for i := range userPod.Containers {
+			targets = append(targets, i)
+		}
+	}
+
+	cmd := &qemu.VolumeAttachCommand{
+		Name:     vol.Name,
+		Path:     mpoint,
+		ReadOnly: readOnly,
+		Callback: make(chan *types.QemuResponse, 1),
+	}
+	for _, i := range targets {
+		cmd.Containers = append(cmd.Containers, mypod.Containers[len(userPod.InitContainers)+i].Id)
+	}
+
+	exist := false
+	for _, v := range userPod.Volumes {
+		if v.Name == vol.Name {
+			exist = true
+			break
+		}
+	}
+	sharedDir := path.Join(qemu.BaseDir, mypod.Vm, qemu.ShareDirTag)
+	if !exist {
+		switch vol.Driver {
+		case "", "vfs", "tmpfs", "raw", "qcow2":
+		default:
+			return -1, "", fmt.Errorf("Unsupported volume driver %s", vol.Driver)
+		}
+		if vol.Source != "" && vol.Driver == "" {
+			return -1, "", fmt.Errorf("Please specify the driver of volume %s", vol.Name)
+		}
+		info, err := daemon.prepareVolume(podId, vol, sharedDir, userPod.Resource.Memory)
+		if err != nil {
+			return -1, "", err
+		}
+		if info == nil {
+			info = &qemu.VolumeInfo{
+				Name:     vol.Name,
+				Filepath: vol.Source,
+				Fstype:   "ext4",
+				Format:   vol.Driver,
+			}
+		}
+		cmd.Volume = &vol
+		cmd.Info = info
+	}
+
+	qemuResponse, err := daemon.sendVolumeCommand(mypod.Vm, cmd, cmd.Callback)
+	if err != nil {
+		return -1, "", err
+	}
+	if qemuResponse.Code != types.E_OK {
+		if qemuResponse.Code == types.E_BAD_REQUEST && cmd.Info != nil && cmd.Info.Fstype == "dir" {
+			// the dir is bound but not used by the VM
+			releaseVolumeDir(sharedDir, cmd.Info.Filepath)
+		}
+		return qemuResponse.Code, qemuResponse.Cause, nil
+	}
+
+	if !exist {
+		userPod.Volumes = append(userPod.Volumes, vol)
+	}
+	for _, i := range targets {
+		userPod.Containers[i].Volumes = append(userPod.Containers[i].Volumes, pod.UserVolumeReference{
+			Path:     mpoint,
+			Volume:   vol.Name,
+			ReadOnly: readOnly,
+		})
+	}
+	if err := daemon.writePodSpec(podId, userPod); err != nil {
+		return -1, "", err
+	}
+	return qemuResponse.Code, qemuResponse.Cause, nil
+}
+
+// DetachVolume unmounts the volume from the containers of the running pod,
+// or all the containers using it if none is given. The volume is removed
+// from the pod spec once no container uses it.
+func (daemon *Daemon) DetachVolume(podId, name string, containers []string) (int, string, error) {
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return -1, "", err
+	}
+	targets, err := volumeTargets(mypod, userPod, containers)
+	if err != nil {
+		return -1, "", err
+	}
+
+	cmd := &qemu.VolumeDetachCommand{
+		Name:     name,
+		Callback: make(chan *types.QemuResponse, 1),
+	}
+	for _, i := range targets {
+		cmd.Containers = append(cmd.Containers, mypod.Containers[len(userPod.InitContainers)+i].Id)
+	}
+	qemuResponse, err := daemon.sendVolumeCommand(mypod.Vm, cmd, cmd.Callback)
+	if err != nil {
+		return -1, "", err
+	}
+	if qemuResponse.Code != types.E_OK {
+		return qemuResponse.Code, qemuResponse.Cause, nil
+	}
+
+	if len(containers) == 0 {
+		for i := range userPod.Containers {
+			targets = append(targets, i)
+		}
+		// the VM forgets the volume of the finished init containers too
+		for i := range userPod.InitContainers {
+			userPod.InitContainers[i].Volumes = removeVolumeRef(userPod.InitContainers[i].Volumes, name)
+		}
+	}
+	for _, i := range targets {
+		userPod.Containers[i].Volumes = removeVolumeRef(userPod.Containers[i].Volumes, name)
+	}
+	used := false
+	for _, c := range userPod.AllContainers() {
+		for _, r := range c.Volumes {
+			if r.Volume == name {
+				used = true
+			}
+		}
+	}
+	if !used {
+		for i, v := range userPod.Volumes {
+			if v.Name == name {
+				userPod.Volumes = append(userPod.Volumes[:i], userPod.Volumes[i+1:]...)
+				break
+			}
+		}
+	}
+	if err := daemon.writePodSpec(podId, userPod); err != nil {
+		return -1, "", err
+	}
+	return qemuResponse.Code, qemuResponse.Cause, nil
+}
+
+// runningPodSpec returns the running pod and its spec in the DB
+func (daemon *Daemon) runningPodSpec(podId string) (*Pod, *pod.UserPod, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return nil, nil, fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	if mypod.Status != types.S_POD_RUNNING || mypod.Vm == "" {
+		return nil, nil, fmt.Errorf("The POD(%s) is not running", podId)
+	}
+	podData, err := daemon.GetPodByName(podId)
+	if err != nil {
+		return nil, nil, err
+	}
+	userPod, err := pod.ProcessPodBytes(podData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(userPod.InitContainers)+len(userPod.Containers) != len(mypod.Containers) {
+		return nil, nil, fmt.Errorf("The containers of POD(%s) do not match its spec", podId)
+	}
+	return mypod, userPod, nil
+}
+
+// volumeTargets returns the index of the containers in the spec, which are
+// given by their names or IDs. The init containers of the pod come first in
+// mypod, they are finished and can not be the targets.
+func volumeTargets(mypod *Pod, userPod *pod.UserPod, containers []string) ([]int, error) {
+	targets := []int{}
+	for _, name := range containers {
+		found := false
+		for i, uc := range userPod.Containers {
+			c := mypod.Containers[len(userPod.InitContainers)+i]
+			if c.Id == name || uc.Name == name {
+				targets = append(targets, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Can not find container %s in POD(%s)", name, mypod.Id)
+		}
+	}
+	return targets, nil
+}
+
+func removeVolumeRef(refs []pod.UserVolumeReference, name string) []pod.UserVolumeReference {
+	kept := []pod.UserVolumeReference{}
+	for _, r := range refs {
+		if r.Volume != name {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func (daemon *Daemon) sendVolumeCommand(vmId string, cmd qemu.QemuEvent, callback chan *types.QemuResponse) (*types.QemuResponse, error) {
+	qemuPodEvent, _, _, err := daemon.GetQemuChan(vmId)
+	if err != nil {
+		return nil, err
+	}
+	qemuPodEvent.(chan qemu.QemuEvent) <- cmd
+	qemuResponse := <-callback
+	glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
+	if data, ok := qemuResponse.Data.([]byte); ok {
+		daemon.UpdateVmData(vmId, data)
+	}
+	return qemuResponse, nil
+}
+
+func (daemon *Daemon) writePodSpec(podId string, userPod *pod.UserPod) error {
+	podData, err := json.Marshal(userPod)
+	if err != nil {
+		return err
+	}
+	if err := daemon.WritePodToDB(podId, podData); err != nil {
+		glog.Error("Found an error while saving the POD file")
+		return err
+	}
+	return nil
+}
+
+func releaseVolumeDir(sharedDir, dir string) {
+	mount := path.Join(sharedDir, dir)
+	if err := syscall.Unmount(mount, 0); err != nil {
+		glog.Warningf("Cannot umount volume %s: %s", mount, err.Error())
+		return
+	}
+	os.Remove(mount)
+}
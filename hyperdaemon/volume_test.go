@@ -0,0 +1,31 @@
+package daemon
+
+import (
+	"testing"
+
+	"hyper/pod"
+)
+
+func TestVolumeTargetsInitContainers(t *testing.T) {
+	mypod := &Pod{
+		Id:         "pod-test",
+		Containers: []*Container{{Id: "i1id"}, {Id: "c1id"}, {Id: "c2id"}},
+	}
+	userPod := &pod.UserPod{
+		InitContainers: []pod.UserContainer{{Name: "init", Image: "busybox"}},
+		Containers:     []pod.UserContainer{{Name: "web", Image: "nginx"}, {Name: "db", Image: "redis"}},
+	}
+
+	targets, err := volumeTargets(mypod, userPod, []string{"db", "c1id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0] != 1 || targets[1] != 0 {
+		t.Errorf("wrong targets %v", targets)
+	}
+	for _, name := range []string{"init", "i1id"} {
+		if _, err := volumeTargets(mypod, userPod, []string{name}); err == nil {
+			t.Errorf("init container %s is a target", name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"fmt"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+)
+
+func (daemon *Daemon) CmdPodWait(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not wait for the POD without POD ID")
+	}
+	podId := job.Args[0]
+
+	containers, err := daemon.WaitPod(podId)
+	if err != nil {
+		return err
+	}
+
+	// The exit code of the pod is the one of its first failing container
+	var (
+		exitCode  = 0
+		failed    = ""
+		exitCodes = []string{}
+	)
+	for _, c := range containers {
+		if c.ExitCode != 0 && failed == "" {
+			exitCode = c.ExitCode
+			failed = c.Id
+		}
+		exitCodes = append(exitCodes, fmt.Sprintf("%s:%d", c.Id, c.ExitCode))
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.SetInt("ExitCode", exitCode)
+	v.Set("Container", failed)
+	v.SetList("Containers", exitCodes)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WaitPod blocks until the pod finishes, and returns its containers with
+// their exit codes.
+func (daemon *Daemon) WaitPod(podId string) ([]*Container, error) {
+	mypod, ok := daemon.podList[podId]
+	if !ok {
+		return nil, fmt.Errorf("Can not find the POD(%s)", podId)
+	}
+	glog.V(1).Infof("Wait for the POD(%s) to finish", podId)
+	stopped := daemon.watchPod(podId)
+	<-stopped
+
+	// the finished pod may be restarted already, its exit codes are kept
+	for _, c := range mypod.Containers {
+		if !c.FinishedAt.IsZero() {
+			continue
+		}
+		if daemon.podList[podId] != mypod {
+			return nil, fmt.Errorf("The POD(%s) was removed while waiting for it", podId)
+		}
+		return nil, fmt.Errorf("The POD(%s) did not run to completion, no exit code of container %s", podId, c.Id)
+	}
+	return mypod.Containers, nil
+}
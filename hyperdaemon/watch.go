@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"sync"
+)
+
+// watchers wakes up the ones waiting for the VMs or the pods to stop, the
+// zero value is ready to use.
+type watchers struct {
+	lock  sync.Mutex
+	chans map[string][]chan bool
+}
+
+// watch returns a channel which is closed once id stops, it is closed at
+// once if id is not alive already.
+func (w *watchers) watch(id string, alive func() bool) chan bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	ch := make(chan bool)
+	if !alive() {
+		close(ch)
+		return ch
+	}
+	if w.chans == nil {
+		w.chans = make(map[string][]chan bool)
+	}
+	w.chans[id] = append(w.chans[id], ch)
+	return ch
+}
+
+// unwatch stops watching id with ch, which may be closed already
+func (w *watchers) unwatch(id string, ch chan bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	chans := w.chans[id]
+	for i, c := range chans {
+		if c == ch {
+			chans = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(chans) == 0 {
+		delete(w.chans, id)
+	} else {
+		w.chans[id] = chans
+	}
+}
+
+// wake closes the channels watching id, it is called once id stops
+func (w *watchers) wake(id string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, ch := range w.chans[id] {
+		close(ch)
+	}
+	delete(w.chans, id)
+}
+
+// watchVm returns a channel which is closed once the VM is removed
+func (daemon *Daemon) watchVm(vmId string) chan bool {
+	return daemon.vmWatchers.watch(vmId, func() bool {
+		_, ok := daemon.vmList[vmId]
+		return ok
+	})
+}
+
+// watchPod returns a channel which is closed once the pod stops running
+func (daemon *Daemon) watchPod(podId string) chan bool {
+	return daemon.podWatchers.watch(podId, func() bool {
+		return daemon.podRunning(podId)
+	})
+}
@@ -0,0 +1,112 @@
+// Package stdcopy multiplexes the stdout and stderr of a command, followed
+// by its exit code, on one stream.
+//
+// Each frame starts with an 8 bytes header, the first byte is the stream
+// and the last 4 bytes are the big endian length of the payload.
+package stdcopy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	Stdout byte = 1
+	Stderr byte = 2
+	// Exit is the last frame, its payload is the 4 bytes exit code
+	Exit byte = 3
+
+	headerLen = 8
+)
+
+// Mux writes the frames of the streams to w
+type Mux struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+func NewMux(w io.Writer) *Mux {
+	return &Mux{w: w}
+}
+
+func (m *Mux) writeFrame(stream byte, p []byte) error {
+	buf := make([]byte, headerLen+len(p))
+	buf[0] = stream
+	binary.BigEndian.PutUint32(buf[4:headerLen], uint32(len(p)))
+	copy(buf[headerLen:], p)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, err := m.w.Write(buf)
+	return err
+}
+
+// Stream returns the writer of stream, closing it does not close the
+// underlying writer, the exit code is still to be written.
+func (m *Mux) Stream(stream byte) io.WriteCloser {
+	return &streamWriter{mux: m, stream: stream}
+}
+
+// WriteExit writes the exit code, nothing should be written after it
+func (m *Mux) WriteExit(code int) error {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(code))
+	return m.writeFrame(Exit, buf)
+}
+
+type streamWriter struct {
+	mux    *Mux
+	stream byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := sw.mux.writeFrame(sw.stream, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (sw *streamWriter) Close() error {
+	return nil
+}
+
+// Demux copies the frames read from r to stdout and stderr, until the exit
+// code is read and returned.
+func Demux(r io.Reader, stdout, stderr io.Writer) (int, error) {
+	header := make([]byte, headerLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return -1, fmt.Errorf("stream closed before the exit code")
+			}
+			return -1, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[4:headerLen]))
+		var out io.Writer
+		switch header[0] {
+		case Stdout:
+			out = stdout
+		case Stderr:
+			out = stderr
+		case Exit:
+			if length != 4 {
+				return -1, fmt.Errorf("bad exit code frame of %d bytes", length)
+			}
+			code := make([]byte, 4)
+			if _, err := io.ReadFull(r, code); err != nil {
+				return -1, err
+			}
+			return int(int32(binary.BigEndian.Uint32(code))), nil
+		default:
+			return -1, fmt.Errorf("unknown stream %d", header[0])
+		}
+		if _, err := io.CopyN(out, r, length); err != nil {
+			return -1, err
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package stdcopy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMuxDemux(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mux := NewMux(buf)
+	mux.Stream(Stdout).Write([]byte("hello "))
+	mux.Stream(Stderr).Write([]byte("oops\n"))
+	mux.Stream(Stdout).Write([]byte("world\n"))
+	mux.WriteExit(3)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code, err := Demux(buf, stdout, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("exit code should be 3, but got %d", code)
+	}
+	if stdout.String() != "hello world\n" || stderr.String() != "oops\n" {
+		t.Errorf("bad output %q, %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestDemuxNoExit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewMux(buf).Stream(Stdout).Write([]byte("hello\n"))
+
+	if _, err := Demux(buf, &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+		t.Error("should fail without exit code")
+	}
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"hyper/lib/glog"
+)
+
+var (
+	// the tap devices with bandwidth limits
+	limitedDevices     = make(map[string]bool)
+	limitedDevicesLock sync.Mutex
+)
+
+// setupBandwidth limits the bandwidth of the pod on its tap device, in
+// Mbit/s, 0 means unlimited. The packets to the pod are shaped by a tbf
+// qdisc on the tap, and the ones from it are policed on the ingress of it.
+func setupBandwidth(device string, ingress, egress int) error {
+	if ingress <= 0 && egress <= 0 {
+		return nil
+	}
+	tcPath, err := exec.LookPath("tc")
+	if err != nil {
+		return fmt.Errorf("tc is required to limit the bandwidth: %s", err)
+	}
+
+	cmds := [][]string{}
+	if ingress > 0 {
+		cmds = append(cmds, []string{"qdisc", "add", "dev", device, "root", "tbf",
+			"rate", fmt.Sprintf("%dmbit", ingress), "burst", tcBurst(ingress), "latency", "50ms"})
+	}
+	if egress > 0 {
+		cmds = append(cmds, []string{"qdisc", "add", "dev", device, "handle", "ffff:", "ingress"},
+			[]string{"filter", "add", "dev", device, "parent", "ffff:", "protocol", "all",
+				"u32", "match", "u32", "0", "0", "police", "rate", fmt.Sprintf("%dmbit", egress),
+				"burst", tcBurst(egress), "drop", "flowid", ":1"})
+	}
+
+	limitedDevicesLock.Lock()
+	limitedDevices[device] = true
+	limitedDevicesLock.Unlock()
+	for _, args := range cmds {
+		glog.V(1).Infof("tc %s", strings.Join(args, " "))
+		if output, err := exec.Command(tcPath, args...).CombinedOutput(); err != nil {
+			cleanupBandwidth(device)
+			return fmt.Errorf("Unable to limit the bandwidth of %s: %s", device, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// cleanupBandwidth removes the qdiscs added by setupBandwidth
+func cleanupBandwidth(device string) {
+	limitedDevicesLock.Lock()
+	limited := limitedDevices[device]
+	delete(limitedDevices, device)
+	limitedDevicesLock.Unlock()
+	if !limited {
+		return
+	}
+
+	exec.Command("tc", "qdisc", "del", "dev", device, "root").Run()
+	exec.Command("tc", "qdisc", "del", "dev", device, "ingress").Run()
+}
+
+// tcBurst returns the burst of the rate in bytes, which is what could be
+// sent in 10ms, but 10 full frames at least.
+func tcBurst(rate int) string {
+	burst := rate * 1000000 / 8 / 100
+	if burst < 10*1514 {
+		burst = 10 * 1514
+	}
+	return strconv.Itoa(burst)
+}
+
+// tapName returns the name of the tap device of the file
+func tapName(file *os.File) (string, error) {
+	var req ifReq
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(),
+		uintptr(syscall.TUNGETIFF),
+		uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return "", errno
+	}
+	return strings.Trim(string(req.Name[:]), "\x00"), nil
+}
@@ -0,0 +1,194 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"hyper/lib/glog"
+	"hyper/network/ipallocator"
+	"hyper/network/iptables"
+)
+
+const isolationChain = "HYPER-ISOLATION"
+
+// isolation drops the packets forwarded between the bridges of hyper, so
+// the pods on one of them can not reach the pods on the others
+type isolation struct {
+	raw     func(args ...string) ([]byte, error)
+	exists  func(table iptables.Table, chain string, rule ...string) bool
+	bridges map[string]bool
+}
+
+var (
+	isolation4    = &isolation{iptables.Raw, iptables.Exists, make(map[string]bool)}
+	isolation6    = &isolation{iptables.Raw6, iptables.Exists6, make(map[string]bool)}
+	isolationLock sync.Mutex
+)
+
+// SetupBridge sets up the bridge of a user defined network. The bridge is
+// created with the first address of subnet if it does not exist, and the
+// subnet is registered to allocate the addresses of the pods on it. The
+// bridge has the IPv6 network subnet6 too if it is not empty.
+func SetupBridge(bridge, subnet, subnet6 string) error {
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("Subnet %s is not an IPv4 network", subnet)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones > 30 {
+		return fmt.Errorf("Subnet %s is too small", subnet)
+	}
+
+	addr, err := GetIfaceAddr(bridge)
+	if err != nil {
+		gateway := ipNet.IP.To4()
+		gateway = net.IPv4(gateway[0], gateway[1], gateway[2], gateway[3]+1)
+		prefix, _ := ipNet.Mask.Size()
+
+		glog.V(1).Infof("create bridge %s, ip %s/%d", bridge, gateway, prefix)
+		if err := configureBridge(fmt.Sprintf("%s/%d", gateway, prefix), bridge); err != nil {
+			glog.Errorf("create bridge %s failed", bridge)
+			return err
+		}
+		if addr, err = GetIfaceAddr(bridge); err != nil {
+			return err
+		}
+	}
+
+	nw := addr.(*net.IPNet)
+	if !ipNet.Contains(nw.IP) {
+		return fmt.Errorf("Bridge %s exists with address %s, which is not in subnet %s", bridge, nw, subnet)
+	}
+	if err := ipAllocator.RegisterSubnet(nw, ipNet); err != nil && err != ipallocator.ErrNetworkAlreadyRegistered {
+		return err
+	}
+	ipAllocator.RequestIP(nw, nw.IP)
+
+	if err := setupIPTables(bridge, nw); err != nil {
+		return err
+	}
+	if err := isolateBridge(bridge); err != nil {
+		return err
+	}
+
+	var nw6 *net.IPNet
+	if subnet6 != "" {
+		if nw6, err = setupBridgeIPv6(bridge, subnet6); err != nil {
+			return err
+		}
+	}
+
+	bridgeNetsLock.Lock()
+	bridgeNets[bridge] = nw
+	if nw6 != nil {
+		bridgeNets6[bridge] = nw6
+	}
+	bridgeNetsLock.Unlock()
+	return nil
+}
+
+// RemoveBridge tears down the bridge set up by SetupBridge, the pods on it
+// should have been stopped.
+func RemoveBridge(bridge string) error {
+	bridgeNetsLock.Lock()
+	nw, ok := bridgeNets[bridge]
+	nw6 := bridgeNets6[bridge]
+	delete(bridgeNets, bridge)
+	delete(bridgeNets6, bridge)
+	bridgeNetsLock.Unlock()
+	if !ok {
+		return fmt.Errorf("Bridge %s is not set up", bridge)
+	}
+
+	isolation4.remove(bridge)
+	cleanupIPTables(bridge, nw)
+	ipAllocator.UnregisterSubnet(nw)
+	// configureBridge allocated the address of the bridge in the subnet
+	ipAllocator.UnregisterSubnet(&net.IPNet{IP: nw.IP.Mask(nw.Mask), Mask: nw.Mask})
+	if nw6 != nil {
+		isolation6.remove(bridge)
+		cleanupIP6Tables(bridge)
+		ipAllocator.UnregisterSubnet(nw6)
+	}
+
+	iface, err := net.InterfaceByName(bridge)
+	if err != nil {
+		return err
+	}
+	if err := NetworkLinkDown(iface); err != nil {
+		glog.Warningf("Unable to stop bridge %s: %s", bridge, err)
+	}
+	return DeleteBridge(bridge)
+}
+
+// cleanupIPTables removes the rules added by setupIPTables for the bridge,
+// the ones shared by all the bridges are left.
+func cleanupIPTables(bridge string, addr net.Addr) {
+	iptables.Raw("-t", string(iptables.Nat), "-D", "POSTROUTING",
+		"-s", addr.String(), "!", "-o", bridge, "-j", "MASQUERADE")
+	iptables.Raw("-D", "FORWARD", "-o", bridge, "-j", "HYPER")
+	iptables.Raw("-D", "FORWARD", "-i", bridge, "-j", "ACCEPT")
+	iptables.Raw("-D", "FORWARD", "-o", bridge, "-m", "conntrack",
+		"--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+}
+
+// isolateBridge drops the packets forwarded between the bridge and the
+// other bridges of hyper.
+func isolateBridge(bridge string) error {
+	return isolation4.add(bridge)
+}
+
+// isolateBridge6 is isolateBridge of the IPv6 packets
+func isolateBridge6(bridge string) error {
+	return isolation6.add(bridge)
+}
+
+func (iso *isolation) add(bridge string) error {
+	isolationLock.Lock()
+	defer isolationLock.Unlock()
+
+	iso.raw("-N", isolationChain)
+	for other := range iso.bridges {
+		if other == bridge {
+			continue
+		}
+		for _, args := range [][]string{
+			{"-i", bridge, "-o", other, "-j", "DROP"},
+			{"-i", other, "-o", bridge, "-j", "DROP"},
+		} {
+			if iso.exists(iptables.Filter, isolationChain, args...) {
+				continue
+			}
+			if output, err := iso.raw(append([]string{"-I", isolationChain}, args...)...); err != nil {
+				return fmt.Errorf("Unable to isolate bridge %s: %s", bridge, err)
+			} else if len(output) != 0 {
+				return &iptables.ChainError{Chain: isolationChain, Output: output}
+			}
+		}
+	}
+	iso.bridges[bridge] = true
+
+	// the isolation goes before the rules accepting the packets of the
+	// bridges, which are inserted to the top of FORWARD
+	iso.raw("-D", "FORWARD", "-j", isolationChain)
+	if output, err := iso.raw("-I", "FORWARD", "-j", isolationChain); err != nil {
+		return fmt.Errorf("Unable to setup goto %s rule %s", isolationChain, err)
+	} else if len(output) != 0 {
+		return &iptables.ChainError{Chain: "FORWARD goto " + isolationChain, Output: output}
+	}
+	return nil
+}
+
+func (iso *isolation) remove(bridge string) {
+	isolationLock.Lock()
+	defer isolationLock.Unlock()
+
+	delete(iso.bridges, bridge)
+	for other := range iso.bridges {
+		iso.raw("-D", isolationChain, "-i", bridge, "-o", other, "-j", "DROP")
+		iso.raw("-D", isolationChain, "-i", other, "-o", bridge, "-j", "DROP")
+	}
+}
@@ -93,6 +93,15 @@ func (a *IPAllocator) RegisterSubnet(network *net.IPNet, subnet *net.IPNet) erro
 	return nil
 }
 
+// UnregisterSubnet removes network from the allocator, all the ips
+// allocated on it are released.
+func (a *IPAllocator) UnregisterSubnet(network *net.IPNet) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.allocatedIPs, network.String())
+}
+
 // RequestIP requests an available ip from the given network.  It
 // will return the next available ip if the ip provided is nil.  If the
 // ip provided is not nil it will validate that the provided ip is available
@@ -126,15 +135,35 @@ func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
 	return nil
 }
 
-func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
+// CheckIP validates that the provided ip is available for use in the
+// given network like RequestIP, without allocating it.
+func (a *IPAllocator) CheckIP(network *net.IPNet, ip net.IP) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allocated, ok := a.allocatedIPs[network.String()]
+	if !ok {
+		allocated = newAllocatedMap(network)
+	}
+	return allocated.validIP(ip)
+}
+
+func (allocated *allocatedMap) validIP(ip net.IP) error {
 	if _, ok := allocated.p[ip.String()]; ok {
-		return nil, ErrIPAlreadyAllocated
+		return ErrIPAlreadyAllocated
 	}
 
 	pos := ipToBigInt(ip)
 	// Verify that the IP address is within our network range.
 	if pos.Cmp(allocated.begin) == -1 || pos.Cmp(allocated.end) == 1 {
-		return nil, ErrIPOutOfRange
+		return ErrIPOutOfRange
+	}
+	return nil
+}
+
+func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
+	if err := allocated.validIP(ip); err != nil {
+		return nil, err
 	}
 
 	// Register the IP.
@@ -176,7 +205,14 @@ func ipToBigInt(ip net.IP) *big.Int {
 	return nil
 }
 
-// Converts 128 bit integer into a 4 bytes IP address
+// Converts 128 bit integer into a 4 bytes IP address, or a 16 bytes one
+// if it does not fit in 4 bytes
 func bigIntToIP(v *big.Int) net.IP {
-	return net.IP(v.Bytes())
+	b := v.Bytes()
+	if len(b) > net.IPv4len && len(b) < net.IPv6len {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip[net.IPv6len-len(b):], b)
+		return ip
+	}
+	return net.IP(b)
 }
@@ -23,9 +23,12 @@ const (
 )
 
 var (
-	iptablesPath        string
-	supportsXlock       = false
-	ErrIptablesNotFound = errors.New("Iptables not found")
+	iptablesPath         string
+	ip6tablesPath        string
+	supportsXlock        = false
+	supportsXlock6       = false
+	ErrIptablesNotFound  = errors.New("Iptables not found")
+	ErrIp6tablesNotFound = errors.New("Ip6tables not found")
 )
 
 type Chain struct {
@@ -55,6 +58,18 @@ func initCheck() error {
 	return nil
 }
 
+func initCheck6() error {
+	if ip6tablesPath == "" {
+		path, err := exec.LookPath("ip6tables")
+		if err != nil {
+			return ErrIp6tablesNotFound
+		}
+		ip6tablesPath = path
+		supportsXlock6 = exec.Command(ip6tablesPath, "--wait", "-L", "-n").Run() == nil
+	}
+	return nil
+}
+
 // Check if a dnat rule exists
 func OperatePortMap(action Action, chain string, rule []string) error {
 	if output, err := Raw(append([]string{
@@ -67,6 +82,18 @@ func OperatePortMap(action Action, chain string, rule []string) error {
 	return nil
 }
 
+// OperatePortMap6 is OperatePortMap of ip6tables
+func OperatePortMap6(action Action, chain string, rule []string) error {
+	if output, err := Raw6(append([]string{
+		"-t", string(Nat), string(action), chain}, rule...)...); err != nil {
+		return fmt.Errorf("Unable to setup network port map: %s", err)
+	} else if len(output) != 0 {
+		return &ChainError{Chain: chain, Output: output}
+	}
+
+	return nil
+}
+
 func PortMapExists(chain string, rule []string) bool {
 	// iptables -C, --check option was added in v.1.4.11
 	// http://ftp.netfilter.org/pub/iptables/changes-iptables-1.4.11.txt
@@ -128,6 +155,16 @@ func Exists(table Table, chain string, rule ...string) bool {
 	)
 }
 
+// Check if a rule exists in ip6tables, which always supports -C
+func Exists6(table Table, chain string, rule ...string) bool {
+	if string(table) == "" {
+		table = Filter
+	}
+
+	_, err := Raw6(append([]string{"-t", string(table), "-C", chain}, rule...)...)
+	return err == nil
+}
+
 // Call 'iptables' system command, passing supplied arguments
 func Raw(args ...string) ([]byte, error) {
 	if err := initCheck(); err != nil {
@@ -136,12 +173,26 @@ func Raw(args ...string) ([]byte, error) {
 	if supportsXlock {
 		args = append([]string{"--wait"}, args...)
 	}
+	return raw("iptables", iptablesPath, args...)
+}
+
+// Call 'ip6tables' system command, passing supplied arguments
+func Raw6(args ...string) ([]byte, error) {
+	if err := initCheck6(); err != nil {
+		return nil, err
+	}
+	if supportsXlock6 {
+		args = append([]string{"--wait"}, args...)
+	}
+	return raw("ip6tables", ip6tablesPath, args...)
+}
 
-	glog.V(3).Infof("%s, %v", iptablesPath, args)
+func raw(name, path string, args ...string) ([]byte, error) {
+	glog.V(3).Infof("%s, %v", path, args)
 
-	output, err := exec.Command(iptablesPath, args...).CombinedOutput()
+	output, err := exec.Command(path, args...).CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("iptables failed: iptables %v: %s (%s)", strings.Join(args, " "), output, err)
+		return nil, fmt.Errorf("%s failed: %s %v: %s (%s)", name, name, strings.Join(args, " "), output, err)
 	}
 
 	// ignore iptables' message about xtables lock
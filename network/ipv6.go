@@ -0,0 +1,212 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"hyper/lib/glog"
+	"hyper/network/ipallocator"
+	"hyper/network/iptables"
+	"hyper/pod"
+)
+
+// setupBridgeIPv6 adds the first address of the IPv6 network prefix to the
+// bridge if it has no address in it, and registers the network to allocate
+// the IPv6 addresses of the pods on the bridge. The prefix should be routed
+// to the host, the IPv6 packets of the pods are not masqueraded.
+func setupBridgeIPv6(bridge, prefix string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("Subnet %s is not an IPv6 network", prefix)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones > 126 {
+		return nil, fmt.Errorf("Subnet %s is too small", prefix)
+	}
+
+	iface, err := net.InterfaceByName(bridge)
+	if err != nil {
+		return nil, err
+	}
+	nw6, err := getIfaceAddr6(iface, ipNet)
+	if err != nil {
+		return nil, err
+	}
+	if nw6 == nil {
+		gateway := make(net.IP, net.IPv6len)
+		copy(gateway, ipNet.IP)
+		gateway[net.IPv6len-1]++
+
+		glog.V(1).Infof("add ip %s to bridge %s", gateway, bridge)
+		if err := NetworkLinkAddIp(iface, gateway, ipNet); err != nil {
+			return nil, fmt.Errorf("Unable to add IPv6 network to bridge %s: %s", bridge, err)
+		}
+		nw6 = &net.IPNet{IP: gateway, Mask: ipNet.Mask}
+	}
+
+	if err := ipAllocator.RegisterSubnet(nw6, ipNet); err != nil && err != ipallocator.ErrNetworkAlreadyRegistered {
+		return nil, err
+	}
+	ipAllocator.RequestIP(nw6, nw6.IP)
+
+	if err := writeSysctl("/proc/sys/net/ipv6/conf/all/forwarding"); err != nil {
+		return nil, err
+	}
+	if err := setupIP6Tables(bridge); err != nil {
+		return nil, err
+	}
+	if err := isolateBridge6(bridge); err != nil {
+		return nil, err
+	}
+	return nw6, nil
+}
+
+// getIfaceAddr6 returns the IPv6 address of the interface in the network,
+// or nil if there is none.
+func getIfaceAddr6(iface *net.Interface, nw *net.IPNet) (*net.IPNet, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil {
+			continue
+		}
+		if nw.Contains(ipNet.IP) {
+			return ipNet, nil
+		}
+	}
+	return nil, nil
+}
+
+func writeSysctl(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString("1")
+	return err
+}
+
+// setupIP6Tables is setupIPTables of ip6tables, without NAT
+func setupIP6Tables(bridge string) error {
+	// Create HYPER ip6tables Chain
+	iptables.Raw6("-N", "HYPER")
+
+	for _, args := range [][]string{
+		// Goto HYPER chain
+		{"-o", bridge, "-j", "HYPER"},
+		// Accept all outgoing packets
+		{"-i", bridge, "-j", "ACCEPT"},
+		// Accept incoming packets for existing connections
+		{"-o", bridge, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	} {
+		if iptables.Exists6(iptables.Filter, "FORWARD", args...) {
+			continue
+		}
+		if output, err := iptables.Raw6(append([]string{"-I", "FORWARD"}, args...)...); err != nil {
+			return fmt.Errorf("Unable to setup ip6tables FORWARD rule: %s", err)
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: "FORWARD", Output: output}
+		}
+	}
+
+	if err := writeSysctl("/proc/sys/net/bridge/bridge-nf-call-ip6tables"); err != nil {
+		glog.V(1).Infof("enable bridge-nf-call-ip6tables failed %s", err)
+	}
+
+	// Create HYPER ip6tables Chain for the port maps
+	iptables.Raw6("-t", string(iptables.Nat), "-N", "HYPER")
+	for chain, args := range map[string][]string{
+		"OUTPUT":     {"-m", "addrtype", "--dst-type", "LOCAL", "!", "-d", "::1/128", "-j", "HYPER"},
+		"PREROUTING": {"-m", "addrtype", "--dst-type", "LOCAL", "-j", "HYPER"},
+	} {
+		if iptables.Exists6(iptables.Nat, chain, args...) {
+			continue
+		}
+		if output, err := iptables.Raw6(append([]string{"-t", string(iptables.Nat),
+			"-I", chain}, args...)...); err != nil {
+			return fmt.Errorf("Unable to setup goto HYPER rule %s", err)
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: chain + " goto HYPER", Output: output}
+		}
+	}
+
+	return nil
+}
+
+func cleanupIP6Tables(bridge string) {
+	iptables.Raw6("-D", "FORWARD", "-o", bridge, "-j", "HYPER")
+	iptables.Raw6("-D", "FORWARD", "-i", bridge, "-j", "ACCEPT")
+	iptables.Raw6("-D", "FORWARD", "-o", bridge, "-m", "conntrack",
+		"--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+}
+
+// bridgeNet6 returns the IPv6 network of the bridge, or nil if it has none.
+func bridgeNet6(bridge string) *net.IPNet {
+	if bridge == "" || bridge == BridgeIface {
+		return bridgeIPv6Net
+	}
+
+	bridgeNetsLock.Lock()
+	defer bridgeNetsLock.Unlock()
+	return bridgeNets6[bridge]
+}
+
+func portMapRules(containerip string, m pod.UserContainerPort) ([]string, []string) {
+	proto := "tcp"
+	if strings.EqualFold(m.Protocol, "udp") {
+		proto = "udp"
+	}
+
+	natArgs := []string{"-p", proto, "-m", proto, "--dport",
+		strconv.Itoa(m.HostPort), "-j", "DNAT", "--to-destination",
+		net.JoinHostPort(containerip, strconv.Itoa(m.ContainerPort))}
+	filterArgs := []string{"-d", containerip, "-p", proto, "-m", proto,
+		"--dport", strconv.Itoa(m.ContainerPort), "-j", "ACCEPT"}
+	return natArgs, filterArgs
+}
+
+// SetupPortMaps6 adds the ip6tables rules of the port maps, which have been
+// allocated by SetupPortMaps for the IPv4 address of the container.
+func SetupPortMaps6(containerip string, maps []pod.UserContainerPort) error {
+	for i, m := range maps {
+		natArgs, filterArgs := portMapRules(containerip, m)
+
+		err := iptables.OperatePortMap6(iptables.Insert, "HYPER", natArgs)
+		if err == nil {
+			if output, e := iptables.Raw6(append([]string{"-I", "HYPER"}, filterArgs...)...); e != nil {
+				err = fmt.Errorf("Unable to setup forward rule in HYPER chain: %s", e)
+			} else if len(output) != 0 {
+				err = &iptables.ChainError{Chain: "HYPER", Output: output}
+			}
+			if err != nil {
+				iptables.OperatePortMap6(iptables.Delete, "HYPER", natArgs)
+			}
+		}
+
+		if err != nil {
+			ReleasePortMaps6(containerip, maps[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+func ReleasePortMaps6(containerip string, maps []pod.UserContainerPort) error {
+	for _, m := range maps {
+		glog.V(1).Infof("release IPv6 port map %d", m.HostPort)
+		natArgs, filterArgs := portMapRules(containerip, m)
+		iptables.OperatePortMap6(iptables.Delete, "HYPER", natArgs)
+		iptables.Raw6(append([]string{"-D", "HYPER"}, filterArgs...)...)
+	}
+	return nil
+}
@@ -43,9 +43,15 @@ var (
 	ipAllocator   = ipallocator.New()
 	portMapper    = portmapper.New()
 	bridgeIPv4Net *net.IPNet
-	tapFile       *os.File
+	bridgeIPv6Net *net.IPNet
 	BridgeIface   string
 	BridgeIP      string
+	BridgeIPv6    string
+
+	// the IPv4 and IPv6 networks of the bridges other than the default one
+	bridgeNets     = make(map[string]*net.IPNet)
+	bridgeNets6    = make(map[string]*net.IPNet)
+	bridgeNetsLock sync.Mutex
 )
 
 type ifReq struct {
@@ -55,13 +61,16 @@ type ifReq struct {
 }
 
 type Settings struct {
-	Mac         string
-	IPAddress   string
-	IPPrefixLen int
-	Gateway     string
-	Bridge      string
-	Device      string
-	File        *os.File
+	Mac           string
+	IPAddress     string
+	IPPrefixLen   int
+	Gateway       string
+	IPv6Address   string
+	IPv6PrefixLen int
+	IPv6Gateway   string
+	Bridge        string
+	Device        string
+	File          *os.File
 }
 
 type IfInfomsg struct {
@@ -115,10 +124,10 @@ type ifaces struct {
 	sync.Mutex
 }
 
-func setupIPTables(addr net.Addr) error {
+func setupIPTables(bridge string, addr net.Addr) error {
 	// Enable NAT
 
-	natArgs := []string{"-s", addr.String(), "!", "-o", BridgeIface, "-j", "MASQUERADE"}
+	natArgs := []string{"-s", addr.String(), "!", "-o", bridge, "-j", "MASQUERADE"}
 
 	if !iptables.Exists(iptables.Nat, "POSTROUTING", natArgs...) {
 		if output, err := iptables.Raw(append([]string{
@@ -133,7 +142,7 @@ func setupIPTables(addr net.Addr) error {
 	iptables.Raw("-N", "HYPER")
 
 	// Goto HYPER chain
-	gotoArgs := []string{"-o", BridgeIface, "-j", "HYPER"}
+	gotoArgs := []string{"-o", bridge, "-j", "HYPER"}
 	if !iptables.Exists(iptables.Filter, "FORWARD", gotoArgs...) {
 		if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, gotoArgs...)...); err != nil {
 			return fmt.Errorf("Unable to setup goto HYPER rule %s", err)
@@ -143,7 +152,7 @@ func setupIPTables(addr net.Addr) error {
 	}
 
 	// Accept all outgoing packets
-	outgoingArgs := []string{"-i", BridgeIface, "-j", "ACCEPT"}
+	outgoingArgs := []string{"-i", bridge, "-j", "ACCEPT"}
 	if !iptables.Exists(iptables.Filter, "FORWARD", outgoingArgs...) {
 		if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, outgoingArgs...)...); err != nil {
 			return fmt.Errorf("Unable to allow outgoing packets: %s", err)
@@ -153,7 +162,7 @@ func setupIPTables(addr net.Addr) error {
 	}
 
 	// Accept incoming packets for existing connections
-	existingArgs := []string{"-o", BridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
+	existingArgs := []string{"-o", bridge, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
 
 	if !iptables.Exists(iptables.Filter, "FORWARD", existingArgs...) {
 		if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, existingArgs...)...); err != nil {
@@ -216,7 +225,9 @@ func init() {
 	}
 }
 
-func InitNetwork(bIface, bIP string) error {
+// InitNetwork sets up the default bridge with the IPv4 network bIP, and the
+// IPv6 network bIP6 if it is not empty.
+func InitNetwork(bIface, bIP, bIP6 string) error {
 	if bIface == "" {
 		BridgeIface = defaultBridgeIface
 	} else {
@@ -270,11 +281,24 @@ func InitNetwork(bIface, bIP string) error {
 		}
 	}
 
-	err = setupIPTables(addr)
+	err = setupIPTables(BridgeIface, addr)
 	if err != nil {
 		return err
 	}
 
+	if err := isolateBridge(BridgeIface); err != nil {
+		return err
+	}
+
+	if bIP6 != "" {
+		nw6, err := setupBridgeIPv6(BridgeIface, bIP6)
+		if err != nil {
+			return err
+		}
+		bridgeIPv6Net = nw6
+		BridgeIPv6 = bIP6
+	}
+
 	ipAllocator.RequestIP(bridgeIPv4Net, bridgeIPv4Net.IP)
 	return nil
 }
@@ -628,7 +652,11 @@ func networkLinkIpAction(action, flags int, ifa IfAddr) error {
 	nlreq.AddData(msg)
 
 	var ipData []byte
-	ipData = ifa.ip.To4()
+	if family == syscall.AF_INET6 {
+		ipData = ifa.ip.To16()
+	} else {
+		ipData = ifa.ip.To4()
+	}
 
 	localData := newRtAttr(syscall.IFA_LOCAL, ipData)
 	nlreq.AddData(localData)
@@ -951,20 +979,117 @@ func ReleasePortMaps(containerip string, maps []pod.UserContainerPort) error {
 	return nil
 }
 
-func Allocate(requestedIP string, maps []pod.UserContainerPort) (*Settings, error) {
+// bridgeNet returns the IPv4 network of the bridge, or of the default
+// bridge if bridge is empty. The bridge other than the default one should
+// have been set up on the host.
+func bridgeNet(bridge string) (string, *net.IPNet, error) {
+	if bridge == "" || bridge == BridgeIface {
+		return BridgeIface, bridgeIPv4Net, nil
+	}
+
+	bridgeNetsLock.Lock()
+	defer bridgeNetsLock.Unlock()
+	if nw, ok := bridgeNets[bridge]; ok {
+		return bridge, nw, nil
+	}
+	addr, err := GetIfaceAddr(bridge)
+	if err != nil {
+		return "", nil, fmt.Errorf("Can not get the address of bridge %s: %s", bridge, err.Error())
+	}
+	nw := addr.(*net.IPNet)
+	ipAllocator.RequestIP(nw, nw.IP)
+	bridgeNets[bridge] = nw
+	return bridge, nw, nil
+}
+
+// CheckAddress checks the static addresses of a nic on the bridge, the
+// default one if bridge is empty. They should be in the networks of the
+// bridge, and not allocated to the others.
+func CheckAddress(bridge, ip, ip6 string) error {
+	bridge, nw, err := bridgeNet(bridge)
+	if err != nil {
+		return err
+	}
+	if ip != "" {
+		if err := checkAddress(bridge, nw, ip); err != nil {
+			return err
+		}
+	}
+	if ip6 != "" {
+		nw6 := bridgeNet6(bridge)
+		if nw6 == nil {
+			return fmt.Errorf("Bridge %s has no IPv6 network for %s", bridge, ip6)
+		}
+		if err := checkAddress(bridge, nw6, ip6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAddress(bridge string, nw *net.IPNet, ip string) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("Invalid IP address %s", ip)
+	}
+	if !nw.Contains(addr) {
+		return fmt.Errorf("IP %s is not in the subnet %s of bridge %s", ip, nw, bridge)
+	}
+	switch ipAllocator.CheckIP(nw, addr) {
+	case nil:
+	case ipallocator.ErrIPAlreadyAllocated:
+		return fmt.Errorf("IP %s is in use on bridge %s", ip, bridge)
+	default:
+		return fmt.Errorf("IP %s can not be used on bridge %s", ip, bridge)
+	}
+	return nil
+}
+
+// Allocate creates a tap device on the bridge, the default one if bridge
+// is empty, and allocates an IP in the network of the bridge for it, and an
+// IPv6 one if the bridge has an IPv6 network. The bandwidth of the device
+// is limited to ingress and egress Mbit/s if they are not 0.
+func Allocate(bridge, requestedIP, requestedIP6 string, ingress, egress int, maps []pod.UserContainerPort) (*Settings, error) {
 	var (
 		req   ifReq
 		errno syscall.Errno
+		ip6   net.IP
 	)
 
-	ip, err := ipAllocator.RequestIP(bridgeIPv4Net, net.ParseIP(requestedIP))
+	bridge, nw, err := bridgeNet(bridge)
 	if err != nil {
 		return nil, err
 	}
+	nw6 := bridgeNet6(bridge)
+	if requestedIP6 != "" && nw6 == nil {
+		return nil, fmt.Errorf("Bridge %s has no IPv6 network for %s", bridge, requestedIP6)
+	}
 
-	maskSize, _ := bridgeIPv4Net.Mask.Size()
+	ip, err := ipAllocator.RequestIP(nw, net.ParseIP(requestedIP))
+	if err != nil {
+		return nil, err
+	}
+	if nw6 != nil {
+		ip6, err = ipAllocator.RequestIP(nw6, net.ParseIP(requestedIP6))
+		if err != nil {
+			ipAllocator.ReleaseIP(nw, ip)
+			return nil, err
+		}
+	}
+	// the addresses are given back if the nic is not set up, all the
+	// errors below are returned in err
+	defer func() {
+		if err != nil {
+			ipAllocator.ReleaseIP(nw, ip)
+			if ip6 != nil {
+				ipAllocator.ReleaseIP(nw6, ip6)
+			}
+		}
+	}()
 
-	tapFile, err = os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	maskSize, _ := nw.Mask.Size()
+
+	tapFile, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -988,16 +1113,16 @@ func Allocate(requestedIP string, maps []pod.UserContainerPort) (*Settings, erro
 		return nil, err
 	}
 
-	bIface, err := net.InterfaceByName(BridgeIface)
+	bIface, err := net.InterfaceByName(bridge)
 	if err != nil {
-		glog.Errorf("get interface by name %s failed", BridgeIface)
+		glog.Errorf("get interface by name %s failed", bridge)
 		tapFile.Close()
 		return nil, err
 	}
 
 	err = AddToBridge(tapIface, bIface)
 	if err != nil {
-		glog.Errorf("Add to bridge failed %s %s", BridgeIface, device)
+		glog.Errorf("Add to bridge failed %s %s", bridge, device)
 		tapFile.Close()
 		return nil, err
 	}
@@ -1009,9 +1134,17 @@ func Allocate(requestedIP string, maps []pod.UserContainerPort) (*Settings, erro
 		return nil, err
 	}
 
+	err = setupBandwidth(device, ingress, egress)
+	if err != nil {
+		glog.Errorf("Limit bandwidth of device %s failed %s", device, err)
+		tapFile.Close()
+		return nil, err
+	}
+
 	mac, err := GenRandomMac()
 	if err != nil {
 		glog.Errorf("Generate Random Mac address failed")
+		cleanupBandwidth(device)
 		tapFile.Close()
 		return nil, err
 	}
@@ -1019,6 +1152,7 @@ func Allocate(requestedIP string, maps []pod.UserContainerPort) (*Settings, erro
 	err = SetupPortMaps(ip.String(), maps)
 	if err != nil {
 		glog.Errorf("Setup Port Map failed %s", err)
+		cleanupBandwidth(device)
 		tapFile.Close()
 		return nil, err
 	}
@@ -1026,20 +1160,43 @@ func Allocate(requestedIP string, maps []pod.UserContainerPort) (*Settings, erro
 	networkSettings := &Settings{
 		Mac:         mac,
 		IPAddress:   ip.String(),
-		Gateway:     bridgeIPv4Net.IP.String(),
-		Bridge:      BridgeIface,
+		Gateway:     nw.IP.String(),
+		Bridge:      bridge,
 		IPPrefixLen: maskSize,
 		Device:      device,
 		File:        tapFile,
 	}
 
+	if ip6 != nil {
+		err = SetupPortMaps6(ip6.String(), maps)
+		if err != nil {
+			glog.Errorf("Setup IPv6 Port Map failed %s", err)
+			ReleasePortMaps(ip.String(), maps)
+			cleanupBandwidth(device)
+			tapFile.Close()
+			return nil, err
+		}
+		networkSettings.IPv6Address = ip6.String()
+		networkSettings.IPv6PrefixLen, _ = nw6.Mask.Size()
+		networkSettings.IPv6Gateway = nw6.IP.String()
+	}
+
 	return networkSettings, nil
 }
 
-// Release an interface for a select ip
-func Release(releasedIP string, maps []pod.UserContainerPort, file *os.File) error {
+// Release an interface for a select ip, and the IPv6 one if it is not empty
+func Release(bridge, releasedIP, releasedIP6 string, maps []pod.UserContainerPort, file *os.File) error {
+	if file != nil {
+		if device, err := tapName(file); err == nil {
+			cleanupBandwidth(device)
+		}
+	}
 	file.Close()
-	if err := ipAllocator.ReleaseIP(bridgeIPv4Net, net.ParseIP(releasedIP)); err != nil {
+	bridge, nw, err := bridgeNet(bridge)
+	if err != nil {
+		return err
+	}
+	if err := ipAllocator.ReleaseIP(nw, net.ParseIP(releasedIP)); err != nil {
 		return err
 	}
 
@@ -1047,5 +1204,16 @@ func Release(releasedIP string, maps []pod.UserContainerPort, file *os.File) err
 		glog.Errorf("fail to release port map %s", err)
 		return err
 	}
+
+	if releasedIP6 == "" {
+		return nil
+	}
+	if nw6 := bridgeNet6(bridge); nw6 != nil {
+		ipAllocator.ReleaseIP(nw6, net.ParseIP(releasedIP6))
+	}
+	if err := ReleasePortMaps6(releasedIP6, maps); err != nil {
+		glog.Errorf("fail to release IPv6 port map %s", err)
+		return err
+	}
 	return nil
 }
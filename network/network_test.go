@@ -5,7 +5,7 @@ import (
 )
 
 func TestInitNetwork(t *testing.T) {
-	if err := InitNetwork("hyper-test", "192.168.138.1/24"); err != nil {
+	if err := InitNetwork("hyper-test", "192.168.138.1/24", ""); err != nil {
 		t.Error("create hyper-test bridge failed")
 	}
 
@@ -17,17 +17,17 @@ func TestInitNetwork(t *testing.T) {
 }
 
 func TestAllocate(t *testing.T) {
-	if err := InitNetwork("hyper-test", "192.168.138.1/24"); err != nil {
+	if err := InitNetwork("hyper-test", "192.168.138.1/24", ""); err != nil {
 		t.Error("create hyper-test bridge failed")
 	}
 
-	if setting, err := Allocate("192.168.138.2"); err != nil {
+	if setting, err := Allocate("", "192.168.138.2", "", 0, 0, nil); err != nil {
 		t.Error("allocate tap device and ip failed")
 	} else {
 		t.Log("alocate tap device finished. bridge %s, device %s, ip %s, gateway %s",
 			setting.Bridge, setting.Device, setting.IPAddress, setting.Gateway)
 
-		if err := Release("192.168.138.2", setting.File); err != nil {
+		if err := Release("", "192.168.138.2", "", nil, setting.File); err != nil {
 			t.Error("release ip failed")
 		}
 	}
@@ -0,0 +1,175 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"hyper/lib/glog"
+	"hyper/network/ipallocator"
+	"hyper/network/iptables"
+	"hyper/pod"
+)
+
+// Restore allocates the addresses and the port maps of a nic set up before
+// the daemon restarted, as the allocators start empty. The iptables rules
+// of the port maps are added again if they are missing.
+func Restore(bridge, ip, ip6 string, maps []pod.UserContainerPort) error {
+	bridge, nw, err := bridgeNet(bridge)
+	if err != nil {
+		return err
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("Invalid IP address %s", ip)
+	}
+	if _, err := ipAllocator.RequestIP(nw, addr); err != nil {
+		return fmt.Errorf("Unable to restore IP %s on bridge %s: %s", ip, bridge, err)
+	}
+
+	if ip6 != "" {
+		nw6 := bridgeNet6(bridge)
+		if nw6 == nil {
+			ipAllocator.ReleaseIP(nw, addr)
+			return fmt.Errorf("Bridge %s has no IPv6 network for %s", bridge, ip6)
+		}
+		if _, err := ipAllocator.RequestIP(nw6, net.ParseIP(ip6)); err != nil {
+			ipAllocator.ReleaseIP(nw, addr)
+			return fmt.Errorf("Unable to restore IP %s on bridge %s: %s", ip6, bridge, err)
+		}
+	}
+
+	TakeOverPortMaps(ip, ip6, maps)
+	return nil
+}
+
+// TakeOverPortMaps allocates the port maps of the nic, whose iptables rules
+// may be set up by another owner, such as the VM the pod is migrated from.
+// The rules missing are added.
+func TakeOverPortMaps(ip, ip6 string, maps []pod.UserContainerPort) {
+	for _, m := range maps {
+		if err := portMapper.AllocateMap(m.Protocol, m.HostPort, ip, m.ContainerPort); err != nil {
+			glog.Errorf("Unable to take over the port map of %s: %s", ip, err)
+			continue
+		}
+		natArgs, filterArgs := portMapRules(ip, m)
+		if err := restorePortMap(iptables.Raw, iptables.Exists, natArgs, filterArgs); err != nil {
+			glog.Errorf("Unable to take over the port map of %s: %s", ip, err)
+		}
+		if ip6 == "" {
+			continue
+		}
+		natArgs, filterArgs = portMapRules(ip6, m)
+		if err := restorePortMap(iptables.Raw6, iptables.Exists6, natArgs, filterArgs); err != nil {
+			glog.Errorf("Unable to take over the port map of %s: %s", ip6, err)
+		}
+	}
+}
+
+// HandOverPortMaps releases the host ports of the maps in the allocator,
+// but keeps their iptables rules for the VM the pod is migrated to, which
+// takes them over with TakeOverPortMaps.
+func HandOverPortMaps(maps []pod.UserContainerPort) {
+	for _, m := range maps {
+		portMapper.ReleaseMap(m.Protocol, m.HostPort)
+	}
+}
+
+// restorePortMap adds the rules of a port map to the HYPER chains if they
+// are not there.
+func restorePortMap(raw func(args ...string) ([]byte, error),
+	exists func(table iptables.Table, chain string, rule ...string) bool,
+	natArgs, filterArgs []string) error {
+	for table, args := range map[iptables.Table][]string{
+		iptables.Nat:    natArgs,
+		iptables.Filter: filterArgs,
+	} {
+		if exists(table, "HYPER", args...) {
+			continue
+		}
+		if output, err := raw(append([]string{"-t", string(table), "-I", "HYPER"}, args...)...); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: "HYPER", Output: output}
+		}
+	}
+	return nil
+}
+
+// CleanupPortMaps removes the port map rules in the HYPER chains to the
+// addresses not allocated, which were left by the pods stopped while the
+// daemon was down. It should be called once the nics are restored.
+func CleanupPortMaps() {
+	cleanupPortMaps(iptables.Raw)
+
+	bridgeNetsLock.Lock()
+	ipv6 := bridgeIPv6Net != nil || len(bridgeNets6) != 0
+	bridgeNetsLock.Unlock()
+	if ipv6 {
+		cleanupPortMaps(iptables.Raw6)
+	}
+}
+
+func cleanupPortMaps(raw func(args ...string) ([]byte, error)) {
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		output, err := raw("-t", string(table), "-S", "HYPER")
+		if err != nil {
+			glog.Warningf("Unable to list the HYPER chain of %s: %s", table, err)
+			continue
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[0] != "-A" || fields[1] != "HYPER" {
+				continue
+			}
+			addr := portMapAddr(fields[2:])
+			if addr == nil || addressAllocated(addr) {
+				continue
+			}
+			glog.V(1).Infof("remove stale port map rule: %s", line)
+			raw(append([]string{"-t", string(table), "-D", "HYPER"}, fields[2:]...)...)
+		}
+	}
+}
+
+// portMapAddr returns the container address of a port map rule, which is
+// the destination of the DNAT rule or of the ACCEPT rule.
+func portMapAddr(rule []string) net.IP {
+	for i := 0; i < len(rule)-1; i++ {
+		switch rule[i] {
+		case "-d":
+			if ip, _, err := net.ParseCIDR(rule[i+1]); err == nil {
+				return ip
+			}
+			return net.ParseIP(rule[i+1])
+		case "--to-destination":
+			host, _, err := net.SplitHostPort(rule[i+1])
+			if err != nil {
+				return nil
+			}
+			return net.ParseIP(host)
+		}
+	}
+	return nil
+}
+
+// addressAllocated returns whether the address is allocated in one of the
+// networks of the bridges.
+func addressAllocated(ip net.IP) bool {
+	bridgeNetsLock.Lock()
+	nets := []*net.IPNet{bridgeIPv4Net, bridgeIPv6Net}
+	for _, nw := range bridgeNets {
+		nets = append(nets, nw)
+	}
+	for _, nw := range bridgeNets6 {
+		nets = append(nets, nw)
+	}
+	bridgeNetsLock.Unlock()
+
+	for _, nw := range nets {
+		if nw != nil && nw.Contains(ip) && ipAllocator.CheckIP(nw, ip) == ipallocator.ErrIPAlreadyAllocated {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,601 @@
+package pod
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ComposeFile is a docker compose file of version 2, all the services of
+// which run in one pod.
+type ComposeFile struct {
+	Version  string                     `yaml:"version"`
+	Services map[string]*ComposeService `yaml:"services"`
+	Volumes  map[string]*ComposeVolume  `yaml:"volumes"`
+	Networks map[string]interface{}     `yaml:"networks"`
+}
+
+type ComposeService struct {
+	Image         string      `yaml:"image"`
+	Build         interface{} `yaml:"build"`
+	ContainerName string      `yaml:"container_name"`
+	Command       interface{} `yaml:"command"`
+	Entrypoint    interface{} `yaml:"entrypoint"`
+	WorkingDir    string      `yaml:"working_dir"`
+	Environment   interface{} `yaml:"environment"`
+	EnvFile       interface{} `yaml:"env_file"`
+	Ports         []string    `yaml:"ports"`
+	Volumes       []string    `yaml:"volumes"`
+	Restart       string      `yaml:"restart"`
+	MemLimit      string      `yaml:"mem_limit"`
+	Cpus          string      `yaml:"cpus"`
+}
+
+type ComposeVolume struct {
+	Driver     string            `yaml:"driver"`
+	DriverOpts map[string]string `yaml:"driver_opts"`
+	External   interface{}       `yaml:"external"`
+}
+
+// the keys of the services which are converted, or need not be as the
+// services are in one pod
+var composeServiceKeys = map[string]bool{
+	"image": true, "build": true, "container_name": true, "command": true,
+	"entrypoint": true, "working_dir": true, "environment": true, "env_file": true,
+	"ports": true, "expose": true, "volumes": true, "restart": true,
+	"mem_limit": true, "cpus": true, "tty": true, "stdin_open": true,
+}
+
+// ParseCompose parses the compose file in dir, in which the relative paths
+// of the file are.
+func ParseCompose(body []byte, dir string) (*ComposeFile, []string, error) {
+	var cf ComposeFile
+	if err := yaml.Unmarshal(body, &cf); err != nil {
+		return nil, nil, err
+	}
+	if cf.Version != "2" && !strings.HasPrefix(cf.Version, "2.") {
+		return nil, nil, fmt.Errorf("version %q of the compose file is not supported, it should be 2", cf.Version)
+	}
+
+	// the unknown keys are dropped by yaml, find them to warn
+	var raw struct {
+		Services map[string]map[string]interface{} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(body, &raw); err != nil {
+		return nil, nil, err
+	}
+	warnings := []string{}
+	for _, name := range sortedKeys(raw.Services) {
+		keys := []string{}
+		for key := range raw.Services[name] {
+			if !composeServiceKeys[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			switch key {
+			case "links", "depends_on", "networks", "network_mode":
+				warnings = append(warnings, fmt.Sprintf("service %s: %s is ignored, the services share the network of the pod and reach each other on localhost", name, key))
+			default:
+				warnings = append(warnings, fmt.Sprintf("service %s: %s is not supported, ignored", name, key))
+			}
+		}
+	}
+	if len(cf.Networks) > 0 {
+		warnings = append(warnings, "networks are ignored, the services share the network of the pod")
+	}
+
+	if err := cf.resolveEnvFiles(dir); err != nil {
+		return nil, nil, err
+	}
+	cf.resolvePaths(dir)
+	return &cf, warnings, nil
+}
+
+// resolveEnvFiles reads the env files of the services into their
+// environments, which override the envs in the files.
+func (cf *ComposeFile) resolveEnvFiles(dir string) error {
+	for name, svc := range cf.Services {
+		if svc == nil || svc.EnvFile == nil {
+			continue
+		}
+		files, err := composeStrings(svc.EnvFile)
+		if err != nil {
+			return fmt.Errorf("service %s: env_file %s", name, err.Error())
+		}
+		envs := []string{}
+		for _, file := range files {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			e, err := readEnvFile(file)
+			if err != nil {
+				return fmt.Errorf("service %s: %s", name, err.Error())
+			}
+			envs = append(envs, e...)
+		}
+		more, err := composeEnvs(svc.Environment)
+		if err != nil {
+			return fmt.Errorf("service %s: environment %s", name, err.Error())
+		}
+		svc.Environment = append(envs, more...)
+		svc.EnvFile = nil
+	}
+	return nil
+}
+
+// resolvePaths turns the relative host paths of the volumes of the services
+// into absolute ones.
+func (cf *ComposeFile) resolvePaths(dir string) {
+	for _, svc := range cf.Services {
+		if svc == nil {
+			continue
+		}
+		for i, v := range svc.Volumes {
+			parts := strings.SplitN(v, ":", 2)
+			src := parts[0]
+			switch {
+			case len(parts) < 2:
+				continue
+			case src == "~" || strings.HasPrefix(src, "~/"):
+				src = filepath.Join(os.Getenv("HOME"), src[1:])
+			case src == "." || src == ".." || strings.HasPrefix(src, "./") || strings.HasPrefix(src, "../"):
+				src = filepath.Join(dir, src)
+			default:
+				continue
+			}
+			svc.Volumes[i] = src + ":" + parts[1]
+		}
+	}
+}
+
+// Convert translates the compose file into a pod named name, the services
+// of which are the containers. The memory and the cpus of the pod are the
+// sums of the limits of the services.
+func (cf *ComposeFile) Convert(name string) (*UserPod, []string, error) {
+	if len(cf.Services) == 0 {
+		return nil, nil, fmt.Errorf("No service in the compose file")
+	}
+
+	warnings := []string{}
+	volumes := []UserVolume{}
+	for _, vname := range sortedKeys(cf.Volumes) {
+		vol, err := cf.Volumes[vname].convert(vname)
+		if err != nil {
+			return nil, nil, err
+		}
+		volumes = append(volumes, *vol)
+	}
+	volumeNames := make(map[string]bool)
+	for _, v := range volumes {
+		volumeNames[v.Name] = true
+	}
+	hostPaths := make(map[string]string)
+
+	cpus, memory := 0.0, int64(0)
+	containers := []UserContainer{}
+	for _, sname := range sortedKeys(cf.Services) {
+		svc := cf.Services[sname]
+		if svc == nil {
+			return nil, nil, fmt.Errorf("service %s is empty", sname)
+		}
+		if svc.Image == "" {
+			return nil, nil, fmt.Errorf("service %s: image is required, build is not supported", sname)
+		}
+		if svc.Build != nil {
+			warnings = append(warnings, fmt.Sprintf("service %s: build is ignored, image %s is used", sname, svc.Image))
+		}
+
+		c := UserContainer{
+			Name:    sname,
+			Image:   svc.Image,
+			Workdir: svc.WorkingDir,
+			Ports:   []UserContainerPort{},
+			Envs:    []UserEnvironmentVar{},
+			Volumes: []UserVolumeReference{},
+			Files:   []UserFileReference{},
+		}
+		if svc.ContainerName != "" {
+			c.Name = svc.ContainerName
+		}
+
+		var err error
+		if c.Command, err = composeCommand(svc.Command); err != nil {
+			return nil, nil, fmt.Errorf("service %s: command %s", sname, err.Error())
+		}
+		if c.Entrypoint, err = composeCommand(svc.Entrypoint); err != nil {
+			return nil, nil, fmt.Errorf("service %s: entrypoint %s", sname, err.Error())
+		}
+		if c.RestartPolicy, err = composeRestart(svc.Restart); err != nil {
+			return nil, nil, fmt.Errorf("service %s: %s", sname, err.Error())
+		}
+
+		envs, err := composeEnvs(svc.Environment)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service %s: environment %s", sname, err.Error())
+		}
+		index := make(map[string]int)
+		for _, e := range envs {
+			parts := strings.SplitN(e, "=", 2)
+			value := ""
+			if len(parts) == 2 {
+				value = parts[1]
+			} else {
+				value = os.Getenv(parts[0])
+			}
+			// the latter env overrides the former one
+			if i, ok := index[parts[0]]; ok {
+				c.Envs[i].Value = value
+				continue
+			}
+			index[parts[0]] = len(c.Envs)
+			c.Envs = append(c.Envs, UserEnvironmentVar{Env: parts[0], Value: value})
+		}
+
+		for _, p := range svc.Ports {
+			ports, w, err := composePorts(p)
+			if err != nil {
+				return nil, nil, fmt.Errorf("service %s: %s", sname, err.Error())
+			}
+			for _, msg := range w {
+				warnings = append(warnings, fmt.Sprintf("service %s: %s", sname, msg))
+			}
+			c.Ports = append(c.Ports, ports...)
+		}
+
+		for i, v := range svc.Volumes {
+			parts := strings.Split(v, ":")
+			if len(parts) > 3 || parts[len(parts)-1] == "" {
+				return nil, nil, fmt.Errorf("service %s: invalid volume %s", sname, v)
+			}
+			ref := UserVolumeReference{Path: parts[len(parts)-1]}
+			if len(parts) == 3 {
+				ref.Path = parts[1]
+				for _, mode := range strings.Split(parts[2], ",") {
+					if mode == "ro" {
+						ref.ReadOnly = true
+					}
+				}
+			}
+
+			switch {
+			case len(parts) == 1:
+				// an anonymous volume, which is empty
+				ref.Volume = uniqueName(fmt.Sprintf("%s-volume-%d", sname, i), volumeNames)
+				volumes = append(volumes, UserVolume{Name: ref.Volume})
+			case filepath.IsAbs(parts[0]):
+				if vname, ok := hostPaths[parts[0]]; ok {
+					ref.Volume = vname
+					break
+				}
+				ref.Volume = uniqueName(fmt.Sprintf("%s-volume-%d", sname, i), volumeNames)
+				hostPaths[parts[0]] = ref.Volume
+				volumes = append(volumes, UserVolume{Name: ref.Volume, Source: parts[0], Driver: "vfs"})
+			default:
+				if _, ok := cf.Volumes[parts[0]]; !ok {
+					return nil, nil, fmt.Errorf("service %s: volume %s is not declared in volumes", sname, parts[0])
+				}
+				ref.Volume = parts[0]
+			}
+			c.Volumes = append(c.Volumes, ref)
+		}
+
+		if svc.MemLimit != "" {
+			limit, err := parseBytes(svc.MemLimit)
+			if err != nil {
+				return nil, nil, fmt.Errorf("service %s: mem_limit %s", sname, err.Error())
+			}
+			memory += limit
+		}
+		if svc.Cpus != "" {
+			n, err := strconv.ParseFloat(svc.Cpus, 64)
+			if err != nil || n < 0 {
+				return nil, nil, fmt.Errorf("service %s: invalid cpus %s", sname, svc.Cpus)
+			}
+			cpus += n
+		}
+		containers = append(containers, c)
+	}
+
+	res := UserResource{
+		Vcpu:   int(math.Ceil(cpus)),
+		Memory: int((memory + 1<<20 - 1) >> 20),
+	}
+	if res.Vcpu < 1 {
+		res.Vcpu = 1
+	}
+	if res.Memory == 0 {
+		res.Memory = 128
+	}
+	return &UserPod{
+		Name:       name,
+		Containers: containers,
+		Resource:   res,
+		Files:      []UserFile{},
+		Volumes:    volumes,
+		Tty:        true,
+	}, warnings, nil
+}
+
+// convert translates the named volume, a local one is an empty volume, or
+// a tmpfs or a bind mount per its driver_opts.
+func (vol *ComposeVolume) convert(name string) (*UserVolume, error) {
+	if vol == nil {
+		return &UserVolume{Name: name}, nil
+	}
+	if external, ok := vol.External.(bool); vol.External != nil && (!ok || external) {
+		return nil, fmt.Errorf("volume %s: external volume is not supported", name)
+	}
+	if vol.Driver != "" && vol.Driver != "local" {
+		return nil, fmt.Errorf("volume %s: driver %s is not supported", name, vol.Driver)
+	}
+	switch {
+	case vol.DriverOpts["type"] == "tmpfs":
+		return &UserVolume{Name: name, Driver: "tmpfs"}, nil
+	case strings.Contains(vol.DriverOpts["o"], "bind") && filepath.IsAbs(vol.DriverOpts["device"]):
+		return &UserVolume{Name: name, Source: vol.DriverOpts["device"], Driver: "vfs"}, nil
+	case len(vol.DriverOpts) > 0:
+		return nil, fmt.Errorf("volume %s: driver_opts %v is not supported", name, vol.DriverOpts)
+	}
+	return &UserVolume{Name: name}, nil
+}
+
+// composePorts translates a port of a service, which is
+// [[IP:]HOST:]CONTAINER[/PROTOCOL], and the ports could be ranges.
+func composePorts(spec string) ([]UserContainerPort, []string, error) {
+	warnings := []string{}
+	protocol := "tcp"
+	if idx := strings.LastIndex(spec, "/"); idx >= 0 {
+		protocol = strings.ToLower(spec[idx+1:])
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, nil, fmt.Errorf("invalid protocol of port %s", spec)
+		}
+		spec = spec[:idx]
+	}
+
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return nil, nil, fmt.Errorf("invalid port %s", spec)
+	}
+	if len(parts) == 3 {
+		if parts[0] != "" && parts[0] != "0.0.0.0" {
+			warnings = append(warnings, fmt.Sprintf("port %s is published on all the addresses, not on %s", spec, parts[0]))
+		}
+		parts = parts[1:]
+	}
+	if len(parts) == 1 || parts[0] == "" {
+		warnings = append(warnings, fmt.Sprintf("port %s has no host port, it is reachable on the IP of the pod only", spec))
+		return nil, warnings, nil
+	}
+
+	hostFirst, hostLast, err := portRange(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	first, last, err := portRange(parts[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	if hostLast-hostFirst != last-first {
+		return nil, nil, fmt.Errorf("the ranges of port %s do not match", spec)
+	}
+	ports := []UserContainerPort{}
+	for i := 0; i <= last-first; i++ {
+		ports = append(ports, UserContainerPort{
+			HostPort:      hostFirst + i,
+			ContainerPort: first + i,
+			Protocol:      protocol,
+		})
+	}
+	return ports, warnings, nil
+}
+
+// portRange parses a port, or a range of ports like 8000-8010
+func portRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil || first <= 0 || first > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %s", s)
+	}
+	last := first
+	if len(parts) == 2 {
+		last, err = strconv.Atoi(parts[1])
+		if err != nil || last < first || last > 65535 {
+			return 0, 0, fmt.Errorf("invalid port range %s", s)
+		}
+	}
+	return first, last, nil
+}
+
+// composeRestart translates the restart policy of a service, hyper does
+// not tell the stops by the user from the ones by the failures.
+func composeRestart(restart string) (string, error) {
+	switch {
+	case restart == "" || restart == "no":
+		return "never", nil
+	case restart == "always" || restart == "unless-stopped":
+		return "always", nil
+	case restart == "on-failure" || strings.HasPrefix(restart, "on-failure:"):
+		return "onFailure", nil
+	}
+	return "", fmt.Errorf("invalid restart policy %s", restart)
+}
+
+// parseBytes parses a size like 512m or 1g of docker, in bytes
+func parseBytes(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+	mul := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			mul = 1 << 10
+		case 'm':
+			mul = 1 << 20
+		case 'g':
+			mul = 1 << 30
+		}
+		if mul > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return n * mul, nil
+}
+
+// composeStrings returns the value which is a string or a list of strings
+func composeStrings(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		result := []string{}
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v is not a string", item)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("should be a string or a list of strings")
+}
+
+// composeCommand returns the command which is a list, or a string split
+// like the shell
+func composeCommand(v interface{}) ([]string, error) {
+	if s, ok := v.(string); ok {
+		return splitCommand(s)
+	}
+	return composeStrings(v)
+}
+
+// composeEnvs returns the envs as NAME=VALUE, or NAME to be taken from the
+// environment, which is a map or a list.
+func composeEnvs(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case []string:
+		return v, nil
+	case map[interface{}]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		envs := []string{}
+		for _, k := range keys {
+			value := v[k]
+			if value == nil {
+				envs = append(envs, k)
+				continue
+			}
+			envs = append(envs, k+"="+fmt.Sprint(value))
+		}
+		return envs, nil
+	}
+	return composeStrings(v)
+}
+
+// readEnvFile reads the NAME=VALUE lines of an env file, the empty lines and
+// the comments are skipped.
+func readEnvFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	envs := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		envs = append(envs, line)
+	}
+	return envs, scanner.Err()
+}
+
+// splitCommand splits the command into words like the shell, with the
+// quotes and the escapes
+func splitCommand(s string) ([]string, error) {
+	words := []string{}
+	word, inWord := []rune{}, false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word, inWord, escaped = append(word, r), true, false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word = append(word, r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word, inWord = []rune{}, false
+			}
+		default:
+			word, inWord = append(word, r), true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("%q has an unterminated quote or escape", s)
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
+
+// uniqueName returns name, with a suffix if it is used, and marks it used
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 1; used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// sortedKeys returns the keys of a map of string keys in order
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch m := m.(type) {
+	case map[string]*ComposeService:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*ComposeVolume:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,134 @@
+package pod
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComposeConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-compose")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "web.env"), []byte("# web\nMODE=dev\nDEBUG=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	yamlStr := `
+version: "2"
+services:
+  web:
+    image: nginx
+    command: nginx -g 'daemon off;'
+    ports:
+      - "8080:80"
+      - "127.0.0.1:5353:53/udp"
+      - "9000-9001:9000-9001"
+      - "443"
+    env_file: web.env
+    environment:
+      MODE: prod
+    volumes:
+      - data:/data:ro
+      - ./html:/usr/share/nginx/html
+      - /cache
+    mem_limit: 512m
+    restart: unless-stopped
+    depends_on:
+      - db
+  db:
+    image: redis
+    environment:
+      - A=1
+    volumes:
+      - ./html:/html
+    mem_limit: 256m
+    cpus: "1.5"
+volumes:
+  data: {}
+`
+	cf, warnings, err := ParseCompose([]byte(yamlStr), dir)
+	if err != nil {
+		t.Fatal("parse the compose file failed: ", err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected the warning of depends_on, got %v", warnings)
+	}
+	userPod, warnings, err := cf.Convert("test")
+	if err != nil {
+		t.Fatal("convert the compose file failed: ", err)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected the warnings of the ports, got %v", warnings)
+	}
+	if userPod.Resource.Vcpu != 2 || userPod.Resource.Memory != 768 {
+		t.Errorf("bad resource %v", userPod.Resource)
+	}
+	if err := userPod.Validate(); err != nil {
+		t.Errorf("the pod is invalid: %s", err)
+	}
+
+	// the services are in the order of their names
+	db, web := userPod.Containers[0], userPod.Containers[1]
+	if db.Name != "db" || web.Name != "web" {
+		t.Fatalf("bad containers %v", userPod.Containers)
+	}
+	if len(web.Command) != 3 || web.Command[2] != "daemon off;" || web.RestartPolicy != "always" {
+		t.Errorf("bad container %v", web)
+	}
+	if len(web.Ports) != 4 || web.Ports[1].Protocol != "udp" || web.Ports[3].HostPort != 9001 {
+		t.Errorf("bad ports %v", web.Ports)
+	}
+	envs := map[string]string{}
+	for _, e := range web.Envs {
+		envs[e.Env] = e.Value
+	}
+	if len(web.Envs) != 2 || envs["MODE"] != "prod" || envs["DEBUG"] != "1" {
+		t.Errorf("bad envs %v", web.Envs)
+	}
+
+	// the host path shared by the services is one volume
+	if len(userPod.Volumes) != 3 || db.Volumes[0].Volume != web.Volumes[1].Volume {
+		t.Errorf("bad volumes %v", userPod.Volumes)
+	}
+	if !web.Volumes[0].ReadOnly || userPod.Volumes[1].Source != filepath.Join(dir, "html") {
+		t.Errorf("bad volumes %v of %v", web.Volumes, userPod.Volumes)
+	}
+
+	for _, bad := range []string{
+		"services:\n  web:\n    image: nginx\n",
+		"version: \"2\"\nservices:\n  web:\n    build: .\n",
+		"version: \"2\"\nservices:\n  web:\n    image: nginx\n    volumes:\n      - data:/data\n",
+		"version: \"2\"\nservices:\n  web:\n    image: nginx\n    ports:\n      - \"80-81:80\"\n",
+	} {
+		cf, _, err := ParseCompose([]byte(bad), dir)
+		if err == nil {
+			_, _, err = cf.Convert("test")
+		}
+		if err == nil {
+			t.Errorf("convert %q should fail", bad)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	words, err := splitCommand(`sh -c "echo \"a b\"" 'c d' e\ f`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"sh", "-c", `echo "a b"`, "c d", "e f"}
+	if len(words) != len(expected) {
+		t.Fatalf("got %q, expected %q", words, expected)
+	}
+	for i := range words {
+		if words[i] != expected[i] {
+			t.Errorf("got %q, expected %q", words, expected)
+		}
+	}
+	if _, err := splitCommand(`echo "a`); err == nil {
+		t.Error("split an unterminated quote should fail")
+	}
+}
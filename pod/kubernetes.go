@@ -1,88 +1,284 @@
 package pod
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
 
 type KPod struct {
-	Kind string `json:"kind"`
-	Meta *KMeta `json:"metadata"`
-	Spec *KSpec `json:"spec"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Meta       *KMeta `json:"metadata"`
+	Spec       *KSpec `json:"spec"`
 }
 
 type KSpec struct {
 	Containers    []*KContainer `json:"containers"`
 	Volumes       []*KVolume    `json:"volumes"`
-	RestartPolicy string        `json:"restartPolicy"`
-	DNSPolicy     []string
+	RestartPolicy string        `json:"restartPolicy,omitempty"`
+	DNSPolicy     string        `json:"dnsPolicy,omitempty"`
 }
 
 type KMeta struct {
-	Name string `json:"name"`
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 type KContainer struct {
-	Name       string                 `json:"name"`
-	Image      string                 `json:"image"`
-	Command    []string               `json:"command"`
-	Args       []string               `json:"args"`
-	WorkingDir string                 `json:"workingDir"`
-	Resources  map[string]interface{} `json:"resources"`
-	CPU        int
-	Memory     int64
-	Volumes    []*KVolumeReference `json:"volumeMounts"`
-	Ports      []*KPort            `json:"ports"`
-	Env        []*KEnv             `json:"env"`
+	Name       string      `json:"name"`
+	Image      string      `json:"image"`
+	Command    []string    `json:"command,omitempty"`
+	Args       []string    `json:"args,omitempty"`
+	WorkingDir string      `json:"workingDir,omitempty"`
+	Resources  *KResources `json:"resources,omitempty"`
+	// the millicores and the bytes of memory of v1beta1, used if there
+	// are no resources
+	CPU     int                 `json:"cpu,omitempty"`
+	Memory  int64               `json:"memory,omitempty"`
+	Volumes []*KVolumeReference `json:"volumeMounts,omitempty"`
+	Ports   []*KPort            `json:"ports,omitempty"`
+	Env     []*KEnv             `json:"env,omitempty"`
+}
+
+type KResources struct {
+	Limits   map[string]KQuantity `json:"limits,omitempty"`
+	Requests map[string]KQuantity `json:"requests,omitempty"`
+}
+
+// KQuantity is a quantity of kubernetes, like 512Mi, 1.5G or 500m, which
+// could be a number in json too.
+type KQuantity string
+
+func (q *KQuantity) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*q = KQuantity(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid quantity %s", string(data))
+	}
+	*q = KQuantity(n)
+	return nil
 }
 
 type KVolumeReference struct {
 	Name      string `json:"name"`
 	MountPath string `json:"mountPath"`
-	ReadOnly  bool   `json:"readOnly"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
 }
 
 type KPort struct {
-	Name          string `json:"name"`
+	Name          string `json:"name,omitempty"`
 	ContainerPort int    `json:"containerPort"`
-	HostPort      int    `json:"hostPort"`
-	Protocol      string `json:"protocol"`
+	HostPort      int    `json:"hostPort,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
 }
 
 type KEnv struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name      string         `json:"name"`
+	Value     string         `json:"value,omitempty"`
+	ValueFrom *KEnvVarSource `json:"valueFrom,omitempty"`
 }
 
+type KEnvVarSource struct {
+	FieldRef         *KObjectFieldSelector   `json:"fieldRef,omitempty"`
+	ResourceFieldRef *KResourceFieldSelector `json:"resourceFieldRef,omitempty"`
+	ConfigMapKeyRef  *json.RawMessage        `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef     *json.RawMessage        `json:"secretKeyRef,omitempty"`
+}
+
+type KObjectFieldSelector struct {
+	FieldPath string `json:"fieldPath"`
+}
+
+type KResourceFieldSelector struct {
+	ContainerName string    `json:"containerName,omitempty"`
+	Resource      string    `json:"resource"`
+	Divisor       KQuantity `json:"divisor,omitempty"`
+}
+
+// KVolume is a volume of v1, the sources of which are inline, or of
+// v1beta1, the sources of which are in Source.
 type KVolume struct {
 	Name   string         `json:"name"`
-	Source *KVolumeSource `json:"source"`
+	Source *KVolumeSource `json:"source,omitempty"`
+	KVolumeSource
 }
 
+// KVolumeSource has the sources hyper supports, and the common ones it
+// does not, which are reported instead of being dropped.
 type KVolumeSource struct {
-	EmptyDir          *KEmptyDir `json:"emptyDir"`
-	HostDir           *KHostDir  `json:"hostDir"`
-	GCEPersistentDisk *KGCEPersistentDisk
+	EmptyDir              *KEmptyDir          `json:"emptyDir,omitempty"`
+	HostDir               *KHostDir           `json:"hostDir,omitempty"`
+	HostPath              *KHostDir           `json:"hostPath,omitempty"`
+	GCEPersistentDisk     *KGCEPersistentDisk `json:"gcePersistentDisk,omitempty"`
+	AWSElasticBlockStore  *json.RawMessage    `json:"awsElasticBlockStore,omitempty"`
+	GitRepo               *json.RawMessage    `json:"gitRepo,omitempty"`
+	Secret                *json.RawMessage    `json:"secret,omitempty"`
+	ConfigMap             *json.RawMessage    `json:"configMap,omitempty"`
+	NFS                   *json.RawMessage    `json:"nfs,omitempty"`
+	ISCSI                 *json.RawMessage    `json:"iscsi,omitempty"`
+	Glusterfs             *json.RawMessage    `json:"glusterfs,omitempty"`
+	RBD                   *json.RawMessage    `json:"rbd,omitempty"`
+	PersistentVolumeClaim *json.RawMessage    `json:"persistentVolumeClaim,omitempty"`
 }
 
-type KEmptyDir struct{}
+type KEmptyDir struct {
+	Medium string `json:"medium,omitempty"`
+}
 
 type KHostDir struct {
 	Path string `json:"path"`
 }
 
-type KGCEPersistentDisk struct{}
+type KGCEPersistentDisk struct {
+	PDName string `json:"pdName"`
+}
+
+// the multipliers of the suffixes of the quantities
+var quantitySuffixes = map[string]*big.Rat{
+	"n":  big.NewRat(1, 1000000000),
+	"u":  big.NewRat(1, 1000000),
+	"m":  big.NewRat(1, 1000),
+	"":   big.NewRat(1, 1),
+	"k":  big.NewRat(1000, 1),
+	"M":  big.NewRat(1000000, 1),
+	"G":  big.NewRat(1000000000, 1),
+	"T":  big.NewRat(1000000000000, 1),
+	"P":  big.NewRat(1000000000000000, 1),
+	"E":  big.NewRat(1000000000000000000, 1),
+	"Ki": big.NewRat(1<<10, 1),
+	"Mi": big.NewRat(1<<20, 1),
+	"Gi": big.NewRat(1<<30, 1),
+	"Ti": big.NewRat(1<<40, 1),
+	"Pi": big.NewRat(1<<50, 1),
+	"Ei": big.NewRat(1<<60, 1),
+}
 
-func (kp *KPod) Convert() (*UserPod, error) {
+// parseQuantity parses a quantity of kubernetes, like 512Mi, 1.5G, 500m or
+// 1e3, into its value.
+func parseQuantity(q KQuantity) (*big.Rat, error) {
+	s := strings.TrimSpace(string(q))
+	num, suffix := "", ""
+	for i := len(s); i > 0; i-- {
+		if c := s[i-1]; c >= '0' && c <= '9' || c == '.' {
+			num, suffix = s[:i], s[i:]
+			break
+		}
+	}
+	mul, ok := quantitySuffixes[suffix]
+	if num == "" || !ok {
+		return nil, fmt.Errorf("invalid quantity %q", s)
+	}
+	value, ok := new(big.Rat).SetString(num)
+	if !ok || value.Sign() < 0 {
+		return nil, fmt.Errorf("invalid quantity %q", s)
+	}
+	return value.Mul(value, mul), nil
+}
+
+// ratCeil returns the value in unit, rounded up
+func ratCeil(value *big.Rat, unit *big.Rat) int64 {
+	r := new(big.Rat).Quo(value, unit)
+	q, m := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if m.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q.Int64()
+}
+
+// kResources is the cpu cores and the bytes of memory of a container
+type kResources struct {
+	limits   map[string]*big.Rat
+	requests map[string]*big.Rat
+}
+
+// resources parses the resources of the container, the other resources
+// than cpu and memory are ignored with warnings.
+func (kc *KContainer) resources() (*kResources, []string, error) {
+	res := &kResources{
+		limits:   make(map[string]*big.Rat),
+		requests: make(map[string]*big.Rat),
+	}
+	warnings := []string{}
+	if kc.Resources == nil {
+		if kc.CPU > 0 {
+			res.limits["cpu"] = big.NewRat(int64(kc.CPU), 1000)
+		}
+		if kc.Memory > 0 {
+			res.limits["memory"] = big.NewRat(kc.Memory, 1)
+		}
+		return res, warnings, nil
+	}
+
+	for kind, quantities := range map[string]map[string]KQuantity{
+		"limits":   kc.Resources.Limits,
+		"requests": kc.Resources.Requests,
+	} {
+		parsed := res.limits
+		if kind == "requests" {
+			parsed = res.requests
+		}
+		for name, q := range quantities {
+			if name != "cpu" && name != "memory" {
+				warnings = append(warnings, fmt.Sprintf("container %s: resource %s in %s is not supported, ignored", kc.Name, name, kind))
+				continue
+			}
+			value, err := parseQuantity(q)
+			if err != nil {
+				return nil, nil, fmt.Errorf("container %s: %s of %s: %s", kc.Name, name, kind, err.Error())
+			}
+			parsed[name] = value
+		}
+	}
+	return res, warnings, nil
+}
+
+// get returns the limit of the resource, or the request if there is no limit
+func (res *kResources) get(name string) *big.Rat {
+	if value, ok := res.limits[name]; ok {
+		return value
+	}
+	return res.requests[name]
+}
+
+// Convert translates the kubernetes pod into a hyper pod. The fields which
+// could not be translated are reported by an error, or by the warnings if
+// they are ignored.
+func (kp *KPod) Convert() (*UserPod, []string, error) {
 
 	name := "default"
-	if kp.Meta != nil && kp.Meta.Name != "" {
-		name = kp.Meta.Name
+	namespace := "default"
+	meta := &KMeta{}
+	if kp.Meta != nil {
+		meta = kp.Meta
+		if meta.Name != "" {
+			name = meta.Name
+		}
+		if meta.Namespace != "" {
+			namespace = meta.Namespace
+		}
 	}
 
 	if kp.Kind != "Pod" {
-		return nil, fmt.Errorf("kind of the json is not Pod: %s", kp.Kind)
+		return nil, nil, fmt.Errorf("kind of the json is not Pod: %s", kp.Kind)
 	}
 
 	if kp.Spec == nil {
-		return nil, fmt.Errorf("No spec in the file")
+		return nil, nil, fmt.Errorf("No spec in the file")
+	}
+
+	warnings := []string{}
+	switch kp.Spec.DNSPolicy {
+	case "", "Default":
+	default:
+		warnings = append(warnings, fmt.Sprintf("dnsPolicy %s is not supported, the DNS of the host is used", kp.Spec.DNSPolicy))
 	}
 
 	rpolicy := "never"
@@ -95,25 +291,60 @@ func (kp *KPod) Convert() (*UserPod, error) {
 		rpolicy = "onFailure"
 	default:
 	}
-	var memory int64 = 0
+
+	resources := make(map[string]*kResources)
+	cpu, memory := new(big.Rat), new(big.Rat)
+	for _, kc := range kp.Spec.Containers {
+		res, w, err := kc.resources()
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, w...)
+		resources[kc.Name] = res
+		if value := res.get("cpu"); value != nil {
+			cpu.Add(cpu, value)
+		}
+		if value := res.get("memory"); value != nil {
+			memory.Add(memory, value)
+		}
+	}
+
 	containers := make([]UserContainer, len(kp.Spec.Containers))
 	for i, kc := range kp.Spec.Containers {
-		memory += kc.Memory
-
-		ports := make([]UserContainerPort, len(kc.Ports))
-		for j, p := range kc.Ports {
-			ports[j] = UserContainerPort{
+		portNames := make(map[string]bool)
+		ports := []UserContainerPort{}
+		for _, p := range kc.Ports {
+			if p.Name != "" {
+				if portNames[p.Name] {
+					return nil, nil, fmt.Errorf("container %s: port name %s is not unique", kc.Name, p.Name)
+				}
+				portNames[p.Name] = true
+			}
+			if p.HostPort == 0 {
+				warnings = append(warnings, fmt.Sprintf("container %s: port %d has no host port, it is reachable on the IP of the pod only", kc.Name, p.ContainerPort))
+				continue
+			}
+			ports = append(ports, UserContainerPort{
+				Name:          p.Name,
 				HostPort:      p.HostPort,
 				ContainerPort: p.ContainerPort,
-				Protocol:      p.Protocol,
-			}
+				Protocol:      strings.ToLower(p.Protocol),
+			})
 		}
 
 		envs := make([]UserEnvironmentVar, len(kc.Env))
 		for j, e := range kc.Env {
+			value := e.Value
+			if e.ValueFrom != nil {
+				v, err := e.ValueFrom.value(kc.Name, name, namespace, meta, resources)
+				if err != nil {
+					return nil, nil, fmt.Errorf("container %s: env %s: %s", kc.Name, e.Name, err.Error())
+				}
+				value = v
+			}
 			envs[j] = UserEnvironmentVar{
 				Env:   e.Name,
-				Value: e.Value,
+				Value: value,
 			}
 		}
 
@@ -142,25 +373,263 @@ func (kp *KPod) Convert() (*UserPod, error) {
 
 	volumes := make([]UserVolume, len(kp.Spec.Volumes))
 	for i, vol := range kp.Spec.Volumes {
-		volumes[i].Name = vol.Name
-		if vol.Source.HostDir != nil && vol.Source.HostDir.Path != "" {
-			volumes[i].Source = vol.Source.HostDir.Path
-			volumes[i].Driver = "vfs"
-		} else {
-			volumes[i].Source = ""
-			volumes[i].Driver = ""
+		v, err := vol.convert()
+		if err != nil {
+			return nil, nil, err
 		}
+		volumes[i] = *v
 	}
 
+	vcpu := int(ratCeil(cpu, big.NewRat(1, 1)))
+	if vcpu < 1 {
+		vcpu = 1
+	}
 	return &UserPod{
 		Name:       name,
 		Containers: containers,
 		Resource: UserResource{
-			Vcpu:   1,
-			Memory: int(memory / 1024 / 1024),
+			Vcpu:   vcpu,
+			Memory: int(ratCeil(memory, big.NewRat(1<<20, 1))),
 		},
 		Volumes: volumes,
 		Tty:     true,
 		Type:    "kubernetes",
-	}, nil
+	}, warnings, nil
+}
+
+// value returns the value of the env of the container, the fields of the
+// pod known before it runs are supported only.
+func (src *KEnvVarSource) value(container, name, namespace string, meta *KMeta, resources map[string]*kResources) (string, error) {
+	switch {
+	case src.FieldRef != nil:
+		path := src.FieldRef.FieldPath
+		switch {
+		case path == "metadata.name":
+			return name, nil
+		case path == "metadata.namespace":
+			return namespace, nil
+		case strings.HasPrefix(path, "metadata.labels['") && strings.HasSuffix(path, "']"):
+			return meta.Labels[path[len("metadata.labels['"):len(path)-2]], nil
+		case strings.HasPrefix(path, "metadata.annotations['") && strings.HasSuffix(path, "']"):
+			return meta.Annotations[path[len("metadata.annotations['"):len(path)-2]], nil
+		}
+		return "", fmt.Errorf("fieldRef %s is not supported", path)
+	case src.ResourceFieldRef != nil:
+		ref := src.ResourceFieldRef
+		if ref.ContainerName != "" {
+			container = ref.ContainerName
+		}
+		res, ok := resources[container]
+		if !ok {
+			return "", fmt.Errorf("container %s of resourceFieldRef does not exist", container)
+		}
+		parts := strings.SplitN(ref.Resource, ".", 2)
+		if len(parts) != 2 || (parts[0] != "limits" && parts[0] != "requests") || (parts[1] != "cpu" && parts[1] != "memory") {
+			return "", fmt.Errorf("resourceFieldRef %s is not supported", ref.Resource)
+		}
+		value := res.requests[parts[1]]
+		if parts[0] == "limits" {
+			value = res.get(parts[1])
+		}
+		if value == nil {
+			return "", fmt.Errorf("resource %s of container %s is not set", ref.Resource, container)
+		}
+		divisor := big.NewRat(1, 1)
+		if ref.Divisor != "" {
+			d, err := parseQuantity(ref.Divisor)
+			if err != nil {
+				return "", err
+			}
+			if d.Sign() == 0 {
+				return "", fmt.Errorf("divisor of resourceFieldRef can not be 0")
+			}
+			divisor = d
+		}
+		return fmt.Sprintf("%d", ratCeil(value, divisor)), nil
+	case src.ConfigMapKeyRef != nil:
+		return "", fmt.Errorf("configMapKeyRef is not supported")
+	case src.SecretKeyRef != nil:
+		return "", fmt.Errorf("secretKeyRef is not supported")
+	}
+	return "", fmt.Errorf("valueFrom has no source")
+}
+
+// convert translates the volume, the empty dir is a volume of hyper which
+// has no source, in memory if its medium is Memory.
+func (vol *KVolume) convert() (*UserVolume, error) {
+	src := &vol.KVolumeSource
+	if vol.Source != nil {
+		src = vol.Source
+	}
+
+	unsupported := map[string]bool{
+		"gcePersistentDisk":     src.GCEPersistentDisk != nil,
+		"awsElasticBlockStore":  src.AWSElasticBlockStore != nil,
+		"gitRepo":               src.GitRepo != nil,
+		"secret":                src.Secret != nil,
+		"configMap":             src.ConfigMap != nil,
+		"nfs":                   src.NFS != nil,
+		"iscsi":                 src.ISCSI != nil,
+		"glusterfs":             src.Glusterfs != nil,
+		"rbd":                   src.RBD != nil,
+		"persistentVolumeClaim": src.PersistentVolumeClaim != nil,
+	}
+	for kind, used := range unsupported {
+		if used {
+			return nil, fmt.Errorf("volume %s: %s volume is not supported", vol.Name, kind)
+		}
+	}
+
+	hostDir := src.HostPath
+	if hostDir == nil {
+		hostDir = src.HostDir
+	}
+	switch {
+	case hostDir != nil && hostDir.Path != "":
+		return &UserVolume{Name: vol.Name, Source: hostDir.Path, Driver: "vfs"}, nil
+	case src.EmptyDir != nil && src.EmptyDir.Medium == "Memory":
+		return &UserVolume{Name: vol.Name, Driver: "tmpfs"}, nil
+	case src.EmptyDir != nil && src.EmptyDir.Medium != "":
+		return nil, fmt.Errorf("volume %s: medium %s of emptyDir is not supported", vol.Name, src.EmptyDir.Medium)
+	}
+	return &UserVolume{Name: vol.Name}, nil
+}
+
+// ToKPod translates the hyper pod into a kubernetes pod, which is the
+// reverse of Convert. The resources of the pod are the limits of its first
+// container, and the fields kubernetes has no counterpart of are reported
+// by the warnings.
+func (pod *UserPod) ToKPod() (*KPod, []string, error) {
+	warnings := []string{}
+	if pod.Resource.MaxVcpu > pod.Resource.Vcpu {
+		warnings = append(warnings, fmt.Sprintf("maxVcpu %d is not supported, ignored", pod.Resource.MaxVcpu))
+	}
+	if pod.Resource.Ingress > 0 || pod.Resource.Egress > 0 {
+		warnings = append(warnings, "the bandwidth limits are not supported, ignored")
+	}
+	if len(pod.Files) > 0 {
+		warnings = append(warnings, "files are not supported, ignored")
+	}
+	if len(pod.Interfaces) > 0 {
+		warnings = append(warnings, "interfaces are not supported, ignored")
+	}
+	if len(pod.InitContainers) > 0 {
+		warnings = append(warnings, "init containers are not supported, ignored")
+	}
+
+	rpolicy := ""
+	names := make(map[string]bool)
+	containers := make([]*KContainer, len(pod.Containers))
+	for i, c := range pod.Containers {
+		name := c.Name
+		if name == "" {
+			// the names of kubernetes are DNS labels
+			image := c.Image
+			if idx := strings.LastIndex(image, "/"); idx >= 0 {
+				image = image[idx+1:]
+			}
+			if idx := strings.IndexAny(image, ":@"); idx >= 0 {
+				image = image[:idx]
+			}
+			name = strings.Map(func(r rune) rune {
+				if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+					return r
+				}
+				return '-'
+			}, strings.ToLower(image))
+			name = fmt.Sprintf("%s-%d", strings.Trim(name, "-"), i)
+		}
+		if names[name] {
+			return nil, nil, fmt.Errorf("container name %s is not unique", name)
+		}
+		names[name] = true
+
+		policy := ""
+		switch c.RestartPolicy {
+		case "", "never":
+			policy = "Never"
+		case "always":
+			policy = "Always"
+		case "onFailure":
+			policy = "OnFailure"
+		default:
+			return nil, nil, fmt.Errorf("container %s: invalid restart policy %s", name, c.RestartPolicy)
+		}
+		if i == 0 {
+			rpolicy = policy
+		} else if policy != rpolicy {
+			warnings = append(warnings, fmt.Sprintf("container %s: restart policy %s differs from the pod, %s is used", name, c.RestartPolicy, rpolicy))
+		}
+
+		kc := &KContainer{
+			Name:       name,
+			Image:      c.Image,
+			Command:    c.Entrypoint,
+			Args:       c.Command,
+			WorkingDir: c.Workdir,
+		}
+		for _, p := range c.Ports {
+			if p.ServicePort != 0 {
+				warnings = append(warnings, fmt.Sprintf("container %s: service port %d is not supported, ignored", name, p.ServicePort))
+			}
+			kc.Ports = append(kc.Ports, &KPort{
+				Name:          p.Name,
+				ContainerPort: p.ContainerPort,
+				HostPort:      p.HostPort,
+				Protocol:      strings.ToUpper(p.Protocol),
+			})
+		}
+		for _, e := range c.Envs {
+			kc.Env = append(kc.Env, &KEnv{Name: e.Env, Value: e.Value})
+		}
+		for _, v := range c.Volumes {
+			kc.Volumes = append(kc.Volumes, &KVolumeReference{
+				Name:      v.Volume,
+				MountPath: v.Path,
+				ReadOnly:  v.ReadOnly,
+			})
+		}
+		if len(c.Files) > 0 {
+			warnings = append(warnings, fmt.Sprintf("container %s: files are not supported, ignored", name))
+		}
+		containers[i] = kc
+	}
+
+	if len(containers) > 0 && (pod.Resource.Vcpu > 0 || pod.Resource.Memory > 0) {
+		limits := make(map[string]KQuantity)
+		if pod.Resource.Vcpu > 0 {
+			limits["cpu"] = KQuantity(fmt.Sprintf("%d", pod.Resource.Vcpu))
+		}
+		if pod.Resource.Memory > 0 {
+			limits["memory"] = KQuantity(fmt.Sprintf("%dMi", pod.Resource.Memory))
+		}
+		containers[0].Resources = &KResources{Limits: limits}
+	}
+
+	volumes := make([]*KVolume, len(pod.Volumes))
+	for i, v := range pod.Volumes {
+		kv := &KVolume{Name: v.Name}
+		switch {
+		case v.Driver == "tmpfs":
+			kv.EmptyDir = &KEmptyDir{Medium: "Memory"}
+		case v.Source == "":
+			kv.EmptyDir = &KEmptyDir{}
+		case v.Driver == "vfs":
+			kv.HostPath = &KHostDir{Path: v.Source}
+		default:
+			return nil, nil, fmt.Errorf("volume %s: %s volume is not supported", v.Name, v.Driver)
+		}
+		volumes[i] = kv
+	}
+
+	return &KPod{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Meta:       &KMeta{Name: pod.Name},
+		Spec: &KSpec{
+			Containers:    containers,
+			Volumes:       volumes,
+			RestartPolicy: rpolicy,
+		},
+	}, warnings, nil
 }
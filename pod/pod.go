@@ -3,14 +3,16 @@ package pod
 import (
 	"crypto/rand"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+
+	"gopkg.in/yaml.v2"
 )
 
 // Pod Data Structure
 type UserContainerPort struct {
+	Name          string `json:"name,omitempty"`
 	HostPort      int    `json:"hostPort"`
 	ContainerPort int    `json:"containerPort"`
 	ServicePort   int    `json:"servicePort"`
@@ -49,9 +51,15 @@ type UserContainer struct {
 	RestartPolicy string                `json:"restartPolicy"`
 }
 
+// UserResource is the resource of the VM of the pod. The bandwidth of each
+// nic of the pod is limited to Ingress and Egress in Mbit/s, 0 means
+// unlimited.
 type UserResource struct {
-	Vcpu   int `json:"vcpu"`
-	Memory int `json:"memory"`
+	Vcpu    int `json:"vcpu"`
+	MaxVcpu int `json:"maxVcpu"`
+	Memory  int `json:"memory"`
+	Ingress int `json:"ingress"`
+	Egress  int `json:"egress"`
 }
 
 type UserFile struct {
@@ -67,14 +75,36 @@ type UserVolume struct {
 	Driver string `json:"driver"`
 }
 
+// UserInterface is a nic of the pod on a network created by hyper, or on a
+// bridge of the host, the default bridge if both are empty. The IP is
+// allocated if it is not given, so is the IPv6 one if the network has IPv6,
+// and the MAC is a random one if it is not given.
+type UserInterface struct {
+	Network string `json:"network"`
+	Bridge  string `json:"bridge"`
+	Ip      string `json:"ip"`
+	Ip6     string `json:"ip6"`
+	Mac     string `json:"mac"`
+}
+
 type UserPod struct {
-	Name       string          `json:"id"`
-	Containers []UserContainer `json:"containers"`
-	Resource   UserResource    `json:"resource"`
-	Files      []UserFile      `json:"files"`
-	Volumes    []UserVolume    `json:"volumes"`
-	Tty        bool            `json:"tty"`
-	Type       string          `json:"type"`
+	Name           string          `json:"id"`
+	InitContainers []UserContainer `json:"initContainers,omitempty"`
+	Containers     []UserContainer `json:"containers"`
+	Resource       UserResource    `json:"resource"`
+	Files          []UserFile      `json:"files"`
+	Volumes        []UserVolume    `json:"volumes"`
+	Interfaces     []UserInterface `json:"interfaces"`
+	Tty            bool            `json:"tty"`
+	Type           string          `json:"type"`
+}
+
+// AllContainers returns the init containers, which run one after another
+// before the others start, followed by the containers of the pod.
+func (pod *UserPod) AllContainers() []UserContainer {
+	all := make([]UserContainer, 0, len(pod.InitContainers)+len(pod.Containers))
+	all = append(all, pod.InitContainers...)
+	return append(all, pod.Containers...)
 }
 
 func ProcessPodFile(jsonFile string) (*UserPod, error) {
@@ -103,6 +133,9 @@ func ProcessPodBytes(body []byte) (*UserPod, error) {
 	if userPod.Resource.Vcpu == 0 {
 		userPod.Resource.Vcpu = 1
 	}
+	if userPod.Resource.MaxVcpu < userPod.Resource.Vcpu {
+		userPod.Resource.MaxVcpu = userPod.Resource.Vcpu
+	}
 	if userPod.Resource.Memory == 0 {
 		userPod.Resource.Memory = 128
 	}
@@ -130,6 +163,21 @@ func ProcessPodBytes(body []byte) (*UserPod, error) {
 	return &userPod, nil
 }
 
+// ToYaml returns the pod file in YAML, whose keys are the same as the JSON
+// one in the same order.
+func (pod *UserPod) ToYaml() ([]byte, error) {
+	data, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	// the JSON is a YAML document already
+	var spec yaml.MapSlice
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(spec)
+}
+
 func RandStr(strSize int, randType string) string {
 	var dictionary string
 	if randType == "alphanum" {
@@ -151,76 +199,3 @@ func RandStr(strSize int, randType string) string {
 	}
 	return string(bytes)
 }
-
-//validate
-// 1. volume name, file name is unique
-// 2. source mount to only one pos in one container
-// 3. container should not use volume/file not in volume/file list
-// 4. environment var should be uniq in one container
-func (pod *UserPod) Validate() error {
-	uniq, vset := keySet(pod.Volumes)
-	if !uniq {
-		return errors.New("Volumes name does not unique")
-	}
-
-	uniq, fset := keySet(pod.Files)
-	if !uniq {
-		return errors.New("Files name does not unique")
-	}
-
-	for idx, container := range pod.Containers {
-
-		if uniq, _ := keySet(container.Volumes); !uniq {
-			return fmt.Errorf("in container %d, volume source are not unique", idx)
-		}
-
-		if uniq, _ := keySet(container.Envs); !uniq {
-			return fmt.Errorf("in container %d, environment name are not unique", idx)
-		}
-
-		for _, f := range container.Files {
-			if _, ok := fset[f.Filename]; !ok {
-				return fmt.Errorf("in container %d, file %s does not exist in file list.", idx, f.Filename)
-			}
-		}
-
-		for _, v := range container.Volumes {
-			if _, ok := vset[v.Volume]; !ok {
-				return fmt.Errorf("in container %d, volume %s does not exist in file list.", idx, v.Volume)
-			}
-		}
-	}
-
-	return nil
-}
-
-type item interface {
-	key() string
-}
-
-func keySet(ilist interface{}) (bool, map[string]bool) {
-	iset := make(map[string]bool)
-	switch ilist.(type) {
-	case []interface{}:
-		for _, x := range ilist.([]interface{}) {
-			switch x.(type) {
-			case item:
-				kx := x.(item).key()
-				if _, ok := iset[kx]; ok {
-					return false, iset
-				}
-				iset[kx] = true
-			default:
-				return false, iset
-			}
-		}
-		return true, iset
-	default:
-		return false, iset
-	}
-}
-
-func (vol UserVolume) key() string          { return vol.Name }
-func (vol UserVolumeReference) key() string { return vol.Volume }
-func (f UserFile) key() string              { return f.Name }
-func (env UserEnvironmentVar) key() string  { return env.Env }
@@ -1,6 +1,8 @@
 package pod
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -41,3 +43,265 @@ func TestProcessPodBytes(t *testing.T) {
 		t.Fatal("The ProcessPodBytes function should return an error while processing a json string without image name!")
 	}
 }
+
+func TestValidateInterfaces(t *testing.T) {
+	jsonStr := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{}, { "bridge": "br1", "ip": "10.0.0.5", "mac": "52:54:00:12:34:56" }] }`
+	userPod, err := ProcessPodBytes([]byte(jsonStr))
+	if err != nil {
+		t.Fatal("The ProcessPodBytes function return an error while processing the interfaces: ", err)
+	}
+	if err := userPod.Validate(); err != nil {
+		t.Fatal("The Validate function return an error while validating the interfaces: ", err)
+	}
+
+	jsonStrBadIp := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{ "ip": "10.0.0.256" }] }`
+	userPod, err = ProcessPodBytes([]byte(jsonStrBadIp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating a bad ip!")
+	}
+
+	jsonStrDupIp := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{ "ip": "10.0.0.5" }, { "ip": "10.0.0.5" }] }`
+	userPod, err = ProcessPodBytes([]byte(jsonStrDupIp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating duplicated ips!")
+	}
+
+	jsonStrIp6 := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{ "ip6": "fd00::5" }, { "ip6": "10.0.0.6" }] }`
+	userPod, err = ProcessPodBytes([]byte(jsonStrIp6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating an IPv4 address as ip6!")
+	}
+
+	jsonStrDupMac := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{ "mac": "52:54:00:12:34:56" }, { "mac": "52:54:00:12:34:56" }] }`
+	userPod, err = ProcessPodBytes([]byte(jsonStrDupMac))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating duplicated macs!")
+	}
+
+	jsonStrMulticastMac := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{ "mac": "01:00:5e:00:00:01" }] }`
+	userPod, err = ProcessPodBytes([]byte(jsonStrMulticastMac))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating a multicast mac!")
+	}
+
+	jsonStrNetworkBridge := `{ "id": "test-nics", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "interfaces": [{ "network": "frontend", "bridge": "br1" }] }`
+	userPod, err = ProcessPodBytes([]byte(jsonStrNetworkBridge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating an interface with both network and bridge!")
+	}
+}
+
+func TestValidateBandwidth(t *testing.T) {
+	jsonStr := `{ "id": "test-bandwidth", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "resource": { "ingress": 100, "egress": 10 } }`
+	userPod, err := ProcessPodBytes([]byte(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userPod.Resource.Ingress != 100 || userPod.Resource.Egress != 10 {
+		t.Fatalf("bad bandwidth limits %v", userPod.Resource)
+	}
+	if err := userPod.Validate(); err != nil {
+		t.Fatal("The Validate function return an error while validating the bandwidth limits: ", err)
+	}
+
+	userPod.Resource.Egress = -1
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while validating a negative bandwidth limit!")
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	for q, expected := range map[KQuantity]string{
+		"512Mi": "536870912",
+		"500m":  "1/2",
+		"1.5G":  "1500000000",
+		"2":     "2",
+		"1e3":   "1000",
+		"100Ki": "102400",
+	} {
+		value, err := parseQuantity(q)
+		if err != nil {
+			t.Fatalf("parse quantity %s failed: %s", q, err)
+		}
+		if value.RatString() != expected {
+			t.Errorf("quantity %s is parsed as %s, expected %s", q, value.RatString(), expected)
+		}
+	}
+	for _, q := range []KQuantity{"", "Mi", "12Xi", "-1"} {
+		if _, err := parseQuantity(q); err == nil {
+			t.Errorf("parse quantity %q should fail", q)
+		}
+	}
+}
+
+func TestKPodConvert(t *testing.T) {
+	jsonStr := `{
+		"kind": "Pod",
+		"metadata": { "name": "web", "labels": { "app": "nginx" } },
+		"spec": {
+			"dnsPolicy": "ClusterFirst",
+			"containers": [{
+				"name": "nginx",
+				"image": "nginx",
+				"resources": { "limits": { "cpu": "500m", "memory": "512Mi" }, "requests": { "ephemeral-storage": "1Gi" } },
+				"ports": [{ "name": "http", "containerPort": 80, "hostPort": 8080, "protocol": "TCP" }],
+				"env": [
+					{ "name": "POD_NAME", "valueFrom": { "fieldRef": { "fieldPath": "metadata.name" } } },
+					{ "name": "APP", "valueFrom": { "fieldRef": { "fieldPath": "metadata.labels['app']" } } },
+					{ "name": "MEM_MB", "valueFrom": { "resourceFieldRef": { "resource": "limits.memory", "divisor": "1Mi" } } }
+				],
+				"volumeMounts": [{ "name": "cache", "mountPath": "/cache" }, { "name": "data", "mountPath": "/data" }]
+			}, {
+				"name": "sidecar",
+				"image": "busybox",
+				"resources": { "requests": { "cpu": 1, "memory": "128M" } }
+			}],
+			"volumes": [
+				{ "name": "cache", "emptyDir": { "medium": "Memory" } },
+				{ "name": "data", "hostPath": { "path": "/srv/data" } }
+			]
+		}
+	}`
+	var kpod KPod
+	if err := json.Unmarshal([]byte(jsonStr), &kpod); err != nil {
+		t.Fatal(err)
+	}
+	userPod, warnings, err := kpod.Convert()
+	if err != nil {
+		t.Fatal("convert the kubernetes pod failed: ", err)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected the warnings of dnsPolicy and ephemeral-storage, got %v", warnings)
+	}
+	// 1.5 cores and 512Mi + 128M
+	if userPod.Resource.Vcpu != 2 || userPod.Resource.Memory != 635 {
+		t.Errorf("bad resource %v", userPod.Resource)
+	}
+	c := userPod.Containers[0]
+	if len(c.Ports) != 1 || c.Ports[0].Name != "http" || c.Ports[0].Protocol != "tcp" {
+		t.Errorf("bad ports %v", c.Ports)
+	}
+	envs := map[string]string{}
+	for _, e := range c.Envs {
+		envs[e.Env] = e.Value
+	}
+	if envs["POD_NAME"] != "web" || envs["APP"] != "nginx" || envs["MEM_MB"] != "512" {
+		t.Errorf("bad envs %v", c.Envs)
+	}
+	if len(userPod.Volumes) != 2 || userPod.Volumes[0].Driver != "tmpfs" || userPod.Volumes[1].Source != "/srv/data" {
+		t.Errorf("bad volumes %v", userPod.Volumes)
+	}
+
+	kpod.Spec.Volumes = append(kpod.Spec.Volumes, &KVolume{Name: "pd", KVolumeSource: KVolumeSource{GCEPersistentDisk: &KGCEPersistentDisk{PDName: "disk"}}})
+	if _, _, err := kpod.Convert(); err == nil {
+		t.Error("convert a pod with a gcePersistentDisk volume should fail")
+	}
+
+	kpod.Spec.Volumes = nil
+	kpod.Spec.Containers[0].Env = append(kpod.Spec.Containers[0].Env, &KEnv{Name: "IP", ValueFrom: &KEnvVarSource{FieldRef: &KObjectFieldSelector{FieldPath: "status.podIP"}}})
+	if _, _, err := kpod.Convert(); err == nil {
+		t.Error("convert a pod with an env from status.podIP should fail")
+	}
+}
+
+func TestUserPodToKPod(t *testing.T) {
+	userPod := &UserPod{
+		Name: "web",
+		Containers: []UserContainer{{
+			Image:         "library/nginx:latest",
+			Entrypoint:    []string{"nginx"},
+			Command:       []string{"-g", "daemon off;"},
+			Ports:         []UserContainerPort{{Name: "http", ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}},
+			Envs:          []UserEnvironmentVar{{Env: "A", Value: "1"}},
+			Volumes:       []UserVolumeReference{{Path: "/cache", Volume: "cache"}, {Path: "/data", Volume: "data", ReadOnly: true}},
+			RestartPolicy: "always",
+		}},
+		Resource: UserResource{Vcpu: 2, Memory: 256, Ingress: 10},
+		Volumes:  []UserVolume{{Name: "cache", Driver: "tmpfs"}, {Name: "data", Source: "/srv/data", Driver: "vfs"}},
+	}
+	kpod, warnings, err := userPod.ToKPod()
+	if err != nil {
+		t.Fatal("export the pod failed: ", err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected the warning of the bandwidth, got %v", warnings)
+	}
+	if kpod.Spec.Containers[0].Name != "nginx-0" || kpod.Spec.RestartPolicy != "Always" {
+		t.Errorf("bad kubernetes pod %v", kpod.Spec)
+	}
+
+	// the kubernetes pod should be converted back to the same one
+	data, err := json.Marshal(kpod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kp KPod
+	if err := json.Unmarshal(data, &kp); err != nil {
+		t.Fatal(err)
+	}
+	converted, _, err := kp.Convert()
+	if err != nil {
+		t.Fatal("convert the exported pod failed: ", err)
+	}
+	if converted.Resource.Vcpu != 2 || converted.Resource.Memory != 256 {
+		t.Errorf("bad resource %v", converted.Resource)
+	}
+	c := converted.Containers[0]
+	if c.RestartPolicy != "always" || len(c.Entrypoint) != 1 || len(c.Command) != 2 {
+		t.Errorf("bad container %v", c)
+	}
+	if len(c.Ports) != 1 || c.Ports[0] != userPod.Containers[0].Ports[0] {
+		t.Errorf("bad ports %v", c.Ports)
+	}
+	if len(c.Volumes) != 2 || !c.Volumes[1].ReadOnly {
+		t.Errorf("bad volume references %v", c.Volumes)
+	}
+	for i, v := range converted.Volumes {
+		if v != userPod.Volumes[i] {
+			t.Errorf("volume %d is %v, expected %v", i, v, userPod.Volumes[i])
+		}
+	}
+
+	userPod.Volumes = append(userPod.Volumes, UserVolume{Name: "disk", Source: "/dev/sdb", Driver: "raw"})
+	if _, _, err := userPod.ToKPod(); err == nil {
+		t.Error("export a pod with a raw volume should fail")
+	}
+}
+
+func TestUserPodToYaml(t *testing.T) {
+	jsonStr := `{"id":"yamlpod","containers":[{"name":"web","image":"nginx","command":["nginx"],"envs":[{"env":"A","value":"1"}]}],"resource":{"vcpu":2,"memory":256}}`
+	userPod, err := ProcessPodBytes([]byte(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := userPod.ToYaml()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id: yamlpod", "containers:", "- name: web", "image: nginx", "env: A", "resource:", "vcpu: 2", "memory: 256"} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("%q is not in the yaml:\n%s", key, data)
+		}
+	}
+	if strings.Contains(string(data), "Name:") || strings.Contains(string(data), "name: yamlpod") {
+		t.Errorf("the yaml is not in the schema of the pod file:\n%s", data)
+	}
+}
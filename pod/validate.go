@@ -0,0 +1,354 @@
+package pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// the names of the pods and the containers, which are made of the
+	// images by hyper run
+	validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:/-]{0,254}$`)
+	// [REGISTRY[:PORT]/]NAME[:TAG][@DIGEST] like docker
+	validImage = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:[._-]+[a-z0-9]+)*(?:/[a-z0-9]+(?:[._-]+[a-z0-9]+)*)*(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?(?:@[a-z0-9]+:[a-f0-9]{32,})?$`)
+	// the drivers of the volumes, the empty one is for the volumes without
+	// source
+	validVolumeDrivers   = map[string]bool{"": true, "vfs": true, "raw": true, "qcow2": true, "tmpfs": true}
+	validRestartPolicies = map[string]bool{"": true, "never": true, "always": true, "onFailure": true}
+)
+
+// FieldError is an error of a field of the pod spec, which is at Field,
+// like containers[1].volumes[0].volume.
+type FieldError struct {
+	Field  string
+	Detail string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Detail
+}
+
+func fieldError(field, format string, a ...interface{}) error {
+	return &FieldError{Field: field, Detail: fmt.Sprintf(format, a...)}
+}
+
+// ValidatePodBytes parses the pod spec sent by the user like
+// ProcessPodBytes, the unknown fields of which are rejected, and validates
+// it.
+func ValidatePodBytes(body []byte) (*UserPod, error) {
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	if err := checkFields(raw, reflect.TypeOf(UserPod{}), ""); err != nil {
+		return nil, err
+	}
+	userPod, err := ProcessPodBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := userPod.Validate(); err != nil {
+		return nil, err
+	}
+	return userPod, nil
+}
+
+// checkFields reports the first field of the json value which is not in
+// the type, the fields are matched case insensitively like encoding/json.
+func checkFields(v interface{}, t reflect.Type, field string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			// the type mismatches are reported by encoding/json
+			return nil
+		}
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields[strings.ToLower(name)] = f
+		}
+		keys := []string{}
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f, ok := fields[strings.ToLower(key)]
+			if !ok {
+				return fieldError(joinField(field, key), "unknown field")
+			}
+			if err := checkFields(obj[key], f.Type, joinField(field, key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range list {
+			if err := checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// Validate checks the pod spec, the errors are FieldErrors of the first
+// invalid field.
+//  1. the names of the pod and the containers, and the images, the init
+//     containers have no ports and are never restarted
+//  2. the resource, the ports, and the restart policies
+//  3. the volumes and the files are unique, and the ones the containers
+//     use are declared
+//  4. the envs are unique in one container
+//  5. the addresses of the interfaces
+func (pod *UserPod) Validate() error {
+	if pod.Name != "" && !validName.MatchString(pod.Name) {
+		return fieldError("id", "%q is not a valid name", pod.Name)
+	}
+	if len(pod.Containers) == 0 {
+		return fieldError("containers", "at least one container is required")
+	}
+
+	res := pod.Resource
+	if res.Vcpu < 0 {
+		return fieldError("resource.vcpu", "%d can not be negative", res.Vcpu)
+	}
+	if res.MaxVcpu != 0 && res.MaxVcpu < res.Vcpu {
+		return fieldError("resource.maxVcpu", "%d is less than vcpu %d", res.MaxVcpu, res.Vcpu)
+	}
+	if res.Memory < 0 {
+		return fieldError("resource.memory", "%d can not be negative", res.Memory)
+	}
+	if res.Ingress < 0 {
+		return fieldError("resource.ingress", "the bandwidth limit can not be negative")
+	}
+	if res.Egress < 0 {
+		return fieldError("resource.egress", "the bandwidth limit can not be negative")
+	}
+
+	volumes := make(map[string]bool)
+	for i, v := range pod.Volumes {
+		field := fmt.Sprintf("volumes[%d]", i)
+		if v.Name == "" {
+			return fieldError(field+".name", "required")
+		}
+		if volumes[v.Name] {
+			return fieldError(field+".name", "%q is not unique", v.Name)
+		}
+		volumes[v.Name] = true
+		if !validVolumeDrivers[v.Driver] {
+			return fieldError(field+".driver", "%q is not supported", v.Driver)
+		}
+		switch {
+		case v.Driver == "tmpfs" && v.Source != "":
+			return fieldError(field+".source", "a tmpfs volume has no source")
+		case (v.Driver == "raw" || v.Driver == "qcow2") && v.Source == "":
+			return fieldError(field+".source", "required by the %s volume", v.Driver)
+		case v.Driver == "vfs" && v.Source != "" && !path.IsAbs(v.Source):
+			return fieldError(field+".source", "%q is not an absolute path", v.Source)
+		}
+	}
+
+	files := make(map[string]bool)
+	for i, f := range pod.Files {
+		if f.Name == "" {
+			return fieldError(fmt.Sprintf("files[%d].name", i), "required")
+		}
+		if files[f.Name] {
+			return fieldError(fmt.Sprintf("files[%d].name", i), "%q is not unique", f.Name)
+		}
+		files[f.Name] = true
+	}
+
+	names := make(map[string]bool)
+	hostPorts := make(map[string]string)
+	for i, c := range pod.InitContainers {
+		field := fmt.Sprintf("initContainers[%d]", i)
+		if len(c.Ports) > 0 {
+			return fieldError(field+".ports", "an init container can not publish ports")
+		}
+		if c.RestartPolicy != "" && c.RestartPolicy != "never" {
+			return fieldError(field+".restartPolicy", "an init container is never restarted")
+		}
+		if err := validateContainer(field, &c, volumes, files, names, hostPorts); err != nil {
+			return err
+		}
+	}
+	for i, c := range pod.Containers {
+		if err := validateContainer(fmt.Sprintf("containers[%d]", i), &c, volumes, files, names, hostPorts); err != nil {
+			return err
+		}
+	}
+
+	return pod.validateInterfaces()
+}
+
+// validateContainer checks the container at field, the names of the
+// containers and the host ports are unique in the pod.
+func validateContainer(field string, c *UserContainer, volumes, files, names map[string]bool,
+	hostPorts map[string]string) error {
+	if c.Name != "" {
+		if !validName.MatchString(c.Name) {
+			return fieldError(field+".name", "%q is not a valid name", c.Name)
+		}
+		if names[c.Name] {
+			return fieldError(field+".name", "%q is not unique", c.Name)
+		}
+		names[c.Name] = true
+	}
+	if c.Image == "" {
+		return fieldError(field+".image", "required")
+	}
+	if !validImage.MatchString(c.Image) {
+		return fieldError(field+".image", "%q is not a valid image reference", c.Image)
+	}
+	if !validRestartPolicies[c.RestartPolicy] {
+		return fieldError(field+".restartPolicy", "%q should be never, always or onFailure", c.RestartPolicy)
+	}
+
+	for j, p := range c.Ports {
+		pfield := fmt.Sprintf("%s.ports[%d]", field, j)
+		if p.ContainerPort <= 0 || p.ContainerPort > 65535 {
+			return fieldError(pfield+".containerPort", "%d is out of range 1-65535", p.ContainerPort)
+		}
+		if p.HostPort <= 0 || p.HostPort > 65535 {
+			return fieldError(pfield+".hostPort", "%d is out of range 1-65535", p.HostPort)
+		}
+		if p.ServicePort < 0 || p.ServicePort > 65535 {
+			return fieldError(pfield+".servicePort", "%d is out of range 0-65535", p.ServicePort)
+		}
+		protocol := strings.ToLower(p.Protocol)
+		switch protocol {
+		case "":
+			protocol = "tcp"
+		case "tcp", "udp":
+		default:
+			return fieldError(pfield+".protocol", "%q should be tcp or udp", p.Protocol)
+		}
+		key := fmt.Sprintf("%d/%s", p.HostPort, protocol)
+		if other, ok := hostPorts[key]; ok {
+			return fieldError(pfield+".hostPort", "%s is used by %s", key, other)
+		}
+		hostPorts[key] = pfield
+	}
+
+	envs := make(map[string]bool)
+	for j, e := range c.Envs {
+		efield := fmt.Sprintf("%s.envs[%d].env", field, j)
+		if e.Env == "" || strings.ContainsAny(e.Env, "= \t\n") {
+			return fieldError(efield, "%q is not a valid name", e.Env)
+		}
+		if envs[e.Env] {
+			return fieldError(efield, "%q is not unique", e.Env)
+		}
+		envs[e.Env] = true
+	}
+
+	refs := make(map[string]bool)
+	paths := make(map[string]bool)
+	for j, v := range c.Volumes {
+		vfield := fmt.Sprintf("%s.volumes[%d]", field, j)
+		if !volumes[v.Volume] {
+			return fieldError(vfield+".volume", "%q not declared", v.Volume)
+		}
+		if refs[v.Volume] {
+			return fieldError(vfield+".volume", "%q is mounted more than once", v.Volume)
+		}
+		refs[v.Volume] = true
+		if !path.IsAbs(v.Path) {
+			return fieldError(vfield+".path", "%q is not an absolute path", v.Path)
+		}
+		if paths[path.Clean(v.Path)] {
+			return fieldError(vfield+".path", "%q is used by another volume", v.Path)
+		}
+		paths[path.Clean(v.Path)] = true
+	}
+
+	for j, f := range c.Files {
+		ffield := fmt.Sprintf("%s.files[%d]", field, j)
+		if !files[f.Filename] {
+			return fieldError(ffield+".filename", "%q not declared", f.Filename)
+		}
+		if !path.IsAbs(f.Path) {
+			return fieldError(ffield+".path", "%q is not an absolute path", f.Path)
+		}
+		if perm, err := strconv.ParseUint(f.Perm, 8, 32); err != nil || perm > 07777 {
+			return fieldError(ffield+".perm", "%q is not an octal permission like 0644", f.Perm)
+		}
+	}
+
+	return nil
+}
+
+func (pod *UserPod) validateInterfaces() error {
+	ips := make(map[string]bool)
+	macs := make(map[string]bool)
+	for i, inf := range pod.Interfaces {
+		field := fmt.Sprintf("interfaces[%d]", i)
+		if inf.Network != "" && inf.Bridge != "" {
+			return fieldError(field, "network and bridge can not be both specified")
+		}
+		if inf.Ip != "" {
+			ip := net.ParseIP(inf.Ip)
+			if ip == nil || ip.To4() == nil {
+				return fieldError(field+".ip", "%q is not a valid IPv4 address", inf.Ip)
+			}
+			if ips[ip.String()] {
+				return fieldError(field+".ip", "%q is used by another interface", inf.Ip)
+			}
+			ips[ip.String()] = true
+		}
+		if inf.Ip6 != "" {
+			ip := net.ParseIP(inf.Ip6)
+			if ip == nil || ip.To4() != nil {
+				return fieldError(field+".ip6", "%q is not a valid IPv6 address", inf.Ip6)
+			}
+			if ips[ip.String()] {
+				return fieldError(field+".ip6", "%q is used by another interface", inf.Ip6)
+			}
+			ips[ip.String()] = true
+		}
+		if inf.Mac != "" {
+			mac, err := net.ParseMAC(inf.Mac)
+			if err != nil || len(mac) != 6 {
+				return fieldError(field+".mac", "%q is not a valid MAC address", inf.Mac)
+			}
+			if mac[0]&1 != 0 {
+				return fieldError(field+".mac", "%q is a multicast address", inf.Mac)
+			}
+			if macs[mac.String()] {
+				return fieldError(field+".mac", "%q is used by another interface", inf.Mac)
+			}
+			macs[mac.String()] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package pod
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestValidatePodBytes(t *testing.T) {
+	for _, file := range []string{"ubuntu.pod", "tomcat.pod", "multi-container.pod", "with-volume.pod"} {
+		body, err := ioutil.ReadFile("../examples/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidatePodBytes(body); err != nil {
+			t.Errorf("validate %s failed: %s", file, err)
+		}
+	}
+
+	for _, c := range []struct {
+		spec string
+		err  string
+	}{
+		{`{ "containers": [{ "image": "busybox", "volume": [] }] }`,
+			`containers[0].volume: unknown field`},
+		{`{ "containers": [{ "image": "busybox" }, { "image": "busybox", "volumes": [{ "volume": "tmp", "path": "/tmp" }] }] }`,
+			`containers[1].volumes[0].volume: "tmp" not declared`},
+		{`{ "containers": [{ "image": "busybox", "volumes": [{ "volume": "tmp", "path": "tmp" }] }], "volumes": [{ "name": "tmp" }] }`,
+			`containers[0].volumes[0].path: "tmp" is not an absolute path`},
+		{`{ "containers": [{ "image": "Busybox:latest" }] }`,
+			`containers[0].image: "Busybox:latest" is not a valid image reference`},
+		{`{ "containers": [{ "name": "a", "image": "busybox" }, { "name": "a", "image": "busybox" }] }`,
+			`containers[1].name: "a" is not unique`},
+		{`{ "containers": [{ "image": "busybox", "ports": [{ "containerPort": 80, "hostPort": 70000 }] }] }`,
+			`containers[0].ports[0].hostPort: 70000 is out of range 1-65535`},
+		{`{ "containers": [{ "image": "nginx", "ports": [{ "containerPort": 80, "hostPort": 8080 }] }, { "image": "nginx", "ports": [{ "containerPort": 81, "hostPort": 8080, "protocol": "tcp" }] }] }`,
+			`containers[1].ports[0].hostPort: 8080/tcp is used by containers[0].ports[0]`},
+		{`{ "containers": [{ "image": "busybox", "files": [{ "filename": "conf", "path": "/etc/conf", "perm": "0999" }] }], "files": [{ "name": "conf" }] }`,
+			`containers[0].files[0].perm: "0999" is not an octal permission like 0644`},
+		{`{ "containers": [{ "image": "busybox", "restartPolicy": "sometimes" }] }`,
+			`containers[0].restartPolicy: "sometimes" should be never, always or onFailure`},
+		{`{ "containers": [{ "image": "busybox" }], "volumes": [{ "name": "ram", "source": "/tmp", "driver": "tmpfs" }] }`,
+			`volumes[0].source: a tmpfs volume has no source`},
+		{`{ "initContainers": [{ "image": "busybox", "ports": [{ "containerPort": 80, "hostPort": 80 }] }], "containers": [{ "image": "nginx" }] }`,
+			`initContainers[0].ports: an init container can not publish ports`},
+		{`{ "initContainers": [{ "name": "a", "image": "busybox" }], "containers": [{ "name": "a", "image": "nginx" }] }`,
+			`containers[0].name: "a" is not unique`},
+	} {
+		_, err := ValidatePodBytes([]byte(c.spec))
+		if err == nil {
+			t.Errorf("validate %s should fail", c.spec)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("validate %s, got error %q, expected %q", c.spec, err.Error(), c.err)
+		}
+	}
+
+	spec := `{ "containers": [{ "image": "localhost:5000/library/busybox:1.2", "ports": [{ "containerPort": 53, "hostPort": 53, "protocol": "udp" }, { "containerPort": 53, "hostPort": 53 }],` +
+		` "files": [{ "filename": "conf", "path": "/etc/conf", "perm": "0644" }] }], "files": [{ "name": "conf" }] }`
+	if _, err := ValidatePodBytes([]byte(spec)); err != nil {
+		t.Errorf("validate %s failed: %s", spec, err)
+	}
+}
+
+// the container added to a running pod is validated with the pod spec
+func TestValidateAddedContainer(t *testing.T) {
+	body, err := ioutil.ReadFile("../examples/with-volume.pod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPod, err := ProcessPodBytes(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := userPod.Containers[0]
+	added.Name = "added"
+	userPod.Containers = append(userPod.Containers, added)
+	if err := userPod.Validate(); err != nil {
+		t.Errorf("validate the pod with the added container failed: %s", err)
+	}
+
+	userPod.Containers[len(userPod.Containers)-1].Name = userPod.Containers[0].Name
+	if err := userPod.Validate(); err == nil {
+		t.Error("the added container has the name of another one")
+	}
+}
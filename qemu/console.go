@@ -0,0 +1,72 @@
+package qemu
+
+import (
+	"os"
+	"path"
+)
+
+const (
+	ConsoleLogName = "console.log"
+	ConsoleLogSize = 1 << 20 // rotate the console log once it grows larger
+)
+
+// ConsoleLogPath returns the file the console of VM vmId is written to,
+// the rotated one has the suffix ".1".
+func ConsoleLogPath(vmId string) string {
+	return path.Join(BaseDir, vmId, ConsoleLogName)
+}
+
+// consoleLog writes the lines of the console to a file, which is moved to
+// the ".1" one once it grows larger than max.
+type consoleLog struct {
+	path string
+	max  int64
+	file *os.File
+	size int64
+}
+
+func openConsoleLog(path string, max int64) (*consoleLog, error) {
+	cl := &consoleLog{path: path, max: max}
+	if err := cl.open(); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+func (cl *consoleLog) open() error {
+	file, err := os.OpenFile(cl.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	cl.file = file
+	cl.size = info.Size()
+	return nil
+}
+
+func (cl *consoleLog) rotate() error {
+	cl.file.Close()
+	if err := os.Rename(cl.path, cl.path+".1"); err != nil {
+		return err
+	}
+	return cl.open()
+}
+
+func (cl *consoleLog) WriteLine(line string) error {
+	if cl.size > 0 && cl.size+int64(len(line))+1 > cl.max {
+		if err := cl.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := cl.file.WriteString(line + "\n")
+	cl.size += int64(n)
+	return err
+}
+
+func (cl *consoleLog) Close() error {
+	return cl.file.Close()
+}
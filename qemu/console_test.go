@@ -0,0 +1,37 @@
+package qemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestConsoleLogRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, ConsoleLogName)
+	clog, err := openConsoleLog(name, 16)
+	if err != nil {
+		t.Fatal("open console log failed: ", err.Error())
+	}
+	for _, line := range []string{"line 1", "line 2", "line 3"} {
+		if err := clog.WriteLine(line); err != nil {
+			t.Fatal("write console log failed: ", err.Error())
+		}
+	}
+	clog.Close()
+
+	rotated, err := ioutil.ReadFile(name + ".1")
+	if err != nil || string(rotated) != "line 1\nline 2\n" {
+		t.Errorf("rotated log mismatch: %q", string(rotated))
+	}
+	current, err := ioutil.ReadFile(name)
+	if err != nil || string(current) != "line 3\n" {
+		t.Errorf("current log mismatch: %q", string(current))
+	}
+}
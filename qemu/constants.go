@@ -6,12 +6,26 @@ const (
 	QmpSockName     = "qmp.sock"
 	TtySockName     = "tty.sock"
 	ConsoleSockName = "console.sock"
+	MigrateSockName = "migrate.sock"
 	ShareDirTag     = "share_dir"
 	DefaultKernel   = "/var/lib/hyper/kernel"
 	DefaultInitrd   = "/var/lib/hyper/hyper-initrd.img"
 	PciAddrFrom     = 0x05
 	ExitChar        = 4
-	InterfaceCount  = 1
+	InterfaceCount  = 1 // nics of the pod declaring none
+)
+
+// PingTimeoutReason is the reason of Interrupted if init does not reply
+const PingTimeoutReason = "init not reply ping mesg"
+
+const (
+	MigrationSpeed   = 1 << 40 // do not throttle the migration
+	MigrationTimeout = 300     // seconds
+)
+
+const (
+	BalloonMinMemory = 64 // MB
+	BalloonTimeout   = 10 // seconds
 )
 
 const (
@@ -35,6 +49,11 @@ const (
 	EVENT_SERIAL_DELETE
 	EVENT_TTY_OPEN
 	EVENT_TTY_CLOSE
+	EVENT_INCOMING_READY
+	EVENT_MIGRATION_DONE
+	EVENT_PAUSE_DONE
+	EVENT_RESIZED
+	EVENT_CPU_ADDED
 	COMMAND_RUN_POD
 	COMMAND_REPLACE_POD
 	COMMAND_STOP_POD
@@ -45,6 +64,16 @@ const (
 	COMMAND_DETACH
 	COMMAND_WINDOWSIZE
 	COMMAND_ACK
+	COMMAND_QUERY
+	COMMAND_SNAPSHOT
+	COMMAND_MIGRATE
+	COMMAND_PAUSE
+	COMMAND_UNPAUSE
+	COMMAND_RESIZE
+	COMMAND_ADD_CPU
+	COMMAND_NEW_CONTAINER
+	COMMAND_ATTACH_VOLUME
+	COMMAND_DETACH_VOLUME
 	ERROR_INIT_FAIL
 	ERROR_QMP_FAIL
 	ERROR_INTERRUPTED
@@ -78,10 +107,14 @@ const (
 	INIT_WINSIZE
 	INIT_PING
 	INIT_FINISHPOD
+	INIT_ONLINECPUMEM
+	INIT_MOUNTVOLUME
+	INIT_UMOUNTVOLUME
 )
 
 const (
 	QMP_EVENT_SHUTDOWN = "SHUTDOWN"
+	QMP_EVENT_RESUME   = "RESUME"
 )
 
 const (
@@ -133,6 +166,16 @@ func EventString(ev int) string {
 		return "EVENT_TTY_OPEN"
 	case EVENT_TTY_CLOSE:
 		return "EVENT_TTY_CLOSE"
+	case EVENT_INCOMING_READY:
+		return "EVENT_INCOMING_READY"
+	case EVENT_MIGRATION_DONE:
+		return "EVENT_MIGRATION_DONE"
+	case EVENT_PAUSE_DONE:
+		return "EVENT_PAUSE_DONE"
+	case EVENT_RESIZED:
+		return "EVENT_RESIZED"
+	case EVENT_CPU_ADDED:
+		return "EVENT_CPU_ADDED"
 	case COMMAND_RUN_POD:
 		return "COMMAND_RUN_POD"
 	case COMMAND_REPLACE_POD:
@@ -153,6 +196,26 @@ func EventString(ev int) string {
 		return "COMMAND_WINDOWSIZE"
 	case COMMAND_ACK:
 		return "COMMAND_ACK"
+	case COMMAND_QUERY:
+		return "COMMAND_QUERY"
+	case COMMAND_SNAPSHOT:
+		return "COMMAND_SNAPSHOT"
+	case COMMAND_MIGRATE:
+		return "COMMAND_MIGRATE"
+	case COMMAND_PAUSE:
+		return "COMMAND_PAUSE"
+	case COMMAND_UNPAUSE:
+		return "COMMAND_UNPAUSE"
+	case COMMAND_RESIZE:
+		return "COMMAND_RESIZE"
+	case COMMAND_ADD_CPU:
+		return "COMMAND_ADD_CPU"
+	case COMMAND_NEW_CONTAINER:
+		return "COMMAND_NEW_CONTAINER"
+	case COMMAND_ATTACH_VOLUME:
+		return "COMMAND_ATTACH_VOLUME"
+	case COMMAND_DETACH_VOLUME:
+		return "COMMAND_DETACH_VOLUME"
 	case ERROR_INIT_FAIL:
 		return "ERROR_INIT_FAIL"
 	case ERROR_QMP_FAIL:
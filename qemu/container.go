@@ -0,0 +1,156 @@
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/types"
+)
+
+// addContainer adds a container to the running pod. Its rootfs is inserted
+// first if it is a block device, and then the init is asked to start it.
+func (ctx *VmContext) addContainer(cmd *NewContainerCommand) {
+	if ctx.newContainer != nil {
+		ctx.replyBadContainer(cmd, "a container is being added to the pod")
+		return
+	}
+	if ctx.Lookup(cmd.Info.Id) >= 0 {
+		ctx.replyBadContainer(cmd, fmt.Sprintf("container %s is in the pod already", cmd.Info.Id))
+		return
+	}
+	for _, v := range cmd.Spec.Volumes {
+		if _, ok := ctx.devices.volumeMap[v.Volume]; !ok {
+			ctx.replyBadContainer(cmd, fmt.Sprintf("volume %s is not in the pod", v.Volume))
+			return
+		}
+	}
+
+	ctx.lock.Lock()
+	idx := len(ctx.vmSpec.Containers)
+	ctx.vmSpec.Containers = append(ctx.vmSpec.Containers, VmContainer{})
+	c := &ctx.vmSpec.Containers[idx]
+	ctx.initContainerInfo(idx, c, cmd.Spec)
+	ctx.setContainerInfo(idx, c, cmd.Info)
+	ctx.lock.Unlock()
+
+	// the volumes of the pod are ready already
+	for _, v := range cmd.Spec.Volumes {
+		vol := ctx.devices.volumeMap[v.Volume]
+		ctx.lock.Lock()
+		vol.pos[idx] = v.Path
+		vol.readOnly[idx] = v.ReadOnly
+		ctx.lock.Unlock()
+		if vol.info.fstype == "" {
+			c.Fsmap = append(c.Fsmap, VmFsmapDescriptor{
+				Source:   vol.info.filename,
+				Path:     v.Path,
+				ReadOnly: v.ReadOnly,
+			})
+		} else {
+			c.Volumes = append(c.Volumes, VmVolumeDescriptor{
+				Device:   vol.info.deviceName,
+				Mount:    v.Path,
+				Fstype:   vol.info.fstype,
+				ReadOnly: v.ReadOnly,
+			})
+		}
+	}
+	ctx.newContainerSessions(idx, c, ctx.userSpec.Tty)
+	ctx.userSpec.Containers = append(ctx.userSpec.Containers, *cmd.Spec)
+
+	ctx.newContainer = cmd
+	ctx.newContainerIdx = idx
+	glog.Infof("add container %s to %s as #%d", cmd.Info.Id, ctx.Id, idx)
+
+	if cmd.Info.Fstype == "dir" {
+		ctx.startNewContainer()
+		return
+	}
+	ctx.DCtx.AddDisk(ctx, cmd.Info.Image, "image", cmd.Info.Image, "raw", ctx.nextScsiId())
+}
+
+// onNewContainerBlockdev starts the container being added once its rootfs
+// is inserted.
+func (ctx *VmContext) onNewContainerBlockdev(info *BlockdevInsertedEvent) {
+	if ctx.newContainer != nil && info.Name == ctx.newContainer.Info.Image {
+		ctx.startNewContainer()
+	}
+}
+
+func (ctx *VmContext) startNewContainer() {
+	c, err := json.Marshal(ctx.vmSpec.Containers[ctx.newContainerIdx])
+	if err != nil {
+		ctx.dropNewContainer()
+		ctx.replyNewContainer(types.E_FAILED, "Generated wrong container spec "+err.Error())
+		return
+	}
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_NEWCONTAINER,
+		message: c,
+	}
+}
+
+// newContainerFailed handles the failure of inserting the rootfs of the
+// container being added, it returns false if the failure is not about it.
+func (ctx *VmContext) newContainerFailed(ev *DeviceFailed) bool {
+	if ctx.newContainer == nil {
+		return false
+	}
+	info, ok := ev.session.(*BlockdevInsertedEvent)
+	if !ok || info.Name != ctx.newContainer.Info.Image {
+		return false
+	}
+	glog.Error("failed to insert the rootfs of container ", ctx.newContainer.Info.Id)
+	ctx.dropNewContainer()
+	ctx.replyNewContainer(types.E_FAILED, "failed to insert the rootfs of the container")
+	return true
+}
+
+// dropNewContainer removes the container being added from the pod
+func (ctx *VmContext) dropNewContainer() {
+	idx := ctx.newContainerIdx
+	c := ctx.vmSpec.Containers[idx]
+	for _, session := range []uint64{c.Tty, c.Stdio, c.Stderr} {
+		if session != 0 {
+			ctx.ptys.Close(ctx, session)
+		}
+	}
+	for _, vol := range ctx.devices.volumeMap {
+		delete(vol.pos, idx)
+		delete(vol.readOnly, idx)
+	}
+	name := ctx.newContainer.Info.Image
+	if image, ok := ctx.devices.imageMap[name]; ok {
+		if image.info.deviceName != "" {
+			ctx.DCtx.RemoveDisk(ctx, image.info.scsiId, &ContainerUnmounted{Index: idx, Success: true})
+		}
+		delete(ctx.devices.imageMap, name)
+		delete(ctx.progress.adding.blockdevs, name)
+		delete(ctx.progress.finished.blockdevs, name)
+	}
+	ctx.vmSpec.Containers = ctx.vmSpec.Containers[:idx]
+	ctx.userSpec.Containers = ctx.userSpec.Containers[:idx]
+}
+
+// replyNewContainer reports the result of NewContainerCommand, with the
+// persist data in which the container is added.
+func (ctx *VmContext) replyNewContainer(code int, cause string) {
+	if ctx.newContainer == nil {
+		return
+	}
+	ctx.newContainer.Callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  code,
+		Cause: cause,
+		Data:  ctx.persistData(),
+	}
+	ctx.newContainer = nil
+}
+
+func (ctx *VmContext) replyBadContainer(cmd *NewContainerCommand, cause string) {
+	cmd.Callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BAD_REQUEST,
+		Cause: cause,
+	}
+}
@@ -0,0 +1,129 @@
+package qemu
+
+import (
+	"encoding/json"
+	"hyper/lib/glog"
+	"hyper/types"
+	"os"
+	"path"
+	"time"
+)
+
+// ContainerLogDir is where the output of the containers is logged
+var ContainerLogDir = "/var/lib/hyper/logs"
+
+// ContainerLogPath returns the log file of the container, each line of it
+// is a types.ContainerLogEntry in json.
+func ContainerLogPath(containerId string) string {
+	return path.Join(ContainerLogDir, containerId, containerId+"-json.log")
+}
+
+// logStream writes a output stream of a container to its log, a entry is
+// written once a line is completed.
+type logStream struct {
+	stream string
+	file   *os.File
+	line   []byte
+}
+
+func openLogStream(containerId, stream string) (*logStream, error) {
+	name := ContainerLogPath(containerId)
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &logStream{stream: stream, file: file}, nil
+}
+
+func (ls *logStream) Write(p []byte) (int, error) {
+	for _, b := range p {
+		ls.line = append(ls.line, b)
+		if b == '\n' {
+			if err := ls.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (ls *logStream) flush() error {
+	if len(ls.line) == 0 {
+		return nil
+	}
+	entry, err := json.Marshal(&types.ContainerLogEntry{
+		Log:    string(ls.line),
+		Stream: ls.stream,
+		Time:   time.Now().UTC(),
+	})
+	ls.line = ls.line[:0]
+	if err != nil {
+		return err
+	}
+	// write the entry at once, the other stream appends to the same file
+	_, err = ls.file.Write(append(entry, '\n'))
+	return err
+}
+
+func (ls *logStream) Close() error {
+	ls.flush()
+	return ls.file.Close()
+}
+
+// newContainerSessions allocates the sessions the output of container idx
+// is sent on. The container with tty has one session for input and output,
+// the other has two for stdout and stderr.
+func (ctx *VmContext) newContainerSessions(idx int, c *VmContainer, tty bool) {
+	if tty {
+		c.Tty = ctx.attachId
+		ctx.attachId++
+	} else {
+		c.Stdio = ctx.attachId
+		c.Stderr = ctx.attachId + 1
+		ctx.attachId += 2
+	}
+	ctx.restoreContainerSessions(idx, c)
+}
+
+// restoreContainerSessions sets up the sessions of container idx, and logs
+// the output of them.
+func (ctx *VmContext) restoreContainerSessions(idx int, c *VmContainer) {
+	streams := map[string]uint64{"stdout": c.Tty}
+	if c.Tty == 0 {
+		streams = map[string]uint64{"stdout": c.Stdio, "stderr": c.Stderr}
+	}
+	for stream, session := range streams {
+		if session == 0 {
+			continue
+		}
+		ta := newAttachments(idx, true)
+		ctx.ptys.ttys[session] = ta
+		w, err := openLogStream(c.Id, stream)
+		if err != nil {
+			glog.Errorf("fail to open log of container %s: %s", c.Id, err.Error())
+			continue
+		}
+		ta.attach(&TtyIO{Stdout: w})
+	}
+}
+
+// closeLogs closes the logs of the containers once the VM quits, the init
+// could not close the sessions then.
+func (pts *pseudoTtys) closeLogs() {
+	pts.lock.Lock()
+	defer pts.lock.Unlock()
+	for _, ta := range pts.ttys {
+		at := []*TtyIO{}
+		for _, tty := range ta.attachments {
+			if ls, ok := tty.Stdout.(*logStream); ok {
+				ls.Close()
+			} else {
+				at = append(at, tty)
+			}
+		}
+		ta.attachments = at
+	}
+}
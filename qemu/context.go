@@ -2,12 +2,10 @@ package qemu
 
 import (
 	"encoding/json"
-	"fmt"
 	"hyper/lib/glog"
 	"hyper/pod"
 	"hyper/types"
 	"os"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -36,13 +34,12 @@ type VmContext struct {
 	client chan *types.QemuResponse
 	vm     chan *DecodedMessage
 
-	qmp chan QmpInteraction
-	wdt chan string
+	DCtx DriverContext
 
-	qmpSockName     string
 	hyperSockName   string
 	ttySockName     string
 	consoleSockName string
+	consoleLogName  string
 	shareDir        string
 
 	pciAddr  int    //next available pci addr for pci hotplug
@@ -59,11 +56,41 @@ type VmContext struct {
 
 	progress *processingList
 
+	// uri to receive the incoming migration, until it finished
+	incoming string
+	// the share dir of the VM migrated from is mounted on shareDir
+	shareBound bool
+	// reply of the MigrateCommand in progress, whether its target is on
+	// another host, and whether the VM has been migrated away
+	migrateCallback chan *types.QemuResponse
+	migrateRemote   bool
+	migrated        bool
+
+	// reply of the PauseCommand or UnpauseCommand in progress
+	pauseCallback chan *types.QemuResponse
+
+	// reply of the ResizeCommand in progress
+	resizeCallback chan *types.QemuResponse
+
+	// reply of the CpuAddCommand in progress
+	cpuCallback chan *types.QemuResponse
+
+	// the container being added and its index in vmSpec
+	newContainer    *NewContainerCommand
+	newContainerIdx int
+
+	// the volume being attached or detached
+	volumeOp *volumeOperation
+
+	// the init containers are the first ones of vmSpec, which are run one
+	// by one before the others, and the exit codes of the finished ones
+	initContainers int
+	initResults    []uint32
+
 	// Internal Helper
 	handler stateHandler
 	current string
 	timer   *time.Timer
-	process *os.Process
 	lock    *sync.Mutex //protect update of context
 	wg      *sync.WaitGroup
 	wait    bool
@@ -75,21 +102,26 @@ func initContext(id string, hub chan QemuEvent, client chan *types.QemuResponse,
 
 	var err error = nil
 
-	qmpChannel := make(chan QmpInteraction, 128)
 	vmChannel := make(chan *DecodedMessage, 128)
 	defer func() {
 		if err != nil {
-			close(qmpChannel)
 			close(vmChannel)
 		}
 	}()
 
+	if boot != nil && boot.Snapshot != "" {
+		if _, err = os.Stat(boot.Snapshot); err != nil {
+			glog.Error("cannot find the snapshot ", boot.Snapshot)
+			return nil, err
+		}
+	}
+
 	//dir and sockets:
 	homeDir := BaseDir + "/" + id + "/"
-	qmpSockName := homeDir + QmpSockName
 	hyperSockName := homeDir + HyperSockName
 	ttySockName := homeDir + TtySockName
 	consoleSockName := homeDir + ConsoleSockName
+	consoleLogName := homeDir + ConsoleLogName
 	shareDir := homeDir + ShareDirTag
 
 	err = os.MkdirAll(shareDir, 0755)
@@ -111,18 +143,16 @@ func initContext(id string, hub chan QemuEvent, client chan *types.QemuResponse,
 		attachId:        1,
 		hub:             hub,
 		client:          client,
-		qmp:             qmpChannel,
 		vm:              vmChannel,
-		wdt:             make(chan string, 16),
+		DCtx:            HDriver.InitContext(homeDir),
 		ptys:            newPts(),
 		ttySessions:     make(map[string]uint64),
-		qmpSockName:     qmpSockName,
 		hyperSockName:   hyperSockName,
 		ttySockName:     ttySockName,
 		consoleSockName: consoleSockName,
+		consoleLogName:  consoleLogName,
 		shareDir:        shareDir,
 		timer:           nil,
-		process:         nil,
 		handler:         stateInit,
 		userSpec:        nil,
 		vmSpec:          nil,
@@ -220,12 +250,21 @@ func (ctx *VmContext) Lookup(container string) int {
 }
 
 func (ctx *VmContext) Close() {
+	ctx.replyNewContainer(types.E_FAILED, "the VM is closed")
+	ctx.replyMigrate(types.E_FAILED, "the VM is closed")
+	ctx.replyPause(&types.QemuResponse{Code: types.E_FAILED, Cause: "the VM is closed"})
+	ctx.replyResize(&types.QemuResponse{Code: types.E_FAILED, Cause: "the VM is closed"})
+	ctx.replyCpuAdd(types.E_FAILED, "the VM is closed")
+	ctx.replyVolume(types.E_FAILED, "the VM is closed")
 	ctx.lock.Lock()
 	defer ctx.lock.Unlock()
 	ctx.unsetTimeout()
-	close(ctx.qmp)
+	ctx.DCtx.Close()
+	ctx.ptys.closeLogs()
 	close(ctx.vm)
-	close(ctx.wdt)
+	if ctx.shareBound {
+		unbindShareDir(ctx.shareDir)
+	}
 	os.Remove(ctx.shareDir)
 	ctx.handler = nil
 	ctx.current = "None"
@@ -249,55 +288,6 @@ func (ctx *VmContext) Become(handler stateHandler, desc string) {
 	glog.V(1).Infof("VM %s: state change from %s to '%s'", ctx.Id, orig, desc)
 }
 
-func (ctx *VmContext) QemuArguments() []string {
-	if ctx.Boot == nil {
-		ctx.Boot = &BootConfig{
-			CPU:    1,
-			Memory: 128,
-			Kernel: DefaultKernel,
-			Initrd: DefaultInitrd,
-		}
-	}
-	boot := ctx.Boot
-
-	params := []string{
-		"-machine", "pc-i440fx-2.0,accel=kvm,usb=off", "-global", "kvm-pit.lost_tick_policy=discard", "-cpu", "host"}
-	if _, err := os.Stat("/dev/kvm"); os.IsNotExist(err) {
-		glog.V(1).Info("kvm not exist change to no kvm mode")
-		params = []string{"-machine", "pc-i440fx-2.0,usb=off", "-cpu", "core2duo"}
-	}
-
-	if boot.Bios != "" && boot.Cbfs != "" {
-		params = append(params,
-			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Bios),
-			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Cbfs))
-	} else if boot.Bios != "" {
-		params = append(params,
-			"-bios", boot.Bios,
-			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", "\"console=ttyS0 panic=1\"")
-	} else if boot.Cbfs != "" {
-		params = append(params,
-			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Cbfs))
-	} else {
-		params = append(params,
-			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", "\"console=ttyS0 panic=1\"")
-	}
-
-	return append(params,
-		"-realtime", "mlock=off", "-no-user-config", "-nodefaults", "-no-hpet",
-		"-rtc", "base=utc,driftfix=slew", "-no-reboot", "-display", "none", "-boot", "strict=on",
-		"-m", strconv.Itoa(ctx.Boot.Memory), "-smp", strconv.Itoa(ctx.Boot.CPU),
-		"-qmp", fmt.Sprintf("unix:%s,server,nowait", ctx.qmpSockName), "-serial", fmt.Sprintf("unix:%s,server,nowait", ctx.consoleSockName),
-		"-device", "virtio-serial-pci,id=virtio-serial0,bus=pci.0,addr=0x2", "-device", "virtio-scsi-pci,id=scsi0,bus=pci.0,addr=0x3",
-		"-chardev", fmt.Sprintf("socket,id=charch0,path=%s,server,nowait", ctx.hyperSockName),
-		"-device", "virtserialport,bus=virtio-serial0.0,nr=1,chardev=charch0,id=channel0,name=sh.hyper.channel.0",
-		"-chardev", fmt.Sprintf("socket,id=charch1,path=%s,server,nowait", ctx.ttySockName),
-		"-device", "virtserialport,bus=virtio-serial0.0,nr=2,chardev=charch1,id=channel1,name=sh.hyper.channel.1",
-		"-fsdev", fmt.Sprintf("local,id=virtio9p,path=%s,security_model=none", ctx.shareDir),
-		"-device", fmt.Sprintf("virtio-9p-pci,fsdev=virtio9p,mount_tag=%s", ShareDirTag),
-	)
-}
-
 // InitDeviceContext will init device info in context
 func (ctx *VmContext) InitDeviceContext(spec *pod.UserPod, wg *sync.WaitGroup,
 	cInfo []*ContainerInfo, vInfo []*VolumeInfo) {
@@ -305,7 +295,20 @@ func (ctx *VmContext) InitDeviceContext(spec *pod.UserPod, wg *sync.WaitGroup,
 	ctx.lock.Lock()
 	defer ctx.lock.Unlock()
 
-	for i := 0; i < InterfaceCount; i++ {
+	ctx.initContainers = len(spec.InitContainers)
+	ctx.initResults = nil
+	if ctx.initContainers > 0 {
+		flat := *spec
+		flat.Containers = spec.AllContainers()
+		flat.InitContainers = nil
+		spec = &flat
+	}
+
+	nics := len(spec.Interfaces)
+	if nics == 0 {
+		nics = InterfaceCount
+	}
+	for i := 0; i < nics; i++ {
 		ctx.progress.adding.networks[i] = true
 	}
 
@@ -335,12 +338,7 @@ func (ctx *VmContext) InitDeviceContext(spec *pod.UserPod, wg *sync.WaitGroup,
 
 		ctx.initContainerInfo(i, &containers[i], &container)
 		ctx.setContainerInfo(i, &containers[i], cInfo[i])
-
-		if spec.Tty {
-			containers[i].Tty = ctx.attachId
-			ctx.attachId++
-			ctx.ptys.ttys[containers[i].Tty] = newAttachments(i, true)
-		}
+		ctx.newContainerSessions(i, &containers[i], spec.Tty)
 	}
 
 	ctx.vmSpec = &VmPod{
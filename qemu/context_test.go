@@ -52,7 +52,7 @@ func TestParseSpec(t *testing.T) {
 		t.Error("parse json failed ", err.Error())
 	}
 
-	ctx.InitDeviceContext(&spec, cs, nil)
+	ctx.InitDeviceContext(&spec, nil, cs, nil)
 
 	if ctx.userSpec != &spec {
 		t.Error("user pod assignment fail")
@@ -101,7 +101,7 @@ func TestParseVolumes(t *testing.T) {
 		&ContainerInfo{},
 	}
 
-	ctx.InitDeviceContext(&spec, cs, nil)
+	ctx.InitDeviceContext(&spec, nil, cs, nil)
 
 	res, err := json.MarshalIndent(*ctx.vmSpec, "    ", "    ")
 	if err != nil {
@@ -239,3 +239,21 @@ func testJson(key string) string {
 
 	return jsons[key]
 }
+
+func TestLateContainerUnmounted(t *testing.T) {
+	b := &BootConfig{
+		CPU:    1,
+		Memory: 128,
+		Kernel: "somekernel",
+		Initrd: "someinitrd",
+	}
+
+	ctx, _ := initContext("vmid", nil, nil, b)
+	defer ctx.Close()
+
+	// the dir rootfs is umounted as both overlay and aufs, the late report
+	// may come after the VM is reset
+	if processed, _ := deviceRemoveHandler(ctx, &ContainerUnmounted{Index: 0, Success: true}); processed {
+		t.Error("the late umount of a removed container is processed")
+	}
+}
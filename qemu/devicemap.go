@@ -223,7 +223,16 @@ func (ctx *VmContext) allocateNetworks() {
 	for i, _ := range ctx.progress.adding.networks {
 		name := fmt.Sprintf("eth%d", i)
 		addr := ctx.nextPciAddr()
-		go CreateInterface(i, addr, name, i == 0, maps, ctx.hub)
+		inf := pod.UserInterface{}
+		if i < len(ctx.userSpec.Interfaces) {
+			inf = ctx.userSpec.Interfaces[i]
+		}
+		// the ports are mapped to the first nic only
+		if i == 0 {
+			go CreateInterface(i, addr, name, true, inf.Bridge, inf.Ip, inf.Ip6, inf.Mac, ctx.userSpec.Resource, maps, ctx.hub)
+		} else {
+			go CreateInterface(i, addr, name, false, inf.Bridge, inf.Ip, inf.Ip6, inf.Mac, ctx.userSpec.Resource, nil, ctx.hub)
+		}
 	}
 }
 
@@ -231,10 +240,10 @@ func (ctx *VmContext) addBlockDevices() {
 	for blk, _ := range ctx.progress.adding.blockdevs {
 		if info, ok := ctx.devices.volumeMap[blk]; ok {
 			sid := ctx.nextScsiId()
-			newDiskAddSession(ctx, info.info.name, "volume", info.info.filename, info.info.format, sid)
+			ctx.DCtx.AddDisk(ctx, info.info.name, "volume", info.info.filename, info.info.format, sid)
 		} else if info, ok := ctx.devices.imageMap[blk]; ok {
 			sid := ctx.nextScsiId()
-			newDiskAddSession(ctx, info.info.name, "image", info.info.filename, info.info.format, sid)
+			ctx.DCtx.AddDisk(ctx, info.info.name, "image", info.info.filename, info.info.format, sid)
 		} else {
 			continue
 		}
@@ -292,6 +301,8 @@ func (ctx *VmContext) netdevInserted(info *NetDevInsertedEvent) {
 			infs[i].Device = ctx.devices.networkMap[i].DeviceName
 			infs[i].IpAddress = ctx.devices.networkMap[i].IpAddr
 			infs[i].NetMask = ctx.devices.networkMap[i].NetMask
+			infs[i].Ipv6Address = ctx.devices.networkMap[i].Ip6Addr
+			infs[i].Ipv6PrefixLen = ctx.devices.networkMap[i].Ip6Prefix
 
 			for _, rl := range ctx.devices.networkMap[i].RouteTable {
 				dev := ""
@@ -413,7 +424,7 @@ func (ctx *VmContext) removeVolumeDrive() {
 	for name, vol := range ctx.devices.volumeMap {
 		if vol.info.format == "raw" || vol.info.format == "qcow2" {
 			glog.V(1).Infof("need detach volume %s (%s) ", name, vol.info.deviceName)
-			newDiskDelSession(ctx, vol.info.scsiId, &VolumeUnmounted{Name: name, Success: true})
+			ctx.DCtx.RemoveDisk(ctx, vol.info.scsiId, &VolumeUnmounted{Name: name, Success: true})
 			ctx.progress.deleting.volumes[name] = true
 		}
 	}
@@ -424,7 +435,7 @@ func (ctx *VmContext) removeImageDrive() {
 		if image.info.fstype != "dir" {
 			glog.V(1).Infof("need eject no.%d image block device: %s", image.pos, image.info.deviceName)
 			ctx.progress.deleting.containers[image.pos] = true
-			newDiskDelSession(ctx, image.info.scsiId, &ContainerUnmounted{Index: image.pos, Success: true})
+			ctx.DCtx.RemoveDisk(ctx, image.info.scsiId, &ContainerUnmounted{Index: image.pos, Success: true})
 		}
 	}
 }
@@ -437,12 +448,19 @@ func (ctx *VmContext) releaseNetwork() {
 			maps = append(maps, m)
 		}
 	}
+	if ctx.migrated && !ctx.migrateRemote {
+		// the rules are kept for the VM the pod migrated to
+		networkHandOver(maps)
+		maps = nil
+	} else if ctx.incoming != "" {
+		// the rules are still owned by the VM the pod migrates from
+		maps = nil
+	}
 
 	for idx, nic := range ctx.devices.networkMap {
 		glog.V(1).Infof("remove network card %d: %s", idx, nic.IpAddr)
 		ctx.progress.deleting.networks[idx] = true
-		ReleaseInterface(idx, nic.IpAddr, nic.Fd, maps, ctx.hub)
-		maps = nil
+		ReleaseInterface(idx, nic.Bridge, nic.IpAddr, nic.Ip6Addr, nic.Fd, nicPortMaps(idx, maps), ctx.hub)
 	}
 }
 
@@ -458,8 +476,16 @@ func (ctx *VmContext) removeInterface() {
 	for idx, nic := range ctx.devices.networkMap {
 		glog.V(1).Infof("remove network card %d: %s", idx, nic.IpAddr)
 		ctx.progress.deleting.networks[idx] = true
-		ReleaseInterface(idx, nic.IpAddr, nic.Fd, maps, ctx.hub)
-		newNetworkDelSession(ctx, nic.DeviceName, &NetDevRemovedEvent{Index: idx})
-		maps = nil
+		ReleaseInterface(idx, nic.Bridge, nic.IpAddr, nic.Ip6Addr, nic.Fd, nicPortMaps(idx, maps), ctx.hub)
+		ctx.DCtx.RemoveNic(ctx, nic.DeviceName, &NetDevRemovedEvent{Index: idx})
+	}
+}
+
+// nicPortMaps returns the port mappings of the nic, only the first nic has
+// the ports mapped.
+func nicPortMaps(index int, maps []pod.UserContainerPort) []pod.UserContainerPort {
+	if index != 0 {
+		return nil
 	}
+	return maps
 }
@@ -2,6 +2,7 @@ package qemu
 
 import (
 	"hyper/pod"
+	"hyper/types"
 	"sync"
 	"net"
 	"os"
@@ -42,11 +43,20 @@ type RunPodCommand struct {
 
 type ReplacePodCommand RunPodCommand
 
+// ExecCommand runs a command in the container, on a tty by default. If
+// ErrStreams is set, the command runs without tty: its stderr is sent on
+// the session Stderr, an empty message on the session Sequence closes its
+// stdin, and the init reports its exit code on Sequence after closing it.
 type ExecCommand struct {
-	Container string   `json:"container,omitempty"`
-	Sequence  uint64   `json:"seq"`
-	Command   []string `json:"cmd"`
-	Streams   *TtyIO   `json:"-"`
+	Container  string             `json:"container,omitempty"`
+	Sequence   uint64             `json:"seq"`
+	Stderr     uint64             `json:"stderr,omitempty"`
+	Command    []string           `json:"cmd"`
+	Envs       []VmEnvironmentVar `json:"envs,omitempty"`
+	Workdir    string             `json:"workdir,omitempty"`
+	User       string             `json:"user,omitempty"`
+	Streams    *TtyIO             `json:"-"`
+	ErrStreams *TtyIO             `json:"-"`
 }
 
 type StopPodCommand struct{}
@@ -57,6 +67,112 @@ type ShutdownCommand struct{
 
 type ReleaseVMCommand struct{}
 
+// QueryCommand asks the hypervisor for the status of the VM, the result
+// is sent to Callback instead of the client chan
+type QueryCommand struct {
+	Item     string
+	Callback chan *types.QemuResponse
+}
+
+// SnapshotCommand saves the memory of an idle VM to Path, which could be
+// restored by BootConfig.Snapshot. The result is sent to Callback.
+type SnapshotCommand struct {
+	Path     string
+	Callback chan *types.QemuResponse
+}
+
+// MigrateCommand moves the running VM to the VM waiting for the incoming
+// migration on Uri, such as "unix:/path" or "tcp:host:port", the result is
+// sent to Callback. Remote is set if the target VM is on another host, the
+// port maps are released here rather than handed over to it.
+type MigrateCommand struct {
+	Uri      string
+	Remote   bool
+	Callback chan *types.QemuResponse
+}
+
+// PauseCommand freezes the VM of a running pod, the result is sent to
+// Callback
+type PauseCommand struct {
+	Callback chan *types.QemuResponse
+}
+
+// UnpauseCommand resumes the VM frozen by PauseCommand, the result is sent
+// to Callback
+type UnpauseCommand struct {
+	Callback chan *types.QemuResponse
+}
+
+// PauseDoneEvent is sent once the VM is paused or resumed
+type PauseDoneEvent struct {
+	Paused bool
+	Reply  *types.QemuResponse
+}
+
+// ResizeCommand sets the memory of the VM to Memory MB with the balloon,
+// the actual size in MB is sent to Callback as Data
+type ResizeCommand struct {
+	Memory   int
+	Callback chan *types.QemuResponse
+}
+
+// ResizedEvent is sent once the balloon is set
+type ResizedEvent struct {
+	Reply *types.QemuResponse
+}
+
+// CpuAddCommand hotplugs vcpus until the VM has Cpu of them, the result
+// is sent to Callback once the init has brought them online
+type CpuAddCommand struct {
+	Cpu      int
+	Callback chan *types.QemuResponse
+}
+
+// CpuAddedEvent is sent once the vcpus are plugged
+type CpuAddedEvent struct {
+	Cpu   int
+	Reply *types.QemuResponse
+}
+
+// NewContainerCommand adds a container to the running pod, the result is
+// sent to Callback once the init has started it
+type NewContainerCommand struct {
+	Spec     *pod.UserContainer
+	Info     *ContainerInfo
+	Callback chan *types.QemuResponse
+}
+
+// VolumeAttachCommand mounts a volume to Path of the containers, or all the
+// containers of the pod if none is given. Volume and Info are set if the
+// volume is not in the pod yet, it is inserted first.
+type VolumeAttachCommand struct {
+	Name       string
+	Volume     *pod.UserVolume
+	Info       *VolumeInfo
+	Containers []string
+	Path       string
+	ReadOnly   bool
+	Callback   chan *types.QemuResponse
+}
+
+// VolumeDetachCommand unmounts a volume from the containers, or all the
+// containers using it if none is given. The volume is removed from the pod
+// once no container uses it.
+type VolumeDetachCommand struct {
+	Name       string
+	Containers []string
+	Callback   chan *types.QemuResponse
+}
+
+// IncomingReadyEvent is sent once the VM is ready to accept the incoming
+// migration
+type IncomingReadyEvent struct{}
+
+type MigrationDoneEvent struct {
+	Success bool
+	Cause   string
+}
+
 type AttachCommand struct {
 	Container string
 	Streams   *TtyIO
@@ -144,9 +260,12 @@ type InterfaceCreated struct {
 	PCIAddr    int
 	Fd         *os.File
 	DeviceName string
+	Bridge     string
 	MacAddr    string
 	IpAddr     string
 	NetMask    string
+	Ip6Addr    string
+	Ip6Prefix  int
 	RouteTable []*RouteRule
 }
 
@@ -203,6 +322,21 @@ func (qe *WindowSizeCommand) Event() int     { return COMMAND_WINDOWSIZE }
 func (qe *ShutdownCommand) Event() int       { return COMMAND_SHUTDOWN }
 func (qe *ReleaseVMCommand) Event() int      { return COMMAND_RELEASE }
 func (qe *CommandAck) Event() int            { return COMMAND_ACK }
+func (qe *QueryCommand) Event() int          { return COMMAND_QUERY }
+func (qe *SnapshotCommand) Event() int       { return COMMAND_SNAPSHOT }
+func (qe *MigrateCommand) Event() int        { return COMMAND_MIGRATE }
+func (qe *IncomingReadyEvent) Event() int    { return EVENT_INCOMING_READY }
+func (qe *MigrationDoneEvent) Event() int    { return EVENT_MIGRATION_DONE }
+func (qe *PauseCommand) Event() int          { return COMMAND_PAUSE }
+func (qe *UnpauseCommand) Event() int        { return COMMAND_UNPAUSE }
+func (qe *PauseDoneEvent) Event() int        { return EVENT_PAUSE_DONE }
+func (qe *ResizeCommand) Event() int         { return COMMAND_RESIZE }
+func (qe *ResizedEvent) Event() int          { return EVENT_RESIZED }
+func (qe *CpuAddCommand) Event() int         { return COMMAND_ADD_CPU }
+func (qe *CpuAddedEvent) Event() int         { return EVENT_CPU_ADDED }
+func (qe *NewContainerCommand) Event() int   { return COMMAND_NEW_CONTAINER }
+func (qe *VolumeAttachCommand) Event() int   { return COMMAND_ATTACH_VOLUME }
+func (qe *VolumeDetachCommand) Event() int   { return COMMAND_DETACH_VOLUME }
 func (qe *InitFailedEvent) Event() int       { return ERROR_INIT_FAIL }
 func (qe *DeviceFailed) Event() int          { return ERROR_QMP_FAIL }
 func (qe *Interrupted) Event() int           { return ERROR_INTERRUPTED }
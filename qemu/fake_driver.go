@@ -0,0 +1,541 @@
+// +build fakevm
+
+package qemu
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/network"
+	"hyper/pod"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeDriver is a hypervisor driver without any VM behind. It serves the
+// QMP, hyper and tty sockets in process and plays the init protocol, so
+// the VM lifecycle could run without qemu or kvm. It is only built with
+// the fakevm tag, `go test -tags fakevm`, select it with `Hypervisor=fake`
+// in the config file of a hyperd built with the tag.
+//
+// The fields are used to inject failures.
+type FakeDriver struct {
+	// LaunchFail makes the VM exit at once, like qemu fails to start
+	LaunchFail bool
+	// QmpTimeout makes the QMP server never send the greeting
+	QmpTimeout bool
+	// QmpErrors are the QMP commands answered with an error
+	QmpErrors map[string]bool
+	// InitTimeout makes the init never report INIT_READY
+	InitTimeout bool
+	// InitCrash is the init command on which the guest crashes
+	InitCrash uint32
+	// InitErrors are the init commands answered with INIT_ERROR
+	InitErrors map[uint32]bool
+	// ExitCodes, if set, is reported by INIT_FINISHPOD once the pod started,
+	// each start of the pod takes the codes of its containers in order, like
+	// the init containers run one by one, the pod keeps running if there is
+	// no code left for it
+	ExitCodes []uint32
+	// ContainerOutput makes the containers write a line to each of their
+	// output sessions once started
+	ContainerOutput bool
+	// ExecExitCode is reported for the commands run without tty
+	ExecExitCode uint32
+}
+
+type FakeContext struct {
+	*QemuContext
+	vm *fakeVm
+}
+
+type fakeVm struct {
+	driver    *FakeDriver
+	homeDir   string
+	ctx       *VmContext
+	listeners []*net.UnixListener
+	conns     []*net.UnixConn
+	qmp       *net.UnixConn
+	tty       *net.UnixConn
+	ready     bool
+	balloon   int64
+	stopped   bool
+	exited    int
+	lock      *sync.Mutex
+}
+
+var (
+	fakeVms     = make(map[string]*fakeVm)
+	fakeVmsLock = &sync.Mutex{}
+)
+
+const fakeQmpBanner = `{"QMP": {"version": {"qemu": {"micro": 0, "minor": 0, "major": 2}, "package": "fake"}, "capabilities": []}}`
+
+func init() {
+	RegisterDriver(&FakeDriver{})
+}
+
+// FakeNetwork replaces the host side network operations, which need the
+// bridge, with the ones allocating a fixed address. It returns the func
+// restoring them.
+func FakeNetwork() func() {
+	allocate, release, takeOver, handOver := networkAllocate, networkRelease, networkTakeOver, networkHandOver
+	networkAllocate = func(bridge, ip, ip6 string, ingress, egress int, maps []pod.UserContainerPort) (*network.Settings, error) {
+		file, err := os.Open(os.DevNull)
+		if err != nil {
+			return nil, err
+		}
+		settings := &network.Settings{
+			Mac:         "52:54:00:12:34:56",
+			IPAddress:   "192.168.123.2",
+			IPPrefixLen: 24,
+			Gateway:     "192.168.123.1",
+			Bridge:      "fake0",
+			Device:      "tap0",
+			File:        file,
+		}
+		if bridge != "" {
+			settings.Bridge = bridge
+		}
+		if ip != "" {
+			settings.IPAddress = ip
+		}
+		if ip6 != "" {
+			settings.IPv6Address = ip6
+			settings.IPv6PrefixLen = 64
+			settings.IPv6Gateway = "fd00::1"
+		}
+		return settings, nil
+	}
+	networkRelease = func(bridge, ip, ip6 string, maps []pod.UserContainerPort, file *os.File) error {
+		file.Close()
+		return nil
+	}
+	networkTakeOver = func(ip, ip6 string, maps []pod.UserContainerPort) {}
+	networkHandOver = func(maps []pod.UserContainerPort) {}
+	return func() {
+		networkAllocate, networkRelease = allocate, release
+		networkTakeOver, networkHandOver = takeOver, handOver
+	}
+}
+
+func (fd *FakeDriver) Name() string {
+	return "fake"
+}
+
+func (fd *FakeDriver) InitContext(homeDir string) DriverContext {
+	return &FakeContext{
+		QemuContext: (&QemuDriver{}).InitContext(homeDir).(*QemuContext),
+		vm: &fakeVm{
+			driver:  fd,
+			homeDir: homeDir,
+			lock:    &sync.Mutex{},
+		},
+	}
+}
+
+func (fd *FakeDriver) LoadContext(homeDir string, persisted map[string]interface{}) (DriverContext, error) {
+	if t, ok := persisted["hypervisor"]; !ok || t != fd.Name() {
+		return nil, errors.New("wrong driver type in persist info")
+	}
+
+	fakeVmsLock.Lock()
+	vm, ok := fakeVms[homeDir]
+	fakeVmsLock.Unlock()
+	if !ok {
+		return nil, errors.New("fake vm is not running")
+	}
+
+	fc := fd.InitContext(homeDir).(*FakeContext)
+	fc.vm = vm
+	return fc, nil
+}
+
+func (fc *FakeContext) Launch(ctx *VmContext) {
+	if fc.vm.driver.LaunchFail {
+		ctx.hub <- &QemuExitEvent{message: "fake vm fails to start"}
+		return
+	}
+	if err := fc.vm.start(ctx, fc.qmpSockName); err != nil {
+		glog.Error("fail to start fake vm: ", err.Error())
+		ctx.hub <- &QemuExitEvent{message: "fail to start fake vm " + err.Error()}
+		return
+	}
+	go qmpHandler(ctx)
+	go waitConsoleOutput(ctx)
+}
+
+func (fc *FakeContext) Associate(ctx *VmContext) {
+	fc.vm.lock.Lock()
+	fc.vm.ctx = ctx
+	fc.vm.lock.Unlock()
+	go qmpHandler(ctx)
+}
+
+func (fc *FakeContext) Dump() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"hypervisor": "fake",
+	}, nil
+}
+
+func (fc *FakeContext) Kill(ctx *VmContext) {
+	fc.vm.stop()
+	ctx.hub <- &QemuKilledEvent{success: true}
+}
+
+func (fc *FakeContext) Close() {
+	fc.vm.stop()
+	fc.QemuContext.Close()
+}
+
+func (vm *fakeVm) start(ctx *VmContext, qmpSockName string) error {
+	vm.ctx = ctx
+	// the init restored from snapshot or migrated in does not report
+	// ready again
+	vm.ready = ctx.Boot != nil && (ctx.Boot.Snapshot != "" || ctx.Boot.Incoming)
+
+	socks := []string{qmpSockName, ctx.hyperSockName, ctx.ttySockName, ctx.consoleSockName}
+	serves := []func(*net.UnixConn){vm.serveQmp, vm.serveInit, vm.serveTty, vm.serveConsole}
+	for i, name := range socks {
+		if err := vm.listen(name, serves[i]); err != nil {
+			vm.stop()
+			return err
+		}
+	}
+
+	fakeVmsLock.Lock()
+	fakeVms[vm.homeDir] = vm
+	fakeVmsLock.Unlock()
+	return nil
+}
+
+func (vm *fakeVm) listen(name string, serve func(*net.UnixConn)) error {
+	os.Remove(name)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	vm.lock.Lock()
+	vm.listeners = append(vm.listeners, l)
+	vm.lock.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				glog.V(1).Infof("fake vm stop listening %s", name)
+				return
+			}
+			vm.lock.Lock()
+			if vm.stopped {
+				vm.lock.Unlock()
+				conn.Close()
+				return
+			}
+			vm.conns = append(vm.conns, conn)
+			vm.lock.Unlock()
+			go serve(conn)
+		}
+	}()
+	return nil
+}
+
+// stop closes all the sockets as if the VM has gone
+func (vm *fakeVm) stop() {
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+	if vm.stopped {
+		return
+	}
+	vm.stopped = true
+	for _, l := range vm.listeners {
+		l.Close()
+	}
+	for _, c := range vm.conns {
+		c.Close()
+	}
+
+	fakeVmsLock.Lock()
+	if fakeVms[vm.homeDir] == vm {
+		delete(fakeVms, vm.homeDir)
+	}
+	fakeVmsLock.Unlock()
+}
+
+// crash emulates a guest kernel panic, qemu reports shutdown and quits
+func (vm *fakeVm) crash() {
+	glog.Info("fake vm crashed")
+	vm.shutdownEvent()
+	vm.stop()
+}
+
+func (vm *fakeVm) shutdownEvent() {
+	vm.write(vm.qmp, []byte(`{"event": "SHUTDOWN", "timestamp": {"seconds": 0, "microseconds": 0}}`))
+}
+
+func (vm *fakeVm) write(conn *net.UnixConn, data []byte) {
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+	if conn == nil || vm.stopped {
+		return
+	}
+	if _, err := conn.Write(data); err != nil {
+		glog.V(1).Info("fake vm write failed: ", err.Error())
+	}
+}
+
+func (vm *fakeVm) serveQmp(conn *net.UnixConn) {
+	vm.lock.Lock()
+	vm.qmp = conn
+	vm.lock.Unlock()
+
+	if vm.driver.QmpTimeout {
+		glog.Info("fake qmp does not greet")
+		return
+	}
+
+	vm.write(conn, []byte(fakeQmpBanner))
+	decoder := json.NewDecoder(conn)
+	for {
+		cmd := &QmpCommand{}
+		if err := decoder.Decode(cmd); err != nil {
+			glog.V(1).Info("fake qmp connection closed: ", err.Error())
+			return
+		}
+		glog.V(1).Info("fake qmp got command ", cmd.Execute)
+
+		if vm.driver.QmpErrors[cmd.Execute] {
+			vm.write(conn, []byte(`{"error": {"class": "GenericError", "desc": "injected error"}}`))
+			continue
+		}
+
+		switch cmd.Execute {
+		case "quit":
+			vm.write(conn, []byte(`{"return": {}}`))
+			vm.shutdownEvent()
+			vm.stop()
+			return
+		case "query-status":
+			vm.write(conn, []byte(`{"return": {"status": "running", "singlestep": false, "running": true}}`))
+		case "migrate":
+			uri, _ := cmd.Arguments["uri"].(string)
+			if err := vm.migrate(uri); err != nil {
+				vm.write(conn, []byte(`{"error": {"class": "GenericError", "desc": "`+err.Error()+`"}}`))
+				continue
+			}
+			vm.write(conn, []byte(`{"return": {}}`))
+		case "balloon":
+			value, _ := cmd.Arguments["value"].(float64)
+			vm.lock.Lock()
+			vm.balloon = int64(value)
+			vm.lock.Unlock()
+			vm.write(conn, []byte(`{"return": {}}`))
+		case "query-balloon":
+			vm.lock.Lock()
+			actual := vm.balloon
+			if actual == 0 && vm.ctx.Boot != nil {
+				actual = int64(vm.ctx.Boot.Memory) << 20
+			}
+			vm.lock.Unlock()
+			vm.write(conn, []byte(fmt.Sprintf(`{"return": {"actual": %d}}`, actual)))
+		case "query-migrate":
+			vm.write(conn, []byte(`{"return": {"status": "completed"}}`))
+		case "migrate-incoming":
+			// there is no source to wait for, resume once the caller
+			// has got the reply
+			vm.write(conn, []byte(`{"return": {}}`))
+			time.AfterFunc(100*time.Millisecond, func() {
+				vm.write(conn, []byte(`{"event": "RESUME", "timestamp": {"seconds": 0, "microseconds": 0}}`))
+			})
+		default:
+			vm.write(conn, []byte(`{"return": {}}`))
+		}
+	}
+}
+
+// migrate writes a fake memory image if migrating to a file, the other
+// migrations always succeed
+func (vm *fakeVm) migrate(uri string) error {
+	if strings.HasPrefix(uri, "unix:") || strings.HasPrefix(uri, "tcp:") {
+		return nil
+	}
+	if !strings.HasPrefix(uri, "file:") {
+		return errors.New("unsupported migration uri")
+	}
+	f, err := os.Create(strings.TrimPrefix(uri, "file:"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString("fake snapshot\n")
+	return err
+}
+
+func (vm *fakeVm) serveInit(conn *net.UnixConn) {
+	vm.lock.Lock()
+	greet := !vm.ready
+	vm.ready = true
+	vm.lock.Unlock()
+
+	if greet {
+		if vm.driver.InitTimeout {
+			glog.Info("fake init does not report ready")
+			return
+		}
+		vm.write(conn, newVmMessage(&DecodedMessage{code: INIT_READY, message: []byte{}}))
+	}
+
+	for {
+		msg, err := readVmMessage(conn)
+		if err != nil {
+			glog.V(1).Info("fake init connection closed: ", err.Error())
+			return
+		}
+		glog.V(1).Infof("fake init got command %d", msg.code)
+
+		if vm.driver.InitCrash != 0 && msg.code == vm.driver.InitCrash {
+			vm.crash()
+			return
+		}
+
+		if vm.driver.InitErrors[msg.code] {
+			vm.write(conn, newVmMessage(&DecodedMessage{code: INIT_ERROR, message: []byte("injected error")}))
+			continue
+		}
+
+		vm.write(conn, newVmMessage(&DecodedMessage{code: INIT_ACK, message: []byte{}}))
+
+		switch msg.code {
+		case INIT_DESTROYPOD:
+			// init powers off the guest after destroying the pod
+			vm.shutdownEvent()
+			vm.stop()
+			return
+		case INIT_STARTPOD:
+			if vm.driver.ContainerOutput {
+				vm.containerOutput(msg.message)
+			}
+			if codes := vm.exitCodes(msg.message); len(codes) > 0 {
+				vm.write(conn, newVmMessage(&DecodedMessage{code: INIT_FINISHPOD, message: codes}))
+			}
+		case INIT_NEWCONTAINER:
+			if vm.driver.ContainerOutput {
+				c := &VmContainer{}
+				if err := json.Unmarshal(msg.message, c); err != nil {
+					glog.Error("fake init got bad container ", string(msg.message))
+					continue
+				}
+				vm.containerStarted(c)
+			}
+		case INIT_EXECCMD:
+			cmd := &ExecCommand{}
+			if err := json.Unmarshal(msg.message, cmd); err != nil {
+				glog.Error("fake init got bad exec command ", string(msg.message))
+				continue
+			}
+			// echo the command line, then close the session
+			vm.ttyOutput(cmd.Sequence, []byte(strings.Join(cmd.Command, " ")+"\n"))
+			if cmd.Stderr != 0 {
+				// report how the command is run on stderr
+				envs := []string{}
+				for _, e := range cmd.Envs {
+					envs = append(envs, e.Env+"="+e.Value)
+				}
+				vm.ttyOutput(cmd.Stderr, []byte(fmt.Sprintf("workdir=%s user=%s envs=%s\n",
+					cmd.Workdir, cmd.User, strings.Join(envs, ","))))
+				vm.ttyOutput(cmd.Stderr, []byte{})
+			}
+			vm.ttyOutput(cmd.Sequence, []byte{})
+			if cmd.Stderr != 0 {
+				code := make([]byte, 4)
+				binary.BigEndian.PutUint32(code, vm.driver.ExecExitCode)
+				vm.ttyOutput(cmd.Sequence, code)
+			}
+		}
+	}
+}
+
+func (vm *fakeVm) containerOutput(spec []byte) {
+	pod := &VmPod{}
+	if err := json.Unmarshal(spec, pod); err != nil {
+		glog.Error("fake init got bad pod ", string(spec))
+		return
+	}
+	for i := range pod.Containers {
+		vm.containerStarted(&pod.Containers[i])
+	}
+}
+
+func (vm *fakeVm) containerStarted(c *VmContainer) {
+	if c.Tty != 0 {
+		vm.ttyOutput(c.Tty, []byte(c.Id+" started\r\n"))
+	}
+	if c.Stdio != 0 {
+		vm.ttyOutput(c.Stdio, []byte(c.Id+" started\n"))
+	}
+	if c.Stderr != 0 {
+		vm.ttyOutput(c.Stderr, []byte(c.Id+" warning\n"))
+	}
+}
+
+func (vm *fakeVm) ttyOutput(session uint64, data []byte) {
+	vm.lock.Lock()
+	tty := vm.tty
+	vm.lock.Unlock()
+	msg := &ttyMessage{session: session, message: data}
+	vm.write(tty, msg.toBuffer())
+}
+
+// fakeBootLog is written to the console once connected
+var fakeBootLog = []string{
+	"fake kernel booting",
+	"fake init started",
+}
+
+func (vm *fakeVm) serveConsole(conn *net.UnixConn) {
+	for _, line := range fakeBootLog {
+		vm.write(conn, []byte(line+"\r\n"))
+	}
+}
+
+// serveTty echoes whatever written to the ttys
+func (vm *fakeVm) serveTty(conn *net.UnixConn) {
+	vm.lock.Lock()
+	vm.tty = conn
+	vm.lock.Unlock()
+
+	for {
+		msg, err := readTtyMessage(conn)
+		if err != nil {
+			glog.V(1).Info("fake tty connection closed: ", err.Error())
+			return
+		}
+		if len(msg.message) > 0 {
+			vm.write(conn, msg.toBuffer())
+		}
+	}
+}
+
+// exitCodes returns the next ExitCodes of the containers of the pod started
+func (vm *fakeVm) exitCodes(spec []byte) []byte {
+	pod := &VmPod{}
+	if err := json.Unmarshal(spec, pod); err != nil {
+		glog.Error("fake init got bad pod ", string(spec))
+	}
+	codes := vm.driver.ExitCodes[vm.exited:]
+	if len(codes) > len(pod.Containers) {
+		codes = codes[:len(pod.Containers)]
+	}
+	vm.exited += len(codes)
+
+	res := make([]byte, 4*len(codes))
+	for i, code := range codes {
+		binary.BigEndian.PutUint32(res[i*4:], code)
+	}
+	return res
+}
@@ -0,0 +1,1302 @@
+// +build fakevm
+
+package qemu
+
+import (
+	"bufio"
+	"encoding/json"
+	"hyper/pod"
+	"hyper/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startFakeVm(t *testing.T, id string, driver *FakeDriver) (chan QemuEvent, chan *types.QemuResponse, func()) {
+	orig := HDriver
+	HDriver = driver
+	restoreNetwork := FakeNetwork()
+	restoreLogDir := fakeLogDir(t)
+
+	hub := make(chan QemuEvent, 128)
+	client := make(chan *types.QemuResponse, 128)
+	go QemuLoop(id, hub, client, &BootConfig{CPU: 1, Memory: 128})
+
+	return hub, client, func() {
+		HDriver = orig
+		restoreNetwork()
+		restoreLogDir()
+	}
+}
+
+func fakeLogDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "hyper-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := ContainerLogDir
+	ContainerLogDir = dir
+	return func() {
+		ContainerLogDir = orig
+		os.RemoveAll(dir)
+	}
+}
+
+func waitResponse(t *testing.T, client chan *types.QemuResponse, code int, seconds int) *types.QemuResponse {
+	timeout := time.After(time.Duration(seconds) * time.Second)
+	for {
+		select {
+		case rsp := <-client:
+			t.Logf("got response %d: %s", rsp.Code, rsp.Cause)
+			if rsp.Code == code {
+				return rsp
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for response %d", code)
+			return nil
+		}
+	}
+}
+
+func fakePodCommand(tty bool) *RunPodCommand {
+	return &RunPodCommand{
+		Spec: &pod.UserPod{
+			Name: "fakepod",
+			Containers: []pod.UserContainer{
+				pod.UserContainer{Name: "c1", Image: "busybox", Command: []string{"sh"}},
+			},
+			Tty: tty,
+		},
+		Containers: []*ContainerInfo{
+			&ContainerInfo{Id: "c1id", Rootfs: "rootfs", Image: "c1id/rootfs", Fstype: "dir"},
+		},
+	}
+}
+
+func TestFakePodLifecycle(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-lifecycle", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	query := make(chan *types.QemuResponse, 1)
+	hub <- &QueryCommand{Item: "status", Callback: query}
+	select {
+	case rsp := <-query:
+		status, ok := rsp.Data.(map[string]interface{})
+		if rsp.Code != types.E_OK || !ok || status["status"] != "running" {
+			t.Error("query status failed ", rsp.Cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query status timeout")
+	}
+
+	hub <- &StopPodCommand{}
+	waitResponse(t, client, types.E_POD_STOPPED, 10)
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodInterfaces(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-nics", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	cmd := fakePodCommand(false)
+	cmd.Spec.Interfaces = []pod.UserInterface{
+		pod.UserInterface{},
+		pod.UserInterface{Bridge: "br1", Ip: "10.0.0.5", Mac: "52:54:00:12:34:56"},
+	}
+	hub <- cmd
+	rsp := waitResponse(t, client, types.E_OK, 10)
+
+	pinfo, err := vmDeserialize(rsp.Data.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infs := pinfo.VmSpec.Interfaces
+	if len(infs) != 2 || infs[0].Device != "eth0" || infs[1].Device != "eth1" || infs[1].IpAddress != "10.0.0.5" {
+		t.Fatalf("bad interfaces %v", infs)
+	}
+	// only the first nic has the default route
+	if len(pinfo.VmSpec.Routes) != 1 || pinfo.VmSpec.Routes[0].Device != "eth0" {
+		t.Errorf("bad routes %v", pinfo.VmSpec.Routes)
+	}
+	for _, nic := range pinfo.NetworkList {
+		if nic.Index == 1 && (nic.Bridge != "br1" || nic.MacAddr != "52:54:00:12:34:56") {
+			t.Errorf("bridge or mac of nic 1 is not persisted: %v", nic)
+		}
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodIPv6(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-ipv6", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	cmd := fakePodCommand(false)
+	cmd.Spec.Interfaces = []pod.UserInterface{
+		pod.UserInterface{Ip6: "fd00::5"},
+	}
+	hub <- cmd
+	rsp := waitResponse(t, client, types.E_OK, 10)
+
+	pinfo, err := vmDeserialize(rsp.Data.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infs := pinfo.VmSpec.Interfaces
+	if len(infs) != 1 || infs[0].IpAddress == "" || infs[0].Ipv6Address != "fd00::5" || infs[0].Ipv6PrefixLen != 64 {
+		t.Fatalf("bad interfaces %v", infs)
+	}
+	// the default nic has both the IPv4 and the IPv6 default routes
+	routes := pinfo.VmSpec.Routes
+	if len(routes) != 2 || routes[1].Dest != "::/0" || routes[1].Gateway != "fd00::1" || routes[1].Device != "eth0" {
+		t.Errorf("bad routes %v", routes)
+	}
+	if len(pinfo.NetworkList) != 1 || pinfo.NetworkList[0].Ip6Addr != "fd00::5" {
+		t.Errorf("IPv6 address is not persisted: %v", pinfo.NetworkList)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestRestoreInterfaces(t *testing.T) {
+	restore := networkRestore
+	defer func() { networkRestore = restore }()
+	restored := map[string][]pod.UserContainerPort{}
+	networkRestore = func(bridge, ip, ip6 string, maps []pod.UserContainerPort) error {
+		restored[bridge+"/"+ip+"/"+ip6] = maps
+		return nil
+	}
+
+	pinfo := &PersistInfo{
+		Id: "fakevm-restore",
+		UserSpec: &pod.UserPod{
+			Containers: []pod.UserContainer{
+				pod.UserContainer{Ports: []pod.UserContainerPort{{HostPort: 8080, ContainerPort: 80}}},
+			},
+		},
+		NetworkList: []*PersistNetworkInfo{
+			&PersistNetworkInfo{Index: 0, Bridge: "hyper0", IpAddr: "192.168.123.2", Ip6Addr: "fd00::2"},
+			&PersistNetworkInfo{Index: 1, Bridge: "br1", IpAddr: "10.0.0.5"},
+		},
+	}
+	data, err := pinfo.serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreInterfaces(data); err != nil {
+		t.Fatal(err)
+	}
+	// the ports are mapped to the first nic only
+	if maps, ok := restored["hyper0/192.168.123.2/fd00::2"]; !ok || len(maps) != 1 || maps[0].HostPort != 8080 {
+		t.Errorf("the first nic is not restored with the port maps: %v", restored)
+	}
+	if maps, ok := restored["br1/10.0.0.5/"]; !ok || len(maps) != 0 {
+		t.Errorf("the second nic is not restored: %v", restored)
+	}
+}
+
+func TestFakePodExec(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-exec", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	r, w := io.Pipe()
+	finish := make(chan *types.QemuResponse, 1)
+	hub <- &ExecCommand{
+		Container: "c1id",
+		Command:   []string{"echo", "hello"},
+		Streams:   &TtyIO{Stdout: w, ClientTag: "exec", Callback: finish},
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil || line != "echo hello\n" {
+		t.Errorf("exec output mismatch: %q", line)
+	}
+
+	select {
+	case rsp := <-finish:
+		if rsp.Code != types.E_EXEC_FINISH {
+			t.Error("exec should be finished, but got ", rsp.Code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exec finish timeout")
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodExecStatus(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-exec-status", &FakeDriver{ExecExitCode: 3})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	ir, iw := io.Pipe()
+	or, ow := io.Pipe()
+	er, ew := io.Pipe()
+	finish := make(chan *types.QemuResponse, 1)
+	hub <- &ExecCommand{
+		Container:  "c1id",
+		Command:    []string{"ls", "-l"},
+		Envs:       []VmEnvironmentVar{{Env: "FOO", Value: "bar"}},
+		Workdir:    "/tmp",
+		User:       "nobody",
+		Streams:    &TtyIO{Stdin: ir, Stdout: ow, ClientTag: "exec", Callback: finish},
+		ErrStreams: &TtyIO{Stdout: ew},
+	}
+
+	line, err := bufio.NewReader(or).ReadString('\n')
+	if err != nil || line != "ls -l\n" {
+		t.Errorf("exec stdout mismatch: %q", line)
+	}
+	line, err = bufio.NewReader(er).ReadString('\n')
+	if err != nil || line != "workdir=/tmp user=nobody envs=FOO=bar\n" {
+		t.Errorf("exec stderr mismatch: %q", line)
+	}
+	iw.Close()
+
+	select {
+	case rsp := <-finish:
+		if rsp.Code != types.E_EXEC_FINISH {
+			t.Error("exec should be finished, but got ", rsp.Code)
+		}
+		if code, ok := rsp.Data.(int); !ok || code != 3 {
+			t.Errorf("exec should exit with 3, but got %v", rsp.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exec finish timeout")
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func fakeNewContainer(id string, callback chan *types.QemuResponse) *NewContainerCommand {
+	return &NewContainerCommand{
+		Spec: &pod.UserContainer{Name: id, Image: "busybox", Command: []string{"top"}},
+		Info: &ContainerInfo{
+			Id: id, Rootfs: "/rootfs", Image: "/dev/mapper/" + id, Fstype: "ext4",
+		},
+		Callback: callback,
+	}
+}
+
+func TestFakePodAddContainer(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-newcontainer", &FakeDriver{ContainerOutput: true})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	added := make(chan *types.QemuResponse, 1)
+	hub <- fakeNewContainer("c2id", added)
+	select {
+	case rsp := <-added:
+		if rsp.Code != types.E_OK {
+			t.Fatal("add container failed ", rsp.Cause)
+		}
+		pinfo, err := vmDeserialize(rsp.Data.([]byte))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pinfo.VmSpec.Containers) != 2 || len(pinfo.UserSpec.Containers) != 2 {
+			t.Fatal("container is not added to the persist info")
+		}
+		c := pinfo.VmSpec.Containers[1]
+		if c.Id != "c2id" || c.Image == "" || c.Fstype != "ext4" || c.Stdio == 0 {
+			t.Errorf("bad container spec %v", c)
+		}
+		found := false
+		for _, vol := range pinfo.VolumeList {
+			if vol.Name == "/dev/mapper/c2id" && len(vol.Containers) == 1 && vol.Containers[0] == 1 {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("rootfs of the container is not persisted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("add container timeout")
+	}
+
+	// the output of the added container is logged
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(ContainerLogPath("c2id"))
+		if strings.Contains(string(data), "c2id started") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the output of the added container is not logged")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	hub <- fakeNewContainer("c2id", added)
+	if rsp := <-added; rsp.Code != types.E_BAD_REQUEST {
+		t.Error("container should not be added twice, but got ", rsp.Code)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodAddContainerVolume(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-newcontainer-vol", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	// no pod is running yet
+	added := make(chan *types.QemuResponse, 1)
+	hub <- fakeNewContainer("c2id", added)
+	if rsp := <-added; rsp.Code != types.E_BAD_REQUEST {
+		t.Error("container is added to the VM without pod, got ", rsp.Code)
+	}
+
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+	callback := make(chan *types.QemuResponse, 1)
+	hub <- fakeNewVolume("share", "dir", callback)
+	volumeResponse(t, callback, types.E_OK)
+
+	cmd := fakeNewContainer("c2id", added)
+	cmd.Spec.Volumes = []pod.UserVolumeReference{{Volume: "share", Path: "/share", ReadOnly: true}}
+	hub <- cmd
+	rsp := <-added
+	if rsp.Code != types.E_OK {
+		t.Fatal("add container failed ", rsp.Cause)
+	}
+	pinfo, err := vmDeserialize(rsp.Data.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := false
+	for _, vol := range pinfo.VolumeList {
+		if vol.Name != "share" || len(vol.Containers) != 2 {
+			continue
+		}
+		for i, idx := range vol.Containers {
+			if idx == 1 && vol.MontPoints[i] == "/share" {
+				registered = true
+			}
+		}
+	}
+	if !registered {
+		t.Errorf("volume of the added container is not registered: %v", pinfo.VolumeList)
+	}
+
+	// the volume is unmounted from the added container too
+	hub <- &VolumeDetachCommand{Name: "share", Callback: callback}
+	pinfo = volumeResponse(t, callback, types.E_OK)
+	if len(pinfo.VmSpec.Containers[1].Fsmap) != 0 || len(pinfo.VolumeList) != 1 {
+		t.Error("volume is not detached from the added container")
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodAddContainerCrash(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-newcontainer-crash", &FakeDriver{InitCrash: INIT_NEWCONTAINER})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	added := make(chan *types.QemuResponse, 1)
+	hub <- fakeNewContainer("c2id", added)
+	select {
+	case rsp := <-added:
+		if rsp.Code != types.E_FAILED {
+			t.Error("add container should fail, but got ", rsp.Code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("adding container is not answered when the VM exits")
+	}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodAddContainerFail(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-newcontainer-fail", &FakeDriver{
+		InitErrors: map[uint32]bool{INIT_NEWCONTAINER: true},
+	})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	added := make(chan *types.QemuResponse, 1)
+	hub <- fakeNewContainer("c2id", added)
+	select {
+	case rsp := <-added:
+		if rsp.Code != types.E_FAILED {
+			t.Fatal("add container should fail, but got ", rsp.Code)
+		}
+		pinfo, err := vmDeserialize(rsp.Data.([]byte))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pinfo.VmSpec.Containers) != 1 || len(pinfo.VolumeList) != 0 {
+			t.Error("failed container is not dropped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("add container timeout")
+	}
+
+	// the pod is still running
+	query := make(chan *types.QemuResponse, 1)
+	hub <- &QueryCommand{Item: "status", Callback: query}
+	if rsp := <-query; rsp.Code != types.E_OK {
+		t.Error("pod should be running, but got ", rsp.Code)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func fakeNewVolume(name, fstype string, callback chan *types.QemuResponse) *VolumeAttachCommand {
+	info := &VolumeInfo{Name: name, Filepath: "/dev/mapper/" + name, Fstype: fstype, Format: "raw"}
+	if fstype == "dir" {
+		info = &VolumeInfo{Name: name, Filepath: name, Fstype: "dir"}
+	}
+	return &VolumeAttachCommand{
+		Name:     name,
+		Volume:   &pod.UserVolume{Name: name},
+		Info:     info,
+		Path:     "/" + name,
+		Callback: callback,
+	}
+}
+
+func volumeResponse(t *testing.T, callback chan *types.QemuResponse, code int) *PersistInfo {
+	select {
+	case rsp := <-callback:
+		if rsp.Code != code {
+			t.Fatalf("expect %d, but got %d: %s", code, rsp.Code, rsp.Cause)
+		}
+		data, ok := rsp.Data.([]byte)
+		if !ok {
+			return nil
+		}
+		pinfo, err := vmDeserialize(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pinfo
+	case <-time.After(5 * time.Second):
+		t.Fatal("volume operation timeout")
+	}
+	return nil
+}
+
+func TestFakePodVolume(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-volume", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	callback := make(chan *types.QemuResponse, 1)
+	cmd := fakeNewVolume("data", "ext4", callback)
+	cmd.Containers = []string{"c1id"}
+	hub <- cmd
+	pinfo := volumeResponse(t, callback, types.E_OK)
+	c := pinfo.VmSpec.Containers[0]
+	if len(c.Volumes) != 1 || c.Volumes[0].Mount != "/data" || c.Volumes[0].Device == "" {
+		t.Errorf("volume is not mounted to the container: %v", c.Volumes)
+	}
+	if len(pinfo.UserSpec.Volumes) != 1 || len(pinfo.UserSpec.Containers[0].Volumes) != 1 {
+		t.Error("volume is not added to the user spec")
+	}
+	if len(pinfo.VolumeList) != 1 || len(pinfo.VolumeList[0].Containers) != 1 {
+		t.Error("volume is not persisted")
+	}
+
+	hub <- &VolumeAttachCommand{Name: "data", Path: "/data2", Containers: []string{"c1id"}, Callback: callback}
+	volumeResponse(t, callback, types.E_BAD_REQUEST)
+
+	hub <- &VolumeDetachCommand{Name: "data", Callback: callback}
+	pinfo = volumeResponse(t, callback, types.E_OK)
+	if len(pinfo.VmSpec.Containers[0].Volumes) != 0 || len(pinfo.UserSpec.Containers[0].Volumes) != 0 {
+		t.Error("volume is not unmounted from the container")
+	}
+	if len(pinfo.UserSpec.Volumes) != 0 || len(pinfo.VolumeList) != 0 {
+		t.Error("unused volume is not removed from the pod")
+	}
+
+	// a dir volume is mounted to all the containers
+	hub <- fakeNewVolume("share", "dir", callback)
+	pinfo = volumeResponse(t, callback, types.E_OK)
+	c = pinfo.VmSpec.Containers[0]
+	if len(c.Fsmap) != 1 || c.Fsmap[0].Source != "share" || c.Fsmap[0].Path != "/share" {
+		t.Errorf("dir volume is not mounted to the container: %v", c.Fsmap)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodVolumeFail(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-volume-fail", &FakeDriver{
+		InitErrors: map[uint32]bool{INIT_MOUNTVOLUME: true},
+	})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	callback := make(chan *types.QemuResponse, 1)
+	hub <- fakeNewVolume("data", "ext4", callback)
+	pinfo := volumeResponse(t, callback, types.E_FAILED)
+	if len(pinfo.VolumeList) != 0 || len(pinfo.VmSpec.Containers[0].Volumes) != 0 {
+		t.Error("failed volume is not released")
+	}
+
+	// the pod is still running
+	query := make(chan *types.QemuResponse, 1)
+	hub <- &QueryCommand{Item: "status", Callback: query}
+	if rsp := <-query; rsp.Code != types.E_OK {
+		t.Error("pod should be running, but got ", rsp.Code)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodVolumeStopping(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-volume-stop", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	callback := make(chan *types.QemuResponse, 1)
+	hub <- &StopPodCommand{}
+	hub <- fakeNewVolume("data", "ext4", callback)
+	volumeResponse(t, callback, types.E_BAD_REQUEST)
+	waitResponse(t, client, types.E_POD_STOPPED, 10)
+	hub <- &VolumeDetachCommand{Name: "data", Callback: callback}
+	volumeResponse(t, callback, types.E_BAD_REQUEST)
+
+	// the volume being inserted is given up once the VM goes down
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+	hub <- fakeNewVolume("data", "ext4", callback)
+	hub <- &ShutdownCommand{}
+	volumeResponse(t, callback, types.E_FAILED)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodAttach(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-attach", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(true)
+	waitResponse(t, client, types.E_OK, 10)
+
+	ir, iw := io.Pipe()
+	or, ow := io.Pipe()
+	hub <- &AttachCommand{
+		Container: "c1id",
+		Streams:   &TtyIO{Stdin: ir, Stdout: ow, ClientTag: "attach"},
+	}
+
+	iw.Write([]byte("ping\n"))
+	line, err := bufio.NewReader(or).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("attach output mismatch: %q", line)
+	}
+	iw.Close()
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodFinished(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-finish", &FakeDriver{ExitCodes: []uint32{3}})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	rsp := waitResponse(t, client, types.E_POD_FINISHED, 10)
+	if res, ok := rsp.Data.([]uint32); !ok || len(res) != 1 || res[0] != 3 {
+		t.Error("wrong pod exit codes ", rsp.Data)
+	}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func fakeInitPodCommand() *RunPodCommand {
+	cmd := fakePodCommand(false)
+	cmd.Spec.InitContainers = []pod.UserContainer{
+		pod.UserContainer{Name: "i1", Image: "busybox", Command: []string{"true"}},
+		pod.UserContainer{Name: "i2", Image: "busybox", Command: []string{"true"}},
+	}
+	cmd.Containers = []*ContainerInfo{
+		&ContainerInfo{Id: "i1id", Rootfs: "rootfs", Image: "i1id/rootfs", Fstype: "dir"},
+		&ContainerInfo{Id: "i2id", Rootfs: "rootfs", Image: "i2id/rootfs", Fstype: "dir"},
+		cmd.Containers[0],
+	}
+	return cmd
+}
+
+func TestFakeInitContainers(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-initc", &FakeDriver{ExitCodes: []uint32{0, 0, 3}})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakeInitPodCommand()
+	waitResponse(t, client, types.E_OK, 10)
+	rsp := waitResponse(t, client, types.E_POD_FINISHED, 10)
+	if res, ok := rsp.Data.([]uint32); !ok || len(res) != 3 || res[0] != 0 || res[1] != 0 || res[2] != 3 {
+		t.Error("wrong pod exit codes ", rsp.Data)
+	}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeInitContainersVolume(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-initv", &FakeDriver{ExitCodes: []uint32{0, 0}})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakeInitPodCommand()
+	waitResponse(t, client, types.E_OK, 10)
+
+	callback := make(chan *types.QemuResponse, 1)
+	cmd := fakeNewVolume("data", "ext4", callback)
+	cmd.Containers = []string{"i1id"}
+	hub <- cmd
+	volumeResponse(t, callback, types.E_BAD_REQUEST)
+
+	// the volume is mounted to the running container only
+	hub <- fakeNewVolume("data", "ext4", callback)
+	pinfo := volumeResponse(t, callback, types.E_OK)
+	if len(pinfo.VmSpec.Containers[0].Volumes) != 0 || len(pinfo.VmSpec.Containers[2].Volumes) != 1 {
+		t.Error("volume is mounted to the init container")
+	}
+	if len(pinfo.InitResults) != 2 {
+		t.Error("init exit codes are not persisted ", pinfo.InitResults)
+	}
+
+	hub <- &VolumeDetachCommand{Name: "data", Callback: callback}
+	pinfo = volumeResponse(t, callback, types.E_OK)
+	if len(pinfo.VolumeList) != 0 {
+		t.Error("unused volume is not removed from the pod")
+	}
+}
+
+func TestFakeInitContainerFailed(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-initf", &FakeDriver{ExitCodes: []uint32{0, 2, 0}})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakeInitPodCommand()
+	for {
+		rsp := <-client
+		if rsp.Code == types.E_OK {
+			t.Fatal("the pod started after its init container failed")
+		}
+		if rsp.Code != types.E_POD_FINISHED {
+			continue
+		}
+		if res, ok := rsp.Data.([]uint32); !ok || len(res) != 2 || res[0] != 0 || res[1] != 2 {
+			t.Error("wrong init exit codes ", rsp.Data)
+		}
+		break
+	}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeInitCrash(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-crash", &FakeDriver{InitCrash: INIT_STARTPOD})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeInitError(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-initerr",
+		&FakeDriver{InitErrors: map[uint32]bool{INIT_STARTPOD: true}})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_FAILED, 10)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeQmpError(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-qmperr",
+		&FakeDriver{QmpErrors: map[string]bool{"netdev_add": true}})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_FAILED, 15)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeQmpTimeout(t *testing.T) {
+	_, client, restore := startFakeVm(t, "fakevm-qmptimeout", &FakeDriver{QmpTimeout: true})
+	defer restore()
+
+	waitResponse(t, client, types.E_FAILED, 15)
+}
+
+func TestFakeSnapshot(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-snapshot", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+
+	dir, err := ioutil.TempDir("", "hyper-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/vm.img"
+
+	result := make(chan *types.QemuResponse, 1)
+	hub <- &SnapshotCommand{Path: path, Callback: result}
+	select {
+	case rsp := <-result:
+		if rsp.Code != types.E_OK {
+			t.Fatal("snapshot failed ", rsp.Cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("snapshot timeout")
+	}
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+
+	// the restored init never reports ready, the VM should not wait for it
+	hub = make(chan QemuEvent, 128)
+	client = make(chan *types.QemuResponse, 128)
+	go QemuLoop("fakevm-restored", hub, client, &BootConfig{CPU: 1, Memory: 128, Snapshot: path})
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeSnapshotMissing(t *testing.T) {
+	client := make(chan *types.QemuResponse, 128)
+	go QemuLoop("fakevm-nosnapshot", make(chan QemuEvent, 128), client,
+		&BootConfig{CPU: 1, Memory: 128, Snapshot: "/nonexist/vm.img"})
+	waitResponse(t, client, types.E_BAD_REQUEST, 5)
+}
+
+func TestFakeMigration(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-migrate", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	rsp := waitResponse(t, client, types.E_OK, 10)
+	pack, ok := rsp.Data.([]byte)
+	if !ok || len(pack) == 0 {
+		t.Fatal("no persist info of the source VM")
+	}
+
+	thub := make(chan QemuEvent, 128)
+	tclient := make(chan *types.QemuResponse, 128)
+	go QemuIncoming("fakevm-incoming", thub, tclient, nil, &BootConfig{CPU: 1, Memory: 128}, pack, "", "")
+	rsp = waitResponse(t, tclient, types.E_OK, 10)
+	uri, ok := rsp.Data.(string)
+	if !ok || !strings.HasPrefix(uri, "unix:") {
+		t.Fatal("the target VM should listen on a unix socket, but got ", rsp.Data)
+	}
+
+	migrate := make(chan *types.QemuResponse, 1)
+	hub <- &MigrateCommand{Uri: uri, Callback: migrate}
+	waitResponse(t, migrate, types.E_OK, 10)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+
+	rsp = waitResponse(t, tclient, types.E_POD_RUNNING, 5)
+	if pack, ok := rsp.Data.([]byte); !ok || len(pack) == 0 {
+		t.Error("no persist info of the target VM")
+	}
+	thub <- &ShutdownCommand{}
+	waitResponse(t, tclient, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeMigrationStorage(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-migsrc", &FakeDriver{})
+	defer restore()
+	handOver := make(chan []pod.UserContainerPort, 1)
+	takeOver := make(chan []pod.UserContainerPort, 1)
+	networkHandOver = func(maps []pod.UserContainerPort) { handOver <- maps }
+	networkTakeOver = func(ip, ip6 string, maps []pod.UserContainerPort) { takeOver <- maps }
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	cmd := fakePodCommand(false)
+	cmd.Spec.Containers[0].Ports = []pod.UserContainerPort{{HostPort: 8080, ContainerPort: 80}}
+	hub <- cmd
+	rsp := waitResponse(t, client, types.E_OK, 10)
+	pack := rsp.Data.([]byte)
+
+	source := path.Join(BaseDir, "fakevm-migsrc", ShareDirTag)
+	defer os.RemoveAll(source)
+	if err := ioutil.WriteFile(path.Join(source, "data"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	thub := make(chan QemuEvent, 128)
+	tclient := make(chan *types.QemuResponse, 128)
+	go QemuIncoming("fakevm-migdst", thub, tclient, nil, &BootConfig{CPU: 1, Memory: 128}, pack, source, "")
+	for rsp = nil; rsp == nil || rsp.Code != types.E_OK && rsp.Code != types.E_BAD_REQUEST; {
+		select {
+		case rsp = <-tclient:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for the target VM")
+		}
+	}
+	if rsp.Code == types.E_BAD_REQUEST {
+		hub <- &ShutdownCommand{}
+		waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+		t.Skip("can not mount the share dir: ", rsp.Cause)
+	}
+	target := path.Join(BaseDir, "fakevm-migdst", ShareDirTag)
+	if data, err := ioutil.ReadFile(path.Join(target, "data")); err != nil || string(data) != "data" {
+		t.Error("the share dir of the source VM is not mounted on the target")
+	}
+	if len(takeOver) != 0 {
+		t.Error("the port maps are taken over before the migration finished")
+	}
+
+	migrate := make(chan *types.QemuResponse, 1)
+	hub <- &MigrateCommand{Uri: rsp.Data.(string), Callback: migrate}
+	waitResponse(t, migrate, types.E_OK, 10)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+	if maps := waitPortMaps(t, handOver); len(maps) != 1 || maps[0].HostPort != 8080 {
+		t.Error("the port maps are not handed over by the source VM: ", maps)
+	}
+
+	waitResponse(t, tclient, types.E_POD_RUNNING, 5)
+	if maps := waitPortMaps(t, takeOver); len(maps) != 1 || maps[0].HostPort != 8080 {
+		t.Error("the port maps are not taken over by the target VM: ", maps)
+	}
+	thub <- &ShutdownCommand{}
+	waitResponse(t, tclient, types.E_VM_SHUTDOWN, 10)
+	// the VM is closed after reporting the shutdown
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path.Join(target, "data")); err != nil {
+			break
+		}
+		if i == 20 {
+			t.Fatal("the share dir is still mounted on the target after it quit")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestFakeIncomingAbort(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-abortsrc", &FakeDriver{})
+	defer restore()
+	released := make(chan []pod.UserContainerPort, 1)
+	networkRelease = func(bridge, ip, ip6 string, maps []pod.UserContainerPort, file *os.File) error {
+		released <- maps
+		file.Close()
+		return nil
+	}
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	cmd := fakePodCommand(false)
+	cmd.Spec.Containers[0].Ports = []pod.UserContainerPort{{HostPort: 8080, ContainerPort: 80}}
+	hub <- cmd
+	rsp := waitResponse(t, client, types.E_OK, 10)
+
+	thub := make(chan QemuEvent, 128)
+	tclient := make(chan *types.QemuResponse, 128)
+	go QemuIncoming("fakevm-abortdst", thub, tclient, nil, &BootConfig{CPU: 1, Memory: 128}, rsp.Data.([]byte), "", "")
+	waitResponse(t, tclient, types.E_OK, 10)
+	thub <- &ShutdownCommand{}
+	waitResponse(t, tclient, types.E_VM_SHUTDOWN, 10)
+	if maps := waitPortMaps(t, released); len(maps) != 0 {
+		t.Error("the port maps of the source VM are released by the target: ", maps)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+	if maps := waitPortMaps(t, released); len(maps) != 1 || maps[0].HostPort != 8080 {
+		t.Error("the port maps are not released by the source VM: ", maps)
+	}
+}
+
+func TestFakeMigrationRemote(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-remotesrc", &FakeDriver{})
+	defer restore()
+	handOver := make(chan []pod.UserContainerPort, 1)
+	released := make(chan []pod.UserContainerPort, 1)
+	networkHandOver = func(maps []pod.UserContainerPort) { handOver <- maps }
+	networkRelease = func(bridge, ip, ip6 string, maps []pod.UserContainerPort, file *os.File) error {
+		released <- maps
+		file.Close()
+		return nil
+	}
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	cmd := fakePodCommand(false)
+	cmd.Spec.Containers[0].Ports = []pod.UserContainerPort{{HostPort: 8080, ContainerPort: 80}}
+	hub <- cmd
+	rsp := waitResponse(t, client, types.E_OK, 10)
+
+	thub := make(chan QemuEvent, 128)
+	tclient := make(chan *types.QemuResponse, 128)
+	go QemuIncoming("fakevm-remotedst", thub, tclient, nil, &BootConfig{CPU: 1, Memory: 128},
+		rsp.Data.([]byte), "", "tcp:0.0.0.0:4446")
+	rsp = waitResponse(t, tclient, types.E_OK, 10)
+	if uri, ok := rsp.Data.(string); !ok || uri != "tcp:0.0.0.0:4446" {
+		t.Fatal("the target VM should listen on the tcp port, but got ", rsp.Data)
+	}
+
+	migrate := make(chan *types.QemuResponse, 1)
+	hub <- &MigrateCommand{Uri: "tcp:192.0.2.1:4446", Remote: true, Callback: migrate}
+	waitResponse(t, migrate, types.E_OK, 10)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+	if maps := waitPortMaps(t, released); len(maps) != 1 || maps[0].HostPort != 8080 {
+		t.Error("the port maps are not released by the source VM: ", maps)
+	}
+	if len(handOver) != 0 {
+		t.Error("the port maps are handed over to the VM on another host")
+	}
+
+	waitResponse(t, tclient, types.E_POD_RUNNING, 5)
+	thub <- &ShutdownCommand{}
+	waitResponse(t, tclient, types.E_VM_SHUTDOWN, 10)
+}
+
+// waitPortMaps returns the port maps the network operation is called with
+func waitPortMaps(t *testing.T, called chan []pod.UserContainerPort) []pod.UserContainerPort {
+	select {
+	case maps := <-called:
+		return maps
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the port maps")
+	}
+	return nil
+}
+
+func TestFakeMigrationBadData(t *testing.T) {
+	client := make(chan *types.QemuResponse, 1)
+	go QemuIncoming("fakevm-badmigrate", make(chan QemuEvent, 128), client, nil,
+		&BootConfig{CPU: 1, Memory: 128}, []byte("{"), "", "")
+	waitResponse(t, client, types.E_BAD_REQUEST, 5)
+}
+
+func TestFakePodPause(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-pause", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	pause := make(chan *types.QemuResponse, 1)
+	hub <- &PauseCommand{Callback: pause}
+	waitResponse(t, pause, types.E_OK, 5)
+
+	r, w := io.Pipe()
+	go io.Copy(ioutil.Discard, r)
+	finish := make(chan *types.QemuResponse, 1)
+	hub <- &ExecCommand{
+		Container: "c1id",
+		Command:   []string{"echo", "hello"},
+		Streams:   &TtyIO{Stdout: w, ClientTag: "exec", Callback: finish},
+	}
+	waitResponse(t, finish, types.E_FAILED, 5)
+
+	hub <- &PauseCommand{Callback: pause}
+	waitResponse(t, pause, types.E_BAD_REQUEST, 5)
+
+	unpause := make(chan *types.QemuResponse, 1)
+	hub <- &UnpauseCommand{Callback: unpause}
+	waitResponse(t, unpause, types.E_OK, 5)
+
+	hub <- &ExecCommand{
+		Container: "c1id",
+		Command:   []string{"echo", "hello"},
+		Streams:   &TtyIO{Stdout: w, ClientTag: "exec", Callback: finish},
+	}
+	waitResponse(t, finish, types.E_EXEC_FINISH, 5)
+
+	// a paused pod could be stopped directly
+	hub <- &PauseCommand{Callback: pause}
+	waitResponse(t, pause, types.E_OK, 5)
+	hub <- &StopPodCommand{}
+	waitResponse(t, client, types.E_POD_STOPPED, 10)
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodPauseStopping(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-pausestop", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	// the pause races the stop, it is answered in the stopping state
+	pause := make(chan *types.QemuResponse, 1)
+	unpause := make(chan *types.QemuResponse, 1)
+	hub <- &StopPodCommand{}
+	hub <- &PauseCommand{Callback: pause}
+	hub <- &UnpauseCommand{Callback: unpause}
+	waitResponse(t, pause, types.E_BAD_REQUEST, 5)
+	waitResponse(t, unpause, types.E_BAD_REQUEST, 5)
+	waitResponse(t, client, types.E_POD_STOPPED, 10)
+
+	// the pause in progress is answered once the VM goes down
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+	hub <- &PauseCommand{Callback: pause}
+	hub <- &ShutdownCommand{}
+	waitResponse(t, pause, types.E_FAILED, 5)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodPauseAssociate(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-pauseassoc", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	pause := make(chan *types.QemuResponse, 1)
+	hub <- &PauseCommand{Callback: pause}
+	rsp := waitResponse(t, pause, types.E_OK, 5)
+	pack, ok := rsp.Data.([]byte)
+	if !ok {
+		t.Fatal("no persist info in the pause response")
+	}
+	if pinfo, err := vmDeserialize(pack); err != nil || !pinfo.Paused {
+		t.Fatal("the paused state is not persisted")
+	}
+
+	// the VM is left frozen when released
+	hub <- &ReleaseVMCommand{}
+	waitResponse(t, client, types.E_OK, 5)
+
+	ahub := make(chan QemuEvent, 128)
+	aclient := make(chan *types.QemuResponse, 128)
+	go QemuAssociate("fakevm-pauseassoc", ahub, aclient, &sync.WaitGroup{}, pack)
+
+	ahub <- &PauseCommand{Callback: pause}
+	waitResponse(t, pause, types.E_BAD_REQUEST, 5)
+
+	unpause := make(chan *types.QemuResponse, 1)
+	ahub <- &UnpauseCommand{Callback: unpause}
+	rsp = waitResponse(t, unpause, types.E_OK, 5)
+	if pinfo, err := vmDeserialize(rsp.Data.([]byte)); err != nil || pinfo.Paused {
+		t.Error("the resumed VM is persisted as paused")
+	}
+
+	ahub <- &ShutdownCommand{}
+	waitResponse(t, aclient, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodResize(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-resize", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	resize := make(chan *types.QemuResponse, 1)
+	hub <- &ResizeCommand{Memory: 96, Callback: resize}
+	rsp := waitResponse(t, resize, types.E_OK, 5)
+	if rsp.Data.(int) != 96 {
+		t.Error("memory should be resized to 96 MB, but got ", rsp.Data)
+	}
+
+	hub <- &ResizeCommand{Memory: 256, Callback: resize}
+	waitResponse(t, resize, types.E_BAD_REQUEST, 5)
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodResizeStopping(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-resizestop", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	resize := make(chan *types.QemuResponse, 1)
+	hub <- &StopPodCommand{}
+	hub <- &ResizeCommand{Memory: 96, Callback: resize}
+	waitResponse(t, resize, types.E_BAD_REQUEST, 5)
+	waitResponse(t, client, types.E_POD_STOPPED, 10)
+
+	// the resize in progress is answered once the VM goes down
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+	hub <- &ResizeCommand{Memory: 96, Callback: resize}
+	hub <- &ShutdownCommand{}
+	select {
+	case <-resize:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resize is not answered")
+	}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodAddCpu(t *testing.T) {
+	orig := HDriver
+	HDriver = &FakeDriver{}
+	restoreNetwork := FakeNetwork()
+	restoreLogDir := fakeLogDir(t)
+	defer func() {
+		HDriver = orig
+		restoreNetwork()
+		restoreLogDir()
+	}()
+
+	hub := make(chan QemuEvent, 128)
+	client := make(chan *types.QemuResponse, 128)
+	go QemuLoop("fakevm-cpu", hub, client, &BootConfig{CPU: 1, MaxCPU: 4, Memory: 128})
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	add := make(chan *types.QemuResponse, 1)
+	hub <- &CpuAddCommand{Cpu: 3, Callback: add}
+	rsp := waitResponse(t, add, types.E_OK, 5)
+	pinfo, err := vmDeserialize(rsp.Data.([]byte))
+	if err != nil || pinfo.Cpu != 3 || pinfo.MaxCpu != 4 {
+		t.Errorf("cpu should be 3 of 4, but got %v", pinfo)
+	}
+
+	hub <- &CpuAddCommand{Cpu: 5, Callback: add}
+	waitResponse(t, add, types.E_BAD_REQUEST, 5)
+	hub <- &CpuAddCommand{Cpu: 2, Callback: add}
+	waitResponse(t, add, types.E_BAD_REQUEST, 5)
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakePodAddCpuStopping(t *testing.T) {
+	orig := HDriver
+	HDriver = &FakeDriver{}
+	restoreNetwork := FakeNetwork()
+	restoreLogDir := fakeLogDir(t)
+	defer func() {
+		HDriver = orig
+		restoreNetwork()
+		restoreLogDir()
+	}()
+
+	hub := make(chan QemuEvent, 128)
+	client := make(chan *types.QemuResponse, 128)
+	go QemuLoop("fakevm-cpustop", hub, client, &BootConfig{CPU: 1, MaxCPU: 4, Memory: 128})
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	add := make(chan *types.QemuResponse, 1)
+	hub <- &StopPodCommand{}
+	hub <- &CpuAddCommand{Cpu: 2, Callback: add}
+	waitResponse(t, add, types.E_BAD_REQUEST, 5)
+	waitResponse(t, client, types.E_POD_STOPPED, 10)
+
+	// the cpu add in progress is answered once the VM goes down
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+	hub <- &CpuAddCommand{Cpu: 2, Callback: add}
+	hub <- &ShutdownCommand{}
+	waitResponse(t, add, types.E_FAILED, 5)
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
+
+func TestFakeConsoleLog(t *testing.T) {
+	os.Remove(ConsoleLogPath("fakevm-console"))
+	hub, client, restore := startFakeVm(t, "fakevm-console", &FakeDriver{})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+
+	// the console is written asynchronously
+	expect := "fake kernel booting\nfake init started\n"
+	data := []byte{}
+	for i := 0; i < 50 && string(data) != expect; i++ {
+		time.Sleep(20 * time.Millisecond)
+		data, _ = ioutil.ReadFile(ConsoleLogPath("fakevm-console"))
+	}
+	if string(data) != expect {
+		t.Errorf("console log mismatch: %q", string(data))
+	}
+}
+
+func TestFakeContainerLog(t *testing.T) {
+	hub, client, restore := startFakeVm(t, "fakevm-logs", &FakeDriver{ContainerOutput: true})
+	defer restore()
+
+	waitResponse(t, client, types.E_VM_RUNNING, 5)
+	hub <- fakePodCommand(false)
+	waitResponse(t, client, types.E_OK, 10)
+
+	// the output is logged asynchronously
+	lines := []string{}
+	for i := 0; i < 50 && len(lines) < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		data, _ := ioutil.ReadFile(ContainerLogPath("c1id"))
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	logs := map[string]string{}
+	for _, line := range lines {
+		entry := &types.ContainerLogEntry{}
+		if err := json.Unmarshal([]byte(line), entry); err != nil {
+			t.Fatalf("bad log entry %q", line)
+		}
+		logs[entry.Stream] = entry.Log
+	}
+	if logs["stdout"] != "c1id started\n" || logs["stderr"] != "c1id warning\n" {
+		t.Errorf("container log mismatch: %v", logs)
+	}
+
+	hub <- &ShutdownCommand{}
+	waitResponse(t, client, types.E_VM_SHUTDOWN, 10)
+}
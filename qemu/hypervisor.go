@@ -0,0 +1,98 @@
+package qemu
+
+import (
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/types"
+)
+
+// HypervisorDriver is the entry of a hypervisor backend, it creates the
+// per-VM DriverContext which the VM state machine talks to.
+type HypervisorDriver interface {
+	Name() string
+
+	// InitContext creates the driver context for a new VM whose runtime
+	// files (sockets etc.) are located under homeDir.
+	InitContext(homeDir string) DriverContext
+
+	// LoadContext rebuilds the driver context of a running VM from the
+	// data returned by DriverContext.Dump()
+	LoadContext(homeDir string, persisted map[string]interface{}) (DriverContext, error)
+}
+
+// DriverContext is the hypervisor specific part of a VmContext. All the
+// device operations are asynchronous, the result should be sent back to
+// ctx.hub as QemuEvent.
+type DriverContext interface {
+	// Launch starts the VM, and report QemuExitEvent to hub if fails
+	Launch(ctx *VmContext)
+	// Associate reconnects to a VM launched by a previous daemon
+	Associate(ctx *VmContext)
+	// Dump returns the info need by LoadContext
+	Dump() (map[string]interface{}, error)
+
+	AddDisk(ctx *VmContext, name, sourceType, filename, format string, id int)
+	RemoveDisk(ctx *VmContext, id int, callback QemuEvent)
+	AddNic(ctx *VmContext, fd uint64, device, mac string, index, addr int)
+	RemoveNic(ctx *VmContext, device string, callback QemuEvent)
+
+	// Query sends the hypervisor specific query, the result is reported
+	// to cmd.Callback
+	Query(ctx *VmContext, cmd *QueryCommand)
+	// Snapshot saves the memory of the VM to path, the VM is left paused
+	// after that. The result is reported to callback.
+	Snapshot(ctx *VmContext, path string, callback chan *types.QemuResponse)
+	// Migrate moves the VM to the one listening on uri, and sends
+	// MigrationDoneEvent to hub once it finished
+	Migrate(ctx *VmContext, uri string)
+	// Incoming starts receiving the migration on uri for the VM launched
+	// with BootConfig.Incoming, and sends IncomingReadyEvent to hub
+	Incoming(ctx *VmContext, uri string)
+	// Pause freezes the VM, or resumes it if pause is false. The result
+	// is reported to callback.
+	Pause(ctx *VmContext, pause bool, callback chan *types.QemuResponse)
+	// Balloon sets the memory of the VM to memory MB, and reports the
+	// actual size in MB to callback.
+	Balloon(ctx *VmContext, memory int, callback chan *types.QemuResponse)
+	// AddCpu plugs the vcpus from id 'from' to 'to - 1', the result is
+	// reported to callback.
+	AddCpu(ctx *VmContext, from, to int, callback chan *types.QemuResponse)
+
+	// Shutdown asks the VM to power off gracefully
+	Shutdown(ctx *VmContext)
+	// Kill force stops the VM, should send QemuKilledEvent to hub
+	Kill(ctx *VmContext)
+
+	Close()
+}
+
+const DefaultDriver = "qemu"
+
+var (
+	drivers = make(map[string]HypervisorDriver)
+	HDriver HypervisorDriver
+)
+
+// RegisterDriver make a hypervisor driver available by its name, the
+// default driver is selected once it is registered.
+func RegisterDriver(driver HypervisorDriver) {
+	drivers[driver.Name()] = driver
+	if HDriver == nil && driver.Name() == DefaultDriver {
+		HDriver = driver
+	}
+}
+
+// SelectDriver sets the driver used by the new VMs, empty name stands
+// for the default driver.
+func SelectDriver(name string) error {
+	if name == "" {
+		name = DefaultDriver
+	}
+	driver, ok := drivers[name]
+	if !ok {
+		return fmt.Errorf("Unsupported hypervisor driver: %s", name)
+	}
+	glog.V(1).Infof("select hypervisor driver %s", name)
+	HDriver = driver
+	return nil
+}
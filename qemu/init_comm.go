@@ -97,6 +97,22 @@ func waitInitReady(ctx *VmContext) {
 	}
 }
 
+// connectRestoredInit connects to the init of a VM restored from snapshot,
+// the init has reported ready before the snapshot was taken.
+func connectRestoredInit(ctx *VmContext) {
+	conn, err := unixSocketConnect(ctx.hyperSockName)
+	if err != nil {
+		glog.Error("Cannot connect to hyper socket ", err.Error())
+		ctx.hub <- &InitFailedEvent{
+			reason: "Cannot connect to hyper socket " + err.Error(),
+		}
+		return
+	}
+
+	ctx.hub <- &InitConnectedEvent{conn: conn.(*net.UnixConn)}
+	go waitCmdToInit(ctx, conn.(*net.UnixConn))
+}
+
 func connectToInit(ctx *VmContext) {
 	conn, err := unixSocketConnect(ctx.hyperSockName)
 	if err != nil {
@@ -188,7 +204,7 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 			if pongTimer == nil {
 				glog.V(1).Info("message sent, set pong timer")
 				pongTimer = time.AfterFunc(30*time.Second, func() {
-					ctx.hub <- &Interrupted{reason: "init not reply ping mesg"}
+					ctx.hub <- &Interrupted{reason: PingTimeoutReason}
 				})
 			}
 		}
@@ -196,6 +212,8 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 }
 
 func waitInitAck(ctx *VmContext, init *net.UnixConn) {
+	// the ack of the last command may arrive after the VM is closed
+	defer func() { recover() }()
 	for {
 		res, err := readVmMessage(init)
 		if err != nil {
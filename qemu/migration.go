@@ -0,0 +1,295 @@
+package qemu
+
+import (
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+	"path"
+	"sync"
+	"syscall"
+)
+
+// QemuIncoming launches a VM to receive the migration of a running pod.
+// pack is the persist info of the source VM, the devices are plugged as
+// they are in the source VM before listening on the uri listen, such as
+// "tcp:0.0.0.0:port", or on a unix socket in the home dir of the VM if it
+// is empty. The uri is reported once ready. The share dir of the source
+// VM, shareDir, is mounted on the one of this VM, so the container rootfs
+// and volumes in it go with the pod. The VM takes its own id, so the
+// sockets would not conflict with the source one.
+func QemuIncoming(vmId string, hub chan QemuEvent, client chan *types.QemuResponse,
+	wg *sync.WaitGroup, boot *BootConfig, pack []byte, shareDir, listen string) {
+
+	pinfo, err := vmDeserialize(pack)
+	if err != nil {
+		client <- &types.QemuResponse{
+			VmId:  vmId,
+			Code:  types.E_BAD_REQUEST,
+			Cause: err.Error(),
+		}
+		return
+	}
+
+	boot.Incoming = true
+	// the target should have the vcpus plugged into the source
+	if pinfo.Cpu > 0 {
+		boot.CPU = pinfo.Cpu
+		boot.MaxCPU = pinfo.MaxCpu
+	}
+	context, err := initContext(vmId, hub, client, boot)
+	if err != nil {
+		client <- &types.QemuResponse{
+			VmId:  vmId,
+			Code:  types.E_BAD_REQUEST,
+			Cause: err.Error(),
+		}
+		return
+	}
+
+	if shareDir != "" {
+		if err := bindShareDir(shareDir, context.shareDir); err != nil {
+			client <- &types.QemuResponse{
+				VmId:  vmId,
+				Code:  types.E_BAD_REQUEST,
+				Cause: err.Error(),
+			}
+			context.Close()
+			return
+		}
+		context.shareBound = true
+	}
+
+	if err := pinfo.loadDevices(context); err != nil {
+		client <- &types.QemuResponse{
+			VmId:  vmId,
+			Code:  types.E_BAD_REQUEST,
+			Cause: err.Error(),
+		}
+		context.Close()
+		return
+	}
+	context.wg = wg
+	context.incoming = listen
+	if listen == "" {
+		context.incoming = "unix:" + path.Join(BaseDir, vmId, MigrateSockName)
+	}
+
+	context.DCtx.Launch(context)
+	go waitPts(context)
+
+	context.Become(stateIncoming, "INCOMING")
+	context.setTimeout(60)
+	context.restoreDevices()
+	context.startIncoming()
+
+	context.loop()
+}
+
+// restoreDevices plugs the disks and nics of the source VM, with the same
+// scsi ids, pci addresses, ip and mac addresses.
+func (ctx *VmContext) restoreDevices() {
+	for name, image := range ctx.devices.imageMap {
+		ctx.progress.adding.blockdevs[name] = true
+		ctx.DCtx.AddDisk(ctx, name, "image", image.info.filename, image.info.format, image.info.scsiId)
+	}
+	for name, vol := range ctx.devices.volumeMap {
+		if vol.info.deviceName == "" {
+			continue
+		}
+		ctx.progress.adding.blockdevs[name] = true
+		ctx.DCtx.AddDisk(ctx, name, "volume", vol.info.filename, vol.info.format, vol.info.scsiId)
+	}
+
+	// the nics are added back to the map once created on this host, the
+	// port maps are still used by the source VM, they are taken over once
+	// the migration finished
+	nics := ctx.devices.networkMap
+	ctx.devices.networkMap = make(map[int]*InterfaceCreated)
+	for idx, nic := range nics {
+		ctx.progress.adding.networks[idx] = true
+		go CreateInterface(idx, nic.PCIAddr, nic.DeviceName, idx == 0, nic.Bridge, nic.IpAddr, nic.Ip6Addr, nic.MacAddr,
+			ctx.userSpec.Resource, nil, ctx.hub)
+	}
+}
+
+// takeOverPortMaps sets up the port maps of the nics, whose rules are left
+// by the source VM on this host, or added if it is on another host.
+func (ctx *VmContext) takeOverPortMaps() {
+	var maps []pod.UserContainerPort
+	for _, c := range ctx.userSpec.Containers {
+		maps = append(maps, c.Ports...)
+	}
+	for idx, nic := range ctx.devices.networkMap {
+		networkTakeOver(nic.IpAddr, nic.Ip6Addr, nicPortMaps(idx, maps))
+	}
+}
+
+// bindShareDir mounts the share dir of the source VM on target with the
+// mounts under it. They are made private, so they are kept when the source
+// unmounts its own, and this VM unmounts them like the ones it mounted.
+func bindShareDir(source, target string) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("can not mount the share dir %s of the source VM: %s", source, err.Error())
+	}
+	if err := syscall.Mount("", target, "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		unbindShareDir(target)
+		return fmt.Errorf("can not make the share dir %s private: %s", target, err.Error())
+	}
+	return nil
+}
+
+func unbindShareDir(target string) {
+	if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil {
+		glog.Warningf("Cannot umount share dir %s: %s", target, err.Error())
+	}
+}
+
+func (ctx *VmContext) startIncoming() {
+	if ctx.deviceReady() {
+		glog.Info("devices restored, wait for migration on ", ctx.incoming)
+		ctx.DCtx.Incoming(ctx, ctx.incoming)
+	}
+}
+
+// abortIncoming gives up the incoming migration. The storage is still used
+// by the source VM, so only the network allocated here is released.
+func (ctx *VmContext) abortIncoming(reason string, exited bool) {
+	glog.Error("incoming migration failed: ", reason)
+	ctx.reportVmFault(reason)
+	ctx.unsetTimeout()
+	ctx.forgetStorage()
+
+	if exited {
+		if closed := ctx.onQemuExit(true); !closed {
+			ctx.Become(stateDestroying, "DESTROYING")
+		}
+		return
+	}
+	ctx.poweroffVM(false, "")
+	ctx.Become(stateTerminating, "TERMINATING")
+}
+
+func (ctx *VmContext) migrateVm(cmd *MigrateCommand) {
+	if ctx.migrateCallback != nil {
+		ctx.replyBadMigrate(cmd, "the pod is being migrated")
+		return
+	}
+	glog.Infof("migrate VM %s to %s", ctx.Id, cmd.Uri)
+	ctx.migrateCallback = cmd.Callback
+	ctx.migrateRemote = cmd.Remote
+	ctx.DCtx.Migrate(ctx, cmd.Uri)
+}
+
+// replyMigrate reports the result of the MigrateCommand in progress
+func (ctx *VmContext) replyMigrate(code int, cause string) {
+	if ctx.migrateCallback == nil {
+		return
+	}
+	ctx.migrateCallback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  code,
+		Cause: cause,
+	}
+	ctx.migrateCallback = nil
+}
+
+func (ctx *VmContext) replyBadMigrate(cmd *MigrateCommand, cause string) {
+	cmd.Callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BAD_REQUEST,
+		Cause: cause,
+	}
+}
+
+// onMigrated quits the VM which has been migrated away. The storage goes
+// with the pod and the port maps are handed over to the target VM on this
+// host, only the tap devices and the addresses, and the port maps if the
+// target is on another host, are released once qemu exits.
+func (ctx *VmContext) onMigrated(result *MigrationDoneEvent) {
+	if !result.Success {
+		// qemu keeps the VM running if the migration failed
+		glog.Error("migration failed: ", result.Cause)
+		ctx.replyMigrate(types.E_FAILED, result.Cause)
+		return
+	}
+
+	glog.Infof("VM %s migrated, release it", ctx.Id)
+	ctx.migrated = true
+	ctx.replyMigrate(types.E_OK, "Migrate POD success")
+	ctx.forgetStorage()
+	ctx.poweroffVM(false, "")
+	ctx.Become(stateTerminating, "TERMINATING")
+}
+
+// forgetStorage drops the images, volumes and containers on block devices
+// from the context, so they would not be reclaimed when the VM exits. The
+// mounts in the share dir are still unmounted, the target VM has its own
+// copies of them.
+func (ctx *VmContext) forgetStorage() {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	for name, image := range ctx.devices.imageMap {
+		if image.info.fstype != "dir" {
+			delete(ctx.devices.imageMap, name)
+		}
+	}
+	for name, vol := range ctx.devices.volumeMap {
+		if vol.info.fstype != "" {
+			delete(ctx.devices.volumeMap, name)
+		}
+	}
+}
+
+func stateIncoming(ctx *VmContext, ev QemuEvent) {
+	switch ev.Event() {
+	case EVENT_BLOCK_INSERTED:
+		// the spec is restored already, just mark it ready
+		info := ev.(*BlockdevInsertedEvent)
+		delete(ctx.progress.adding.blockdevs, info.Name)
+		ctx.startIncoming()
+	case EVENT_INTERFACE_ADD:
+		info := ev.(*InterfaceCreated)
+		ctx.interfaceCreated(info)
+		ctx.DCtx.AddNic(ctx, uint64(info.Fd.Fd()), info.DeviceName, info.MacAddr, info.Index, info.PCIAddr)
+	case EVENT_INTERFACE_INSERTED:
+		ctx.netdevInserted(ev.(*NetDevInsertedEvent))
+		ctx.startIncoming()
+	case EVENT_INCOMING_READY:
+		glog.Info("ready for the incoming migration")
+		ctx.unsetTimeout()
+		ctx.reportSuccess("Ready for incoming migration", ctx.incoming)
+	case EVENT_QMP_EVENT:
+		switch ev.(*QmpEvent).Type {
+		case QMP_EVENT_RESUME:
+			glog.Info("incoming migration finished, VM resumed")
+			ctx.takeOverPortMaps()
+			ctx.incoming = ""
+			go connectToInit(ctx)
+			ctx.Become(stateRunning, "RUNNING")
+			// the pod is running here now, report the persist info of
+			// this VM
+			ctx.reportPodRunning("Migrated POD runs", ctx.persistData())
+		case QMP_EVENT_SHUTDOWN:
+			ctx.abortIncoming("qemu shut down during incoming migration", true)
+		}
+	case EVENT_QEMU_EXIT:
+		ctx.abortIncoming("qemu exit during incoming migration", true)
+	case ERROR_QMP_FAIL:
+		ctx.abortIncoming("failed to restore devices", false)
+	case ERROR_INIT_FAIL:
+		ctx.abortIncoming(ev.(*InitFailedEvent).reason, false)
+	case ERROR_INTERRUPTED:
+		ctx.abortIncoming("connection to VM broken", false)
+	case EVENT_QEMU_TIMEOUT:
+		ctx.abortIncoming("restore devices timeout", false)
+	case COMMAND_SHUTDOWN:
+		ctx.abortIncoming("incoming migration canceled", false)
+	case COMMAND_QUERY:
+		ctx.DCtx.Query(ctx, ev.(*QueryCommand))
+	default:
+		if !ctx.rejectPodCommand(ev) {
+			glog.Warning("got unexpected event during incoming migration")
+		}
+	}
+}
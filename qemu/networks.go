@@ -9,9 +9,22 @@ import (
 	"os"
 )
 
-func CreateInterface(index int, pciAddr int, name string, isDefault bool,
-	maps []pod.UserContainerPort, callback chan QemuEvent) {
-	inf, err := network.Allocate("", maps)
+// the host side network operations, replaced in tests which have no bridge
+var (
+	networkAllocate = network.Allocate
+	networkRelease  = network.Release
+	networkRestore  = network.Restore
+	networkTakeOver = network.TakeOverPortMaps
+	networkHandOver = network.HandOverPortMaps
+)
+
+// CreateInterface allocates the host side of a nic on the bridge, bridge,
+// ipAddr, ip6Addr and macAddr could be empty to let them allocated
+// automatically. The bandwidth of the nic is limited by the resource of the
+// pod.
+func CreateInterface(index int, pciAddr int, name string, isDefault bool, bridge, ipAddr, ip6Addr, macAddr string,
+	resource pod.UserResource, maps []pod.UserContainerPort, callback chan QemuEvent) {
+	inf, err := networkAllocate(bridge, ipAddr, ip6Addr, resource.Ingress, resource.Egress, maps)
 	if err != nil {
 		glog.Error("interface creating failed: ", err.Error())
 		callback <- &DeviceFailed{
@@ -20,13 +33,16 @@ func CreateInterface(index int, pciAddr int, name string, isDefault bool,
 		return
 	}
 
+	if macAddr != "" {
+		inf.Mac = macAddr
+	}
 	interfaceGot(index, pciAddr, name, isDefault, callback, inf)
 }
 
-func ReleaseInterface(index int, ipAddr string, file *os.File,
+func ReleaseInterface(index int, bridge, ipAddr, ip6Addr string, file *os.File,
 	maps []pod.UserContainerPort, callback chan QemuEvent) {
 	success := true
-	err := network.Release(ipAddr, maps, file)
+	err := networkRelease(bridge, ipAddr, ip6Addr, maps, file)
 	if err != nil {
 		glog.Warning("Unable to release network interface, address: ", ipAddr, err)
 		success = false
@@ -34,6 +50,33 @@ func ReleaseInterface(index int, ipAddr string, file *os.File,
 	callback <- &InterfaceReleased{Index: index, Success: success}
 }
 
+// RestoreInterfaces allocates the addresses and the port maps of the nics
+// of the VM persisted in pack again, they are lost once the daemon restarts.
+func RestoreInterfaces(pack []byte) error {
+	pinfo, err := vmDeserialize(pack)
+	if err != nil {
+		return err
+	}
+
+	var maps []pod.UserContainerPort
+	if pinfo.UserSpec != nil {
+		for _, c := range pinfo.UserSpec.Containers {
+			maps = append(maps, c.Ports...)
+		}
+	}
+
+	for _, nic := range pinfo.NetworkList {
+		if nic.IpAddr == "" {
+			continue
+		}
+		if e := networkRestore(nic.Bridge, nic.IpAddr, nic.Ip6Addr, nicPortMaps(nic.Index, maps)); e != nil {
+			glog.Errorf("Unable to restore nic %d of VM %s: %s", nic.Index, pinfo.Id, e.Error())
+			err = e
+		}
+	}
+	return err
+}
+
 func interfaceGot(index int, pciAddr int, name string, isDefault bool, callback chan QemuEvent, inf *network.Settings) {
 
 	ip, nw, err := net.ParseCIDR(fmt.Sprintf("%s/%d", inf.IPAddress, inf.IPPrefixLen))
@@ -58,6 +101,12 @@ func interfaceGot(index int, pciAddr int, name string, isDefault bool, callback
 			Destination: "0.0.0.0/0",
 			Gateway:     inf.Gateway, ViaThis: true,
 		})
+		if inf.IPv6Gateway != "" {
+			rt = append(rt, &RouteRule{
+				Destination: "::/0",
+				Gateway:     inf.IPv6Gateway, ViaThis: true,
+			})
+		}
 	}
 
 	event := &InterfaceCreated{
@@ -65,9 +114,12 @@ func interfaceGot(index int, pciAddr int, name string, isDefault bool, callback
 		PCIAddr:    pciAddr,
 		DeviceName: name,
 		Fd:         inf.File,
+		Bridge:     inf.Bridge,
 		MacAddr:    inf.Mac,
 		IpAddr:     ip.String(),
 		NetMask:    mask.String(),
+		Ip6Addr:    inf.IPv6Address,
+		Ip6Prefix:  inf.IPv6PrefixLen,
 		RouteTable: rt,
 	}
 
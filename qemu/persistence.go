@@ -3,10 +3,10 @@ package qemu
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"hyper/pod"
 	"hyper/types"
-	"os"
 )
 
 type PersistVolumeInfo struct {
@@ -24,17 +24,31 @@ type PersistNetworkInfo struct {
 	Index      int
 	PciAddr    int
 	DeviceName string
+	Bridge     string
 	IpAddr     string
+	Ip6Addr    string
+	MacAddr    string
 }
 
 type PersistInfo struct {
 	Id          string
 	Pid         int
+	DriverInfo  map[string]interface{}
 	UserSpec    *pod.UserPod
 	VmSpec      *VmPod
 	HwStat      *VmHwStatus
 	VolumeList  []*PersistVolumeInfo
 	NetworkList []*PersistNetworkInfo
+	// the vcpus plugged, the most vcpus could be plugged, and the memory
+	// the VM booted with
+	Cpu    int
+	MaxCpu int
+	Memory int
+	// the VM is frozen, it should be associated in the paused state
+	Paused bool `json:",omitempty"`
+	// the exit codes of the init containers, which are the first ones of
+	// the spec
+	InitResults []uint32 `json:",omitempty"`
 }
 
 func (ctx *VmContext) dump() (*PersistInfo, error) {
@@ -42,15 +56,27 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 		Id:          ctx.Id,
 		UserSpec:    ctx.userSpec,
 		VmSpec:      ctx.vmSpec,
+		Paused:      ctx.current == "PAUSED",
+		InitResults: ctx.initResults,
 		HwStat:      ctx.dumpHwInfo(),
 		VolumeList:  make([]*PersistVolumeInfo, len(ctx.devices.imageMap)+len(ctx.devices.volumeMap)),
 		NetworkList: make([]*PersistNetworkInfo, len(ctx.devices.networkMap)),
 	}
 
-	if ctx.process == nil {
-		return nil, errors.New("No process id available")
+	if ctx.Boot != nil {
+		info.Cpu = ctx.Boot.CPU
+		info.MaxCpu = ctx.maxCpu()
+		info.Memory = ctx.Boot.Memory
+	}
+
+	dinfo, err := ctx.DCtx.Dump()
+	if err != nil {
+		return nil, err
+	}
+	info.DriverInfo = dinfo
+	if pid, ok := dinfo["pid"].(int); ok {
+		info.Pid = pid
 	}
-	info.Pid = ctx.process.Pid
 
 	vid := 0
 	for _, image := range ctx.devices.imageMap {
@@ -80,7 +106,10 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 			Index:      nic.Index,
 			PciAddr:    nic.PCIAddr,
 			DeviceName: nic.DeviceName,
+			Bridge:     nic.Bridge,
 			IpAddr:     nic.IpAddr,
+			Ip6Addr:    nic.Ip6Addr,
+			MacAddr:    nic.MacAddr,
 		}
 		nid++
 	}
@@ -152,6 +181,20 @@ func (cr *VmContainer) volLookup(mpoint string) *VmVolumeDescriptor {
 	return nil
 }
 
+// persistData returns the serialized persist info, or empty data if it
+// failed to dump the context
+func (ctx *VmContext) persistData() []byte {
+	persist, err := ctx.dump()
+	if err != nil {
+		return []byte{}
+	}
+	buf, err := persist.serialize()
+	if err != nil {
+		return []byte{}
+	}
+	return buf
+}
+
 func vmDeserialize(s []byte) (*PersistInfo, error) {
 	info := &PersistInfo{}
 	err := json.Unmarshal(s, info)
@@ -166,31 +209,59 @@ func (pinfo *PersistInfo) vmContext(hub chan QemuEvent,
 				    client chan *types.QemuResponse,
 				    wg *sync.WaitGroup) (*VmContext, error) {
 
-	proc, err := os.FindProcess(pinfo.Pid)
+	dinfo := pinfo.DriverInfo
+	if dinfo == nil {
+		// persisted by the daemon without driver support, must be qemu
+		dinfo = map[string]interface{}{
+			"hypervisor": "qemu",
+			"pid":        float64(pinfo.Pid),
+		}
+	}
+
+	name, _ := dinfo["hypervisor"].(string)
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported hypervisor driver: %s", name)
+	}
+
+	boot := &BootConfig{CPU: pinfo.Cpu, MaxCPU: pinfo.MaxCpu, Memory: pinfo.Memory}
+	ctx, err := initContext(pinfo.Id, hub, client, boot)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, err := initContext(pinfo.Id, hub, client, &BootConfig{})
+	dctx, err := driver.LoadContext(BaseDir+"/"+pinfo.Id+"/", dinfo)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx.process = proc
+	ctx.DCtx = dctx
+	ctx.wg = wg
+
+	if err := pinfo.loadDevices(ctx); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// loadDevices restores the spec and the devices of the pod into ctx
+func (pinfo *PersistInfo) loadDevices(ctx *VmContext) error {
 	ctx.vmSpec = pinfo.VmSpec
 	ctx.userSpec = pinfo.UserSpec
-	ctx.wg = wg
+	ctx.initContainers = len(pinfo.InitResults)
+	ctx.initResults = pinfo.InitResults
 
 	ctx.loadHwStatus(pinfo)
 
-	for idx, container := range ctx.vmSpec.Containers {
-		ctx.ptys.ttys[container.Tty] = newAttachments(idx, true)
+	for idx := range ctx.vmSpec.Containers {
+		ctx.restoreContainerSessions(idx, &ctx.vmSpec.Containers[idx])
 	}
 
 	for _, vol := range pinfo.VolumeList {
 		binfo := vol.blockInfo()
 		if len(vol.Containers) != len(vol.MontPoints) {
-			return nil, errors.New("persistent data corrupt, volume info mismatch")
+			return errors.New("persistent data corrupt, volume info mismatch")
 		}
 		if len(vol.MontPoints) == 1 && vol.MontPoints[0] == "/" {
 			img := &imageInfo{
@@ -209,6 +280,7 @@ func (pinfo *PersistInfo) vmContext(hub chan QemuEvent,
 				v.pos[idx] = vol.MontPoints[i]
 				v.readOnly[idx] = ctx.vmSpec.Containers[idx].roLookup(vol.MontPoints[i])
 			}
+			ctx.devices.volumeMap[vol.Name] = v
 		}
 	}
 
@@ -217,9 +289,12 @@ func (pinfo *PersistInfo) vmContext(hub chan QemuEvent,
 			Index:      nic.Index,
 			PCIAddr:    nic.PciAddr,
 			DeviceName: nic.DeviceName,
+			Bridge:     nic.Bridge,
 			IpAddr:     nic.IpAddr,
+			Ip6Addr:    nic.Ip6Addr,
+			MacAddr:    nic.MacAddr,
 		}
 	}
 
-	return ctx, nil
+	return nil
 }
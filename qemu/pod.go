@@ -17,6 +17,17 @@ type VmFsmapDescriptor struct {
 	ReadOnly bool   `json:"readOnly"`
 }
 
+// VmVolumeMount asks the init to mount a volume to a container, or unmount
+// it. Device is set for a block volume, and Source for a dir in share_dir.
+type VmVolumeMount struct {
+	Container string `json:"container"`
+	Device    string `json:"device,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Fstype    string `json:"fstype,omitempty"`
+	Mount     string `json:"mount"`
+	ReadOnly  bool   `json:"readOnly"`
+}
+
 type VmEnvironmentVar struct {
 	Env   string `json:"env"`
 	Value string `json:"value"`
@@ -30,6 +41,8 @@ type VmContainer struct {
 	Volumes       []VmVolumeDescriptor `json:"volumes,omitempty"`
 	Fsmap         []VmFsmapDescriptor  `json:"fsmap,omitempty"`
 	Tty           uint64               `json:"tty,omitempty"`
+	Stdio         uint64               `json:"stdio,omitempty"`  // stdout session if no tty
+	Stderr        uint64               `json:"stderr,omitempty"` // stderr session if no tty
 	Workdir       string               `json:"workdir"`
 	Entrypoint    []string             `json:"-"`
 	Cmd           []string             `json:"cmd"`
@@ -38,9 +51,11 @@ type VmContainer struct {
 }
 
 type VmNetworkInf struct {
-	Device    string `json:"device"`
-	IpAddress string `json:"ipAddress"`
-	NetMask   string `json:"netMask"`
+	Device        string `json:"device"`
+	IpAddress     string `json:"ipAddress"`
+	NetMask       string `json:"netMask"`
+	Ipv6Address   string `json:"ipv6Address,omitempty"`
+	Ipv6PrefixLen int    `json:"ipv6PrefixLen,omitempty"`
 }
 
 type VmRoute struct {
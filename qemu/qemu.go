@@ -8,11 +8,18 @@ import (
 
 type BootConfig struct {
 	CPU    int
+	// MaxCPU is the most vcpus could be plugged, same as CPU if not set
+	MaxCPU int
 	Memory int
 	Kernel string
 	Initrd string
 	Bios   string
 	Cbfs   string
+	// Snapshot is the memory image saved by SnapshotCommand, the VM is
+	// restored from it instead of booting the kernel.
+	Snapshot string
+	// Incoming makes the VM wait for an incoming migration
+	Incoming bool
 }
 
 func (ctx *VmContext) loop() {
@@ -43,9 +50,12 @@ func QemuLoop(vmId string, hub chan QemuEvent, client chan *types.QemuResponse,
 	}
 
 	//launch routines
-	go qmpHandler(context)
-	go waitInitReady(context)
-	go launchQemu(context)
+	if boot != nil && boot.Snapshot != "" {
+		go connectRestoredInit(context)
+	} else {
+		go waitInitReady(context)
+	}
+	context.DCtx.Launch(context)
 	go waitPts(context)
 
 	context.loop()
@@ -87,12 +97,15 @@ func QemuAssociate(vmId string, hub chan QemuEvent, client chan *types.QemuRespo
 		return
 	}
 
-	go qmpHandler(context)
-	go associateQemu(context)
+	context.DCtx.Associate(context)
 	go waitPts(context)
 	go connectToInit(context)
 
-	context.Become(stateRunning, "RUNNING")
+	if pinfo.Paused {
+		context.Become(statePaused, "PAUSED")
+	} else {
+		context.Become(stateRunning, "RUNNING")
+	}
 
 	context.loop()
 }
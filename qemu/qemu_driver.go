@@ -0,0 +1,218 @@
+package qemu
+
+import (
+	"errors"
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/types"
+	"os"
+	"strconv"
+)
+
+// QemuDriver is the default hypervisor driver, it launches
+// qemu-system-x86_64 and controls it through QMP.
+type QemuDriver struct{}
+
+// QemuContext is the per-VM state of the QEMU driver
+type QemuContext struct {
+	qmp         chan QmpInteraction
+	wdt         chan string
+	qmpSockName string
+	process     *os.Process
+}
+
+func init() {
+	RegisterDriver(&QemuDriver{})
+}
+
+// qmpContext is implemented by the driver contexts controlling the VM
+// through QMP, i.e. QemuContext and the ones embedding it.
+type qmpContext interface {
+	qemu() *QemuContext
+}
+
+func qemuContext(ctx *VmContext) *QemuContext {
+	return ctx.DCtx.(qmpContext).qemu()
+}
+
+func (qc *QemuContext) qemu() *QemuContext {
+	return qc
+}
+
+func (qd *QemuDriver) Name() string {
+	return "qemu"
+}
+
+func (qd *QemuDriver) InitContext(homeDir string) DriverContext {
+	return &QemuContext{
+		qmp:         make(chan QmpInteraction, 128),
+		wdt:         make(chan string, 16),
+		qmpSockName: homeDir + QmpSockName,
+		process:     nil,
+	}
+}
+
+func (qd *QemuDriver) LoadContext(homeDir string, persisted map[string]interface{}) (DriverContext, error) {
+	if t, ok := persisted["hypervisor"]; !ok || t != qd.Name() {
+		return nil, errors.New("wrong driver type in persist info")
+	}
+
+	pid, ok := persisted["pid"].(float64)
+	if !ok {
+		return nil, errors.New("cannot read the pid info from persist info")
+	}
+
+	proc, err := os.FindProcess(int(pid))
+	if err != nil {
+		return nil, err
+	}
+
+	qc := qd.InitContext(homeDir).(*QemuContext)
+	qc.process = proc
+	return qc, nil
+}
+
+func (qc *QemuContext) Launch(ctx *VmContext) {
+	go qmpHandler(ctx)
+	go launchQemu(ctx)
+}
+
+func (qc *QemuContext) Associate(ctx *VmContext) {
+	go qmpHandler(ctx)
+	go associateQemu(ctx)
+}
+
+func (qc *QemuContext) Dump() (map[string]interface{}, error) {
+	if qc.process == nil {
+		return nil, errors.New("No process id available")
+	}
+	return map[string]interface{}{
+		"hypervisor": "qemu",
+		"pid":        qc.process.Pid,
+	}, nil
+}
+
+func (qc *QemuContext) AddDisk(ctx *VmContext, name, sourceType, filename, format string, id int) {
+	newDiskAddSession(ctx, name, sourceType, filename, format, id)
+}
+
+func (qc *QemuContext) RemoveDisk(ctx *VmContext, id int, callback QemuEvent) {
+	newDiskDelSession(ctx, id, callback)
+}
+
+func (qc *QemuContext) AddNic(ctx *VmContext, fd uint64, device, mac string, index, addr int) {
+	newNetworkAddSession(ctx, fd, device, mac, index, addr)
+}
+
+func (qc *QemuContext) RemoveNic(ctx *VmContext, device string, callback QemuEvent) {
+	newNetworkDelSession(ctx, device, callback)
+}
+
+func (qc *QemuContext) Query(ctx *VmContext, cmd *QueryCommand) {
+	newQuerySession(ctx, "query-"+cmd.Item, cmd.Callback)
+}
+
+func (qc *QemuContext) Snapshot(ctx *VmContext, path string, callback chan *types.QemuResponse) {
+	newMigrationSession(ctx, "file:"+path, callback)
+}
+
+func (qc *QemuContext) Migrate(ctx *VmContext, uri string) {
+	result := make(chan *types.QemuResponse, 1)
+	newMigrationSession(ctx, uri, result)
+	go func() {
+		rsp := <-result
+		ctx.hub <- &MigrationDoneEvent{Success: rsp.Code == types.E_OK, Cause: rsp.Cause}
+	}()
+}
+
+func (qc *QemuContext) Incoming(ctx *VmContext, uri string) {
+	newIncomingSession(ctx, uri)
+}
+
+func (qc *QemuContext) Pause(ctx *VmContext, pause bool, callback chan *types.QemuResponse) {
+	if pause {
+		newPauseSession(ctx, "stop", callback)
+	} else {
+		newPauseSession(ctx, "cont", callback)
+	}
+}
+
+func (qc *QemuContext) Balloon(ctx *VmContext, memory int, callback chan *types.QemuResponse) {
+	newBalloonSession(ctx, memory, callback)
+}
+
+func (qc *QemuContext) AddCpu(ctx *VmContext, from, to int, callback chan *types.QemuResponse) {
+	newCpuAddSession(ctx, from, to, callback)
+}
+
+func (qc *QemuContext) Shutdown(ctx *VmContext) {
+	qmpQemuQuit(ctx)
+}
+
+func (qc *QemuContext) Kill(ctx *VmContext) {
+	qc.wdt <- "kill"
+}
+
+func (qc *QemuContext) Close() {
+	qc.wdt <- "quit"
+	// the receiver may still report the EOF of qmp socket, do not close
+	// the channel under it
+	qc.qmp <- &QmpQuit{}
+	close(qc.wdt)
+}
+
+func (qc *QemuContext) arguments(ctx *VmContext) []string {
+	if ctx.Boot == nil {
+		ctx.Boot = &BootConfig{
+			CPU:    1,
+			Memory: 128,
+			Kernel: DefaultKernel,
+			Initrd: DefaultInitrd,
+		}
+	}
+	boot := ctx.Boot
+
+	params := []string{
+		"-machine", "pc-i440fx-2.0,accel=kvm,usb=off", "-global", "kvm-pit.lost_tick_policy=discard", "-cpu", "host"}
+	if _, err := os.Stat("/dev/kvm"); os.IsNotExist(err) {
+		glog.V(1).Info("kvm not exist change to no kvm mode")
+		params = []string{"-machine", "pc-i440fx-2.0,usb=off", "-cpu", "core2duo"}
+	}
+
+	if boot.Bios != "" && boot.Cbfs != "" {
+		params = append(params,
+			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Bios),
+			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Cbfs))
+	} else if boot.Bios != "" {
+		params = append(params,
+			"-bios", boot.Bios,
+			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", "\"console=ttyS0 panic=1\"")
+	} else if boot.Cbfs != "" {
+		params = append(params,
+			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Cbfs))
+	} else {
+		params = append(params,
+			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", "\"console=ttyS0 panic=1\"")
+	}
+
+	if boot.Snapshot != "" {
+		params = append(params, "-incoming", "file:"+boot.Snapshot)
+	} else if boot.Incoming {
+		params = append(params, "-incoming", "defer")
+	}
+
+	return append(params,
+		"-realtime", "mlock=off", "-no-user-config", "-nodefaults", "-no-hpet",
+		"-rtc", "base=utc,driftfix=slew", "-no-reboot", "-display", "none", "-boot", "strict=on",
+		"-m", strconv.Itoa(ctx.Boot.Memory), "-smp", fmt.Sprintf("%d,maxcpus=%d", ctx.Boot.CPU, ctx.maxCpu()),
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", qc.qmpSockName), "-serial", fmt.Sprintf("unix:%s,server,nowait", ctx.consoleSockName),
+		"-device", "virtio-serial-pci,id=virtio-serial0,bus=pci.0,addr=0x2", "-device", "virtio-scsi-pci,id=scsi0,bus=pci.0,addr=0x3",
+		"-device", "virtio-balloon-pci,id=balloon0,bus=pci.0,addr=0x4",
+		"-chardev", fmt.Sprintf("socket,id=charch0,path=%s,server,nowait", ctx.hyperSockName),
+		"-device", "virtserialport,bus=virtio-serial0.0,nr=1,chardev=charch0,id=channel0,name=sh.hyper.channel.0",
+		"-chardev", fmt.Sprintf("socket,id=charch1,path=%s,server,nowait", ctx.ttySockName),
+		"-device", "virtserialport,bus=virtio-serial0.0,nr=2,chardev=charch1,id=channel1,name=sh.hyper.channel.1",
+		"-fsdev", fmt.Sprintf("local,id=virtio9p,path=%s,security_model=none", ctx.shareDir),
+		"-device", fmt.Sprintf("virtio-9p-pci,fsdev=virtio9p,mount_tag=%s", ShareDirTag),
+	)
+}
@@ -47,10 +47,27 @@ func waitConsoleOutput(ctx *VmContext) {
 	cout := make(chan string, 128)
 	go ttyLiner(tc, cout)
 
+	clog, err := openConsoleLog(ctx.consoleLogName, ConsoleLogSize)
+	if err != nil {
+		glog.Error("fail to open console log ", ctx.consoleLogName, ": ", err.Error())
+	}
+	defer func() {
+		if clog != nil {
+			clog.Close()
+		}
+	}()
+
 	for {
 		line, ok := <-cout
 		if ok {
 			glog.V(1).Info("[console] ", line)
+			if clog != nil {
+				if err := clog.WriteLine(line); err != nil {
+					glog.Error("fail to write console log: ", err.Error())
+					clog.Close()
+					clog = nil
+				}
+			}
 		} else {
 			glog.Info("console output end")
 			break
@@ -61,14 +78,15 @@ func waitConsoleOutput(ctx *VmContext) {
 func (ctx *VmContext) timedKill(seconds int) {
 	ctx.timer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
 		if ctx != nil && ctx.handler != nil {
-			ctx.wdt <- "kill"
+			ctx.DCtx.Kill(ctx)
 		}
 	})
 }
 
 func watchDog(ctx *VmContext) {
+	qc := qemuContext(ctx)
 	for {
-		msg, ok := <-ctx.wdt
+		msg, ok := <-qc.wdt
 		if ok {
 			switch msg {
 			case "quit":
@@ -76,9 +94,9 @@ func watchDog(ctx *VmContext) {
 				return
 			case "kill":
 				success := false
-				if ctx.process != nil {
-					glog.V(0).Infof("kill Qemu... %d", ctx.process.Pid)
-					if err := ctx.process.Kill(); err == nil {
+				if qc.process != nil {
+					glog.V(0).Infof("kill Qemu... %d", qc.process.Pid)
+					if err := qc.process.Kill(); err == nil {
 						success = true
 					}
 				} else {
@@ -203,12 +221,12 @@ func daemon(cmd string, argv []string, pipe int) error {
 	return nil
 }
 
-func (ctx *VmContext) watchPid(pid int) error {
+func (qc *QemuContext) watchPid(ctx *VmContext, pid int) error {
 	proc, err := os.FindProcess(pid)
 	if err != nil {
 		return err
 	}
-	ctx.process = proc
+	qc.process = proc
 	go watchDog(ctx)
 
 	return nil
@@ -222,7 +240,8 @@ func launchQemu(ctx *VmContext) {
 		return
 	}
 
-	args := ctx.QemuArguments()
+	qc := qemuContext(ctx)
+	args := qc.arguments(ctx)
 
 	if glog.V(1) {
 		glog.Info("cmdline arguments: ", strings.Join(args, " "))
@@ -259,7 +278,7 @@ func launchQemu(ctx *VmContext) {
 	pid := binary.BigEndian.Uint32(buf[:nr])
 	glog.V(1).Infof("starting daemon with pid: %d", pid)
 
-	err = ctx.watchPid(int(pid))
+	err = qc.watchPid(ctx, int(pid))
 	if err != nil {
 		glog.Error("watch qemu process failed")
 		ctx.hub <- &QemuExitEvent{message: "watch qemu process failed"}
@@ -35,9 +35,9 @@ func TestMessageParse(t *testing.T) {
 }
 
 func testQmpInitHelper(t *testing.T, ctx *VmContext) (*net.UnixListener, net.Conn) {
-	t.Log("setup ", ctx.qmpSockName)
+	t.Log("setup ", qemuContext(ctx).qmpSockName)
 
-	ss, err := net.ListenUnix("unix", &net.UnixAddr{ctx.qmpSockName, "unix"})
+	ss, err := net.ListenUnix("unix", &net.UnixAddr{qemuContext(ctx).qmpSockName, "unix"})
 	if err != nil {
 		t.Error("fail to setup connect to qmp socket", err.Error())
 	}
@@ -125,9 +125,9 @@ func TestInitFail(t *testing.T) {
 
 	go qmpHandler(ctx)
 
-	t.Log("setup ", ctx.qmpSockName)
+	t.Log("setup ", qemuContext(ctx).qmpSockName)
 
-	ss, err := net.ListenUnix("unix", &net.UnixAddr{ctx.qmpSockName, "unix"})
+	ss, err := net.ListenUnix("unix", &net.UnixAddr{qemuContext(ctx).qmpSockName, "unix"})
 	if err != nil {
 		t.Error("fail to setup connect to qmp socket", err.Error())
 	}
@@ -213,9 +213,9 @@ func TestQmpInitTimeout(t *testing.T) {
 
 	go qmpHandler(ctx)
 
-	t.Log("connecting to ", ctx.qmpSockName)
+	t.Log("connecting to ", qemuContext(ctx).qmpSockName)
 
-	ss, err := net.ListenUnix("unix", &net.UnixAddr{ctx.qmpSockName, "unix"})
+	ss, err := net.ListenUnix("unix", &net.UnixAddr{qemuContext(ctx).qmpSockName, "unix"})
 	if err != nil {
 		t.Error("fail to setup connect to qmp socket", err.Error())
 	}
@@ -3,6 +3,7 @@ package qemu
 import (
 	"encoding/json"
 	"hyper/lib/glog"
+	"hyper/types"
 	"io"
 	"net"
 	"syscall"
@@ -27,12 +28,15 @@ type QmpInternalError struct{ cause string }
 type QmpSession struct {
 	commands []*QmpCommand
 	callback QemuEvent
+	respond  chan *types.QemuResponse
 }
 
 type QmpFinish struct {
 	success  bool
 	reason   map[string]interface{}
+	result   map[string]interface{}
 	callback QemuEvent
+	respond  chan *types.QemuResponse
 }
 
 type QmpCommand struct {
@@ -69,10 +73,12 @@ func (qmp *QmpQuit) MessageType() int          { return QMP_QUIT }
 func (qmp *QmpTimeout) MessageType() int       { return QMP_TIMEOUT }
 func (qmp *QmpInternalError) MessageType() int { return QMP_INTERNAL_ERROR }
 func (qmp *QmpSession) MessageType() int       { return QMP_SESSION }
-func (qmp *QmpSession) Finish() *QmpFinish {
+func (qmp *QmpSession) Finish(result map[string]interface{}) *QmpFinish {
 	return &QmpFinish{
 		success:  true,
+		result:   result,
 		callback: qmp.callback,
+		respond:  qmp.respond,
 	}
 }
 func (qmp *QmpFinish) MessageType() int { return QMP_FINISH }
@@ -80,11 +86,12 @@ func (qmp *QmpFinish) MessageType() int { return QMP_FINISH }
 func (qmp *QmpResult) MessageType() int { return QMP_RESULT }
 
 func (qmp *QmpError) MessageType() int { return QMP_ERROR }
-func (qmp *QmpError) Finish(callback QemuEvent) *QmpFinish {
+func (qmp *QmpError) Finish(session *QmpSession) *QmpFinish {
 	return &QmpFinish{
 		success:  false,
 		reason:   qmp.Cause,
-		callback: callback,
+		callback: session.callback,
+		respond:  session.respond,
 	}
 }
 
@@ -111,6 +118,10 @@ func (qmp *QmpResponse) UnmarshalJSON(raw []byte) error {
 			msg.Return = map[string]interface{}{
 				"return": r.(string),
 			}
+		case []interface{}:
+			msg.Return = map[string]interface{}{
+				"return": r,
+			}
 		default:
 			err = json.Unmarshal(raw, msg)
 		}
@@ -155,14 +166,15 @@ func qmpReceiver(ch chan QmpInteraction, decoder *json.Decoder) {
 }
 
 func qmpInitializer(ctx *VmContext) {
-	conn, err := unixSocketConnect(ctx.qmpSockName)
+	qc := qemuContext(ctx)
+	conn, err := unixSocketConnect(qc.qmpSockName)
 	if err != nil {
-		glog.Error("failed to connected to ", ctx.qmpSockName, " ", err.Error())
-		ctx.qmp <- qmpFail(err.Error(), nil)
+		glog.Error("failed to connected to ", qc.qmpSockName, " ", err.Error())
+		qc.qmp <- qmpFail(err.Error(), nil)
 		return
 	}
 
-	glog.V(1).Info("connected to ", ctx.qmpSockName)
+	glog.V(1).Info("connected to ", qc.qmpSockName)
 
 	var msg map[string]interface{}
 	decoder := json.NewDecoder(conn)
@@ -177,7 +189,7 @@ func qmpInitializer(ctx *VmContext) {
 	err = decoder.Decode(&msg)
 	if err != nil {
 		glog.Error("get qmp welcome failed: ", err.Error())
-		ctx.qmp <- qmpFail(err.Error(), nil)
+		qc.qmp <- qmpFail(err.Error(), nil)
 		return
 	}
 
@@ -186,13 +198,13 @@ func qmpInitializer(ctx *VmContext) {
 	cmd, err := json.Marshal(QmpCommand{Execute: "qmp_capabilities"})
 	if err != nil {
 		glog.Error("qmp_capabilities marshal failed ", err.Error())
-		ctx.qmp <- qmpFail(err.Error(), nil)
+		qc.qmp <- qmpFail(err.Error(), nil)
 		return
 	}
 	_, err = conn.Write(cmd)
 	if err != nil {
 		glog.Error("qmp_capabilities send failed ", err.Error())
-		ctx.qmp <- qmpFail(err.Error(), nil)
+		qc.qmp <- qmpFail(err.Error(), nil)
 		return
 	}
 
@@ -201,7 +213,7 @@ func qmpInitializer(ctx *VmContext) {
 	err = decoder.Decode(rsp)
 	if err != nil {
 		glog.Error("response receive failed ", err.Error())
-		ctx.qmp <- qmpFail(err.Error(), nil)
+		qc.qmp <- qmpFail(err.Error(), nil)
 		return
 	}
 
@@ -209,22 +221,25 @@ func qmpInitializer(ctx *VmContext) {
 
 	if rsp.msg.MessageType() == QMP_RESULT {
 		glog.Info("QMP connection initialized")
-		ctx.qmp <- &QmpInit{
+		qc.qmp <- &QmpInit{
 			conn:    conn.(*net.UnixConn),
 			decoder: decoder,
 		}
 		return
 	}
 
-	ctx.qmp <- qmpFail("handshake failed", nil)
+	qc.qmp <- qmpFail("handshake failed", nil)
 }
 
 func qmpCommander(handler chan QmpInteraction, conn *net.UnixConn, session *QmpSession, feedback chan QmpInteraction) {
 	glog.V(1).Info("Begin process command session")
+	var result map[string]interface{} = nil
 	for _, cmd := range session.commands {
 		msg, err := json.Marshal(*cmd)
 		if err != nil {
-			handler <- qmpFail("cannot marshal command", session.callback)
+			fail := qmpFail("cannot marshal command", session.callback)
+			fail.respond = session.respond
+			handler <- fail
 			return
 		}
 
@@ -250,6 +265,7 @@ func qmpCommander(handler chan QmpInteraction, conn *net.UnixConn, session *QmpS
 			switch res.MessageType() {
 			case QMP_RESULT:
 				success = true
+				result = res.(*QmpResult).Return
 				break
 			//success
 			case QMP_ERROR:
@@ -263,21 +279,45 @@ func qmpCommander(handler chan QmpInteraction, conn *net.UnixConn, session *QmpS
 		}
 
 		if !success {
-			handler <- qe.Finish(session.callback)
+			handler <- qe.Finish(session)
 			return
 		}
 	}
-	handler <- session.Finish()
+	handler <- session.Finish(result)
 	return
 }
 
+func qmpRespond(vmId string, r *QmpFinish) {
+	if r.success {
+		r.respond <- &types.QemuResponse{
+			VmId: vmId,
+			Code: types.E_OK,
+			Data: r.result,
+		}
+		return
+	}
+	reason := "unknown"
+	if c, ok := r.reason["error"].(string); ok {
+		reason = c
+	} else if c, ok := r.reason["desc"].(string); ok {
+		reason = c
+	}
+	glog.Error("QMP query failed ", reason)
+	r.respond <- &types.QemuResponse{
+		VmId:  vmId,
+		Code:  types.E_FAILED,
+		Cause: reason,
+	}
+}
+
 func qmpHandler(ctx *VmContext) {
+	qc := qemuContext(ctx)
 
 	go qmpInitializer(ctx)
 
 	timer := time.AfterFunc(10*time.Second, func() {
 		glog.Warning("Initializer Timeout.")
-		ctx.qmp <- &QmpTimeout{}
+		qc.qmp <- &QmpTimeout{}
 	})
 
 	type msgHandler func(QmpInteraction)
@@ -293,12 +333,14 @@ func qmpHandler(ctx *VmContext) {
 			glog.Info("got new session")
 			buf = append(buf, msg.(*QmpSession))
 			if len(buf) == 1 {
-				go qmpCommander(ctx.qmp, conn, msg.(*QmpSession), res)
+				go qmpCommander(qc.qmp, conn, msg.(*QmpSession), res)
 			}
 		case QMP_FINISH:
 			glog.Infof("session finished, buffer size %d", len(buf))
 			r := msg.(*QmpFinish)
-			if r.success {
+			if r.respond != nil {
+				qmpRespond(ctx.Id, r)
+			} else if r.success {
 				glog.V(1).Info("success ")
 				if r.callback != nil {
 					ctx.hub <- r.callback
@@ -315,7 +357,7 @@ func qmpHandler(ctx *VmContext) {
 			}
 			buf = buf[1:]
 			if len(buf) > 0 {
-				go qmpCommander(ctx.qmp, conn, buf[0], res)
+				go qmpCommander(qc.qmp, conn, buf[0], res)
 			}
 		case QMP_RESULT, QMP_ERROR:
 			res <- msg
@@ -346,9 +388,9 @@ func qmpHandler(ctx *VmContext) {
 			glog.Info("QMP initialzed, go into main QMP loop")
 
 			//routine for get message
-			go qmpReceiver(ctx.qmp, init.decoder)
+			go qmpReceiver(qc.qmp, init.decoder)
 			if len(buf) > 0 {
-				go qmpCommander(ctx.qmp, conn, buf[0], res)
+				go qmpCommander(qc.qmp, conn, buf[0], res)
 			}
 		case QMP_FINISH:
 			finish := msg.(*QmpFinish)
@@ -366,6 +408,8 @@ func qmpHandler(ctx *VmContext) {
 			}
 			handler = nil
 			glog.Error("QMP initialize timeout")
+		case QMP_QUIT:
+			handler = nil
 		case QMP_SESSION:
 			glog.Info("got new session during initializing")
 			buf = append(buf, msg.(*QmpSession))
@@ -375,7 +419,7 @@ func qmpHandler(ctx *VmContext) {
 	handler = initializing
 
 	for handler != nil {
-		msg, ok := <-ctx.qmp
+		msg, ok := <-qc.qmp
 		if !ok {
 			glog.Info("QMP channel closed, Quit qmp handler")
 			break
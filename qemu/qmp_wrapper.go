@@ -3,15 +3,180 @@ package qemu
 import (
 	"fmt"
 	"hyper/lib/glog"
+	"hyper/types"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 func qmpQemuQuit(ctx *VmContext) {
 	commands := []*QmpCommand{
 		&QmpCommand{Execute: "quit", Arguments: map[string]interface{}{}},
 	}
-	ctx.qmp <- &QmpSession{commands: commands, callback: nil}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, callback: nil}
+}
+
+func newQuerySession(ctx *VmContext, execute string, respond chan *types.QemuResponse) {
+	commands := []*QmpCommand{
+		&QmpCommand{Execute: execute, Arguments: map[string]interface{}{}},
+	}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, respond: respond}
+}
+
+// newPauseSession stops or continues the vcpus of the VM
+func newPauseSession(ctx *VmContext, execute string, respond chan *types.QemuResponse) {
+	commands := []*QmpCommand{
+		&QmpCommand{Execute: execute, Arguments: map[string]interface{}{}},
+	}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, respond: respond}
+}
+
+// newMigrationSession migrates the VM to uri, and then polls the migration
+// status until it finished.
+func newMigrationSession(ctx *VmContext, uri string, respond chan *types.QemuResponse) {
+	result := make(chan *types.QemuResponse, 1)
+	commands := []*QmpCommand{
+		&QmpCommand{
+			Execute:   "migrate_set_speed",
+			Arguments: map[string]interface{}{"value": MigrationSpeed},
+		},
+		&QmpCommand{
+			Execute:   "migrate",
+			Arguments: map[string]interface{}{"uri": uri},
+		},
+	}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, respond: result}
+	go waitMigration(ctx, result, respond)
+}
+
+func waitMigration(ctx *VmContext, result, respond chan *types.QemuResponse) {
+	timeout := time.After(MigrationTimeout * time.Second)
+	for {
+		var rsp *types.QemuResponse
+		select {
+		case rsp = <-result:
+		case <-timeout:
+			respond <- &types.QemuResponse{
+				VmId:  ctx.Id,
+				Code:  types.E_FAILED,
+				Cause: "migration timeout",
+			}
+			return
+		}
+		if rsp.Code != types.E_OK {
+			respond <- rsp
+			return
+		}
+
+		status := ""
+		if info, ok := rsp.Data.(map[string]interface{}); ok {
+			status, _ = info["status"].(string)
+		}
+		switch status {
+		case "completed":
+			glog.Infof("migration of %s finished", ctx.Id)
+			respond <- &types.QemuResponse{
+				VmId: ctx.Id,
+				Code: types.E_OK,
+			}
+			return
+		case "failed", "cancelled":
+			respond <- &types.QemuResponse{
+				VmId:  ctx.Id,
+				Code:  types.E_FAILED,
+				Cause: "migration " + status,
+			}
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		ctx.hub <- &QueryCommand{Item: "migrate", Callback: result}
+	}
+}
+
+// newBalloonSession sets the balloon target, and then polls the balloon
+// until the guest reaches the target or timeout.
+func newBalloonSession(ctx *VmContext, memory int, respond chan *types.QemuResponse) {
+	result := make(chan *types.QemuResponse, 1)
+	commands := []*QmpCommand{
+		&QmpCommand{
+			Execute:   "balloon",
+			Arguments: map[string]interface{}{"value": int64(memory) << 20},
+		},
+		&QmpCommand{Execute: "query-balloon", Arguments: map[string]interface{}{}},
+	}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, respond: result}
+	go waitBalloon(ctx, memory, result, respond)
+}
+
+func waitBalloon(ctx *VmContext, memory int, result, respond chan *types.QemuResponse) {
+	timeout := time.After(BalloonTimeout * time.Second)
+	actual := 0
+	for {
+		var rsp *types.QemuResponse
+		select {
+		case rsp = <-result:
+		case <-timeout:
+			// the guest may not be able to release so much memory, report
+			// the size it has got
+			glog.Warningf("balloon of %s timeout, target %d MB, actual %d MB", ctx.Id, memory, actual)
+			respond <- &types.QemuResponse{
+				VmId:  ctx.Id,
+				Code:  types.E_OK,
+				Cause: "Resize POD timeout",
+				Data:  actual,
+			}
+			return
+		}
+		if rsp.Code != types.E_OK {
+			respond <- rsp
+			return
+		}
+
+		if info, ok := rsp.Data.(map[string]interface{}); ok {
+			if size, ok := info["actual"].(float64); ok {
+				actual = int(int64(size) >> 20)
+			}
+		}
+		if actual == memory {
+			break
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		ctx.hub <- &QueryCommand{Item: "balloon", Callback: result}
+	}
+
+	glog.Infof("balloon of %s finished, memory is %d MB", ctx.Id, actual)
+	respond <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_OK,
+		Cause: "Resize POD success",
+		Data:  actual,
+	}
+}
+
+// newCpuAddSession plugs the vcpus with id in [from, to)
+func newCpuAddSession(ctx *VmContext, from, to int, respond chan *types.QemuResponse) {
+	commands := []*QmpCommand{}
+	for id := from; id < to; id++ {
+		commands = append(commands, &QmpCommand{
+			Execute:   "cpu-add",
+			Arguments: map[string]interface{}{"id": id},
+		})
+	}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, respond: respond}
+}
+
+// newIncomingSession starts receiving the migration on uri, the VM should
+// be launched with "-incoming defer"
+func newIncomingSession(ctx *VmContext, uri string) {
+	commands := []*QmpCommand{
+		&QmpCommand{
+			Execute:   "migrate-incoming",
+			Arguments: map[string]interface{}{"uri": uri},
+		},
+	}
+	qemuContext(ctx).qmp <- &QmpSession{commands: commands, callback: &IncomingReadyEvent{}}
 }
 
 func scsiId2Name(id int) string {
@@ -39,7 +204,7 @@ func newDiskAddSession(ctx *VmContext, name, sourceType, filename, format string
 		},
 	}
 	devName := scsiId2Name(id)
-	ctx.qmp <- &QmpSession{
+	qemuContext(ctx).qmp <- &QmpSession{
 		commands: commands,
 		callback: &BlockdevInsertedEvent{
 			Name:       name,
@@ -64,7 +229,7 @@ func newDiskDelSession(ctx *VmContext, id int, callback QemuEvent) {
 			"command-line": fmt.Sprintf("drive_del drive%d", id),
 		},
 	}
-	ctx.qmp <- &QmpSession{
+	qemuContext(ctx).qmp <- &QmpSession{
 		commands: commands,
 		callback: callback,
 	}
@@ -100,7 +265,7 @@ func newNetworkAddSession(ctx *VmContext, fd uint64, device, mac string, index,
 		},
 	}
 
-	ctx.qmp <- &QmpSession{
+	qemuContext(ctx).qmp <- &QmpSession{
 		commands: commands,
 		callback: &NetDevInsertedEvent{
 			Index:      index,
@@ -125,7 +290,7 @@ func newNetworkDelSession(ctx *VmContext, device string, callback QemuEvent) {
 		},
 	}
 
-	ctx.qmp <- &QmpSession{
+	qemuContext(ctx).qmp <- &QmpSession{
 		commands: commands,
 		callback: callback,
 	}
@@ -25,6 +25,11 @@ type ttyAttachments struct {
 	container   int
 	persistent  bool
 	attachments []*TtyIO
+	// the session of a command without tty gets its exit code after closed
+	expectExit bool
+	closed     bool
+	exited     bool
+	exitCode   int
 }
 
 type pseudoTtys struct {
@@ -136,9 +141,20 @@ func waitPts(ctx *VmContext) {
 			return
 		}
 		if ta, ok := ctx.ptys.ttys[res.session]; ok {
-			if len(res.message) == 0 {
+			if len(res.message) == 0 && ta.expectExit && !ta.closed {
+				glog.V(1).Infof("session %d closed by peer, wait for the exit code", res.session)
+				ta.closed = true
+			} else if len(res.message) == 0 {
 				glog.V(1).Infof("session %d closed by peer, close pty", res.session)
 				ctx.ptys.Close(ctx, res.session)
+			} else if ta.closed {
+				ta.exited = true
+				ta.exitCode = 255
+				if len(res.message) == 4 {
+					ta.exitCode = int(int32(binary.BigEndian.Uint32(res.message)))
+				}
+				glog.V(1).Infof("session %d exited with %d, close pty", res.session, ta.exitCode)
+				ctx.ptys.Close(ctx, res.session)
 			} else {
 				for _, tty := range ta.attachments {
 					if tty.Stdout != nil {
@@ -190,9 +206,13 @@ func (ta *ttyAttachments) detach(tty *TtyIO) {
 }
 
 func (ta *ttyAttachments) close() []string {
+	var data interface{}
+	if ta.exited {
+		data = ta.exitCode
+	}
 	tags := []string{}
 	for _, t := range ta.attachments {
-		tags = append(tags, t.Close())
+		tags = append(tags, t.close(data))
 	}
 	ta.attachments = []*TtyIO{}
 	return tags
@@ -203,6 +223,12 @@ func (ta *ttyAttachments) empty() bool {
 }
 
 func (tty *TtyIO) Close() string {
+	return tty.close(nil)
+}
+
+// close closes the streams and reports to the callback with data, the exit
+// code of the command if known.
+func (tty *TtyIO) close(data interface{}) string {
 	if tty.Stdin != nil {
 		tty.Stdin.Close()
 	}
@@ -213,6 +239,7 @@ func (tty *TtyIO) Close() string {
 		tty.Callback <- &types.QemuResponse{
 			Code:  types.E_EXEC_FINISH,
 			Cause: "Command finished",
+			Data:  data,
 		}
 	}
 	return tty.ClientTag
@@ -242,27 +269,52 @@ func (pts *pseudoTtys) Close(ctx *VmContext, session uint64) {
 	}
 }
 
+// exitSession sets up the session of a command without tty, it waits for
+// the exit code of the command after closed by the init.
+func (pts *pseudoTtys) exitSession(container int, session uint64) {
+	ta := newAttachments(container, false)
+	ta.expectExit = true
+	pts.lock.Lock()
+	pts.ttys[session] = ta
+	pts.lock.Unlock()
+}
+
 func (pts *pseudoTtys) ptyConnect(ctx *VmContext, container int, session uint64, tty *TtyIO) {
 
 	pts.lock.Lock()
-	if ta, ok := pts.ttys[session]; ok {
+	ta, ok := pts.ttys[session]
+	if ok {
 		ta.attach(tty)
 	} else {
-		pts.ttys[session] = newAttachmentsWithTty(container, false, tty)
+		ta = newAttachmentsWithTty(container, false, tty)
+		pts.ttys[session] = ta
 	}
 	pts.lock.Unlock()
 
 	if tty.Stdin != nil {
 		go func() {
 			buf := make([]byte, 32)
-			defer pts.Detach(ctx, session, tty)
+			detach := true
+			defer func() {
+				if detach {
+					pts.Detach(ctx, session, tty)
+				}
+			}()
 			defer func() { recover() }()
 			for {
 				nr, err := tty.Stdin.Read(buf)
 				if err != nil {
 					glog.Info("a stdin closed, ", err.Error())
+					if ta.expectExit {
+						// the command keeps running, just close its stdin
+						detach = false
+						pts.channel <- &ttyMessage{
+							session: session,
+							message: []byte{},
+						}
+					}
 					return
-				} else if nr == 1 && buf[0] == ExitChar {
+				} else if nr == 1 && buf[0] == ExitChar && !ta.expectExit {
 					glog.Info("got stdin detach char, exit term")
 					return
 				}
@@ -13,7 +13,6 @@ func (ctx *VmContext) onQemuExit(reclaim bool) bool {
 	ctx.reportVmShutdown()
 	ctx.setTimeout(60)
 
-	ctx.wdt <- "quit"
 	if reclaim {
 		ctx.reclaimDevice()
 	}
@@ -45,7 +44,7 @@ func (ctx *VmContext) detatchDevice() {
 
 func (ctx *VmContext) prepareDevice(cmd *RunPodCommand) bool {
 
-	if len(cmd.Spec.Containers) != len(cmd.Containers) {
+	if len(cmd.Spec.InitContainers)+len(cmd.Spec.Containers) != len(cmd.Containers) {
 		ctx.reportBadRequest("Spec and Container Info mismatch")
 		return false
 	}
@@ -88,6 +87,9 @@ func (ctx *VmContext) setWindowSize(tag string, size *WindowSize) {
 
 func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 	cmd.Sequence = ctx.nextAttachId()
+	if cmd.ErrStreams != nil {
+		cmd.Stderr = ctx.nextAttachId()
+	}
 	pkg, err := json.Marshal(*cmd)
 	if err != nil {
 		cmd.Streams.Callback <- &types.QemuResponse{
@@ -96,7 +98,12 @@ func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 		}
 		return
 	}
-	ctx.ptys.ptyConnect(ctx, ctx.Lookup(cmd.Container), cmd.Sequence, cmd.Streams)
+	idx := ctx.Lookup(cmd.Container)
+	if cmd.ErrStreams != nil {
+		ctx.ptys.exitSession(idx, cmd.Sequence)
+		ctx.ptys.ptyConnect(ctx, idx, cmd.Stderr, cmd.ErrStreams)
+	}
+	ctx.ptys.ptyConnect(ctx, idx, cmd.Sequence, cmd.Streams)
 	ctx.clientReg(cmd.Streams.ClientTag, cmd.Sequence)
 	ctx.vm <- &DecodedMessage{
 		code:    INIT_EXECCMD,
@@ -125,8 +132,17 @@ func (ctx *VmContext) attachCmd(cmd *AttachCommand) {
 	}
 }
 
+// startPod starts the next init container alone, or the other containers
+// once all the init containers finished.
 func (ctx *VmContext) startPod() {
-	pod, err := json.Marshal(*ctx.vmSpec)
+	spec := *ctx.vmSpec
+	if ctx.initRunning() {
+		n := len(ctx.initResults)
+		spec.Containers = spec.Containers[n : n+1]
+	} else {
+		spec.Containers = spec.Containers[ctx.initContainers:]
+	}
+	pod, err := json.Marshal(spec)
 	if err != nil {
 		ctx.hub <- &InitFailedEvent{
 			reason: "Generated wrong run profile " + err.Error(),
@@ -139,6 +155,39 @@ func (ctx *VmContext) startPod() {
 	}
 }
 
+// initRunning tells whether some init containers of the pod are not
+// finished yet
+func (ctx *VmContext) initRunning() bool {
+	return len(ctx.initResults) < ctx.initContainers
+}
+
+// initContainerFinished records the exit code of the init container, the
+// pod finishes as soon as one of them fails, otherwise the init cleans the
+// finished one up before the next one starts.
+func (ctx *VmContext) initContainerFinished(result *PodFinished) {
+	if !ctx.initRunning() {
+		glog.Warning("got pod finished before the pod started")
+		return
+	}
+	if len(result.result) == 0 {
+		ctx.shutdownVM(true, "No exit code of the init container")
+		ctx.Become(stateTerminating, "TERMINATING")
+		return
+	}
+
+	c := ctx.vmSpec.Containers[len(ctx.initResults)]
+	ctx.initResults = append(ctx.initResults, result.result[0])
+	if result.result[0] != 0 {
+		glog.Errorf("init container %s exited with %d", c.Id, result.result[0])
+		ctx.reportPodFinished(&PodFinished{result: ctx.initResults})
+		ctx.shutdownVM(false, "")
+		ctx.Become(stateTerminating, "TERMINATING")
+		return
+	}
+	glog.Infof("init container %s finished", c.Id)
+	ctx.stopPod()
+}
+
 func (ctx *VmContext) stopPod() {
 	ctx.setTimeout(30)
 	ctx.vm <- &DecodedMessage{
@@ -160,6 +209,8 @@ func (ctx *VmContext) exitVM(err bool, msg string, hasPod, wait bool) {
 }
 
 func (ctx *VmContext) shutdownVM(err bool, msg string) {
+	ctx.replyNewContainer(types.E_FAILED, "the pod is shutting down")
+	ctx.replyMigrate(types.E_FAILED, "the pod is shutting down")
 	if err {
 		ctx.reportVmFault(msg)
 		glog.Error("Shutting down because of an exception: ", msg)
@@ -169,14 +220,54 @@ func (ctx *VmContext) shutdownVM(err bool, msg string) {
 }
 
 func (ctx *VmContext) poweroffVM(err bool, msg string) {
+	ctx.replyNewContainer(types.E_FAILED, "the pod is shutting down")
+	ctx.replyMigrate(types.E_FAILED, "the pod is shutting down")
 	if err {
 		ctx.reportVmFault(msg)
 		glog.Error("Shutting down because of an exception: ", msg)
 	}
-	qmpQemuQuit(ctx)
+	ctx.DCtx.Shutdown(ctx)
 	ctx.timedKill(10)
 }
 
+// rejectPodCommand answers the commands to the running pod, which could not
+// be handled in the current state. It returns false for the other events.
+func (ctx *VmContext) rejectPodCommand(ev QemuEvent) bool {
+	switch ev.Event() {
+	case COMMAND_NEW_CONTAINER:
+		ctx.replyBadContainer(ev.(*NewContainerCommand), "the pod is not running, can not add container to it")
+	case COMMAND_MIGRATE:
+		ctx.replyBadMigrate(ev.(*MigrateCommand), "the pod is not running, can not migrate it")
+	case COMMAND_PAUSE:
+		ctx.replyBadPause(ev.(*PauseCommand).Callback, "the pod is not running, can not pause it")
+	case COMMAND_UNPAUSE:
+		ctx.replyBadPause(ev.(*UnpauseCommand).Callback, "the pod is not paused")
+	case EVENT_PAUSE_DONE:
+		// the pod left running while the VM was being paused
+		if done := ev.(*PauseDoneEvent); done.Paused && done.Reply.Code == types.E_OK {
+			ctx.DCtx.Pause(ctx, false, make(chan *types.QemuResponse, 1))
+		}
+		ctx.replyPause(&types.QemuResponse{Code: types.E_FAILED, Cause: "the pod is not running"})
+	case COMMAND_RESIZE:
+		ctx.replyBadResize(ev.(*ResizeCommand).Callback, "the pod is not running, can not resize it")
+	case EVENT_RESIZED:
+		// the balloon is set, whatever the pod is doing
+		ctx.replyResize(ev.(*ResizedEvent).Reply)
+	case COMMAND_ADD_CPU:
+		ctx.replyBadCpuAdd(ev.(*CpuAddCommand).Callback, "the pod is not running, can not add cpu to it")
+	case EVENT_CPU_ADDED:
+		// the init could not bring the vcpus online any more
+		ctx.replyCpuAdd(types.E_FAILED, "the pod is not running")
+	case COMMAND_ATTACH_VOLUME:
+		ctx.replyBadVolume(ev.(*VolumeAttachCommand).Callback, "the pod is not running, can not attach volume to it")
+	case COMMAND_DETACH_VOLUME:
+		ctx.replyBadVolume(ev.(*VolumeDetachCommand).Callback, "the pod is not running, can not detach volume from it")
+	default:
+		return false
+	}
+	return true
+}
+
 // state machine
 func commonStateHandler(ctx *VmContext, ev QemuEvent, hasPod bool) bool {
 	processed := true
@@ -212,7 +303,7 @@ func deviceInitHandler(ctx *VmContext, ev QemuEvent) bool {
 	case EVENT_INTERFACE_ADD:
 		info := ev.(*InterfaceCreated)
 		ctx.interfaceCreated(info)
-		newNetworkAddSession(ctx, uint64(info.Fd.Fd()), info.DeviceName, info.MacAddr, info.Index, info.PCIAddr)
+		ctx.DCtx.AddNic(ctx, uint64(info.Fd.Fd()), info.DeviceName, info.MacAddr, info.Index, info.PCIAddr)
 	case EVENT_INTERFACE_INSERTED:
 		info := ev.(*NetDevInsertedEvent)
 		ctx.netdevInserted(info)
@@ -227,7 +318,15 @@ func deviceRemoveHandler(ctx *VmContext, ev QemuEvent) (bool, bool) {
 	success := true
 	switch ev.Event() {
 	case EVENT_CONTAINER_DELETE:
-		success = ctx.onContainerRemoved(ev.(*ContainerUnmounted))
+		c := ev.(*ContainerUnmounted)
+		if _, ok := ctx.progress.deleting.containers[c.Index]; !ok {
+			// the dir rootfs is umounted as both overlay and aufs, both
+			// report it, ignore the late one which may come after reset
+			glog.V(1).Infof("container %d has already been removed", c.Index)
+			processed = false
+			break
+		}
+		success = ctx.onContainerRemoved(c)
 		glog.V(1).Info("Unplug container return with ", success)
 	case EVENT_INTERFACE_DELETE:
 		success = ctx.onInterfaceRemoved(ev.(*InterfaceReleased))
@@ -250,7 +349,7 @@ func deviceRemoveHandler(ctx *VmContext, ev QemuEvent) (bool, bool) {
 		}
 
 		glog.V(1).Infof("release %d interface: %s", n.Index, nic.IpAddr)
-		go ReleaseInterface(n.Index, nic.IpAddr, nic.Fd, maps, ctx.hub)
+		go ReleaseInterface(n.Index, nic.Bridge, nic.IpAddr, nic.Ip6Addr, nic.Fd, nicPortMaps(n.Index, maps), ctx.hub)
 	default:
 		processed = false
 	}
@@ -297,6 +396,11 @@ func stateInit(ctx *VmContext, ev QemuEvent) {
 			ctx.reportVmShutdown()
 		case COMMAND_EXEC:
 			ctx.execCmd(ev.(*ExecCommand))
+		case COMMAND_QUERY:
+			ctx.DCtx.Query(ctx, ev.(*QueryCommand))
+		case COMMAND_SNAPSHOT:
+			cmd := ev.(*SnapshotCommand)
+			ctx.DCtx.Snapshot(ctx, cmd.Path, cmd.Callback)
 		case COMMAND_WINDOWSIZE:
 			cmd := ev.(*WindowSizeCommand)
 			ctx.setWindowSize(cmd.ClientTag, cmd.Size)
@@ -307,7 +411,9 @@ func stateInit(ctx *VmContext, ev QemuEvent) {
 				ctx.Become(stateStarting, "STARTING")
 			}
 		default:
-			glog.Warning("got event during pod initiating")
+			if !ctx.rejectPodCommand(ev) {
+				glog.Warning("got event during pod initiating")
+			}
 		}
 	}
 }
@@ -346,47 +452,57 @@ func stateStarting(ctx *VmContext, ev QemuEvent) {
 		case COMMAND_ACK:
 			ack := ev.(*CommandAck)
 			glog.V(1).Infof("[starting] got init ack to %d", ack.reply)
-			if ack.reply == INIT_STARTPOD {
+			if ack.reply == INIT_STARTPOD && ctx.initRunning() {
+				// the init container may run as long as it needs
 				ctx.unsetTimeout()
-				var pinfo []byte = []byte{}
-				persist, err := ctx.dump()
-				if err == nil {
-					buf, err := persist.serialize()
-					if err == nil {
-						pinfo = buf
-					}
-				}
-				ctx.reportSuccess("Start POD success", pinfo)
+				glog.Infof("init container %d of the pod started", len(ctx.initResults))
+			} else if ack.reply == INIT_STOPPOD {
+				ctx.unsetTimeout()
+				ctx.setTimeout(60)
+				ctx.startPod()
+			} else if ack.reply == INIT_STARTPOD {
+				ctx.unsetTimeout()
+				ctx.reportSuccess("Start POD success", ctx.persistData())
 				ctx.Become(stateRunning, "RUNNING")
 				glog.Info("pod start success ", string(ack.msg))
 			}
 		case ERROR_CMD_FAIL:
 			ack := ev.(*CommandError)
-			if ack.context.code == INIT_STARTPOD {
+			if ack.context.code == INIT_STARTPOD || ack.context.code == INIT_STOPPOD {
 				reason := "Start POD failed"
 				ctx.shutdownVM(true, reason)
 				ctx.Become(stateTerminating, "TERMINATING")
 				glog.Error(reason)
 			}
+		case EVENT_POD_FINISH:
+			ctx.initContainerFinished(ev.(*PodFinished))
 		case EVENT_QEMU_TIMEOUT:
 			reason := "Start POD timeout"
 			ctx.shutdownVM(true, reason)
 			ctx.Become(stateTerminating, "TERMINATING")
 			glog.Error(reason)
 		default:
-			glog.Warning("got event during pod initiating")
+			if !ctx.rejectPodCommand(ev) {
+				glog.Warning("got event during pod initiating")
+			}
 		}
 	}
 }
 
 func stateRunning(ctx *VmContext, ev QemuEvent) {
 	if processed := commonStateHandler(ctx, ev, true); processed {
+	} else if ev.Event() == ERROR_QMP_FAIL && ctx.newContainerFailed(ev.(*DeviceFailed)) {
+		// only the container being added failed, the pod keeps running
+	} else if ev.Event() == ERROR_QMP_FAIL && ctx.attachVolumeFailed(ev.(*DeviceFailed)) {
+		// only the volume being attached failed, the pod keeps running
 	} else if processed := initFailureHandler(ctx, ev); processed {
 		ctx.shutdownVM(true, "Fail during reconnect to a running pod")
 		ctx.Become(stateTerminating, "TERMINATING")
 	} else {
 		switch ev.Event() {
 		case COMMAND_STOP_POD:
+			// the init would not answer the volume mounts any more
+			ctx.replyVolume(types.E_FAILED, "the pod is stopped")
 			ctx.stopPod()
 			ctx.Become(statePodStopping, "STOPPING")
 		case COMMAND_RELEASE:
@@ -397,6 +513,43 @@ func stateRunning(ctx *VmContext, ev QemuEvent) {
 			ctx.execCmd(ev.(*ExecCommand))
 		case COMMAND_ATTACH:
 			ctx.attachCmd(ev.(*AttachCommand))
+		case COMMAND_QUERY:
+			ctx.DCtx.Query(ctx, ev.(*QueryCommand))
+		case COMMAND_MIGRATE:
+			ctx.migrateVm(ev.(*MigrateCommand))
+		case EVENT_MIGRATION_DONE:
+			ctx.onMigrated(ev.(*MigrationDoneEvent))
+		case COMMAND_PAUSE:
+			ctx.pauseVm(true, ev.(*PauseCommand).Callback)
+		case COMMAND_RESIZE:
+			ctx.resizeVm(ev.(*ResizeCommand))
+		case EVENT_RESIZED:
+			ctx.replyResize(ev.(*ResizedEvent).Reply)
+		case COMMAND_ADD_CPU:
+			ctx.addCpu(ev.(*CpuAddCommand))
+		case EVENT_CPU_ADDED:
+			ctx.onCpuAdded(ev.(*CpuAddedEvent))
+		case COMMAND_NEW_CONTAINER:
+			ctx.addContainer(ev.(*NewContainerCommand))
+		case COMMAND_ATTACH_VOLUME:
+			ctx.attachVolume(ev.(*VolumeAttachCommand))
+		case COMMAND_DETACH_VOLUME:
+			ctx.detachVolume(ev.(*VolumeDetachCommand))
+		case EVENT_BLOCK_INSERTED:
+			info := ev.(*BlockdevInsertedEvent)
+			ctx.blockdevInserted(info)
+			ctx.onNewContainerBlockdev(info)
+			ctx.onVolumeBlockdev(info)
+		case EVENT_BLOCK_EJECTED:
+			ctx.onVolumeReleased(ev.(*VolumeUnmounted))
+		case EVENT_VOLUME_DELETE:
+			ctx.onBlockReleased(ev.(*BlockdevRemovedEvent))
+		case EVENT_CONTAINER_DELETE:
+			glog.V(1).Infof("rootfs of the dropped container %d ejected", ev.(*ContainerUnmounted).Index)
+		case COMMAND_UNPAUSE:
+			ctx.replyBadPause(ev.(*UnpauseCommand).Callback, "the pod is not paused")
+		case EVENT_PAUSE_DONE:
+			ctx.onPauseDone(ev.(*PauseDoneEvent))
 		case COMMAND_WINDOWSIZE:
 			cmd := ev.(*WindowSizeCommand)
 			if ctx.userSpec.Tty {
@@ -404,17 +557,42 @@ func stateRunning(ctx *VmContext, ev QemuEvent) {
 			}
 		case EVENT_POD_FINISH:
 			result := ev.(*PodFinished)
+			if ctx.initContainers > 0 {
+				// the exit codes of the init containers go first, like
+				// the containers in the spec
+				codes := append([]uint32{}, ctx.initResults...)
+				result = &PodFinished{result: append(codes, result.result...)}
+			}
 			ctx.reportPodFinished(result)
 			ctx.shutdownVM(false, "")
 			ctx.Become(stateTerminating, "TERMINATING")
 		case COMMAND_ACK:
 			ack := ev.(*CommandAck)
 			glog.V(1).Infof("[running] got init ack to %d", ack.reply)
+			if ack.reply == INIT_ONLINECPUMEM {
+				ctx.replyCpuAdd(types.E_OK, "Add CPU success")
+			} else if ack.reply == INIT_NEWCONTAINER {
+				ctx.replyNewContainer(types.E_OK, "Add container success")
+			} else if ack.reply == INIT_MOUNTVOLUME || ack.reply == INIT_UMOUNTVOLUME {
+				ctx.onVolumeMounted()
+			}
 		case ERROR_CMD_FAIL:
 			ack := ev.(*CommandError)
-			if ack.context.code == INIT_EXECCMD {
+			if ack.context.code == INIT_ONLINECPUMEM {
+				ctx.replyCpuAdd(types.E_FAILED, "init failed to online the cpus: "+string(ack.msg))
+			} else if ack.context.code == INIT_NEWCONTAINER {
+				ctx.dropNewContainer()
+				ctx.replyNewContainer(types.E_FAILED, "init failed to start the container: "+string(ack.msg))
+			} else if ack.context.code == INIT_MOUNTVOLUME {
+				ctx.onVolumeCmdFail("init failed to mount the volume: " + string(ack.msg))
+			} else if ack.context.code == INIT_UMOUNTVOLUME {
+				ctx.onVolumeCmdFail("init failed to unmount the volume: " + string(ack.msg))
+			} else if ack.context.code == INIT_EXECCMD {
 				cmd := ExecCommand{}
 				json.Unmarshal(ack.context.message, &cmd)
+				if cmd.Stderr != 0 {
+					ctx.ptys.Close(ctx, cmd.Stderr)
+				}
 				ctx.ptys.Close(ctx, cmd.Sequence)
 				glog.V(0).Infof("Exec command %s on session %d failed", cmd.Command[0], cmd.Sequence)
 			}
@@ -424,6 +602,239 @@ func stateRunning(ctx *VmContext, ev QemuEvent) {
 	}
 }
 
+// statePaused holds a pod whose VM is frozen. The init could not reply
+// while paused, so the commands need it resume the VM first.
+func statePaused(ctx *VmContext, ev QemuEvent) {
+	switch ev.Event() {
+	case COMMAND_STOP_POD, COMMAND_SHUTDOWN:
+		glog.Info("resume the paused VM to handle ", EventString(ev.Event()))
+		ctx.DCtx.Pause(ctx, false, make(chan *types.QemuResponse, 1))
+		ctx.Become(stateRunning, "RUNNING")
+		stateRunning(ctx, ev)
+		return
+	case ERROR_INTERRUPTED:
+		if ev.(*Interrupted).reason == PingTimeoutReason {
+			// the ping will be answered once the VM resumed
+			glog.V(1).Info("ignore ping timeout while paused")
+			return
+		}
+	}
+
+	if processed := commonStateHandler(ctx, ev, true); processed {
+	} else if processed := initFailureHandler(ctx, ev); processed {
+		ctx.shutdownVM(true, "Fail during reconnect to a paused pod")
+		ctx.Become(stateTerminating, "TERMINATING")
+	} else {
+		switch ev.Event() {
+		case COMMAND_RELEASE:
+			// keep it frozen, it is associated as paused later
+			glog.Info("pod is paused, got release command, let qemu fly")
+			ctx.Become(nil, "NONE")
+			ctx.reportSuccess("", nil)
+		case COMMAND_UNPAUSE:
+			ctx.pauseVm(false, ev.(*UnpauseCommand).Callback)
+		case COMMAND_MIGRATE:
+			ctx.replyBadMigrate(ev.(*MigrateCommand), "the pod is paused, can not migrate it")
+		case COMMAND_RESIZE:
+			ctx.replyBadResize(ev.(*ResizeCommand).Callback, "the pod is paused, can not resize it")
+		case EVENT_RESIZED:
+			ctx.replyResize(ev.(*ResizedEvent).Reply)
+		case COMMAND_ADD_CPU:
+			ctx.replyBadCpuAdd(ev.(*CpuAddCommand).Callback, "the pod is paused, can not add cpu to it")
+		case EVENT_CPU_ADDED:
+			// the init brings them online once resumed
+			ctx.onCpuAdded(ev.(*CpuAddedEvent))
+		case COMMAND_NEW_CONTAINER:
+			ctx.replyBadContainer(ev.(*NewContainerCommand), "the pod is paused, can not add container to it")
+		case COMMAND_ATTACH_VOLUME:
+			ctx.replyBadVolume(ev.(*VolumeAttachCommand).Callback, "the pod is paused, can not attach volume to it")
+		case COMMAND_DETACH_VOLUME:
+			ctx.replyBadVolume(ev.(*VolumeDetachCommand).Callback, "the pod is paused, can not detach volume from it")
+		case COMMAND_PAUSE:
+			ctx.replyBadPause(ev.(*PauseCommand).Callback, "the pod is paused already")
+		case EVENT_PAUSE_DONE:
+			ctx.onPauseDone(ev.(*PauseDoneEvent))
+		case COMMAND_EXEC:
+			ev.(*ExecCommand).Streams.Callback <- &types.QemuResponse{
+				VmId:  ctx.Id,
+				Code:  types.E_FAILED,
+				Cause: "the pod is paused, can not exec in it",
+			}
+		case COMMAND_ATTACH:
+			ev.(*AttachCommand).Streams.Callback <- &types.QemuResponse{
+				VmId:  ctx.Id,
+				Code:  types.E_FAILED,
+				Cause: "the pod is paused, can not attach to it",
+				Data:  uint64(0),
+			}
+		case COMMAND_QUERY:
+			ctx.DCtx.Query(ctx, ev.(*QueryCommand))
+		case EVENT_QMP_EVENT:
+			glog.V(1).Info("got QMP event while paused: ", ev.(*QmpEvent).Type)
+		case COMMAND_ACK:
+			ack := ev.(*CommandAck)
+			glog.V(1).Infof("[paused] got init ack to %d", ack.reply)
+		default:
+			glog.Warning("got unexpected event during pod paused")
+		}
+	}
+}
+
+// pauseVm freezes or resumes the VM, PauseDoneEvent is sent to hub with
+// the result.
+func (ctx *VmContext) pauseVm(pause bool, callback chan *types.QemuResponse) {
+	if ctx.pauseCallback != nil {
+		ctx.replyBadPause(callback, "the pod is being paused or resumed")
+		return
+	}
+	ctx.pauseCallback = callback
+	result := make(chan *types.QemuResponse, 1)
+	ctx.DCtx.Pause(ctx, pause, result)
+	go func() {
+		ctx.hub <- &PauseDoneEvent{Paused: pause, Reply: <-result}
+	}()
+}
+
+// replyPause reports the result of the PauseCommand or UnpauseCommand in
+// progress
+func (ctx *VmContext) replyPause(rsp *types.QemuResponse) {
+	if ctx.pauseCallback == nil {
+		return
+	}
+	rsp.VmId = ctx.Id
+	ctx.pauseCallback <- rsp
+	ctx.pauseCallback = nil
+}
+
+func (ctx *VmContext) replyBadPause(callback chan *types.QemuResponse, cause string) {
+	callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BAD_REQUEST,
+		Cause: cause,
+	}
+}
+
+// resizeVm sets the memory of the VM with the balloon, it could not be
+// larger than the memory the VM booted with.
+func (ctx *VmContext) resizeVm(cmd *ResizeCommand) {
+	if ctx.resizeCallback != nil {
+		ctx.replyBadResize(cmd.Callback, "memory of the pod is being resized")
+		return
+	}
+	if cmd.Memory < BalloonMinMemory || cmd.Memory > ctx.Boot.Memory {
+		ctx.replyBadResize(cmd.Callback,
+			fmt.Sprintf("memory should be between %d and %d MB", BalloonMinMemory, ctx.Boot.Memory))
+		return
+	}
+	glog.Infof("resize the memory of %s to %d MB", ctx.Id, cmd.Memory)
+	ctx.resizeCallback = cmd.Callback
+	result := make(chan *types.QemuResponse, 1)
+	ctx.DCtx.Balloon(ctx, cmd.Memory, result)
+	go func() {
+		ctx.hub <- &ResizedEvent{Reply: <-result}
+	}()
+}
+
+// replyResize reports the result of the ResizeCommand in progress
+func (ctx *VmContext) replyResize(rsp *types.QemuResponse) {
+	if ctx.resizeCallback == nil {
+		return
+	}
+	rsp.VmId = ctx.Id
+	ctx.resizeCallback <- rsp
+	ctx.resizeCallback = nil
+}
+
+func (ctx *VmContext) replyBadResize(callback chan *types.QemuResponse, cause string) {
+	callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BAD_REQUEST,
+		Cause: cause,
+	}
+}
+
+func (ctx *VmContext) maxCpu() int {
+	if ctx.Boot.MaxCPU < ctx.Boot.CPU {
+		return ctx.Boot.CPU
+	}
+	return ctx.Boot.MaxCPU
+}
+
+// addCpu plugs vcpus into the VM until it has cmd.Cpu of them, and then
+// asks the init to bring them online.
+func (ctx *VmContext) addCpu(cmd *CpuAddCommand) {
+	if ctx.cpuCallback != nil {
+		ctx.replyBadCpuAdd(cmd.Callback, "cpu of the pod is being added")
+		return
+	}
+	if cmd.Cpu <= ctx.Boot.CPU || cmd.Cpu > ctx.maxCpu() {
+		ctx.replyBadCpuAdd(cmd.Callback,
+			fmt.Sprintf("cpu should be more than %d and no more than %d", ctx.Boot.CPU, ctx.maxCpu()))
+		return
+	}
+
+	glog.Infof("add cpu of %s from %d to %d", ctx.Id, ctx.Boot.CPU, cmd.Cpu)
+	ctx.cpuCallback = cmd.Callback
+	result := make(chan *types.QemuResponse, 1)
+	ctx.DCtx.AddCpu(ctx, ctx.Boot.CPU, cmd.Cpu, result)
+	go func() {
+		ctx.hub <- &CpuAddedEvent{Cpu: cmd.Cpu, Reply: <-result}
+	}()
+}
+
+func (ctx *VmContext) onCpuAdded(ev *CpuAddedEvent) {
+	if ev.Reply.Code != types.E_OK {
+		ctx.replyCpuAdd(ev.Reply.Code, ev.Reply.Cause)
+		return
+	}
+	ctx.Boot.CPU = ev.Cpu
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_ONLINECPUMEM,
+		message: []byte{},
+	}
+}
+
+// replyCpuAdd reports the result of CpuAddCommand, with the persist data
+// in which the vcpus are updated.
+func (ctx *VmContext) replyCpuAdd(code int, cause string) {
+	if ctx.cpuCallback == nil {
+		return
+	}
+	ctx.cpuCallback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  code,
+		Cause: cause,
+		Data:  ctx.persistData(),
+	}
+	ctx.cpuCallback = nil
+}
+
+func (ctx *VmContext) replyBadCpuAdd(callback chan *types.QemuResponse, cause string) {
+	callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BAD_REQUEST,
+		Cause: cause,
+	}
+}
+
+// onPauseDone moves to the state pauseVm asked for if it succeeded, and
+// reports the result with the persist data, in which the paused state is
+// saved.
+func (ctx *VmContext) onPauseDone(ev *PauseDoneEvent) {
+	rsp := ev.Reply
+	if rsp.Code == types.E_OK {
+		if ev.Paused {
+			ctx.Become(statePaused, "PAUSED")
+			rsp.Cause = "Pause POD success"
+		} else {
+			ctx.Become(stateRunning, "RUNNING")
+			rsp.Cause = "Unpause POD success"
+		}
+		rsp.Data = ctx.persistData()
+	}
+	ctx.replyPause(rsp)
+}
+
 func statePodStopping(ctx *VmContext, ev QemuEvent) {
 	if processed := commonStateHandler(ctx, ev, true); processed {
 	} else {
@@ -456,7 +867,9 @@ func statePodStopping(ctx *VmContext, ev QemuEvent) {
 			ctx.Become(stateTerminating, "TERMINATING")
 			glog.Error(reason)
 		default:
-			glog.Warning("got unexpected event during pod stopping")
+			if !ctx.rejectPodCommand(ev) {
+				glog.Warning("got unexpected event during pod stopping")
+			}
 		}
 	}
 }
@@ -499,7 +912,9 @@ func stateTerminating(ctx *VmContext, ev QemuEvent) {
 	case ERROR_INTERRUPTED:
 		glog.V(1).Info("Connection interrupted while terminating")
 	default:
-		glog.V(1).Info("got event during terminating")
+		if !ctx.rejectPodCommand(ev) {
+			glog.V(1).Info("got event during terminating")
+		}
 	}
 }
 
@@ -546,7 +961,9 @@ func stateCleaning(ctx *VmContext, ev QemuEvent) {
 				ctx.Become(stateInit, "INIT")
 			}
 		default:
-			glog.V(1).Info("got event message while cleaning")
+			if !ctx.rejectPodCommand(ev) {
+				glog.V(1).Info("got event message while cleaning")
+			}
 		}
 	}
 }
@@ -581,7 +998,9 @@ func stateDestroying(ctx *VmContext, ev QemuEvent) {
 			glog.Info("Device removing timeout")
 			ctx.Close()
 		default:
-			glog.Warning("got event during vm cleaning up")
+			if !ctx.rejectPodCommand(ev) {
+				glog.Warning("got event during vm cleaning up")
+			}
 		}
 	}
 }
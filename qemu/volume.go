@@ -0,0 +1,385 @@
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+	"sort"
+)
+
+// volumeOperation is the volume being attached or detached. If the volume
+// is not used any more, it is released, and the result is replied once
+// it is released.
+type volumeOperation struct {
+	name     string
+	attach   *VolumeAttachCommand // nil if detaching
+	targets  []int
+	mounts   []VmVolumeMount
+	callback chan *types.QemuResponse
+	code     int
+	cause    string
+}
+
+// attachVolume mounts a volume to the containers of the running pod. A new
+// volume is inserted first if it is a block device.
+func (ctx *VmContext) attachVolume(cmd *VolumeAttachCommand) {
+	if ctx.volumeOp != nil {
+		ctx.replyBadVolume(cmd.Callback, "a volume is being attached or detached")
+		return
+	}
+	vol, exist := ctx.devices.volumeMap[cmd.Name]
+	if exist && cmd.Info != nil {
+		ctx.replyBadVolume(cmd.Callback, fmt.Sprintf("volume %s is in the pod already", cmd.Name))
+		return
+	} else if !exist && cmd.Info == nil {
+		ctx.replyBadVolume(cmd.Callback, fmt.Sprintf("volume %s is not in the pod", cmd.Name))
+		return
+	}
+
+	targets, err := ctx.volumeTargets(cmd.Containers)
+	if err != nil {
+		ctx.replyBadVolume(cmd.Callback, err.Error())
+		return
+	}
+	if len(cmd.Containers) == 0 {
+		// the init containers are finished already
+		for idx := ctx.initContainers; idx < len(ctx.vmSpec.Containers); idx++ {
+			targets = append(targets, idx)
+		}
+	}
+	for _, idx := range targets {
+		c := &ctx.vmSpec.Containers[idx]
+		if exist {
+			if _, ok := vol.pos[idx]; ok {
+				ctx.replyBadVolume(cmd.Callback, fmt.Sprintf("volume %s is mounted to container %s already", cmd.Name, c.Id))
+				return
+			}
+		}
+		if c.volLookup(cmd.Path) != nil || c.mapLookup(cmd.Path) != nil {
+			ctx.replyBadVolume(cmd.Callback, fmt.Sprintf("container %s has a volume on %s already", c.Id, cmd.Path))
+			return
+		}
+	}
+
+	ctx.volumeOp = &volumeOperation{
+		name:     cmd.Name,
+		attach:   cmd,
+		targets:  targets,
+		callback: cmd.Callback,
+	}
+	if exist {
+		ctx.mountVolume()
+		return
+	}
+
+	info := &blockDescriptor{
+		name: cmd.Name, filename: cmd.Info.Filepath, format: cmd.Info.Format, fstype: cmd.Info.Fstype, deviceName: ""}
+	if info.fstype == "dir" {
+		info.fstype = ""
+	}
+	ctx.devices.volumeMap[cmd.Name] = &volumeInfo{
+		info:     info,
+		pos:      make(map[int]string),
+		readOnly: make(map[int]bool),
+	}
+	if info.fstype == "" {
+		ctx.mountVolume()
+		return
+	}
+	glog.Infof("insert volume %s to %s", cmd.Name, ctx.Id)
+	ctx.DCtx.AddDisk(ctx, info.name, "volume", info.filename, info.format, ctx.nextScsiId())
+}
+
+// detachVolume unmounts a volume from the containers of the running pod,
+// and removes it from the pod if no container uses it any more.
+func (ctx *VmContext) detachVolume(cmd *VolumeDetachCommand) {
+	if ctx.volumeOp != nil {
+		ctx.replyBadVolume(cmd.Callback, "a volume is being attached or detached")
+		return
+	}
+	vol, ok := ctx.devices.volumeMap[cmd.Name]
+	if !ok {
+		ctx.replyBadVolume(cmd.Callback, fmt.Sprintf("volume %s is not in the pod", cmd.Name))
+		return
+	}
+
+	targets, err := ctx.volumeTargets(cmd.Containers)
+	if err != nil {
+		ctx.replyBadVolume(cmd.Callback, err.Error())
+		return
+	}
+	if len(cmd.Containers) == 0 {
+		for idx := range vol.pos {
+			targets = append(targets, idx)
+		}
+		sort.Ints(targets)
+	}
+	mounts := []VmVolumeMount{}
+	for _, idx := range targets {
+		mpoint, ok := vol.pos[idx]
+		if !ok {
+			ctx.replyBadVolume(cmd.Callback, fmt.Sprintf("volume %s is not mounted to container %s",
+				cmd.Name, ctx.vmSpec.Containers[idx].Id))
+			return
+		}
+		if idx < ctx.initContainers {
+			// the finished init container has nothing to unmount
+			continue
+		}
+		mounts = append(mounts, vol.mount(ctx.vmSpec.Containers[idx].Id, mpoint, vol.readOnly[idx]))
+	}
+
+	ctx.volumeOp = &volumeOperation{
+		name:     cmd.Name,
+		targets:  targets,
+		mounts:   mounts,
+		callback: cmd.Callback,
+	}
+	if len(mounts) == 0 {
+		ctx.onVolumeMounted()
+		return
+	}
+	ctx.sendVolumeMounts(INIT_UMOUNTVOLUME)
+}
+
+// volumeTargets returns the index of the containers
+func (ctx *VmContext) volumeTargets(containers []string) ([]int, error) {
+	targets := []int{}
+	for _, id := range containers {
+		idx := ctx.Lookup(id)
+		if idx < 0 {
+			return nil, fmt.Errorf("container %s is not in the pod", id)
+		}
+		if idx < ctx.initContainers {
+			return nil, fmt.Errorf("container %s is a finished init container", id)
+		}
+		targets = append(targets, idx)
+	}
+	return targets, nil
+}
+
+func (vol *volumeInfo) mount(container, mpoint string, readOnly bool) VmVolumeMount {
+	m := VmVolumeMount{
+		Container: container,
+		Mount:     mpoint,
+		ReadOnly:  readOnly,
+	}
+	if vol.info.fstype == "" {
+		m.Source = vol.info.filename
+	} else {
+		m.Device = vol.info.deviceName
+		m.Fstype = vol.info.fstype
+	}
+	return m
+}
+
+// mountVolume asks the init to mount the volume being attached, whose
+// device is ready.
+func (ctx *VmContext) mountVolume() {
+	op := ctx.volumeOp
+	vol := ctx.devices.volumeMap[op.name]
+	op.mounts = make([]VmVolumeMount, len(op.targets))
+	for i, idx := range op.targets {
+		op.mounts[i] = vol.mount(ctx.vmSpec.Containers[idx].Id, op.attach.Path, op.attach.ReadOnly)
+	}
+	ctx.sendVolumeMounts(INIT_MOUNTVOLUME)
+}
+
+func (ctx *VmContext) sendVolumeMounts(code uint32) {
+	msg, err := json.Marshal(ctx.volumeOp.mounts)
+	if err != nil {
+		ctx.onVolumeCmdFail("Generated wrong volume mounts " + err.Error())
+		return
+	}
+	ctx.vm <- &DecodedMessage{
+		code:    code,
+		message: msg,
+	}
+}
+
+// onVolumeBlockdev mounts the volume being attached once it is inserted
+func (ctx *VmContext) onVolumeBlockdev(info *BlockdevInsertedEvent) {
+	op := ctx.volumeOp
+	if op != nil && op.attach != nil && info.SourceType == "volume" && info.Name == op.name {
+		ctx.mountVolume()
+	}
+}
+
+// attachVolumeFailed handles the failure of inserting the volume being
+// attached, it returns false if the failure is not about it.
+func (ctx *VmContext) attachVolumeFailed(ev *DeviceFailed) bool {
+	op := ctx.volumeOp
+	if op == nil || op.attach == nil {
+		return false
+	}
+	info, ok := ev.session.(*BlockdevInsertedEvent)
+	if !ok || info.SourceType != "volume" || info.Name != op.name {
+		return false
+	}
+	glog.Error("failed to insert volume ", op.name)
+	delete(ctx.devices.volumeMap, op.name)
+	ctx.replyVolume(types.E_FAILED, "failed to insert the volume")
+	return true
+}
+
+// onVolumeMounted updates the specs once the init mounted or unmounted
+// the volume.
+func (ctx *VmContext) onVolumeMounted() {
+	op := ctx.volumeOp
+	if op == nil {
+		return
+	}
+	vol := ctx.devices.volumeMap[op.name]
+
+	ctx.lock.Lock()
+	if op.attach != nil {
+		for _, idx := range op.targets {
+			vol.pos[idx] = op.attach.Path
+			vol.readOnly[idx] = op.attach.ReadOnly
+			c := &ctx.vmSpec.Containers[idx]
+			if vol.info.fstype == "" {
+				c.Fsmap = append(c.Fsmap, VmFsmapDescriptor{
+					Source:   vol.info.filename,
+					Path:     op.attach.Path,
+					ReadOnly: op.attach.ReadOnly,
+				})
+			} else {
+				c.Volumes = append(c.Volumes, VmVolumeDescriptor{
+					Device:   vol.info.deviceName,
+					Mount:    op.attach.Path,
+					Fstype:   vol.info.fstype,
+					ReadOnly: op.attach.ReadOnly,
+				})
+			}
+			uc := &ctx.userSpec.Containers[idx]
+			uc.Volumes = append(uc.Volumes, pod.UserVolumeReference{
+				Path:     op.attach.Path,
+				Volume:   op.name,
+				ReadOnly: op.attach.ReadOnly,
+			})
+		}
+		if op.attach.Volume != nil {
+			ctx.userSpec.Volumes = append(ctx.userSpec.Volumes, *op.attach.Volume)
+		}
+		ctx.lock.Unlock()
+		ctx.replyVolume(types.E_OK, "Attach volume success")
+		return
+	}
+
+	for _, idx := range op.targets {
+		mpoint := vol.pos[idx]
+		delete(vol.pos, idx)
+		delete(vol.readOnly, idx)
+		c := &ctx.vmSpec.Containers[idx]
+		volumes := []VmVolumeDescriptor{}
+		for _, v := range c.Volumes {
+			if v.Mount != mpoint {
+				volumes = append(volumes, v)
+			}
+		}
+		c.Volumes = volumes
+		fsmap := []VmFsmapDescriptor{}
+		for _, m := range c.Fsmap {
+			if m.Path != mpoint {
+				fsmap = append(fsmap, m)
+			}
+		}
+		c.Fsmap = fsmap
+		uc := &ctx.userSpec.Containers[idx]
+		refs := []pod.UserVolumeReference{}
+		for _, r := range uc.Volumes {
+			if r.Volume != op.name {
+				refs = append(refs, r)
+			}
+		}
+		uc.Volumes = refs
+	}
+	ctx.lock.Unlock()
+
+	if len(vol.pos) > 0 {
+		ctx.replyVolume(types.E_OK, "Detach volume success")
+		return
+	}
+	op.code, op.cause = types.E_OK, "Detach volume success"
+	ctx.releaseVolume()
+}
+
+// onVolumeCmdFail handles the failure of the init to mount or unmount the
+// volume, a new volume is released then.
+func (ctx *VmContext) onVolumeCmdFail(cause string) {
+	op := ctx.volumeOp
+	if op == nil {
+		return
+	}
+	if op.attach != nil && op.attach.Info != nil {
+		op.code, op.cause = types.E_FAILED, cause
+		ctx.releaseVolume()
+		return
+	}
+	ctx.replyVolume(types.E_FAILED, cause)
+}
+
+// releaseVolume removes the device of the volume being operated, which is
+// used by no container.
+func (ctx *VmContext) releaseVolume() {
+	name := ctx.volumeOp.name
+	vol := ctx.devices.volumeMap[name]
+	glog.Infof("release volume %s of %s", name, ctx.Id)
+	ctx.progress.deleting.volumes[name] = true
+	if vol.info.fstype == "" {
+		go UmountVolume(ctx.shareDir, vol.info.filename, name, ctx.hub)
+	} else {
+		ctx.DCtx.RemoveDisk(ctx, vol.info.scsiId, &VolumeUnmounted{Name: name, Success: true})
+	}
+}
+
+// onVolumeReleased removes the volume from the pod once its device is
+// removed, and replies the operation.
+func (ctx *VmContext) onVolumeReleased(v *VolumeUnmounted) {
+	if _, ok := ctx.devices.volumeMap[v.Name]; !ok {
+		return
+	}
+	if !ctx.onVolumeRemoved(v) {
+		glog.Warningf("failed to release volume %s", v.Name)
+	}
+
+	ctx.lock.Lock()
+	delete(ctx.devices.volumeMap, v.Name)
+	delete(ctx.progress.finished.blockdevs, v.Name)
+	for i, vol := range ctx.userSpec.Volumes {
+		if vol.Name == v.Name {
+			ctx.userSpec.Volumes = append(ctx.userSpec.Volumes[:i], ctx.userSpec.Volumes[i+1:]...)
+			break
+		}
+	}
+	ctx.lock.Unlock()
+
+	if op := ctx.volumeOp; op != nil && op.name == v.Name {
+		ctx.replyVolume(op.code, op.cause)
+	}
+}
+
+// replyVolume reports the result of the volume operation, with the persist
+// data in which the volume is attached or detached.
+func (ctx *VmContext) replyVolume(code int, cause string) {
+	if ctx.volumeOp == nil {
+		return
+	}
+	ctx.volumeOp.callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  code,
+		Cause: cause,
+		Data:  ctx.persistData(),
+	}
+	ctx.volumeOp = nil
+}
+
+func (ctx *VmContext) replyBadVolume(callback chan *types.QemuResponse, cause string) {
+	callback <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BAD_REQUEST,
+		Cause: cause,
+	}
+}
@@ -53,6 +53,29 @@ func hijackServer(w http.ResponseWriter) (io.ReadCloser, io.Writer, error) {
 	return conn, conn, nil
 }
 
+// writeFlusher flushes the response once written, so the client could get
+// the streamed output in time
+type writeFlusher struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newWriteFlusher(w http.ResponseWriter) *writeFlusher {
+	wf := &writeFlusher{w: w}
+	if f, ok := w.(http.Flusher); ok {
+		wf.flusher = f
+	}
+	return wf
+}
+
+func (wf *writeFlusher) Write(p []byte) (int, error) {
+	n, err := wf.w.Write(p)
+	if wf.flusher != nil {
+		wf.flusher.Flush()
+	}
+	return n, err
+}
+
 func closeStreams(streams ...interface{}) {
 	for _, stream := range streams {
 		if tcpc, ok := stream.(interface {
@@ -238,6 +261,64 @@ func getPodInfo(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	return writeJSONEnv(w, http.StatusCreated, env)
 }
 
+func getPodExport(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("podExport", r.Form.Get("podId"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	// the spec is an object in the output
+	if err := env.SetJson("PodSpec", dat["PodSpec"]); err != nil {
+		return err
+	}
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getVmConsole(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Read the console of VM %s", r.Form.Get("vm"))
+	job := eng.Job("vmConsole", r.Form.Get("vm"), r.Form.Get("follow"))
+	w.Header().Set("Content-Type", "text/plain")
+	job.Stdout.Add(newWriteFlusher(w))
+
+	return job.Run()
+}
+
+func getContainerLogs(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Read the logs of container %s", r.Form.Get("container"))
+	job := eng.Job("containerLogs", r.Form.Get("container"), r.Form.Get("follow"),
+		r.Form.Get("since"), r.Form.Get("tail"))
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(newWriteFlusher(w))
+
+	return job.Run()
+}
+
 func postStop(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
@@ -274,7 +355,8 @@ func postExec(eng *engine.Engine, version version.Version, w http.ResponseWriter
 	}
 
 	var (
-		job                 = eng.Job("exec", r.Form.Get("type"), r.Form.Get("value"), r.Form.Get("command"), r.Form.Get("tag"))
+		job                 = eng.Job("exec", r.Form.Get("type"), r.Form.Get("value"), r.Form.Get("command"), r.Form.Get("tag"),
+			r.Form.Get("tty"), r.Form.Get("env"), r.Form.Get("workdir"), r.Form.Get("user"))
 		errOut    io.Writer = os.Stderr
 		errStream io.Writer
 	)
@@ -286,7 +368,12 @@ func postExec(eng *engine.Engine, version version.Version, w http.ResponseWriter
 	}
 	defer closeStreams(inStream, outStream)
 
-	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	// the output of the command without tty is multiplexed
+	contentType := "application/vnd.docker.raw-stream"
+	if r.Form.Get("tty") == "no" {
+		contentType = "application/vnd.hyper.multiplexed-stream"
+	}
+	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", contentType)
 
 	errStream = outStream
 	job.Stdin.Add(inStream)
@@ -462,6 +549,350 @@ func postPodRun(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
+func postPodMigrate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Migrate the POD %s to %s", r.Form.Get("podId"), r.Form.Get("to"))
+	job := eng.Job("podMigrate", r.Form.Get("podId"), r.Form.Get("to"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodIncoming(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Prepare VM for the incoming POD %s", r.Form.Get("podId"))
+	job := eng.Job("podIncoming", r.Form.Get("podId"), r.Form.Get("cpu"), r.Form.Get("mem"),
+		r.Form.Get("podArgs"), r.Form.Get("vmData"), r.Form.Get("containers"), r.Form.Get("shareDir"), r.Form.Get("migrateHost"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.Set("Uri", dat["Uri"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodPause(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	return postPodPauseJob(eng, "podPause", w, r)
+}
+
+func postPodUnpause(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	return postPodPauseJob(eng, "podUnpause", w, r)
+}
+
+func postPodPauseJob(eng *engine.Engine, name string, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Run %s for the POD %s", name, r.Form.Get("podId"))
+	job := eng.Job(name, r.Form.Get("podId"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodResize(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Resize the POD %s to %s cpus, %s MB", r.Form.Get("podId"), r.Form.Get("cpu"), r.Form.Get("memory"))
+	job := eng.Job("podResize", r.Form.Get("podId"), r.Form.Get("cpu"), r.Form.Get("memory"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Cpu", (int)(dat["Cpu"].(float64)))
+	env.SetInt("Memory", (int)(dat["Memory"].(float64)))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodWait(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Wait for the POD %s", r.Form.Get("podId"))
+	job := eng.Job("podWait", r.Form.Get("podId"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("ExitCode", (int)(dat["ExitCode"].(float64)))
+	env.Set("Container", dat["Container"].(string))
+	// the exit codes of the containers are a list in the output
+	if err := env.SetJson("Containers", dat["Containers"]); err != nil {
+		return err
+	}
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodAddContainer(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Add a container to the POD %s", r.Form.Get("podId"))
+	job := eng.Job("podAddContainer", r.Form.Get("podId"), r.Form.Get("containerArgs"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.Set("ContainerID", dat["ContainerID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postVolumeAttach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Attach the volume %s to the POD %s", r.Form.Get("volume"), r.Form.Get("podId"))
+	job := eng.Job("volumeAttach", r.Form.Get("podId"), r.Form.Get("volume"), r.Form.Get("path"),
+		r.Form.Get("readOnly"), r.Form.Get("containers"), r.Form.Get("source"), r.Form.Get("driver"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postVolumeDetach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Detach the volume %s from the POD %s", r.Form.Get("volume"), r.Form.Get("podId"))
+	job := eng.Job("volumeDetach", r.Form.Get("podId"), r.Form.Get("volume"), r.Form.Get("containers"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getNetworkList(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("networkList")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type listResponse struct {
+		NetworkData []string `json:"networkData"`
+	}
+	var res listResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("networkData", res.NetworkData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postNetworkCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Create the network %s with subnet %s %s", r.Form.Get("name"), r.Form.Get("subnet"), r.Form.Get("subnet6"))
+	job := eng.Job("networkCreate", r.Form.Get("name"), r.Form.Get("subnet"), r.Form.Get("subnet6"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("Name", dat["Name"].(string))
+	env.Set("Bridge", dat["Bridge"].(string))
+	env.Set("Subnet", dat["Subnet"].(string))
+	env.Set("Subnet6", dat["Subnet6"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postNetworkRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Remove the network %s", r.Form.Get("name"))
+	job := eng.Job("networkRemove", r.Form.Get("name"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("Name", dat["Name"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
 func postVmCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
@@ -667,10 +1098,14 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 	}
 	m := map[string]map[string]HttpApiFunc{
 		"GET": {
-			"/info":     getInfo,
-			"/pod/info": getPodInfo,
-			"/version":  getVersion,
-			"/list":     getList,
+			"/info":           getInfo,
+			"/pod/info":       getPodInfo,
+			"/version":        getVersion,
+			"/list":           getList,
+			"/vm/console":     getVmConsole,
+			"/container/logs": getContainerLogs,
+			"/network/list":   getNetworkList,
+			"/pod/export":     getPodExport,
 		},
 		"POST": {
 			"/container/create": postContainerCreate,
@@ -680,6 +1115,17 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			"/pod/remove":       postPodRemove,
 			"/pod/run":          postPodRun,
 			"/pod/stop":         postStop,
+			"/pod/migrate":      postPodMigrate,
+			"/pod/incoming":     postPodIncoming,
+			"/pod/pause":        postPodPause,
+			"/pod/unpause":      postPodUnpause,
+			"/pod/resize":       postPodResize,
+			"/pod/wait":         postPodWait,
+			"/pod/addcontainer": postPodAddContainer,
+			"/volume/attach":    postVolumeAttach,
+			"/volume/detach":    postVolumeDetach,
+			"/network/create":   postNetworkCreate,
+			"/network/remove":   postNetworkRemove,
 			"/vm/create":        postVmCreate,
 			"/vm/kill":          postVmKill,
 			"/exec":             postExec,
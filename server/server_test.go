@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hyper/engine"
+	"hyper/pod"
+)
+
+// serve calls the API with the job handled by handler
+func serve(t *testing.T, name string, handler engine.Handler, req *http.Request) *httptest.ResponseRecorder {
+	eng := engine.New("")
+	if err := eng.Register(name, handler); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	createRouter(eng, false, false, "", "test").ServeHTTP(rec, req)
+	return rec
+}
+
+func newRequest(t *testing.T, method, url string) *http.Request {
+	req, err := http.NewRequest(method, url, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// serveJob calls the API with the job handled by handler, and decodes the
+// response like the client.
+func serveJob(t *testing.T, name string, handler engine.Handler, method, url string) *engine.Env {
+	rec := serve(t, name, handler, newRequest(t, method, url))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s %s returned %d: %s", method, url, rec.Code, rec.Body.String())
+	}
+
+	env := &engine.Env{}
+	if err := env.Decode(rec.Body); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestPodWait(t *testing.T) {
+	env := serveJob(t, "podWait", func(job *engine.Job) error {
+		v := &engine.Env{}
+		v.Set("ID", job.Args[0])
+		v.SetInt("ExitCode", 2)
+		v.Set("Container", "c2")
+		v.SetList("Containers", []string{"c1:0", "c2:2"})
+		_, err := v.WriteTo(job.Stdout)
+		return err
+	}, "POST", "/pod/wait?podId=pod-test")
+
+	if env.Get("ID") != "pod-test" || env.GetInt("ExitCode") != 2 || env.Get("Container") != "c2" {
+		t.Errorf("wrong wait result %v", env.Map())
+	}
+	if codes := env.GetList("Containers"); len(codes) != 2 || codes[0] != "c1:0" || codes[1] != "c2:2" {
+		t.Errorf("wrong exit codes %v", codes)
+	}
+}
+
+func TestPodExport(t *testing.T) {
+	spec := `{"id":"web","containers":[{"name":"nginx","image":"nginx:latest","ports":[{"containerPort":80,"hostPort":8080}]}],"resource":{"vcpu":2,"memory":256}}`
+	env := serveJob(t, "podExport", func(job *engine.Job) error {
+		v := &engine.Env{}
+		v.Set("ID", job.Args[0])
+		v.Set("PodSpec", spec)
+		_, err := v.WriteTo(job.Stdout)
+		return err
+	}, "GET", "/pod/export?podId=pod-test")
+
+	userPod := &pod.UserPod{}
+	if err := env.GetJson("PodSpec", userPod); err != nil {
+		t.Fatal(err)
+	}
+	if env.Get("ID") != "pod-test" || userPod.Name != "web" || userPod.Resource.Memory != 256 ||
+		len(userPod.Containers) != 1 || userPod.Containers[0].Ports[0].HostPort != 8080 {
+		t.Errorf("wrong exported spec %s", env.Get("PodSpec"))
+	}
+}
+
+func TestPodIncoming(t *testing.T) {
+	var args []string
+	handler := func(job *engine.Job) error {
+		args = job.Args
+		v := &engine.Env{}
+		v.Set("ID", "vm-test")
+		v.Set("Uri", "tcp:192.0.2.2:4446")
+		v.SetInt("Code", 0)
+		v.Set("Cause", "")
+		_, err := v.WriteTo(job.Stdout)
+		return err
+	}
+	// the daemon on another host asks for it over tcp
+	req := newRequest(t, "POST", "/pod/incoming?podId=pod-test&shareDir=/var/run/hyper/vm-src/share_dir&migrateHost=192.0.2.2")
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := serve(t, "podIncoming", handler, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("incoming POD returned %d: %s", rec.Code, rec.Body.String())
+	}
+	env := &engine.Env{}
+	if err := env.Decode(rec.Body); err != nil {
+		t.Fatal(err)
+	}
+	if env.Get("ID") != "vm-test" || env.Get("Uri") != "tcp:192.0.2.2:4446" {
+		t.Errorf("wrong incoming result %v", env.Map())
+	}
+	if len(args) != 8 || args[0] != "pod-test" || args[6] != "/var/run/hyper/vm-src/share_dir" || args[7] != "192.0.2.2" {
+		t.Errorf("wrong incoming arguments %v", args)
+	}
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 const (
 	E_OK = iota
 	E_VM_RUNNING
@@ -22,6 +24,8 @@ const (
 	S_POD_RUNNING
 	S_POD_FAILED
 	S_POD_SUCCEEDED
+	S_POD_MIGRATING
+	S_POD_PAUSED
 
 	S_VM_IDLE
 	S_VM_ASSOCIATED
@@ -33,3 +37,10 @@ type QemuResponse struct {
 	Cause string
 	Data  interface{}
 }
+
+// ContainerLogEntry is a line of the output of a container in its log
+type ContainerLogEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}